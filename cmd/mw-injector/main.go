@@ -1,21 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/middleware-labs/java-injector/pkg/cli"
-)
-
-const (
-	DefaultAgentDir  = "/opt/middleware/agents"
-	DefaultAgentName = "middleware-javaagent-1.8.1.jar"
-	DefaultAgentPath = DefaultAgentDir + "/" + DefaultAgentName
+	"github.com/middleware-labs/java-injector/pkg/shim"
 )
 
 func main() {
+	// Installed as the Java launcher shim, act as java
+	if shim.IsShimInvocation(os.Args[0]) {
+		os.Exit(shim.Run(os.Args))
+	}
+
 	router := cli.NewRouter()
 	if err := router.Run(os.Args); err != nil {
-		// Error is already printed by the router or command
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
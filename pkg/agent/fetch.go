@@ -0,0 +1,442 @@
+package agent
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCacheDir keeps the agents downloaded from the repository. It
+	// can be copied to air-gapped hosts, which then install from it alone.
+	DefaultCacheDir = "/var/cache/middleware/agents"
+
+	// Latest asks for the newest release
+	Latest = "latest"
+
+	// partSuffix marks a download in progress, resumed by the next fetch
+	partSuffix = ".part"
+)
+
+// errNotFound means the repository doesn't have a file
+var errNotFound = errors.New("not found")
+
+// Release is an agent version published by a repository
+type Release struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`              // relative to the index
+	SHA256  string `json:"sha256,omitempty"` // else read from <url>.sha256 or SHA256SUMS
+}
+
+// index is the index.json of a repository:
+//
+//	{"releases": [{"version": "1.9.0", "url": "middleware-javaagent-1.9.0.jar", "sha256": "..."}]}
+type index struct {
+	Releases []Release `json:"releases"`
+}
+
+// mavenMetadata is the maven-metadata.xml of an artifact
+type mavenMetadata struct {
+	ArtifactID string   `xml:"artifactId"`
+	Versions   []string `xml:"versioning>versions>version"`
+}
+
+// Fetcher downloads agent releases from an artifact repository into a
+// cache. The repository is a Maven artifact, e.g.
+// https://repo.example.com/maven2/io/middleware/middleware-javaagent, or
+// the URL of an index.json. file:// URLs read a mirror on disk.
+type Fetcher struct {
+	Repository string // only the cache is used when empty
+	CacheDir   string
+	client     *http.Client
+}
+
+// NewFetcher returns a fetcher going through proxy, or the proxy the
+// environment sets (HTTPS_PROXY, NO_PROXY) when empty
+func NewFetcher(repository, cacheDir, proxy string) (*Fetcher, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	// Downloads take as long as they take, a silent server doesn't
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+
+	if repository != "" {
+		if u, err := url.Parse(repository); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return nil, fmt.Errorf("invalid agent repository %q: want an http, https or file URL", repository)
+		}
+	}
+	return &Fetcher{
+		Repository: repository,
+		CacheDir:   cacheDir,
+		client:     &http.Client{Transport: transport},
+	}, nil
+}
+
+// Fetch downloads a release and returns its path in the cache, the latest
+// release when version is empty or "latest". A release already in the
+// cache isn't downloaded again, and the cache is used alone when the
+// repository can't be reached.
+func (f *Fetcher) Fetch(version string) (string, error) {
+	if version == Latest {
+		version = ""
+	}
+	if version != "" && !ValidVersion(version) {
+		return "", fmt.Errorf("invalid agent version %q", version)
+	}
+	if f.Repository == "" {
+		return f.cached(version)
+	}
+
+	releases, err := f.Releases()
+	if err != nil {
+		cached, cacheErr := f.cached(version)
+		if cacheErr != nil {
+			return "", err
+		}
+		fmt.Printf("⚠️  %v, using the cached agent\n", err)
+		return cached, nil
+	}
+	release, err := pick(releases, version)
+	if err != nil {
+		return "", fmt.Errorf("%w in %s", err, f.redacted())
+	}
+	return f.download(release)
+}
+
+// Releases lists the releases of the repository, oldest first
+func (f *Fetcher) Releases() ([]Release, error) {
+	base, err := url.Parse(f.Repository)
+	if err != nil {
+		return nil, err
+	}
+	var releases []Release
+
+	if strings.HasSuffix(base.Path, ".json") {
+		data, err := f.get(base.String())
+		if err != nil {
+			return nil, err
+		}
+		var idx index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, fmt.Errorf("invalid agent index %s: %w", f.redacted(), err)
+		}
+		for _, r := range idx.Releases {
+			ref, err := url.Parse(r.URL)
+			if err != nil || r.URL == "" {
+				return nil, fmt.Errorf("invalid agent index %s: release %s has the URL %q", f.redacted(), r.Version, r.URL)
+			}
+			r.URL = base.ResolveReference(ref).String()
+			releases = append(releases, r)
+		}
+	} else {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+		data, err := f.get(base.JoinPath("maven-metadata.xml").String())
+		if err != nil {
+			return nil, err
+		}
+		var meta mavenMetadata
+		if err := xml.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid maven-metadata.xml in %s: %w", f.redacted(), err)
+		}
+		artifact := firstNonEmpty(meta.ArtifactID, path.Base(base.Path))
+		for _, version := range meta.Versions {
+			releases = append(releases, Release{
+				Version: version,
+				URL:     base.JoinPath(version, artifact+"-"+version+".jar").String(),
+			})
+		}
+	}
+
+	valid := releases[:0]
+	for _, r := range releases {
+		if ValidVersion(r.Version) {
+			valid = append(valid, r)
+		}
+	}
+	sort.Slice(valid, func(i, j int) bool {
+		return CompareVersions(valid[i].Version, valid[j].Version) < 0
+	})
+	return valid, nil
+}
+
+// pick finds a version among the releases, the newest one that isn't a
+// pre-release when version is empty
+func pick(releases []Release, version string) (Release, error) {
+	for i := len(releases) - 1; i >= 0; i-- {
+		if releases[i].Version == version || (version == "" && !prerelease(releases[i].Version)) {
+			return releases[i], nil
+		}
+	}
+	if version == "" {
+		return Release{}, fmt.Errorf("no agent release")
+	}
+	return Release{}, fmt.Errorf("no agent release %s", version)
+}
+
+// download fetches a release and what vouches for it into the cache,
+// resuming an interrupted download, and checks its checksum
+func (f *Fetcher) download(release Release) (string, error) {
+	jarURL, err := url.Parse(release.URL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(jarURL.Path)
+	if !strings.HasSuffix(name, ".jar") {
+		return "", fmt.Errorf("agent %s isn't a JAR: %s", release.Version, jarURL.Redacted())
+	}
+	dir := filepath.Join(f.CacheDir, release.Version)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create agent cache: %w", err)
+	}
+	target := filepath.Join(dir, name)
+
+	// Kept next to the agent, so it's verified like an agent published
+	// with its manifests
+	for _, manifest := range []string{name + ".sha256", ChecksumsFile} {
+		for _, file := range []string{manifest, manifest + SignatureSuffix} {
+			data, err := f.get(jarURL.ResolveReference(&url.URL{Path: file}).String())
+			switch {
+			case errors.Is(err, errNotFound):
+				os.Remove(filepath.Join(dir, file))
+			case err != nil:
+				return "", err
+			default:
+				if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+					return "", fmt.Errorf("failed to write agent cache: %w", err)
+				}
+			}
+		}
+	}
+
+	expected := release.SHA256
+	for _, manifest := range []string{name + ".sha256", ChecksumsFile} {
+		if data, err := os.ReadFile(filepath.Join(dir, manifest)); err == nil && expected == "" {
+			expected, _ = lookupChecksum(string(data), manifest, name)
+		}
+	}
+	if expected == "" {
+		return "", fmt.Errorf("%s publishes no checksum for agent %s, want %s.sha256, %s or a sha256 in the index", f.redacted(), release.Version, name, ChecksumsFile)
+	}
+
+	if sum, err := Checksum(target); err == nil && strings.EqualFold(sum, expected) {
+		fmt.Printf("📦 Agent %s is in the cache: %s\n", release.Version, target)
+		return target, nil
+	}
+
+	fmt.Printf("⬇️  Downloading agent %s from %s\n", release.Version, jarURL.Redacted())
+	part := target + partSuffix
+	resumed, err := f.resume(release.URL, part)
+	if err != nil {
+		return "", err
+	}
+	sum, err := Checksum(part)
+	if err == nil && !strings.EqualFold(sum, expected) && resumed {
+		// What was downloaded before may be from another build, start over
+		os.Remove(part)
+		if _, err = f.resume(release.URL, part); err != nil {
+			return "", err
+		}
+		sum, err = Checksum(part)
+	}
+	if err != nil {
+		os.Remove(part)
+		return "", err
+	}
+	if !strings.EqualFold(sum, expected) {
+		os.Remove(part)
+		return "", fmt.Errorf("downloaded agent %s has SHA-256 %s, the repository lists %s", release.Version, sum, expected)
+	}
+	if err := os.Rename(part, target); err != nil {
+		return "", fmt.Errorf("failed to write agent cache: %w", err)
+	}
+	fmt.Printf("✅ Agent %s downloaded to %s\n", release.Version, target)
+	return target, nil
+}
+
+// resume downloads a file, continuing from what an earlier download left.
+// It tells whether it continued one.
+func (f *Fetcher) resume(u, part string) (bool, error) {
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("failed to write agent cache: %w", err)
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to download agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	contentRange := resp.Header.Get("Content-Range")
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && rangeStart(contentRange) == offset:
+		fmt.Printf("   Resuming at %d bytes\n", offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 && rangeSize(contentRange) == offset:
+		// Already complete, the checksum tells if it's the same file
+		return true, nil
+	case offset > 0 && (resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable):
+		// Not the range asked for, or the file is shorter than what was
+		// downloaded: the partial file is of another build, start over
+		resp.Body.Close()
+		file.Close()
+		if err := os.Truncate(part, 0); err != nil {
+			return false, err
+		}
+		return f.resume(u, part)
+	case resp.StatusCode == http.StatusOK:
+		// The server can't resume, start over
+		if err := file.Truncate(0); err != nil {
+			return false, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		offset = 0
+	default:
+		return false, fmt.Errorf("failed to download agent from %s: %s", redactString(u), resp.Status)
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return offset > 0, fmt.Errorf("download of agent interrupted, run again to resume: %w", err)
+	}
+	return offset > 0, file.Close()
+}
+
+// cached returns a release from the cache, the newest one that isn't a
+// pre-release when version is empty
+func (f *Fetcher) cached(version string) (string, error) {
+	entries, err := os.ReadDir(f.CacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read agent cache: %w", err)
+	}
+	var releases []Release
+	for _, e := range entries {
+		if !e.IsDir() || !ValidVersion(e.Name()) {
+			continue
+		}
+		jars, _ := filepath.Glob(filepath.Join(f.CacheDir, e.Name(), "*.jar"))
+		if len(jars) == 1 {
+			releases = append(releases, Release{Version: e.Name(), URL: jars[0]})
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return CompareVersions(releases[i].Version, releases[j].Version) < 0
+	})
+	release, err := pick(releases, version)
+	if err != nil {
+		if f.Repository == "" {
+			return "", fmt.Errorf("%w in the agent cache %s and no repository is configured", err, f.CacheDir)
+		}
+		return "", fmt.Errorf("%w in the agent cache %s", err, f.CacheDir)
+	}
+	fmt.Printf("📦 Agent %s is in the cache: %s\n", release.Version, release.URL)
+	return release.URL, nil
+}
+
+// get reads a small file of the repository
+func (f *Fetcher) get(u string) ([]byte, error) {
+	resp, err := f.client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("agent repository %s can't be reached: %w", f.redacted(), err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", redactString(u), errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to read %s: %s", redactString(u), resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+}
+
+// redacted returns the repository URL without its password
+func (f *Fetcher) redacted() string {
+	return redactString(f.Repository)
+}
+
+// redactString returns a URL without its password
+func redactString(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// rangeStart returns where a Content-Range starts, -1 when it's invalid
+func rangeStart(contentRange string) int64 {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// rangeSize returns the complete length from a Content-Range header, like
+// the 42 of "bytes */42", -1 when it's missing or unknown
+func rangeSize(contentRange string) int64 {
+	_, size, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// prerelease tells whether a version has a part that isn't a number, like
+// 2.0.0-rc.1
+func prerelease(version string) bool {
+	for _, part := range versionParts(version) {
+		if _, err := strconv.Atoi(part); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package agent_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+)
+
+// repository serves agent releases in the Maven layout under /maven and
+// with an index under /index
+type repository struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	requests []string // path and Range of each request
+
+	// wholeRanges answers every range with the file from its start, like a
+	// proxy that rewrites them
+	wholeRanges bool
+}
+
+func newRepository(t *testing.T, versions ...string) *repository {
+	repo := &repository{files: make(map[string][]byte)}
+	metadata := "<metadata><artifactId>middleware-javaagent</artifactId><versioning><versions>"
+	var index []string
+	for _, version := range versions {
+		path := filepath.Join(t.TempDir(), "agent.jar")
+		writeAgent(t, path, version, "release")
+		jar, _ := os.ReadFile(path)
+		digest := sha256.Sum256(jar)
+		sum := hex.EncodeToString(digest[:])
+		name := "middleware-javaagent-" + version + ".jar"
+
+		repo.files["/maven/"+version+"/"+name] = jar
+		repo.files["/maven/"+version+"/"+name+".sha256"] = []byte(sum + "\n")
+		repo.files["/index/"+name] = jar
+		metadata += "<version>" + version + "</version>"
+		index = append(index, fmt.Sprintf(`{"version": %q, "url": %q, "sha256": %q}`, version, name, sum))
+	}
+	repo.files["/maven/maven-metadata.xml"] = []byte(metadata + "</versions></versioning></metadata>")
+	repo.files["/index/index.json"] = []byte(`{"releases": [` + strings.Join(index, ",") + `]}`)
+	return repo
+}
+
+func (repo *repository) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repo.mu.Lock()
+	repo.requests = append(repo.requests, strings.TrimSpace(r.URL.Path+" "+r.Header.Get("Range")))
+	data, ok := repo.files[r.URL.Path]
+	repo.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if repo.wholeRanges && r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(r.URL.Path), time.Time{}, bytes.NewReader(data))
+}
+
+// downloads returns the requests for agent JARs
+func (repo *repository) downloads() []string {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var jars []string
+	for _, request := range repo.requests {
+		if path, _, _ := strings.Cut(request, " "); strings.HasSuffix(path, ".jar") {
+			jars = append(jars, request)
+		}
+	}
+	return jars
+}
+
+func TestFetch(t *testing.T) {
+	repo := newRepository(t, "1.9.0", "1.10.0", "1.11.0-rc.1")
+	server := httptest.NewServer(repo)
+	defer server.Close()
+
+	for _, repository := range []string{server.URL + "/maven", server.URL + "/index/index.json"} {
+		t.Run(repository, func(t *testing.T) {
+			fetcher, err := agent.NewFetcher(repository, t.TempDir(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The newest release, not the release candidate
+			path, err := fetcher.Fetch(agent.Latest)
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if version, err := agent.JarVersion(path); err != nil || version != "1.10.0" {
+				t.Errorf("Fetch() = %s declaring %q, %v, want 1.10.0", path, version, err)
+			}
+			if path, err := fetcher.Fetch("1.11.0-rc.1"); err != nil || filepath.Base(filepath.Dir(path)) != "1.11.0-rc.1" {
+				t.Errorf("Fetch(1.11.0-rc.1) = %s, %v", path, err)
+			}
+			if _, err := fetcher.Fetch("2.0.0"); err == nil {
+				t.Errorf("Fetch() of a version the repository doesn't have succeeded")
+			}
+
+			downloads := len(repo.downloads())
+			if again, err := fetcher.Fetch("1.10.0"); err != nil || again != path {
+				t.Errorf("Fetch() again = %s, %v, want %s", again, err, path)
+			}
+			if len(repo.downloads()) != downloads {
+				t.Errorf("Fetch() downloaded the cached agent again")
+			}
+		})
+	}
+
+	// The sums the Maven layout publishes are kept next to the agent
+	fetcher, _ := agent.NewFetcher(server.URL+"/maven", t.TempDir(), "")
+	path, err := fetcher.Fetch("1.9.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".sha256"); err != nil {
+		t.Errorf("Fetch() didn't cache the checksum: %v", err)
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	repo := newRepository(t, "1.9.0")
+	server := httptest.NewServer(repo)
+	defer server.Close()
+	jar := repo.files["/maven/1.9.0/middleware-javaagent-1.9.0.jar"]
+	half := len(jar) / 2
+
+	tests := []struct {
+		name        string
+		part        []byte
+		wholeRanges bool
+		want        []string // JAR requests
+	}{
+		{"Interrupted", jar[:half], false, []string{fmt.Sprintf("bytes=%d-", half)}},
+		{"Another build", bytes.Repeat([]byte{'x'}, half), false, []string{fmt.Sprintf("bytes=%d-", half), ""}},
+		{"Longer build", bytes.Repeat([]byte{'x'}, len(jar)+10), false, []string{fmt.Sprintf("bytes=%d-", len(jar)+10), ""}},
+		{"Other range", jar[:half], true, []string{fmt.Sprintf("bytes=%d-", half), ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.requests = nil
+			repo.wholeRanges = tt.wholeRanges
+			cache := t.TempDir()
+			target := filepath.Join(cache, "1.9.0", "middleware-javaagent-1.9.0.jar")
+			os.MkdirAll(filepath.Dir(target), 0o755)
+			os.WriteFile(target+".part", tt.part, 0o644)
+
+			fetcher, _ := agent.NewFetcher(server.URL+"/maven", cache, "")
+			path, err := fetcher.Fetch("1.9.0")
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if data, _ := os.ReadFile(path); path != target || !bytes.Equal(data, jar) {
+				t.Errorf("Fetch() = %s, not the agent", path)
+			}
+			if _, err := os.Stat(target + ".part"); !os.IsNotExist(err) {
+				t.Errorf("Fetch() left the partial download")
+			}
+
+			var ranges []string
+			for _, request := range repo.downloads() {
+				_, r, _ := strings.Cut(request, " ")
+				ranges = append(ranges, r)
+			}
+			if strings.Join(ranges, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Fetch() requested ranges %q, want %q", ranges, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	repo := newRepository(t, "1.9.0")
+	repo.files["/maven/1.9.0/middleware-javaagent-1.9.0.jar.sha256"] = []byte(strings.Repeat("0", 64))
+	delete(repo.files, "/index/index.json")
+	server := httptest.NewServer(repo)
+	defer server.Close()
+
+	// A partial file as long as the agent looks complete to the server
+	cache := t.TempDir()
+	jar := repo.files["/maven/1.9.0/middleware-javaagent-1.9.0.jar"]
+	os.MkdirAll(filepath.Join(cache, "1.9.0"), 0o755)
+	os.WriteFile(filepath.Join(cache, "1.9.0", "middleware-javaagent-1.9.0.jar.part"), bytes.Repeat([]byte{'x'}, len(jar)), 0o644)
+
+	fetcher, _ := agent.NewFetcher(server.URL+"/maven", cache, "")
+	if _, err := fetcher.Fetch("1.9.0"); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("Fetch() error = %v, want a checksum mismatch", err)
+	}
+	for _, kept := range []string{"middleware-javaagent-1.9.0.jar", "middleware-javaagent-1.9.0.jar.part"} {
+		if _, err := os.Stat(filepath.Join(cache, "1.9.0", kept)); !os.IsNotExist(err) {
+			t.Errorf("Fetch() kept %s", kept)
+		}
+	}
+
+	// Nothing to check the download against
+	delete(repo.files, "/maven/1.9.0/middleware-javaagent-1.9.0.jar.sha256")
+	if _, err := fetcher.Fetch("1.9.0"); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Errorf("Fetch() error = %v, want no checksum", err)
+	}
+}
+
+func TestFetchOffline(t *testing.T) {
+	repo := newRepository(t, "1.9.0", "1.10.0")
+	server := httptest.NewServer(repo)
+
+	// Repository URLs name a host the proxy reaches
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		repo.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	cache := t.TempDir()
+	fetcher, err := agent.NewFetcher("http://repo.example.invalid/maven", cache, proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := fetcher.Fetch(agent.Latest)
+	if err != nil {
+		t.Fatalf("Fetch() through the proxy error = %v", err)
+	}
+	if len(proxied) == 0 || proxied[0] != "repo.example.invalid" {
+		t.Errorf("proxy saw %v", proxied)
+	}
+
+	// The repository is gone, the cache is enough
+	server.Close()
+	offline, _ := agent.NewFetcher(server.URL+"/maven", cache, "")
+	if got, err := offline.Fetch(agent.Latest); err != nil || got != path {
+		t.Errorf("Fetch() offline = %s, %v, want %s", got, err, path)
+	}
+	if _, err := offline.Fetch("1.9.0"); err == nil {
+		t.Errorf("Fetch() offline of a version that isn't cached succeeded")
+	}
+	mirror, _ := agent.NewFetcher("", cache, "")
+	if got, err := mirror.Fetch(""); err != nil || got != path {
+		t.Errorf("Fetch() without a repository = %s, %v, want %s", got, err, path)
+	}
+
+	if _, err := agent.NewFetcher("ftp://repo.example.com", cache, ""); err == nil {
+		t.Errorf("NewFetcher() accepted an ftp repository")
+	}
+}
@@ -2,47 +2,61 @@ package agent
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 )
 
 // EnsureInstalled checks if the agent exists and is properly configured
 // Moved from main.go: EnsureAgentInstalled()
 func EnsureInstalled(sourcePath string, targetPath string) (string, error) {
-	// If source path is already the target location, just validate permissions
+	// If source path is already the target location, just validate it
 	if sourcePath == targetPath {
+		if _, err := agentjar.Validate(targetPath); err != nil {
+			return "", err
+		}
 		return targetPath, ValidatePermissions(targetPath)
 	}
 
 	// Check if target agent already exists
-	if _, err := os.Stat(targetPath); err == nil {
+	if changeset.Exists(targetPath) {
+		if _, err := agentjar.Validate(targetPath); err != nil {
+			return "", err
+		}
 		fmt.Printf("Agent already exists at %s\n", targetPath)
 		return targetPath, ValidatePermissions(targetPath)
 	}
 
+	// Refuse to install anything but a Java agent
+	jar, err := agentjar.Validate(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
 	// Create directory structure
 	targetDir := filepath.Dir(targetPath)
-	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+	if err := changeset.MkdirAll(targetDir, 0o755); err != nil {
 		return "", fmt.Errorf("failed to create agent directory: %w", err)
 	}
 
 	// Copy agent to target location
-	if err := copyAgentFile(sourcePath, targetPath); err != nil {
+	if err := changeset.CopyFile(sourcePath, targetPath, 0o644); err != nil {
 		return "", fmt.Errorf("failed to copy agent: %w", err)
 	}
 
 	// Set proper permissions
-	if err := os.Chmod(targetPath, 0o644); err != nil {
+	if err := changeset.Chmod(targetPath, 0o644); err != nil {
 		return "", fmt.Errorf("failed to set agent permissions: %w", err)
 	}
 
 	// Set ownership to root:root
-	if err := os.Chown(targetPath, 0, 0); err != nil {
+	if err := changeset.Chown(targetPath, 0, 0); err != nil {
 		return "", fmt.Errorf("failed to set agent ownership: %w", err)
 	}
 
-	fmt.Printf("✅ Agent installed to %s with proper permissions\n", targetPath)
+	fmt.Printf("✅ Agent %s installed to %s with proper permissions\n", versionOf(jar), targetPath)
 	return targetPath, nil
 }
 
@@ -61,7 +75,7 @@ func ValidatePermissions(agentPath string) error {
 		fmt.Printf("   Current permissions: %s\n", mode)
 		fmt.Printf("   Fixing permissions...\n")
 
-		if err := os.Chmod(agentPath, 0o644); err != nil {
+		if err := changeset.Chmod(agentPath, 0o644); err != nil {
 			return fmt.Errorf("failed to fix permissions: %w", err)
 		}
 		fmt.Printf("✅ Permissions fixed to 0644\n")
@@ -70,25 +84,6 @@ func ValidatePermissions(agentPath string) error {
 	return nil
 }
 
-// copyAgentFile copies the agent JAR from source to destination
-// Moved from main.go: copyAgent()
-func copyAgentFile(src, dst string) error {
-	source, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer source.Close()
-
-	destination, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destination.Close()
-
-	_, err = io.Copy(destination, source)
-	return err
-}
-
 // IsAccessible tests if a user can access the agent
 // Moved from main.go: CheckAgentAccessible()
 func IsAccessible(agentPath, username string) bool {
@@ -107,29 +102,35 @@ func IsAccessible(agentPath, username string) bool {
 func Install(config *InstallationConfig) error {
 	// Create target directory
 	targetDir := filepath.Dir(config.TargetPath)
-	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+	if err := changeset.MkdirAll(targetDir, 0o755); err != nil {
 		return fmt.Errorf("failed to create target directory: %w", err)
 	}
 
 	// Copy agent file
-	if err := copyAgentFile(config.SourcePath, config.TargetPath); err != nil {
+	if err := changeset.CopyFile(config.SourcePath, config.TargetPath, config.RequiredPerms); err != nil {
 		return fmt.Errorf("failed to copy agent: %w", err)
 	}
 
 	// Set permissions
-	if err := os.Chmod(config.TargetPath, config.RequiredPerms); err != nil {
+	if err := changeset.Chmod(config.TargetPath, config.RequiredPerms); err != nil {
 		return fmt.Errorf("failed to set permissions: %w", err)
 	}
 
 	// Set ownership
-	if err := os.Chown(config.TargetPath, config.OwnerUID, config.OwnerGID); err != nil {
+	if err := changeset.Chown(config.TargetPath, config.OwnerUID, config.OwnerGID); err != nil {
 		return fmt.Errorf("failed to set ownership: %w", err)
 	}
 
 	return nil
 }
 
+// versionOf returns the declared version of an agent, "unknown" without one
+func versionOf(jar *agentjar.Info) string {
+	if jar.Version == "" {
+		return "unknown"
+	}
+	return jar.Version
+}
+
 // TODO: Add functions for:
-// - Agent version detection from JAR manifest
-// - Agent signature validation
 // - Automatic agent updates
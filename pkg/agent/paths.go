@@ -5,9 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
 )
 
-// ValidateAgentPath checks if the agent path is valid (basic validation only)
+// ValidateAgentPath checks the agent exists and is a Java agent JAR
 func ValidateAgentPath(agentPath string) error {
 	// Check if file exists
 	if _, err := os.Stat(agentPath); err != nil {
@@ -19,6 +21,11 @@ func ValidateAgentPath(agentPath string) error {
 		return fmt.Errorf("agent file must be a .jar file: %s", agentPath)
 	}
 
+	// Check its manifest declares an agent
+	if _, err := agentjar.Validate(agentPath); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -37,18 +44,24 @@ func ValidateAgentPathForUser(agentPath string, processOwner string) error {
 	return nil
 }
 
-// GetAgentInfo extracts information about an agent file
+// GetAgentInfo extracts information about an agent file from its manifest
 func GetAgentInfo(agentPath string) (*Agent, error) {
 	info, err := os.Stat(agentPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agent info: %w", err)
 	}
+	jar, err := agentjar.Read(agentPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent manifest: %w", err)
+	}
 
 	return &Agent{
-		Path:    agentPath,
-		Name:    filepath.Base(agentPath),
-		Version: "unknown", // TODO: Extract version from JAR manifest
-		Size:    info.Size(),
-		ModTime: info.ModTime(),
+		Path:         agentPath,
+		Name:         filepath.Base(agentPath),
+		Version:      versionOf(jar),
+		SDKVersion:   jar.SDKVersion,
+		PremainClass: jar.PremainClass,
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
 	}, nil
 }
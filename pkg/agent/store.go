@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+// versionedName is how releases name the agent, the version is taken from
+// it when the manifest doesn't declare one
+var versionedName = regexp.MustCompile(`^middleware-javaagent-(.+)\.jar$`)
+
+// Store keeps agent versions side by side, each as <dir>/<version>/JarName.
+// The current link points at the version instrumentation uses, so switching
+// versions doesn't touch the configs referencing it.
+type Store struct {
+	Dir string
+}
+
+// NewStore returns the agent store in dir
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Path returns where a version of the agent is kept
+func (s *Store) Path(version string) string {
+	return filepath.Join(s.Dir, version, JarName)
+}
+
+// CurrentPath returns the stable path of the current agent
+func (s *Store) CurrentPath() string {
+	return s.Path(CurrentLink)
+}
+
+// Current returns the version the current link points at, empty if none
+func (s *Store) Current() string {
+	target, err := os.Readlink(filepath.Join(s.Dir, CurrentLink))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// Versions returns the installed versions, oldest first
+func (s *Store) Versions() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read agent store: %w", err)
+	}
+	var versions []string
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == CurrentLink {
+			continue
+		}
+		if _, err := os.Stat(s.Path(e.Name())); err == nil {
+			versions = append(versions, e.Name())
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+	return versions, nil
+}
+
+// VersionOf resolves a path of the store to the version it holds, empty
+// when the path is outside the store
+func (s *Store) VersionOf(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return s.versionAt(resolved)
+}
+
+// LoadedVersion returns the version of the store a JVM has open, empty when
+// it has none open. The JVM keeps the agent open, and the open file is the
+// version the current link pointed at when the JVM started.
+func (s *Store) LoadedVersion(pid int32) string {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	fds, err := os.ReadDir(fdDir)
+	if err != nil {
+		return ""
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		// Pruned while still loaded
+		if version := s.versionAt(strings.TrimSuffix(target, " (deleted)")); version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+// versionAt returns the version a resolved path holds
+func (s *Store) versionAt(resolved string) string {
+	dir, err := filepath.EvalSymlinks(s.Dir)
+	if err != nil {
+		dir = s.Dir
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil {
+		return ""
+	}
+	version, name, found := strings.Cut(rel, string(filepath.Separator))
+	if !found || name != JarName || !ValidVersion(version) {
+		return ""
+	}
+	return version
+}
+
+// Install copies an agent into the store under the version it declares and
+// returns the version. A version already installed is kept, unless it
+// isn't the same agent.
+func (s *Store) Install(sourcePath string) (string, error) {
+	version, err := JarVersion(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	target := s.Path(version)
+	if s.VersionOf(sourcePath) == version {
+		return version, nil
+	}
+
+	// A dry run may only have recorded the copy
+	if _, err := os.Stat(target); err == nil {
+		installed, err := Checksum(target)
+		if err != nil {
+			return "", err
+		}
+		source, err := Checksum(sourcePath)
+		if err != nil {
+			return "", err
+		}
+		if installed != source {
+			return "", fmt.Errorf("a different agent %s is already installed at %s, remove it with mw-injector agent prune before installing %s", version, target, sourcePath)
+		}
+	}
+
+	if _, err := EnsureInstalled(sourcePath, target); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// Use points the current link at an installed version
+func (s *Store) Use(version string) error {
+	if !ValidVersion(version) {
+		return fmt.Errorf("invalid agent version %q", version)
+	}
+	if !changeset.Exists(s.Path(version)) {
+		return fmt.Errorf("agent %s is not installed", version)
+	}
+	// Relative, so the store can be moved or mounted elsewhere
+	if err := changeset.Symlink(version, filepath.Join(s.Dir, CurrentLink)); err != nil {
+		return fmt.Errorf("failed to switch the current agent: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes an installed version, refusing the current one
+func (s *Store) Remove(version string) error {
+	if !ValidVersion(version) {
+		return fmt.Errorf("invalid agent version %q", version)
+	}
+	if version == s.Current() {
+		return fmt.Errorf("agent %s is the current one", version)
+	}
+	dir := filepath.Join(s.Dir, version)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("agent %s is not installed", version)
+	}
+	for _, e := range entries {
+		if err := changeset.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("failed to remove agent %s: %w", version, err)
+		}
+	}
+	if err := changeset.Remove(dir); err != nil {
+		return fmt.Errorf("failed to remove agent %s: %w", version, err)
+	}
+	return nil
+}
+
+// JarVersion returns the version an agent is stored under: the one its
+// manifest declares, or the one in its release name
+func JarVersion(jarPath string) (string, error) {
+	jar, err := agentjar.Validate(jarPath)
+	if err != nil {
+		return "", err
+	}
+	version := jar.Version
+	if version == "" {
+		if m := versionedName.FindStringSubmatch(filepath.Base(jarPath)); m != nil {
+			version = m[1]
+		}
+	}
+	if version == "" {
+		return "", fmt.Errorf("%s declares no Implementation-Version, it can't be stored side by side with other versions", jarPath)
+	}
+	if !ValidVersion(version) {
+		return "", fmt.Errorf("%s declares the invalid version %q", jarPath, version)
+	}
+	return version, nil
+}
+
+// ContextMapVersion is the first agent release that names Tomcat webapps
+// by MW_SERVICE_NAME_CONTEXT_MAP, set when building a release:
+//
+//	go build -ldflags "-X github.com/middleware-labs/java-injector/pkg/agent.ContextMapVersion=<version>"
+var ContextMapVersion string
+
+// SupportsContextMap checks if an agent names webapps by their context path.
+// Without ContextMapVersion no agent does, webapps report under the instance.
+func SupportsContextMap(jarPath string) bool {
+	if ContextMapVersion == "" {
+		return false
+	}
+	version, err := JarVersion(jarPath)
+	return err == nil && CompareVersions(version, ContextMapVersion) >= 0
+}
+
+// ValidVersion checks a version can name a directory of the store
+func ValidVersion(version string) bool {
+	return version != "" && version != "." && version != ".." && version != CurrentLink &&
+		!strings.ContainsAny(version, "/\\ ")
+}
+
+// CompareVersions orders versions by their numeric parts, 1.10.0 after
+// 1.9.0. Parts that aren't numbers compare as text, before numbers.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case pa[i] == pb[i], errA == nil && errB == nil && na == nb:
+		case errA == nil && errB == nil:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case errA != nil && errB != nil:
+			return strings.Compare(pa[i], pb[i])
+		case errA != nil:
+			// A pre-release like rc.1 comes before a release part
+			return -1
+		default:
+			return 1
+		}
+	}
+	if len(pa) == len(pb) {
+		return 0
+	}
+	// 2.0.0-rc.1 comes before 2.0.0, 2.0.0.1 after it
+	longer, order := pa, 1
+	if len(pb) > len(pa) {
+		longer, order = pb, -1
+	}
+	if _, err := strconv.Atoi(longer[min(len(pa), len(pb))]); err != nil {
+		return -order
+	}
+	return order
+}
+
+// versionParts splits a version into the parts CompareVersions compares
+func versionParts(version string) []string {
+	return strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+}
@@ -0,0 +1,116 @@
+package agent_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
+)
+
+// writeAgent writes an agent JAR declaring a version, content tells builds
+// of the same version apart
+func writeAgent(t *testing.T, path, version, content string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	w, _ := archive.Create(agentjar.ManifestPath)
+	w.Write([]byte("Premain-Class: Agent\nImplementation-Version: " + version + "\n"))
+	w, _ = archive.Create("build.txt")
+	w.Write([]byte(content))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := agent.NewStore(filepath.Join(t.TempDir(), "agents"))
+	src := t.TempDir()
+	for _, version := range []string{"1.9.0", "1.10.0"} {
+		writeAgent(t, filepath.Join(src, version+".jar"), version, "release")
+		if installed, err := store.Install(filepath.Join(src, version+".jar")); err != nil || installed != version {
+			t.Fatalf("Install(%s) = %q, %v", version, installed, err)
+		}
+	}
+
+	// The same version built differently isn't silently kept
+	writeAgent(t, filepath.Join(src, "rebuilt.jar"), "1.9.0", "rebuilt")
+	if _, err := store.Install(filepath.Join(src, "rebuilt.jar")); err == nil || !strings.Contains(err.Error(), "different agent") {
+		t.Errorf("Install() of another 1.9.0 error = %v", err)
+	}
+
+	if versions, _ := store.Versions(); strings.Join(versions, " ") != "1.9.0 1.10.0" {
+		t.Errorf("Versions() = %v, want 1.9.0 1.10.0", versions)
+	}
+
+	if store.Current() != "" {
+		t.Errorf("Current() = %q before Use()", store.Current())
+	}
+	for _, version := range []string{"1.9.0", "1.10.0"} {
+		if err := store.Use(version); err != nil {
+			t.Fatalf("Use(%s) error = %v", version, err)
+		}
+		if store.Current() != version || store.VersionOf(store.CurrentPath()) != version {
+			t.Errorf("after Use(%s) current is %q, resolving to %q", version, store.Current(), store.VersionOf(store.CurrentPath()))
+		}
+	}
+	if err := store.Use("2.0.0"); err == nil {
+		t.Errorf("Use() of a version that isn't installed succeeded")
+	}
+
+	if err := store.Remove("1.10.0"); err == nil {
+		t.Errorf("Remove() of the current version succeeded")
+	}
+	if err := store.Remove("1.9.0"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if versions, _ := store.Versions(); strings.Join(versions, " ") != "1.10.0" {
+		t.Errorf("Versions() after Remove() = %v", versions)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"1.8.1", "1.9.0", "1.10.0-rc.1", "1.10.0", "1.10.0.1", "2.0.0"}
+	for i := range ordered {
+		for j := range ordered {
+			got := agent.CompareVersions(ordered[i], ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got != want {
+				t.Errorf("CompareVersions(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestSupportsContextMap(t *testing.T) {
+	dir := t.TempDir()
+	older := filepath.Join(dir, "older.jar")
+	newer := filepath.Join(dir, "newer.jar")
+	writeAgent(t, older, "2.3.0", "a")
+	writeAgent(t, newer, "2.10.0", "b")
+
+	if agent.SupportsContextMap(newer) {
+		t.Error("SupportsContextMap() = true without ContextMapVersion")
+	}
+
+	defer func(version string) { agent.ContextMapVersion = version }(agent.ContextMapVersion)
+	agent.ContextMapVersion = "2.4.0"
+	if agent.SupportsContextMap(older) {
+		t.Error("SupportsContextMap(2.3.0) = true, want false")
+	}
+	if !agent.SupportsContextMap(newer) {
+		t.Error("SupportsContextMap(2.10.0) = false, want true")
+	}
+}
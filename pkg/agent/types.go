@@ -5,13 +5,36 @@ import (
 	"time"
 )
 
+const (
+	// DefaultDir is where the agent is installed
+	DefaultDir = "/opt/middleware/agents"
+
+	// CurrentLink names the link to the version of the agent in use
+	CurrentLink = "current"
+
+	// JarName is the name of the agent in each version directory
+	JarName = "middleware-javaagent.jar"
+
+	// DefaultName is the agent installed when no other is configured
+	DefaultName = JarName
+
+	// DefaultPath is the current agent, the path instrumentation references
+	DefaultPath = DefaultDir + "/" + CurrentLink + "/" + JarName
+
+	// LegacyPath is where the agent was installed before versions were kept
+	// side by side
+	LegacyPath = DefaultDir + "/middleware-javaagent-1.8.1.jar"
+)
+
 // Agent represents information about a Java agent
 type Agent struct {
-	Path    string
-	Name    string
-	Version string
-	Size    int64
-	ModTime time.Time
+	Path         string
+	Name         string
+	Version      string // Implementation-Version of the manifest, "unknown" when missing
+	SDKVersion   string // bundled OpenTelemetry SDK, empty when unknown
+	PremainClass string
+	Size         int64
+	ModTime      time.Time
 }
 
 // InstallationConfig holds configuration for agent installation
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How an agent was verified
+const (
+	VerifiedSignature = "signature" // listed in a checksum manifest signed by a trusted key
+	VerifiedChecksum  = "checksum"  // listed in the checksum manifest the config names
+	VerifiedPinned    = "pinned"    // matches the SHA-256 the config pins
+	Unverified        = "unverified"
+)
+
+const (
+	// ChecksumsFile is the checksum manifest published next to agent
+	// releases, in sha256sum format
+	ChecksumsFile = "SHA256SUMS"
+
+	// SignatureSuffix names the detached signature of a manifest, the
+	// base64 ed25519 signature of its content
+	SignatureSuffix = ".sig"
+)
+
+// ReleaseKey is the base64 ed25519 public key agent releases are signed
+// with, set when building a release:
+//
+//	go build -ldflags "-X github.com/middleware-labs/java-injector/pkg/agent.ReleaseKey=<key>"
+var ReleaseKey string
+
+// ErrUnverified means nothing trusted vouches for an agent. A checksum or
+// signature that doesn't match is a different error.
+var ErrUnverified = errors.New("agent is not verified")
+
+// VerifyOptions are the trust anchors configured besides ReleaseKey
+type VerifyOptions struct {
+	SHA256     string   // checksum the agent must have, MW_AGENT_SHA256
+	Checksums  string   // checksum manifest to trust, MW_AGENT_CHECKSUMS
+	PublicKeys []string // base64 keys or files holding one, MW_AGENT_PUBLIC_KEY
+}
+
+// Verification is how an agent was verified
+type Verification struct {
+	Method string
+	SHA256 string
+	Source string // the manifest listing the agent
+}
+
+// Checksum returns the hex SHA-256 of a file
+func Checksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify checks an agent against a pinned checksum, the configured checksum
+// manifest, or a signed manifest next to it: SHA256SUMS or <jar>.sha256,
+// with its .sig. A checksum manifest found next to the agent but not
+// signed, or signed without a key to check it, doesn't verify it, it comes
+// from the same place as the agent, so the next manifest is tried.
+func Verify(jarPath string, opts VerifyOptions) (*Verification, error) {
+	sum, err := Checksum(jarPath)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verification{Method: Unverified, SHA256: sum}
+	name := filepath.Base(jarPath)
+
+	if opts.SHA256 != "" {
+		if !strings.EqualFold(opts.SHA256, sum) {
+			return v, fmt.Errorf("%s has SHA-256 %s, the config pins %s", name, sum, opts.SHA256)
+		}
+		v.Method = VerifiedPinned
+		return v, nil
+	}
+
+	keys, err := trustedKeys(opts.PublicKeys)
+	if err != nil {
+		return v, err
+	}
+
+	manifests := []string{filepath.Join(filepath.Dir(jarPath), ChecksumsFile), jarPath + ".sha256"}
+	if opts.Checksums != "" {
+		manifests = []string{opts.Checksums}
+	}
+	var unsigned error
+	for _, manifest := range manifests {
+		data, err := os.ReadFile(manifest)
+		if err != nil {
+			if opts.Checksums != "" {
+				return v, fmt.Errorf("failed to read checksum manifest: %w", err)
+			}
+			continue
+		}
+		expected, found := lookupChecksum(string(data), manifest, name)
+		if !found {
+			if opts.Checksums != "" {
+				return v, fmt.Errorf("%w: %s isn't listed in %s", ErrUnverified, name, manifest)
+			}
+			continue
+		}
+		if !strings.EqualFold(expected, sum) {
+			return v, fmt.Errorf("%s has SHA-256 %s, %s lists %s", name, sum, manifest, expected)
+		}
+		v.Source = manifest
+
+		signature, err := os.ReadFile(manifest + SignatureSuffix)
+		switch {
+		case err == nil && len(keys) > 0:
+			if err := checkSignature(data, signature, keys); err != nil {
+				return v, fmt.Errorf("%s%s: %w", manifest, SignatureSuffix, err)
+			}
+			v.Method = VerifiedSignature
+		case opts.Checksums != "":
+			v.Method = VerifiedChecksum
+		case err == nil:
+			// Tells what's missing, so it wins over an unsigned manifest
+			unsigned = fmt.Errorf("%w: %s is signed but no public key is configured, set MW_AGENT_PUBLIC_KEY", ErrUnverified, manifest)
+			continue
+		default:
+			if unsigned == nil {
+				unsigned = fmt.Errorf("%w: %s matches %s, which isn't signed", ErrUnverified, name, manifest)
+			}
+			continue
+		}
+		return v, nil
+	}
+	if unsigned != nil {
+		return v, unsigned
+	}
+	return v, fmt.Errorf("%w: no checksum manifest lists %s", ErrUnverified, name)
+}
+
+// lookupChecksum finds a file in a sha256sum manifest. A single checksum
+// without a name is only accepted from <name>.sha256, the manifest of that
+// one file.
+func lookupChecksum(data, manifest, name string) (string, bool) {
+	single := filepath.Base(manifest) == name+".sha256"
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case single && len(fields) == 1 && len(fields[0]) == sha256.Size*2:
+			return fields[0], true
+		case len(fields) == 2 && strings.TrimPrefix(filepath.Base(fields[1]), "*") == name:
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// trustedKeys parses ReleaseKey and the configured keys
+func trustedKeys(configured []string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, value := range append([]string{ReleaseKey}, configured...) {
+		if value == "" {
+			continue
+		}
+		key, err := parseKey(value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseKey parses a base64 ed25519 public key, or the file holding one,
+// ignoring comment lines
+func parseKey(value string) (ed25519.PublicKey, error) {
+	source := "public key"
+	if data, err := os.ReadFile(value); err == nil {
+		source, value = value, ""
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				value = line
+			}
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid %s: want a base64 ed25519 public key", source)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// checkSignature checks a detached signature was made by one of the keys
+func checkSignature(data, signature []byte, keys []ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("not a base64 ed25519 signature")
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature doesn't match any trusted key")
+}
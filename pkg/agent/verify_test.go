@@ -0,0 +1,152 @@
+package agent_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+)
+
+func TestVerify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	key := base64.StdEncoding.EncodeToString(publicKey)
+
+	// release writes an agent and, unless empty, a manifest listing it with
+	// sum and the manifest's signature
+	release := func(t *testing.T, sum string, sign ed25519.PrivateKey) string {
+		dir := t.TempDir()
+		jar := filepath.Join(dir, "middleware-javaagent-1.9.0.jar")
+		if err := os.WriteFile(jar, []byte("agent"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if sum == "" {
+			return jar
+		}
+		manifest := []byte(fmt.Sprintf("%s  other.jar\n%s  middleware-javaagent-1.9.0.jar\n", strings.Repeat("0", 64), sum))
+		os.WriteFile(filepath.Join(dir, agent.ChecksumsFile), manifest, 0o644)
+		if sign != nil {
+			signature := base64.StdEncoding.EncodeToString(ed25519.Sign(sign, manifest))
+			os.WriteFile(filepath.Join(dir, agent.ChecksumsFile+agent.SignatureSuffix), []byte(signature+"\n"), 0o644)
+		}
+		return jar
+	}
+	digest := sha256.Sum256([]byte("agent"))
+	good := hex.EncodeToString(digest[:])
+	otherSigner := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+	tests := []struct {
+		name   string
+		jar    string
+		opts   agent.VerifyOptions
+		method string
+		err    string // empty for none, "unverified" for ErrUnverified
+	}{
+		{"Signed manifest", release(t, good, privateKey), agent.VerifyOptions{PublicKeys: []string{key}}, agent.VerifiedSignature, ""},
+		{"Signed by another key", release(t, good, otherSigner), agent.VerifyOptions{PublicKeys: []string{key}}, "", "doesn't match any trusted key"},
+		{"Signed but no key", release(t, good, privateKey), agent.VerifyOptions{}, "", "unverified"},
+		{"Unsigned manifest", release(t, good, nil), agent.VerifyOptions{PublicKeys: []string{key}}, "", "unverified"},
+		{"Checksum mismatch", release(t, strings.Repeat("f", 64), privateKey), agent.VerifyOptions{PublicKeys: []string{key}}, "", "lists"},
+		{"No manifest", release(t, "", nil), agent.VerifyOptions{}, "", "unverified"},
+		{"Pinned", release(t, "", nil), agent.VerifyOptions{SHA256: strings.ToUpper(good)}, agent.VerifiedPinned, ""},
+		{"Pinned mismatch", release(t, good, privateKey), agent.VerifyOptions{SHA256: strings.Repeat("f", 64), PublicKeys: []string{key}}, "", "pins"},
+		{"Invalid key", release(t, good, privateKey), agent.VerifyOptions{PublicKeys: []string{base64.StdEncoding.EncodeToString(otherKey[:16])}}, "", "invalid public key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := agent.Verify(tt.jar, tt.opts)
+			switch {
+			case tt.err == "":
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				if v.Method != tt.method || v.SHA256 != good {
+					t.Errorf("Verify() = %s %s, want %s %s", v.Method, v.SHA256, tt.method, good)
+				}
+			case tt.err == "unverified":
+				if !errors.Is(err, agent.ErrUnverified) {
+					t.Errorf("Verify() error = %v, want ErrUnverified", err)
+				}
+			default:
+				if err == nil || errors.Is(err, agent.ErrUnverified) || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Verify() error = %v, want a failure containing %q", err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyManifests(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	opts := agent.VerifyOptions{PublicKeys: []string{base64.StdEncoding.EncodeToString(publicKey)}}
+	dir := t.TempDir()
+	jar := filepath.Join(dir, "middleware-javaagent-1.9.0.jar")
+	os.WriteFile(jar, []byte("agent"), 0o644)
+	sum, _ := agent.Checksum(jar)
+	sign := func(path string, data []byte) {
+		os.WriteFile(path, data, 0o644)
+		os.WriteFile(path+agent.SignatureSuffix, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))), 0o644)
+	}
+
+	// An unsigned SHA256SUMS doesn't stop the signed <jar>.sha256 from
+	// verifying the agent
+	os.WriteFile(filepath.Join(dir, agent.ChecksumsFile), []byte(sum+"  middleware-javaagent-1.9.0.jar\n"), 0o644)
+	sign(jar+".sha256", []byte(sum+"\n"))
+	v, err := agent.Verify(jar, opts)
+	if err != nil || v.Method != agent.VerifiedSignature || v.Source != jar+".sha256" {
+		t.Errorf("Verify() = %+v, %v, want verified by the signed %s.sha256", v, err, filepath.Base(jar))
+	}
+
+	// A checksum without a name only counts in <jar>.sha256
+	os.Remove(jar + ".sha256")
+	sign(filepath.Join(dir, agent.ChecksumsFile), []byte(sum+"\n"))
+	if _, err := agent.Verify(jar, opts); !errors.Is(err, agent.ErrUnverified) {
+		t.Errorf("Verify() error = %v for a %s without names, want ErrUnverified", err, agent.ChecksumsFile)
+	}
+
+	// Without a key a signed SHA256SUMS is passed over like an unsigned one,
+	// and the error tells the key is missing
+	sign(filepath.Join(dir, agent.ChecksumsFile), []byte(sum+"  middleware-javaagent-1.9.0.jar\n"))
+	os.WriteFile(jar+".sha256", []byte(sum+"\n"), 0o644)
+	if _, err := agent.Verify(jar, agent.VerifyOptions{}); !errors.Is(err, agent.ErrUnverified) || !strings.Contains(err.Error(), "MW_AGENT_PUBLIC_KEY") {
+		t.Errorf("Verify() error = %v without a key, want ErrUnverified naming MW_AGENT_PUBLIC_KEY", err)
+	}
+}
+
+func TestVerifyConfiguredManifest(t *testing.T) {
+	dir := t.TempDir()
+	jar := filepath.Join(dir, "agent.jar")
+	os.WriteFile(jar, []byte("agent"), 0o644)
+	sum, _ := agent.Checksum(jar)
+
+	// A manifest the config names is trusted without a signature
+	manifest := filepath.Join(t.TempDir(), "checksums.txt")
+	os.WriteFile(manifest, []byte(sum+" *agent.jar\n"), 0o644)
+	v, err := agent.Verify(jar, agent.VerifyOptions{Checksums: manifest})
+	if err != nil || v.Method != agent.VerifiedChecksum {
+		t.Errorf("Verify() = %+v, %v, want verified by checksum", v, err)
+	}
+
+	// Also when it's signed and no key is configured
+	os.WriteFile(manifest+agent.SignatureSuffix, []byte("c2lnbmF0dXJl\n"), 0o644)
+	if v, err := agent.Verify(jar, agent.VerifyOptions{Checksums: manifest}); err != nil || v.Method != agent.VerifiedChecksum {
+		t.Errorf("Verify() = %+v, %v for a signed manifest without a key, want verified by checksum", v, err)
+	}
+
+	os.WriteFile(manifest, []byte(sum+"  another.jar\n"), 0o644)
+	if _, err := agent.Verify(jar, agent.VerifyOptions{Checksums: manifest}); !errors.Is(err, agent.ErrUnverified) {
+		t.Errorf("Verify() error = %v for an agent the manifest doesn't list, want ErrUnverified", err)
+	}
+}
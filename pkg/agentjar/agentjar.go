@@ -0,0 +1,172 @@
+// Package agentjar reads what a Java agent JAR says about itself: the
+// attributes of its manifest and the OpenTelemetry SDK it bundles
+package agentjar
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestPath is the manifest inside a JAR
+const ManifestPath = "META-INF/MANIFEST.MF"
+
+// sdkAttributes are manifest attributes that may hold the version of the
+// bundled OpenTelemetry SDK, in order of preference
+var sdkAttributes = []string{
+	"OpenTelemetry-SDK-Version",
+	"Otel-SDK-Version",
+	"OpenTelemetry-Instrumentation-Version",
+}
+
+// sdkProperties are the Maven properties of the OpenTelemetry SDK, kept in
+// the JAR by most shading setups
+const sdkProperties = "io.opentelemetry/opentelemetry-sdk/pom.properties"
+
+// Info is what an agent JAR declares about itself
+type Info struct {
+	Title        string            // Implementation-Title
+	Vendor       string            // Implementation-Vendor
+	Version      string            // Implementation-Version, empty when not declared
+	PremainClass string            // the class the JVM starts with -javaagent
+	SDKVersion   string            // version of the bundled OpenTelemetry SDK, empty when unknown
+	Attributes   map[string]string // all main attributes of the manifest
+}
+
+// IsAgent tells whether the JAR can be loaded with -javaagent
+func (i *Info) IsAgent() bool {
+	return i.PremainClass != ""
+}
+
+// cached is a read JAR, valid while the file isn't changed
+type cached struct {
+	size    int64
+	modTime time.Time
+	info    *Info
+}
+
+var cache sync.Map // path to cached
+
+// Read reads an agent JAR. Reading the archive checks its structure, reading
+// the manifest its checksum. Results are cached until the file changes.
+func Read(jarPath string) (*Info, error) {
+	stat, err := os.Stat(jarPath)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := cache.Load(jarPath); ok {
+		c := c.(cached)
+		if c.size == stat.Size() && c.modTime.Equal(stat.ModTime()) {
+			return c.info, nil
+		}
+	}
+
+	info, err := read(jarPath)
+	if err != nil {
+		return nil, err
+	}
+	cache.Store(jarPath, cached{size: stat.Size(), modTime: stat.ModTime(), info: info})
+	return info, nil
+}
+
+func read(jarPath string) (*Info, error) {
+	archive, err := zip.OpenReader(jarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	data, err := readEntry(&archive.Reader, ManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("no %s", ManifestPath)
+	}
+	attributes := ParseManifest(string(data))
+
+	info := &Info{
+		Title:        attributes["Implementation-Title"],
+		Vendor:       attributes["Implementation-Vendor"],
+		Version:      attributes["Implementation-Version"],
+		PremainClass: attributes["Premain-Class"],
+		Attributes:   attributes,
+	}
+	for _, name := range sdkAttributes {
+		if info.SDKVersion = attributes[name]; info.SDKVersion != "" {
+			break
+		}
+	}
+	if info.SDKVersion == "" {
+		info.SDKVersion = sdkVersion(&archive.Reader)
+	}
+	return info, nil
+}
+
+// Validate reads a JAR and checks it is a Java agent
+func Validate(jarPath string) (*Info, error) {
+	if !strings.HasSuffix(jarPath, ".jar") {
+		return nil, fmt.Errorf("%s is not a .jar file", jarPath)
+	}
+	info, err := Read(jarPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid JAR: %w", jarPath, err)
+	}
+	if !info.IsAgent() {
+		return nil, fmt.Errorf("%s has no Premain-Class, it is not a Java agent", jarPath)
+	}
+	return info, nil
+}
+
+// ParseManifest parses the main attributes of a manifest
+func ParseManifest(manifest string) map[string]string {
+	attributes := make(map[string]string)
+	var last string
+	scanner := bufio.NewScanner(strings.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break // the main section ends at the first blank line
+		}
+		// Long values continue on lines starting with a space
+		if strings.HasPrefix(line, " ") && last != "" {
+			attributes[last] += line[1:]
+			continue
+		}
+		if key, value, found := strings.Cut(line, ":"); found {
+			last = strings.TrimSpace(key)
+			attributes[last] = strings.TrimSpace(value)
+		}
+	}
+	return attributes
+}
+
+// sdkVersion finds the OpenTelemetry SDK version in its Maven properties
+func sdkVersion(archive *zip.Reader) string {
+	for _, file := range archive.File {
+		if !strings.HasSuffix(file.Name, sdkProperties) {
+			continue
+		}
+		data, err := readEntry(archive, file.Name)
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if version, found := strings.CutPrefix(strings.TrimSpace(line), "version="); found {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+func readEntry(archive *zip.Reader, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
@@ -0,0 +1,119 @@
+package agentjar_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
+)
+
+// writeJar writes a JAR holding the given entries
+func writeJar(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	for name, content := range entries {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.jar")
+	writeJar(t, path, map[string]string{
+		agentjar.ManifestPath: "Manifest-Version: 1.0\r\n" +
+			"Premain-Class: io.opentelemetry.javaagent.OpenTelemet\r\n ryAgent\r\n" +
+			"Implementation-Title: Middleware Java Agent\r\n" +
+			"Implementation-Version: 1.9.0\r\n\r\n" +
+			"Name: inst/\r\nImplementation-Version: 0.0.0\r\n\r\n",
+		"META-INF/maven/io.opentelemetry/opentelemetry-sdk/pom.properties": "artifactId=opentelemetry-sdk\nversion=1.42.1\n",
+	})
+
+	info, err := agentjar.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PremainClass != "io.opentelemetry.javaagent.OpenTelemetryAgent" {
+		t.Errorf("PremainClass = %q, continuation line not joined", info.PremainClass)
+	}
+	if info.Version != "1.9.0" {
+		t.Errorf("Version = %q, want the main section's 1.9.0", info.Version)
+	}
+	if info.Title != "Middleware Java Agent" {
+		t.Errorf("Title = %q", info.Title)
+	}
+	if info.SDKVersion != "1.42.1" {
+		t.Errorf("SDKVersion = %q, want 1.42.1 from pom.properties", info.SDKVersion)
+	}
+
+	// A replaced JAR is read again
+	writeJar(t, path, map[string]string{
+		agentjar.ManifestPath: "Premain-Class: Agent\nImplementation-Version: 2.0.0\nOpenTelemetry-SDK-Version: 1.50.0\n",
+	})
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, err = agentjar.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "2.0.0" || info.SDKVersion != "1.50.0" {
+		t.Errorf("after replacing the JAR got version %q SDK %q, want 2.0.0 and 1.50.0", info.Version, info.SDKVersion)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	agent := filepath.Join(dir, "agent.jar")
+	writeJar(t, agent, map[string]string{agentjar.ManifestPath: "Premain-Class: Agent\n"})
+	library := filepath.Join(dir, "library.jar")
+	writeJar(t, library, map[string]string{agentjar.ManifestPath: "Manifest-Version: 1.0\n"})
+	bare := filepath.Join(dir, "bare.jar")
+	writeJar(t, bare, map[string]string{"Agent.class": ""})
+	corrupt := filepath.Join(dir, "corrupt.jar")
+	os.WriteFile(corrupt, []byte("not a zip"), 0o644)
+
+	tests := []struct {
+		name string
+		path string
+		err  string
+	}{
+		{"Agent", agent, ""},
+		{"Library", library, "no Premain-Class"},
+		{"No manifest", bare, "no META-INF/MANIFEST.MF"},
+		{"Corrupt", corrupt, "not a valid JAR"},
+		{"Not a JAR", filepath.Join(dir, "agent.zip"), "not a .jar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := agentjar.Validate(tt.path)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+// Package changeset routes the file writes and commands of instrumentation
+// through one place. Normally they are performed immediately. While
+// recording they only update an in-memory overlay and are collected as a
+// plan, which can be reviewed, saved and applied verbatim later.
+package changeset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Operation kinds
+const (
+	OpWrite   = "write"
+	OpRemove  = "remove"
+	OpMkdir   = "mkdir"
+	OpChmod   = "chmod"
+	OpChown   = "chown"
+	OpRename  = "rename"
+	OpCopy    = "copy"
+	OpSymlink = "symlink"
+	OpExec    = "exec"
+)
+
+// Op is one recorded change, replayed in order by Apply
+type Op struct {
+	Kind    string      `json:"kind"`
+	Path    string      `json:"path,omitempty"`
+	Target  string      `json:"target,omitempty"` // rename destination, symlink target
+	Source  string      `json:"source,omitempty"` // copy source
+	Hash    string      `json:"hash,omitempty"`   // sha256 of the copy source
+	Content string      `json:"content,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	UID     int         `json:"uid,omitempty"`
+	GID     int         `json:"gid,omitempty"`
+	Args    []string    `json:"args,omitempty"`
+	Dir     string      `json:"dir,omitempty"`
+	Scope   string      `json:"scope,omitempty"` // transaction target the op was recorded in
+}
+
+// Snapshot is the state of a file when the plan was made
+type Snapshot struct {
+	Exists bool   `json:"exists"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+// file is a file in the overlay
+type file struct {
+	exists  bool
+	content []byte
+}
+
+// Recorder collects changes instead of performing them
+type Recorder struct {
+	mu       sync.Mutex
+	overlay  map[string]*file
+	before   map[string]Snapshot
+	original map[string][]byte
+	ops      []Op
+	targets  map[string]bool
+	scope    string // target of the transaction ops are recorded in
+}
+
+var active *Recorder
+
+// Record starts recording changes. Stop ends it.
+func Record() *Recorder {
+	active = &Recorder{
+		overlay:  make(map[string]*file),
+		before:   make(map[string]Snapshot),
+		original: make(map[string][]byte),
+		targets:  make(map[string]bool),
+	}
+	return active
+}
+
+// Stop stops recording and returns the recorder
+func Stop() *Recorder {
+	r := active
+	active = nil
+	return r
+}
+
+// Recording reports whether changes are being recorded
+func Recording() bool {
+	return active != nil
+}
+
+// AddTarget notes a process or container the plan was made for, so apply
+// can check that it's still there
+func AddTarget(key string) {
+	if active == nil {
+		return
+	}
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.targets[key] = true
+}
+
+// snapshot records the on-disk state of a path the first time it's touched
+func (r *Recorder) snapshot(path string) {
+	if _, ok := r.before[path]; ok {
+		return
+	}
+	data, err := readState(path)
+	if err != nil {
+		r.before[path] = Snapshot{}
+		return
+	}
+	r.before[path] = Snapshot{Exists: true, Hash: Hash(data)}
+	r.original[path] = data
+}
+
+// readState reads a file. A symlink that can't be read as a file, like one
+// to a directory, is read as the path it points at.
+func readState(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if target, lerr := os.Readlink(path); lerr == nil {
+			return linkContent(target), nil
+		}
+	}
+	return data, err
+}
+
+// linkContent is how a symlink shows in plans
+func linkContent(target string) []byte {
+	return []byte("-> " + target + "\n")
+}
+
+// lookup returns the overlay state of a path, nil when it wasn't changed
+func (r *Recorder) lookup(path string) *file {
+	return r.overlay[path]
+}
+
+func (r *Recorder) add(op Op) {
+	op.Scope = r.scope
+	r.ops = append(r.ops, op)
+}
+
+// WriteFile writes a file, or records the write
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	if active == nil {
+		return journaled(Op{Kind: OpWrite, Path: path, Mode: perm}, func() error {
+			return os.WriteFile(path, data, perm)
+		}, path)
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(path)
+	r.overlay[path] = &file{exists: true, content: append([]byte(nil), data...)}
+	r.add(Op{Kind: OpWrite, Path: path, Content: string(data), Mode: perm})
+	return nil
+}
+
+// WriteFileAtomic writes a file through a temporary file renamed over it, so
+// a crash or a concurrent reader never sees it half written
+func WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if active != nil {
+		return WriteFile(path, data, perm)
+	}
+	return journaled(Op{Kind: OpWrite, Path: path, Mode: perm}, func() error {
+		return writeAtomic(path, data, perm)
+	}, path)
+}
+
+// writeAtomic writes and syncs a temporary file next to path, renames it
+// over path and syncs the directory so the rename is durable
+func writeAtomic(path string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // gone once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ReadFile reads a file, seeing recorded writes
+func ReadFile(path string) ([]byte, error) {
+	if active == nil {
+		return os.ReadFile(path)
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(path)
+	if f := r.lookup(path); f != nil {
+		if !f.exists {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+		return append([]byte(nil), f.content...), nil
+	}
+	return os.ReadFile(path)
+}
+
+// Exists reports whether a path exists, seeing recorded writes and removals
+func Exists(path string) bool {
+	if active != nil {
+		active.mu.Lock()
+		f := active.lookup(path)
+		active.mu.Unlock()
+		if f != nil {
+			return f.exists
+		}
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Remove removes a file, or records the removal
+func Remove(path string) error {
+	if active == nil {
+		return journaled(Op{Kind: OpRemove, Path: path}, func() error {
+			return os.Remove(path)
+		}, path)
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(path)
+	if f := r.lookup(path); f != nil && !f.exists {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	} else if f == nil {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && !r.emptyDir(path) {
+			return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+		}
+	}
+	r.overlay[path] = &file{}
+	r.add(Op{Kind: OpRemove, Path: path})
+	return nil
+}
+
+// emptyDir checks if a directory is empty once recorded changes are applied
+func (r *Recorder) emptyDir(dir string) bool {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if f := r.lookup(filepath.Join(dir, e.Name())); f == nil || f.exists {
+			return false
+		}
+	}
+	for path, f := range r.overlay {
+		if f.exists && filepath.Dir(path) == dir {
+			return false
+		}
+	}
+	return true
+}
+
+// Rename renames a file, or records the rename
+func Rename(oldPath, newPath string) error {
+	if active == nil {
+		return journaled(Op{Kind: OpRename, Path: oldPath, Target: newPath}, func() error {
+			return os.Rename(oldPath, newPath)
+		}, oldPath, newPath)
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(oldPath)
+	r.snapshot(newPath)
+	src := r.lookup(oldPath)
+	if src == nil {
+		data, err := os.ReadFile(oldPath)
+		if err != nil {
+			return err
+		}
+		src = &file{exists: true, content: data}
+	}
+	if !src.exists {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	r.overlay[newPath] = &file{exists: true, content: src.content}
+	r.overlay[oldPath] = &file{}
+	r.add(Op{Kind: OpRename, Path: oldPath, Target: newPath})
+	return nil
+}
+
+// MkdirAll creates a directory, or records it
+func MkdirAll(path string, perm fs.FileMode) error {
+	if active == nil {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return nil
+		}
+		return journaled(Op{Kind: OpMkdir, Path: path, Mode: perm}, func() error {
+			return os.MkdirAll(path, perm)
+		}, path)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil
+	}
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.add(Op{Kind: OpMkdir, Path: path, Mode: perm})
+	return nil
+}
+
+// Chmod changes the mode of a file, or records it
+func Chmod(path string, mode fs.FileMode) error {
+	if active == nil {
+		return journaled(Op{Kind: OpChmod, Path: path, Mode: mode}, func() error {
+			return os.Chmod(path, mode)
+		}, path)
+	}
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.add(Op{Kind: OpChmod, Path: path, Mode: mode})
+	return nil
+}
+
+// Chown changes the owner of a file, or records it
+func Chown(path string, uid, gid int) error {
+	if active == nil {
+		return journaled(Op{Kind: OpChown, Path: path, UID: uid, GID: gid}, func() error {
+			return os.Chown(path, uid, gid)
+		}, path)
+	}
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.add(Op{Kind: OpChown, Path: path, UID: uid, GID: gid})
+	return nil
+}
+
+// CopyFile copies a file, or records the copy
+func CopyFile(src, dst string, perm fs.FileMode) error {
+	if active == nil {
+		// Agent JARs aren't captured, a rollback leaves them installed
+		return journaled(Op{Kind: OpCopy, Path: dst, Source: src, Mode: perm}, func() error {
+			return copyFile(src, dst, perm)
+		})
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(dst)
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	// Binary content isn't kept in the plan, only its hash
+	r.overlay[dst] = &file{exists: true, content: []byte(fmt.Sprintf("<copy of %s, sha256 %s>", src, Hash(data)))}
+	r.add(Op{Kind: OpCopy, Path: dst, Source: src, Hash: Hash(data), Mode: perm})
+	return nil
+}
+
+// Symlink points link at target, replacing whatever link pointed at in one
+// step, or records it
+func Symlink(target, link string) error {
+	if active == nil {
+		// The link isn't captured, whoever switches it switches it back
+		return journaled(Op{Kind: OpSymlink, Path: link, Target: target}, func() error {
+			return symlink(target, link)
+		})
+	}
+	r := active
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshot(link)
+	r.overlay[link] = &file{exists: true, content: linkContent(target)}
+	r.add(Op{Kind: OpSymlink, Path: link, Target: target})
+	return nil
+}
+
+// symlink creates the new link next to the old one and renames it over,
+// so the link never is missing
+func symlink(target, link string) error {
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, perm)
+}
+
+// Run runs a command that changes the host, or records it
+func Run(cmd *exec.Cmd) error {
+	if active == nil {
+		return journaled(Op{Kind: OpExec, Args: cmd.Args, Dir: cmd.Dir}, cmd.Run)
+	}
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.add(Op{Kind: OpExec, Args: cmd.Args, Dir: cmd.Dir})
+	return nil
+}
+
+// CombinedOutput runs a command that changes the host and returns its
+// output, or records it
+func CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	if active == nil {
+		var output []byte
+		err := journaled(Op{Kind: OpExec, Args: cmd.Args, Dir: cmd.Dir}, func() (err error) {
+			output, err = cmd.CombinedOutput()
+			return err
+		})
+		return output, err
+	}
+	return nil, Run(cmd)
+}
+
+// Hash returns the sha256 of content
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Plan returns the recorded changes
+func (r *Recorder) Plan(command string) *Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hostname, _ := os.Hostname()
+	p := &Plan{
+		Version:   PlanVersion,
+		CreatedAt: time.Now(),
+		Hostname:  hostname,
+		Command:   command,
+		Before:    make(map[string]Snapshot),
+		Ops:       append([]Op(nil), r.ops...),
+	}
+
+	for target := range r.targets {
+		p.Targets = append(p.Targets, target)
+	}
+	sort.Strings(p.Targets)
+
+	paths := make([]string, 0, len(r.overlay))
+	for path := range r.overlay {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		p.Before[path] = r.before[path]
+		after := r.overlay[path]
+		change := FileChange{Path: path}
+		switch {
+		case !r.before[path].Exists && !after.exists:
+			// Created and removed again, e.g. a temporary file
+			continue
+		case !r.before[path].Exists:
+			change.Action = "create"
+		case !after.exists:
+			change.Action = "delete"
+		default:
+			if string(r.original[path]) == string(after.content) {
+				continue
+			}
+			change.Action = "update"
+		}
+		change.Diff = UnifiedDiff(path, string(r.original[path]), string(after.content))
+		p.Files = append(p.Files, change)
+	}
+
+	// Files that were only read still guard against drift
+	for path, snapshot := range r.before {
+		if _, ok := p.Before[path]; !ok {
+			p.Before[path] = snapshot
+		}
+	}
+	return p
+}
@@ -0,0 +1,376 @@
+package changeset_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "[Service]\nUser=app\nExecStart=/usr/bin/java -jar app.jar\n"
+	after := "[Service]\nUser=app\nEnvironment=\"MW_TARGET=https://x\"\nExecStart=/usr/bin/java -jar app.jar\n"
+
+	expected := `--- a/etc/app.conf
++++ b/etc/app.conf
+@@ -1,3 +1,4 @@
+ [Service]
+ User=app
++Environment="MW_TARGET=https://x"
+ ExecStart=/usr/bin/java -jar app.jar
+`
+	if got := changeset.UnifiedDiff("/etc/app.conf", before, after); got != expected {
+		t.Errorf("UnifiedDiff() =\n%s\nexpected\n%s", got, expected)
+	}
+
+	created := changeset.UnifiedDiff("/etc/new.conf", "", "A=1\n")
+	if !strings.HasPrefix(created, "--- /dev/null\n+++ b/etc/new.conf\n@@ -0,0 +1,1 @@\n+A=1\n") {
+		t.Errorf("UnifiedDiff() of a new file =\n%s", created)
+	}
+
+	if got := changeset.UnifiedDiff("/etc/app.conf", before, before); got != "" {
+		t.Errorf("UnifiedDiff() of identical content = %q", got)
+	}
+}
+
+func TestRecordAndApply(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.conf")
+	created := filepath.Join(dir, "drop-in", "middleware.conf")
+	removed := filepath.Join(dir, "old.conf")
+	marker := filepath.Join(dir, "restarted")
+
+	os.WriteFile(existing, []byte("A=1\n"), 0o644)
+	os.WriteFile(removed, []byte("old\n"), 0o644)
+
+	recorder := changeset.Record()
+	changeset.MkdirAll(filepath.Dir(created), 0o755)
+	changeset.WriteFile(created, []byte("B=2\n"), 0o644)
+	changeset.WriteFile(existing, []byte("A=1\nC=3\n"), 0o644)
+	changeset.Remove(removed)
+	changeset.Run(exec.Command("touch", marker))
+	changeset.AddTarget("process app")
+
+	// Reads see the recorded changes, the disk doesn't
+	if data, _ := changeset.ReadFile(existing); string(data) != "A=1\nC=3\n" {
+		t.Errorf("ReadFile() while recording = %q", data)
+	}
+	if changeset.Exists(removed) || !changeset.Exists(created) {
+		t.Errorf("Exists() doesn't see recorded changes")
+	}
+	changeset.Stop()
+
+	if data, _ := os.ReadFile(existing); string(data) != "A=1\n" {
+		t.Errorf("recording changed the disk: %q", data)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("recording ran a command")
+	}
+
+	plan := recorder.Plan("test")
+	actions := map[string]string{}
+	for _, f := range plan.Files {
+		actions[f.Path] = f.Action
+	}
+	if actions[existing] != "update" || actions[created] != "create" || actions[removed] != "delete" {
+		t.Errorf("plan files = %v", actions)
+	}
+
+	path := filepath.Join(dir, "plan.json")
+	if err := plan.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := changeset.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if drift := loaded.Drift(nil); len(drift) != 1 || !strings.Contains(drift[0], "process app") {
+		t.Errorf("Drift() without the target = %v", drift)
+	}
+
+	// A file edited after planning is drift
+	os.WriteFile(existing, []byte("A=2\n"), 0o644)
+	if drift := loaded.Drift([]string{"process app"}); len(drift) != 1 || !strings.Contains(drift[0], existing) {
+		t.Errorf("Drift() after an edit = %v", drift)
+	}
+	os.WriteFile(existing, []byte("A=1\n"), 0o644)
+
+	if drift := loaded.Drift([]string{"process app"}); len(drift) != 0 {
+		t.Fatalf("Drift() = %v", drift)
+	}
+	if errs := loaded.Apply(); len(errs) != 0 {
+		t.Fatalf("Apply() errors = %v", errs)
+	}
+
+	if data, _ := os.ReadFile(existing); string(data) != "A=1\nC=3\n" {
+		t.Errorf("existing after apply = %q", data)
+	}
+	if data, _ := os.ReadFile(created); string(data) != "B=2\n" {
+		t.Errorf("created after apply = %q", data)
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("removed file still exists")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("apply didn't run the command")
+	}
+}
+
+func TestJournalRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "app.env")
+	dropIn := filepath.Join(dir, "app.service.d", "middleware.conf")
+	removed := filepath.Join(dir, "old.conf")
+	shared := filepath.Join(dir, "host.json")
+
+	os.WriteFile(existing, []byte("A=1\n"), 0o640)
+	os.WriteFile(removed, []byte("old\n"), 0o644)
+
+	j, err := changeset.OpenJournal(filepath.Join(dir, "journal"), "test")
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	defer changeset.CloseJournal()
+
+	tx := changeset.Begin("process app")
+	changeset.MkdirAll(filepath.Dir(dropIn), 0o755)
+	changeset.WriteFile(dropIn, []byte("[Service]\n"), 0o644)
+	changeset.WriteFile(existing, []byte("A=1\nB=2\n"), 0o640)
+	changeset.Remove(removed)
+	changeset.WriteFile(shared, []byte("app\n"), 0o644)
+
+	// A later service keeps its change to a shared file
+	changeset.Begin("process other")
+	changeset.WriteFile(shared, []byte("app\nother\n"), 0o644)
+	changeset.End()
+
+	if err := tx.Rollback(errors.New("app.service failed to start")); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(existing); string(data) != "A=1\n" {
+		t.Errorf("existing after rollback = %q", data)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0o640 {
+		t.Errorf("existing mode after rollback = %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Dir(dropIn)); !os.IsNotExist(err) {
+		t.Errorf("drop-in directory still exists")
+	}
+	if data, _ := os.ReadFile(removed); string(data) != "old\n" {
+		t.Errorf("removed file after rollback = %q", data)
+	}
+	if data, _ := os.ReadFile(shared); string(data) != "app\nother\n" {
+		t.Errorf("shared file after rollback = %q", data)
+	}
+
+	data, err := os.ReadFile(j.Path())
+	if err != nil {
+		t.Fatalf("journal not written: %v", err)
+	}
+	var saved changeset.Journal
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("journal is invalid: %v", err)
+	}
+	if len(saved.Transactions) != 2 || saved.Transactions[0].Status != changeset.TxRolledBack {
+		t.Fatalf("journal transactions = %+v", saved.Transactions)
+	}
+	if !strings.Contains(saved.Transactions[0].Error, "failed to start") {
+		t.Errorf("journal error = %q", saved.Transactions[0].Error)
+	}
+}
+
+func TestJournalRollbackSymlink(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "setenv.sh.dist")
+	link := filepath.Join(dir, "setenv.sh")
+	os.WriteFile(real, []byte("JAVA_OPTS=-Xmx1g\n"), 0o755)
+	os.Symlink("setenv.sh.dist", link)
+	unit := filepath.Join(dir, "app.service")
+	os.WriteFile(filepath.Join(dir, "app.service.dist"), []byte("[Service]\n"), 0o644)
+	os.Symlink("app.service.dist", unit)
+
+	if _, err := changeset.OpenJournal(filepath.Join(dir, "journal"), "test"); err != nil {
+		t.Fatal(err)
+	}
+	defer changeset.CloseJournal()
+
+	// One write goes through the link, the other replaces it
+	tx := changeset.Begin("process app")
+	changeset.WriteFile(link, []byte("JAVA_OPTS=-Xmx1g -javaagent:/opt/agent.jar\n"), 0o755)
+	changeset.WriteFileAtomic(unit, []byte("[Service]\nEnvironment=A=1\n"), 0o644)
+	changeset.End()
+
+	if err := tx.Rollback(errors.New("app.service failed to start")); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	for path, want := range map[string]string{link: "setenv.sh.dist", unit: "app.service.dist"} {
+		if target, err := os.Readlink(path); err != nil || target != want {
+			t.Errorf("%s after rollback = %q, %v, want a link to %s", filepath.Base(path), target, err, want)
+		}
+	}
+	if data, _ := os.ReadFile(real); string(data) != "JAVA_OPTS=-Xmx1g\n" {
+		t.Errorf("link target after rollback = %q", data)
+	}
+}
+
+func TestApplyJournaled(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.conf")
+	second := filepath.Join(dir, "second.conf")
+	blocker := filepath.Join(dir, "blocker")
+	os.WriteFile(blocker, []byte("a file, not a directory\n"), 0o644)
+
+	recorder := changeset.Record()
+	changeset.Begin("process first")
+	changeset.WriteFile(first, []byte("A=1\n"), 0o644)
+	changeset.Begin("process second")
+	changeset.WriteFile(second, []byte("B=2\n"), 0o644)
+	changeset.WriteFile(filepath.Join(blocker, "middleware.conf"), []byte("[Service]\n"), 0o644)
+	changeset.End()
+	changeset.Stop()
+	plan := recorder.Plan("test")
+
+	j, err := changeset.OpenJournal(filepath.Join(dir, "journal"), "apply")
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	defer changeset.CloseJournal()
+	if errs := plan.Apply(); len(errs) != 1 {
+		t.Fatalf("Apply() errors = %v, want the blocked write", errs)
+	}
+
+	// The service that failed halfway is rolled back, the other is kept
+	if data, _ := os.ReadFile(first); string(data) != "A=1\n" {
+		t.Errorf("first after apply = %q", data)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("second wasn't rolled back")
+	}
+
+	if len(j.Transactions) != 2 {
+		t.Fatalf("journal transactions = %+v", j.Transactions)
+	}
+	if tx := j.Transactions[0]; tx.Target != "process first" || tx.Status != changeset.TxApplied || len(tx.Steps) == 0 {
+		t.Errorf("first transaction = %+v", tx)
+	}
+	if tx := j.Transactions[1]; tx.Target != "process second" || tx.Status != changeset.TxRolledBack {
+		t.Errorf("second transaction = %+v", tx)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	os.WriteFile(path, []byte("old\n"), 0o644)
+
+	if err := changeset.WriteFileAtomic(path, []byte("new\n"), 0o600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "new\n" {
+		t.Errorf("content = %q, expected %q", data, "new\n")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, expected 0600", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// Recorded like any other write
+	recorder := changeset.Record()
+	changeset.WriteFileAtomic(path, []byte("planned\n"), 0o600)
+	changeset.Stop()
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("recorded write changed the file to %q", data)
+	}
+	if plan := recorder.Plan("test"); plan.Empty() {
+		t.Error("recorded write missing from the plan")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir := t.TempDir()
+	journalDir := filepath.Join(dir, "journal")
+	existing := filepath.Join(dir, "docker-compose.yml")
+	created := filepath.Join(dir, "web.env")
+	marker := filepath.Join(dir, "recreated")
+	os.WriteFile(existing, []byte("services: {}\n"), 0o644)
+
+	changeset.OpenJournal(journalDir, "instrument-docker")
+	tx := changeset.Begin("container web (abc)")
+	changeset.WriteFile(existing, []byte("services: {web: {}}\n"), 0o644)
+	changeset.WriteFile(created, []byte("MW_API_KEY=x\n"), 0o600)
+	tx.OnUndo(exec.Command("touch", marker))
+	changeset.CloseJournal()
+
+	journals, err := changeset.LoadJournals(journalDir)
+	if err != nil || len(journals) != 1 {
+		t.Fatalf("LoadJournals() = %v, %v", journals, err)
+	}
+	op := journals[0].Transactions[0]
+	if touched := op.Touched(); len(touched) != 2 {
+		t.Errorf("Touched() = %v", touched)
+	}
+
+	for i := len(op.Originals) - 1; i >= 0; i-- {
+		if err := op.Originals[i].Restore(); err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+	}
+	if err := op.RunUndo(false); err != nil {
+		t.Fatalf("RunUndo() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(existing); string(data) != "services: {}\n" {
+		t.Errorf("compose file after undo = %q", data)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("env file still exists after undo")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("undo command didn't run: %v", err)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "1.9.0"), 0o755)
+	os.Mkdir(filepath.Join(dir, "2.0.0"), 0o755)
+	link := filepath.Join(dir, "current")
+	if err := changeset.Symlink("1.9.0", link); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	// A recorded switch shows as a change of where the link points
+	recorder := changeset.Record()
+	changeset.Symlink("2.0.0", link)
+	changeset.Stop()
+	plan := recorder.Plan("test")
+	if len(plan.Files) != 1 || plan.Files[0].Action != "update" || !strings.Contains(plan.Files[0].Diff, "+-> 2.0.0") {
+		t.Fatalf("plan files = %+v", plan.Files)
+	}
+	if target, _ := os.Readlink(link); target != "1.9.0" {
+		t.Errorf("recording switched the link to %q", target)
+	}
+
+	if drift := plan.Drift(nil); len(drift) != 0 {
+		t.Errorf("Drift() = %v", drift)
+	}
+	if errs := plan.Apply(); len(errs) != 0 {
+		t.Fatalf("Apply() errors = %v", errs)
+	}
+	if target, _ := os.Readlink(link); target != "2.0.0" {
+		t.Errorf("link points at %q after apply, want 2.0.0", target)
+	}
+	if _, err := os.Lstat(link + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary link left behind")
+	}
+}
@@ -0,0 +1,503 @@
+package changeset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Step states
+const (
+	StepIntended = "intended"
+	StepApplied  = "applied"
+	StepFailed   = "failed"
+)
+
+// Transaction states
+const (
+	TxInProgress     = "in-progress"
+	TxApplied        = "applied"
+	TxVerified       = "verified"
+	TxRolledBack     = "rolled-back"
+	TxRollbackFailed = "rollback-failed"
+)
+
+// Journal records the operations of one command run as they happen, so a
+// failed service can be rolled back and every change can be reviewed later
+type Journal struct {
+	ID           string         `json:"id"`
+	Command      string         `json:"command"`
+	Args         []string       `json:"args,omitempty"`
+	User         string         `json:"user,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	Undoes       string         `json:"undoes,omitempty"` // the operation this run undid
+	Steps        []*Step        `json:"steps,omitempty"`  // outside any transaction
+	Transactions []*Transaction `json:"transactions,omitempty"`
+
+	mu      sync.Mutex
+	path    string
+	current *Transaction
+}
+
+// Transaction groups the changes made for one service or container
+type Transaction struct {
+	Target    string      `json:"target"`
+	Status    string      `json:"status"`
+	StartedAt time.Time   `json:"started_at"`
+	Steps     []*Step     `json:"steps,omitempty"`
+	Originals []*Original `json:"originals,omitempty"`
+	Unit      string      `json:"unit,omitempty"` // restarted to apply the changes
+	Undo      []*Command  `json:"undo,omitempty"`
+	Error     string      `json:"error,omitempty"`
+
+	journal  *Journal
+	captured map[string]bool
+}
+
+// Step is an operation, recorded before it's performed and updated after
+type Step struct {
+	Op
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Original is a file or directory as it was before a transaction changed it
+type Original struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Dir     bool        `json:"dir,omitempty"`
+	Content string      `json:"content,omitempty"`
+	Link    string      `json:"link,omitempty"` // target of a symlink
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+}
+
+var journal *Journal
+
+// OpenJournal starts journaling the operations of a command to a file in
+// dir. The journal holds original file contents, so it's root-only.
+func OpenJournal(dir, command string) (*Journal, error) {
+	// Only the journal directory itself is root-only, not its parents
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	now := time.Now()
+	j := &Journal{
+		ID:        fmt.Sprintf("%s-%d", now.Format("20060102-150405"), os.Getpid()),
+		Command:   command,
+		Args:      os.Args[1:],
+		User:      runBy(),
+		StartedAt: now,
+	}
+	j.path = filepath.Join(dir, j.ID+".json")
+	journal = j
+	return j, j.save()
+}
+
+// LoadJournal reads the journal of an earlier run, so its transactions can
+// still be rolled back
+func LoadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	j := &Journal{path: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("invalid journal %s: %w", path, err)
+	}
+	for _, tx := range j.Transactions {
+		tx.journal = j
+		tx.captured = make(map[string]bool)
+		for _, o := range tx.Originals {
+			tx.captured[o.Path] = true
+		}
+	}
+	return j, nil
+}
+
+// LoadJournals reads the journals in dir, newest first. Journals that can't
+// be read are skipped.
+func LoadJournals(dir string) ([]*Journal, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal directory: %w", err)
+	}
+
+	var journals []*Journal
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].IsDir() || filepath.Ext(entries[i].Name()) != ".json" {
+			continue
+		}
+		if j, err := LoadJournal(filepath.Join(dir, entries[i].Name())); err == nil {
+			journals = append(journals, j)
+		}
+	}
+	return journals, nil
+}
+
+// LastTransaction returns the latest transaction of a target, rolled back
+// or not, nil if none
+func (j *Journal) LastTransaction(target string) *Transaction {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := len(j.Transactions) - 1; i >= 0; i-- {
+		if j.Transactions[i].Target == target {
+			return j.Transactions[i]
+		}
+	}
+	return nil
+}
+
+// Transaction returns the latest transaction of a target that wasn't rolled
+// back, nil if none
+func (j *Journal) Transaction(target string) *Transaction {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := len(j.Transactions) - 1; i >= 0; i-- {
+		tx := j.Transactions[i]
+		if tx.Target == target && tx.Status != TxRolledBack && tx.Status != TxRollbackFailed {
+			return tx
+		}
+	}
+	return nil
+}
+
+// CloseJournal stops journaling
+func CloseJournal() {
+	j := journal
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endCurrent()
+	if len(j.Steps) == 0 && len(j.Transactions) == 0 {
+		// Nothing changed, nothing to keep
+		os.Remove(j.path)
+	} else {
+		j.save()
+	}
+	journal = nil
+}
+
+// CurrentJournal returns the open journal, nil if none
+func CurrentJournal() *Journal {
+	return journal
+}
+
+// Path returns the file the journal is written to
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Begin starts a transaction for a service or container, ending the previous
+// one. It returns nil when no journal is open or changes are only recorded,
+// recorded changes are then scoped to the target so apply replays them in
+// a transaction.
+func Begin(target string) *Transaction {
+	if r := active; r != nil {
+		r.mu.Lock()
+		r.scope = target
+		r.mu.Unlock()
+		return nil
+	}
+	j := journal
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endCurrent()
+	tx := &Transaction{
+		Target:    target,
+		Status:    TxInProgress,
+		StartedAt: time.Now(),
+		journal:   j,
+		captured:  make(map[string]bool),
+	}
+	j.Transactions = append(j.Transactions, tx)
+	j.current = tx
+	j.save()
+	return tx
+}
+
+// End ends the current transaction, later operations aren't part of it
+func End() {
+	if r := active; r != nil {
+		r.mu.Lock()
+		r.scope = ""
+		r.mu.Unlock()
+	}
+	j := journal
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endCurrent()
+	j.save()
+}
+
+func (j *Journal) endCurrent() {
+	if j.current != nil && j.current.Status == TxInProgress {
+		j.current.Status = TxApplied
+	}
+	j.current = nil
+}
+
+// save writes the journal, errors are ignored so journaling never blocks
+// the operation itself
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A journal torn by a crash couldn't be rolled back from
+	return writeAtomic(j.path, data, 0o600)
+}
+
+// journaled performs an operation, recording it in the open journal and
+// capturing the originals of the paths it changes. The journal isn't locked
+// while the operation runs, restarts of several services run in parallel.
+func journaled(op Op, perform func() error, paths ...string) error {
+	j := journal
+	if j == nil {
+		return perform()
+	}
+	j.mu.Lock()
+
+	tx := j.current
+	if tx != nil {
+		for _, path := range paths {
+			tx.capture(path, op.Kind == OpMkdir)
+		}
+	}
+
+	step := &Step{Op: op, Status: StepIntended}
+	step.Content = "" // file contents are captured as originals, not per step
+	if tx != nil {
+		tx.Steps = append(tx.Steps, step)
+	} else {
+		j.Steps = append(j.Steps, step)
+	}
+	j.save()
+	j.mu.Unlock()
+
+	err := perform()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		step.Status = StepFailed
+		step.Error = err.Error()
+	} else {
+		step.Status = StepApplied
+	}
+	j.save()
+	return err
+}
+
+// capture records the state of a path before the transaction first changes
+// it. For directories only the missing ones are recorded, to be removed
+// again on rollback.
+func (tx *Transaction) capture(path string, dir bool) {
+	if tx.captured[path] {
+		return
+	}
+	tx.captured[path] = true
+
+	if dir {
+		var missing []string
+		for p := path; p != "/" && p != "."; p = filepath.Dir(p) {
+			if _, err := os.Stat(p); err == nil {
+				break
+			}
+			missing = append(missing, p)
+		}
+		for i := len(missing) - 1; i >= 0; i-- {
+			tx.Originals = append(tx.Originals, &Original{Path: missing[i], Dir: true})
+		}
+		return
+	}
+
+	original := &Original{Path: path}
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return
+		}
+		original.Existed = true
+		original.Link = link
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			original.UID, original.GID = int(st.Uid), int(st.Gid)
+		}
+		tx.Originals = append(tx.Originals, original)
+
+		// Writes through the link change the file it points at
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			tx.capture(target, false)
+		}
+		return
+	}
+	if err == nil && !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		// Binaries don't fit the journal, like copies they aren't restored
+		if !utf8.Valid(data) {
+			return
+		}
+		original.Existed = true
+		original.Content = string(data)
+		original.Mode = info.Mode().Perm()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			original.UID, original.GID = int(st.Uid), int(st.Gid)
+		}
+	} else if err == nil {
+		// Directories that are removed again aren't restored
+		return
+	}
+	tx.Originals = append(tx.Originals, original)
+}
+
+// Current returns the transaction in progress, nil if none
+func Current() *Transaction {
+	j := journal
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.current
+}
+
+// Verify marks the transaction's service as running with its changes
+func (tx *Transaction) Verify() {
+	if tx == nil {
+		return
+	}
+	tx.journal.mu.Lock()
+	defer tx.journal.mu.Unlock()
+	tx.Status = TxVerified
+	tx.journal.save()
+}
+
+// Rollback restores every file the transaction changed, newest first. The
+// reason is recorded in the journal.
+func (tx *Transaction) Rollback(reason error) error {
+	if tx == nil {
+		return nil
+	}
+	j := tx.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.current == tx {
+		j.endCurrent()
+	}
+
+	// Files a later transaction changed too, like the host state, keep
+	// that transaction's changes
+	later := make(map[string]bool)
+	for i := len(j.Transactions) - 1; i >= 0 && j.Transactions[i] != tx; i-- {
+		if t := j.Transactions[i]; t.Status != TxRolledBack {
+			for path := range t.captured {
+				later[path] = true
+			}
+		}
+	}
+
+	var errs []error
+	for i := len(tx.Originals) - 1; i >= 0; i-- {
+		if later[tx.Originals[i].Path] {
+			continue
+		}
+		if err := tx.Originals[i].restore(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	tx.Status = TxRolledBack
+	if reason != nil {
+		tx.Error = reason.Error()
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		tx.Status = TxRollbackFailed
+		tx.Error = fmt.Sprintf("%s; rollback: %v", tx.Error, err)
+	}
+	j.save()
+	return err
+}
+
+// JournalPath returns the file of the journal the transaction belongs to
+func (tx *Transaction) JournalPath() string {
+	return tx.journal.path
+}
+
+// Restored returns the paths a rollback restores
+func (tx *Transaction) Restored() []string {
+	if tx == nil {
+		return nil
+	}
+	var paths []string
+	for _, o := range tx.Originals {
+		if !o.Dir {
+			paths = append(paths, o.Path)
+		}
+	}
+	return paths
+}
+
+// restore puts a file back the way it was
+func (o *Original) restore() error {
+	switch {
+	case o.Dir:
+		// Only removed when empty, other files may live there now
+		os.Remove(o.Path)
+		return nil
+	case !o.Existed:
+		if err := os.Remove(o.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", o.Path, err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", o.Path, err)
+	}
+	if o.Link != "" {
+		if err := symlink(o.Link, o.Path); err != nil {
+			return fmt.Errorf("failed to restore link %s: %w", o.Path, err)
+		}
+		if err := os.Lchown(o.Path, o.UID, o.GID); err != nil {
+			return fmt.Errorf("failed to restore owner of %s: %w", o.Path, err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(o.Path, []byte(o.Content), o.Mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", o.Path, err)
+	}
+	if err := os.Chmod(o.Path, o.Mode); err != nil {
+		return fmt.Errorf("failed to restore permissions of %s: %w", o.Path, err)
+	}
+	if err := os.Lchown(o.Path, o.UID, o.GID); err != nil {
+		return fmt.Errorf("failed to restore owner of %s: %w", o.Path, err)
+	}
+	return nil
+}
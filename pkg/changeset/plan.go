@@ -0,0 +1,216 @@
+package changeset
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PlanVersion is the version of the plan file format
+const PlanVersion = 1
+
+// FileChange is a file the plan creates, updates or deletes
+type FileChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"` // create, update or delete
+	Diff   string `json:"diff,omitempty"`
+}
+
+// Plan is a reviewed set of changes that can be applied later
+type Plan struct {
+	Version   int                 `json:"version"`
+	CreatedAt time.Time           `json:"created_at"`
+	Hostname  string              `json:"hostname"`
+	Command   string              `json:"command"`
+	Targets   []string            `json:"targets,omitempty"`
+	Before    map[string]Snapshot `json:"before"`
+	Files     []FileChange        `json:"files,omitempty"`
+	Ops       []Op                `json:"ops"`
+}
+
+// Empty checks if the plan changes nothing
+func (p *Plan) Empty() bool {
+	return len(p.Ops) == 0
+}
+
+// Commands returns the commands the plan runs, in order
+func (p *Plan) Commands() [][]string {
+	var cmds [][]string
+	for _, op := range p.Ops {
+		if op.Kind == OpExec {
+			cmds = append(cmds, op.Args)
+		}
+	}
+	return cmds
+}
+
+// Save writes the plan to a file. Plans carry the files they write, which
+// may include credentials, so the file is only readable by its owner.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write plan %s: %w", path, err)
+	}
+	return os.Chmod(path, 0o600)
+}
+
+// Load reads a plan saved with Save
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("plan %s has version %d, expected %d", path, p.Version, PlanVersion)
+	}
+	return &p, nil
+}
+
+// Drift lists the differences between the host and the plan's snapshot:
+// files changed since planning and targets that are gone. current holds
+// the targets found now.
+func (p *Plan) Drift(current []string) []string {
+	var drift []string
+
+	paths := make([]string, 0, len(p.Before))
+	for path := range p.Before {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		want := p.Before[path]
+		data, err := readState(path)
+		switch {
+		case err != nil && want.Exists:
+			drift = append(drift, fmt.Sprintf("%s was removed", path))
+		case err == nil && !want.Exists:
+			drift = append(drift, fmt.Sprintf("%s was created", path))
+		case err == nil && Hash(data) != want.Hash:
+			drift = append(drift, fmt.Sprintf("%s was modified", path))
+		}
+	}
+
+	for _, op := range p.Ops {
+		if op.Kind != OpCopy {
+			continue
+		}
+		if data, err := os.ReadFile(op.Source); err != nil || Hash(data) != op.Hash {
+			drift = append(drift, fmt.Sprintf("%s changed", op.Source))
+		}
+	}
+
+	found := make(map[string]bool, len(current))
+	for _, target := range current {
+		found[target] = true
+	}
+	for _, target := range p.Targets {
+		if !found[target] {
+			drift = append(drift, fmt.Sprintf("%s is no longer running", target))
+		}
+	}
+
+	if hostname, _ := os.Hostname(); p.Hostname != "" && hostname != p.Hostname {
+		drift = append(drift, fmt.Sprintf("plan was made on %s, this is %s", p.Hostname, hostname))
+	}
+	return drift
+}
+
+// Apply performs the plan's operations in the order they were recorded,
+// through the journal so the run can be reviewed and undone like any
+// other. Operations recorded for a service or container are replayed in a
+// transaction of their own. File operations stop the apply on failure and
+// roll back the transaction they failed in. Failed commands are reported
+// and the apply continues, like the instrumentation does.
+func (p *Plan) Apply() []error {
+	var failed []error
+	var tx *Transaction
+	scope := ""
+	defer End()
+	for _, op := range p.Ops {
+		if op.Scope != scope {
+			if scope = op.Scope; scope != "" {
+				tx = Begin(scope)
+			} else {
+				End()
+				tx = nil
+			}
+		}
+		if err := op.apply(); err != nil {
+			if op.Kind != OpExec {
+				if rbErr := tx.Rollback(err); rbErr != nil {
+					err = fmt.Errorf("%w; rollback: %v", err, rbErr)
+				}
+				return append(failed, err)
+			}
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+func (op Op) apply() error {
+	switch op.Kind {
+	case OpWrite:
+		if err := MkdirAll(filepath.Dir(op.Path), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(op.Path), err)
+		}
+		if err := WriteFile(op.Path, []byte(op.Content), op.Mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", op.Path, err)
+		}
+	case OpRemove:
+		if !Exists(op.Path) {
+			return nil
+		}
+		if err := Remove(op.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", op.Path, err)
+		}
+	case OpRename:
+		if err := Rename(op.Path, op.Target); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", op.Path, err)
+		}
+	case OpMkdir:
+		if err := MkdirAll(op.Path, op.Mode); err != nil {
+			return fmt.Errorf("failed to create %s: %w", op.Path, err)
+		}
+	case OpChmod:
+		if err := Chmod(op.Path, op.Mode); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %w", op.Path, err)
+		}
+	case OpChown:
+		if err := Chown(op.Path, op.UID, op.GID); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", op.Path, err)
+		}
+	case OpCopy:
+		if err := CopyFile(op.Source, op.Path, op.Mode); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", op.Source, err)
+		}
+	case OpSymlink:
+		if err := Symlink(op.Target, op.Path); err != nil {
+			return fmt.Errorf("failed to link %s: %w", op.Path, err)
+		}
+	case OpExec:
+		if len(op.Args) == 0 {
+			return nil
+		}
+		cmd := exec.Command(op.Args[0], op.Args[1:]...)
+		cmd.Dir = op.Dir
+		if output, err := CombinedOutput(cmd); err != nil {
+			return fmt.Errorf("%v failed: %w\n%s", op.Args, err, output)
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", op.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package changeset
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// Command is an external command undo runs to bring a target back as it
+// was, like recreating a container
+type Command struct {
+	Dir    string   `json:"dir,omitempty"`
+	Args   []string `json:"args"`
+	Before bool     `json:"before,omitempty"` // run before the files are restored
+}
+
+// runBy names the user running the command, and who used sudo for it
+func runBy() string {
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != name {
+		return fmt.Sprintf("%s (as %s)", sudo, name)
+	}
+	return name
+}
+
+// MarkUndo records that the open journal's run undoes another operation
+func MarkUndo(id string) {
+	j := journal
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Undoes = id
+	j.save()
+}
+
+// SetUnit records the systemd unit restarted to apply the transaction
+func (tx *Transaction) SetUnit(unit string) {
+	if tx == nil || unit == "" {
+		return
+	}
+	tx.journal.mu.Lock()
+	defer tx.journal.mu.Unlock()
+	tx.Unit = unit
+	tx.journal.save()
+}
+
+// OnUndo records a command that brings the target back once the files of
+// the transaction are restored
+func (tx *Transaction) OnUndo(cmd *exec.Cmd) {
+	tx.addUndo(cmd, false)
+}
+
+// OnUndoFirst records a command that brings the target back and needs the
+// files of the transaction, so it runs before they're restored
+func (tx *Transaction) OnUndoFirst(cmd *exec.Cmd) {
+	tx.addUndo(cmd, true)
+}
+
+func (tx *Transaction) addUndo(cmd *exec.Cmd, before bool) {
+	if tx == nil {
+		return
+	}
+	tx.journal.mu.Lock()
+	defer tx.journal.mu.Unlock()
+	tx.Undo = append(tx.Undo, &Command{Dir: cmd.Dir, Args: cmd.Args, Before: before})
+	tx.journal.save()
+}
+
+// RunUndo runs the undo commands that go before or after the files are
+// restored
+func (tx *Transaction) RunUndo(before bool) error {
+	for _, c := range tx.Undo {
+		if c.Before != before || len(c.Args) == 0 {
+			continue
+		}
+		cmd := exec.Command(c.Args[0], c.Args[1:]...)
+		cmd.Dir = c.Dir
+		if output, err := CombinedOutput(cmd); err != nil {
+			return fmt.Errorf("%s failed: %w: %s", c.Args[0], err, output)
+		}
+	}
+	return nil
+}
+
+// Restore puts a file changed by an earlier run back the way it was. Unlike
+// a rollback it goes through the recorded operations, so an undo is
+// journaled and can be planned.
+func (o *Original) Restore() error {
+	switch {
+	case o.Dir:
+		// Only removed when empty, other files may live there now
+		if Exists(o.Path) {
+			Remove(o.Path)
+		}
+		return nil
+	case !o.Existed:
+		if !Exists(o.Path) {
+			return nil
+		}
+		if err := Remove(o.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", o.Path, err)
+		}
+		return nil
+	}
+
+	if err := MkdirAll(filepath.Dir(o.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", o.Path, err)
+	}
+	if o.Link != "" {
+		if err := Symlink(o.Link, o.Path); err != nil {
+			return fmt.Errorf("failed to restore link %s: %w", o.Path, err)
+		}
+		return nil
+	}
+	if err := WriteFile(o.Path, []byte(o.Content), o.Mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", o.Path, err)
+	}
+	if err := Chmod(o.Path, o.Mode); err != nil {
+		return fmt.Errorf("failed to restore permissions of %s: %w", o.Path, err)
+	}
+	if err := Chown(o.Path, o.UID, o.GID); err != nil {
+		return fmt.Errorf("failed to restore owner of %s: %w", o.Path, err)
+	}
+	return nil
+}
+
+// Touched returns the paths a transaction changed
+func (tx *Transaction) Touched() []string {
+	var paths []string
+	for _, o := range tx.Originals {
+		paths = append(paths, o.Path)
+	}
+	return paths
+}
+
+// Touched returns the paths changed by the journal's run
+func (j *Journal) Touched() []string {
+	var paths []string
+	for _, step := range j.Steps {
+		for _, path := range []string{step.Path, step.Target} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	for _, tx := range j.Transactions {
+		if tx.Status != TxRolledBack {
+			paths = append(paths, tx.Touched()...)
+		}
+	}
+	return paths
+}
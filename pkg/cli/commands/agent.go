@@ -0,0 +1,534 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/restart"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// agentUsage lists the agent actions
+const agentUsage = "mw-injector agent <install <jar|version|latest>|list|use <version>|prune|upgrade [version|jar|latest]>"
+
+// upgradeGroup restarts the services of an upgrade one after the other, so
+// the first failing one stops it
+const upgradeGroup = "agent upgrade"
+
+// AgentCommand manages the agent versions kept side by side in the agent
+// store
+type AgentCommand struct {
+	config *types.CommandConfig
+	action string
+	args   []string
+	agents *agent.Store
+}
+
+func NewAgentCommand(config *types.CommandConfig, action string, args []string) *AgentCommand {
+	return &AgentCommand{
+		config: config,
+		action: action,
+		args:   args,
+		agents: agent.NewStore(config.DefaultAgentDir),
+	}
+}
+
+func (c *AgentCommand) Execute() error {
+	if os.Geteuid() != 0 && c.action != "list" {
+		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector agent %s", c.action)
+	}
+
+	switch {
+	case c.action == "install" && len(c.args) == 1:
+		return c.install(c.args[0])
+	case c.action == "list" && len(c.args) == 0:
+		return c.list()
+	case c.action == "use" && len(c.args) == 1:
+		return c.use(c.args[0])
+	case c.action == "prune" && len(c.args) == 0:
+		return c.prune()
+	case c.action == "upgrade" && len(c.args) <= 1:
+		var version string
+		if len(c.args) == 1 {
+			version = c.args[0]
+		}
+		return c.upgrade(version)
+	default:
+		return fmt.Errorf("❌ Usage: %s", agentUsage)
+	}
+}
+
+func (c *AgentCommand) GetDescription() string {
+	return "Install, fetch, list, switch, prune and upgrade the agent versions kept side by side"
+}
+
+// vars reads the config file, for the repository, trust anchors, policy
+// and timeouts
+func (c *AgentCommand) vars() (systemd.ConfigVars, error) {
+	path := firstNonEmpty(c.config.ConfigFile, findDefaultConfigFile())
+	if path == "" {
+		return systemd.ConfigVars{}, nil
+	}
+	vars, err := systemd.ReadConfigFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Failed to load config from %s: %v", path, err)
+	}
+	fmt.Printf("🔧 Using configuration from: %s\n", path)
+	return vars, nil
+}
+
+// install verifies an agent, a JAR or a release of the repository, and
+// adds it to the store
+func (c *AgentCommand) install(source string) error {
+	vars, err := c.vars()
+	if err != nil {
+		return err
+	}
+	if _, err := installRelease(c.config, vars, source); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	return nil
+}
+
+// list prints the installed versions and the services running each
+func (c *AgentCommand) list() error {
+	versions, err := c.agents.Versions()
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if len(versions) == 0 {
+		fmt.Printf("No agent versions installed in %s\n", c.agents.Dir)
+		fmt.Println("   Install one with: mw-injector agent install <jar|version|latest>")
+		return nil
+	}
+
+	services := make(map[string][]string)
+	if processes, err := discovery.FindAllJavaProcesses(context.Background()); err == nil {
+		for i := range processes {
+			if version := c.agents.LoadedVersion(processes[i].ProcessPID); version != "" {
+				services[version] = append(services[version], processes[i].ServiceName)
+			}
+		}
+	}
+	store, _ := state.LoadStore()
+	current := c.agents.Current()
+
+	fmt.Printf("📦 Agent versions in %s:\n", c.agents.Dir)
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		marker, details := " ", []string{}
+		if version == current {
+			marker = "*"
+			details = append(details, "current")
+		}
+		if store != nil {
+			if record := store.Agent(c.agents.Path(version)); record != nil {
+				if record.SDKVersion != "" {
+					details = append(details, "OpenTelemetry SDK "+record.SDKVersion)
+				}
+				details = append(details, "verification: "+record.Verification)
+			}
+		}
+		if names := services[version]; len(names) > 0 {
+			details = append(details, fmt.Sprintf("%d service(s): %s", len(names), strings.Join(names, ", ")))
+		}
+		fmt.Printf("   %s %-12s %s\n", marker, version, strings.Join(details, ", "))
+	}
+	return nil
+}
+
+// use switches the current agent, services load it when they next restart
+func (c *AgentCommand) use(version string) error {
+	previous := c.agents.Current()
+	if previous == version {
+		fmt.Printf("✅ Agent %s is already the current one\n", version)
+		return nil
+	}
+	if err := c.agents.Use(version); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	fmt.Printf("✅ Current agent: %s\n", version)
+	fmt.Println("   Running services keep the agent they loaded until they restart")
+	fmt.Printf("   Restart them one by one with: mw-injector agent upgrade %s\n", version)
+	return nil
+}
+
+// prune removes the versions nothing uses: not current, not pinned by the
+// policy and not loaded by a running JVM
+func (c *AgentCommand) prune() error {
+	versions, err := c.agents.Versions()
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	vars, err := c.vars()
+	if err != nil {
+		return err
+	}
+	pol, err := loadPolicy(vars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	keep := map[string]string{c.agents.Current(): "current"}
+	if pol != nil {
+		for _, rule := range pol.Rules {
+			if rule.AgentVersion != "" {
+				keep[rule.AgentVersion] = "pinned by rule " + rule.Name
+			}
+		}
+	}
+	processes, err := discovery.FindAllJavaProcesses(context.Background())
+	if err != nil {
+		return fmt.Errorf("❌ Error discovering processes: %v", err)
+	}
+	for i := range processes {
+		if version := c.agents.LoadedVersion(processes[i].ProcessPID); version != "" {
+			keep[version] = "loaded by " + processes[i].ServiceName
+		}
+	}
+
+	var unused []string
+	for _, version := range versions {
+		if reason, ok := keep[version]; ok {
+			fmt.Printf("📌 Keeping %s: %s\n", version, reason)
+			continue
+		}
+		unused = append(unused, version)
+	}
+	if len(unused) == 0 {
+		fmt.Println("✅ No unused agent versions")
+		return nil
+	}
+	if !confirm(c.config, fmt.Sprintf("Remove agent %s?", strings.Join(unused, ", "))) {
+		return nil
+	}
+
+	store, err := state.LoadStore()
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	for _, version := range unused {
+		if err := c.agents.Remove(version); err != nil {
+			return fmt.Errorf("❌ %v", err)
+		}
+		store.RemoveAgent(c.agents.Path(version))
+		fmt.Printf("🗑️  Removed agent %s\n", version)
+	}
+	if err := state.SaveStore(store); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	return nil
+}
+
+// upgrade switches the current agent and restarts the services still
+// running another version, one at a time behind their health checks. When
+// one fails, the previous version becomes current again and the services
+// restarted so far are restarted on it. A JAR, the latest release or a
+// version the repository has but the store doesn't is installed first.
+func (c *AgentCommand) upgrade(version string) error {
+	vars, err := c.vars()
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(version, ".jar") || version == agent.Latest ||
+		(version != "" && vars["MW_AGENT_REPOSITORY"] != "" && !changeset.Exists(c.agents.Path(version))) {
+		if version, err = installRelease(c.config, vars, version); err != nil {
+			return fmt.Errorf("❌ %v", err)
+		}
+	}
+	pol, err := loadPolicy(vars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if err := setRestartTimeout(vars["MW_RESTART_TIMEOUT"]); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	if version == "" {
+		versions, err := c.agents.Versions()
+		if err != nil {
+			return fmt.Errorf("❌ %v", err)
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("❌ No agent versions installed\n   Install one with: mw-injector agent install <jar|version>")
+		}
+		version = versions[len(versions)-1]
+	}
+	if !changeset.Exists(c.agents.Path(version)) {
+		return fmt.Errorf("❌ Agent %s is not installed\n   Install it with: mw-injector agent install <jar|version>", version)
+	}
+
+	processes, err := discovery.FindAllJavaProcesses(context.Background())
+	if err != nil {
+		return fmt.Errorf("❌ Error discovering processes: %v", err)
+	}
+	processes = filterProcesses(c.config.Filter, processes)
+	queue := c.outdated(processes, version, pol)
+
+	previous := c.agents.Current()
+	if previous != version {
+		if err := c.agents.Use(version); err != nil {
+			return fmt.Errorf("❌ %v", err)
+		}
+		fmt.Printf("🔀 Current agent: %s (was %s)\n", version, firstNonEmpty(previous, "none"))
+	}
+
+	var now, deferred []*restartEntry
+	for _, e := range queue.entries {
+		if e.deferred {
+			deferred = append(deferred, e)
+		} else {
+			now = append(now, e)
+		}
+	}
+	if len(deferred) > 0 {
+		queue.deferRestarts(deferred)
+	}
+	if len(now) == 0 {
+		fmt.Printf("✅ No running service needs a restart for agent %s\n", version)
+		return nil
+	}
+
+	fmt.Printf("\n🔄 Restarting %d service(s) on agent %s, one at a time\n\n", len(now), version)
+	orchestrator := &restart.Orchestrator{Delay: queue.settings.Delay, Timeout: systemd.RestartTimeout}
+	tasks := make([]restart.Task, len(now))
+	for i, e := range now {
+		tasks[i] = restartTask(e, nil, orchestrator)
+		tasks[i].Group = upgradeGroup
+	}
+	results := orchestrator.Run(tasks)
+
+	var restarted []*restartEntry
+	failed := ""
+	for i, result := range results {
+		if !result.Skipped {
+			restarted = append(restarted, now[i])
+		}
+		if result.Err != nil && !result.Skipped {
+			failed = result.Name
+		}
+	}
+	if failed == "" {
+		fmt.Printf("\n✅ All services run agent %s\n", version)
+		return nil
+	}
+
+	if previous == "" || previous == version {
+		return fmt.Errorf("❌ %s failed on agent %s, the upgrade stopped\n   Check it, then run mw-injector agent upgrade %s again", failed, version, version)
+	}
+	fmt.Printf("\n⏪ %s failed on agent %s, switching back to %s\n", failed, version, previous)
+	if err := c.agents.Use(previous); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	fmt.Printf("🔄 Restarting %d service(s) on agent %s again...\n\n", len(restarted), previous)
+	runRestarts(restarted, transactions{}, queue.settings)
+	return fmt.Errorf("❌ Agent upgrade to %s failed at %s, %s is current again", version, failed, previous)
+}
+
+// outdated queues the restarts of the services that reference the current
+// agent but loaded another version. Services the policy pins are left
+// alone, they reference their version directly.
+func (c *AgentCommand) outdated(processes []discovery.JavaProcess, version string, pol *policy.Policy) *restartQueue {
+	queue := newRestartQueue(pol)
+	for i := range processes {
+		proc := &processes[i]
+		if !proc.HasJavaAgent || filepath.Clean(proc.JavaAgentPath) != c.agents.CurrentPath() {
+			continue
+		}
+		decision := pol.EvaluateProcess(proc)
+		if decision.AgentVersion != "" {
+			fmt.Printf("📌 PID %d (%s) is pinned to agent %s by rule %s, run reconcile to update its config\n",
+				proc.ProcessPID, proc.ServiceName, decision.AgentVersion, decision.Rule)
+			continue
+		}
+		if loaded := c.agents.LoadedVersion(proc.ProcessPID); loaded == version {
+			continue
+		}
+
+		unit := discovery.SystemdUnitForPID(proc.ProcessPID)
+		if decision.Restart == policy.RestartManual {
+			fmt.Printf("⭐️  PID %d (%s) runs an older agent\n", proc.ProcessPID, proc.ServiceName)
+			restartManually(decision, unit)
+			continue
+		}
+		if info := proc.DetectSupervisor(); info.Kind != discovery.SupervisorSystemd && isSupervised(info) {
+			injector, err := supervisor.NewInjector(info)
+			if err == nil {
+				queue.addSupervised(proc, injector, info, decision, nil)
+				continue
+			}
+		}
+		if unit == "" {
+			fmt.Printf("⭐️  PID %d (%s) runs an older agent, restart it by hand\n", proc.ProcessPID, proc.ServiceName)
+			continue
+		}
+		queue.addUnit(proc, unit, decision, nil)
+	}
+	return queue
+}
+
+// installAgent verifies the agent, installs it into the agent store and
+// records its version and checksum in the state store. It returns the path
+// of the current agent, which the first agent installed becomes.
+func installAgent(config *types.CommandConfig, vars systemd.ConfigVars, sourcePath string) (string, error) {
+	agents := agent.NewStore(config.DefaultAgentDir)
+	store, err := state.LoadStore()
+	if err != nil {
+		return "", err
+	}
+
+	// Hosts instrumented before the store have the agent at the old path,
+	// others without one get the latest release
+	if sourcePath == agents.CurrentPath() && !changeset.Exists(sourcePath) {
+		if changeset.Exists(agent.LegacyPath) {
+			sourcePath = agent.LegacyPath
+		} else if sourcePath, err = fetchAgent(vars, agent.Latest); err != nil {
+			return "", fmt.Errorf("no agent is installed and none could be fetched: %v\n   Set MW_AGENT_REPOSITORY in the config file, or pass --agent-path", err)
+		}
+	}
+
+	version, err := agent.JarVersion(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	// An agent already installed is kept, it's the one to verify
+	path := sourcePath
+	if changeset.Exists(agents.Path(version)) {
+		path = agents.Path(version)
+	}
+	v, err := verifyAgent(config, vars, path, store.Agent(path))
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := agents.Install(sourcePath); err != nil {
+		return "", err
+	}
+	// The source may have changed since it was verified, what counts is
+	// the copy in the store
+	installedPath := agents.Path(version)
+	if sum, err := agent.Checksum(existingPath(installedPath, sourcePath)); err != nil {
+		return "", err
+	} else if !strings.EqualFold(sum, v.SHA256) {
+		if path != installedPath {
+			changeset.Remove(installedPath)
+		}
+		return "", fmt.Errorf("%s changed while it was installed (SHA-256 %s, verified %s), it may have been tampered with", sourcePath, sum, v.SHA256)
+	}
+	switch current := agents.Current(); {
+	case current == "":
+		if err := agents.Use(version); err != nil {
+			return "", err
+		}
+	case current != version:
+		fmt.Printf("ℹ️  Services keep using agent %s, switch them to %s with: mw-injector agent upgrade %s\n", current, version, version)
+	}
+
+	if err := recordAgent(store, version, installedPath, existingPath(installedPath, sourcePath), v); err != nil {
+		return "", err
+	}
+	return agents.CurrentPath(), nil
+}
+
+// installRelease installs an agent JAR, or a version fetched from the
+// repository, and returns its version
+func installRelease(config *types.CommandConfig, vars systemd.ConfigVars, source string) (string, error) {
+	if !strings.HasSuffix(source, ".jar") {
+		fetched, err := fetchAgent(vars, source)
+		if err != nil {
+			return "", fmt.Errorf("Failed to fetch agent: %v", err)
+		}
+		source = fetched
+	}
+	if _, err := installAgent(config, vars, source); err != nil {
+		return "", fmt.Errorf("Failed to install agent: %v", err)
+	}
+	return agent.JarVersion(source)
+}
+
+// fetchAgent downloads a release from the repository the config file
+// names into the agent cache, or finds it there when offline
+func fetchAgent(vars systemd.ConfigVars, version string) (string, error) {
+	fetcher, err := agent.NewFetcher(vars["MW_AGENT_REPOSITORY"],
+		firstNonEmpty(vars["MW_AGENT_CACHE_DIR"], agent.DefaultCacheDir), vars["MW_AGENT_PROXY"])
+	if err != nil {
+		return "", err
+	}
+	return fetcher.Fetch(version)
+}
+
+// recordAgent records an installed agent, skipping the save when the
+// record is unchanged. A dry run only pretends to copy the agent, so its
+// info is read from where it would be copied from.
+func recordAgent(store *state.Store, version, installedPath, readPath string, v *agent.Verification) error {
+	info, err := agent.GetAgentInfo(readPath)
+	if err != nil {
+		return err
+	}
+	if recorded := store.Agent(installedPath); recorded != nil && recorded.Version == version &&
+		recorded.SHA256 == v.SHA256 && recorded.Verification == v.Method {
+		return nil
+	}
+	store.RecordAgent(state.AgentRecord{
+		Path:         installedPath,
+		Version:      version,
+		SDKVersion:   info.SDKVersion,
+		SHA256:       v.SHA256,
+		Verification: v.Method,
+	})
+	if err := state.SaveStore(store); err != nil {
+		return fmt.Errorf("failed to record agent: %w", err)
+	}
+	return nil
+}
+
+// verifyAgent checks nothing but a verified agent is installed. An agent
+// installed earlier must still have the checksum recorded then.
+func verifyAgent(config *types.CommandConfig, vars systemd.ConfigVars, path string, recorded *state.AgentRecord) (*agent.Verification, error) {
+	if recorded != nil && recorded.SHA256 != "" {
+		sum, err := agent.Checksum(path)
+		if err != nil {
+			return nil, err
+		}
+		if sum != recorded.SHA256 {
+			return nil, fmt.Errorf("%s changed since it was installed (SHA-256 %s, recorded %s), it may have been tampered with\n   Remove it and install a verified agent", path, sum, recorded.SHA256)
+		}
+		if recorded.Verification != agent.Unverified || config.AllowUnverified {
+			return &agent.Verification{Method: recorded.Verification, SHA256: sum}, nil
+		}
+	}
+
+	v, err := agent.Verify(path, verifyOptions(vars))
+	switch {
+	case err == nil:
+		fmt.Printf("🔏 Agent verified by %s\n", v.Method)
+	case errors.Is(err, agent.ErrUnverified) && config.AllowUnverified:
+		fmt.Printf("⚠️  %v, installing it anyway (--allow-unverified)\n", err)
+	case errors.Is(err, agent.ErrUnverified):
+		return nil, fmt.Errorf("%v\n   Publish a signed %s next to the agent, set MW_AGENT_SHA256 or MW_AGENT_CHECKSUMS, or pass --allow-unverified", err, agent.ChecksumsFile)
+	default:
+		return nil, fmt.Errorf("agent verification failed: %v", err)
+	}
+	return v, nil
+}
+
+// verifyOptions reads the trust anchors from the config file
+func verifyOptions(vars systemd.ConfigVars) agent.VerifyOptions {
+	opts := agent.VerifyOptions{SHA256: vars["MW_AGENT_SHA256"], Checksums: vars["MW_AGENT_CHECKSUMS"]}
+	if key := vars["MW_AGENT_PUBLIC_KEY"]; key != "" {
+		opts.PublicKeys = []string{key}
+	}
+	return opts
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/doctor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// severityIcons are printed before each finding
+var severityIcons = map[string]string{
+	doctor.OK:      "✅",
+	doctor.Info:    "ℹ️ ",
+	doctor.Warning: "⚠️ ",
+	doctor.Error:   "❌",
+}
+
+// DoctorCommand checks the host is ready to be instrumented
+type DoctorCommand struct {
+	config     *types.CommandConfig
+	configFile string
+}
+
+func NewDoctorCommand(config *types.CommandConfig, configFile string) *DoctorCommand {
+	return &DoctorCommand{config: config, configFile: configFile}
+}
+
+func (c *DoctorCommand) Execute() error {
+	vars := systemd.ConfigVars{}
+	if path := firstNonEmpty(c.configFile, findDefaultConfigFile()); path != "" {
+		var err error
+		if vars, err = systemd.ReadConfigFile(path); err != nil {
+			return fmt.Errorf("❌ Failed to load config from %s: %v", path, err)
+		}
+		fmt.Printf("🔧 Using configuration from: %s\n", path)
+	}
+	agentPath := firstNonEmpty(c.config.AgentPath, vars["MW_JAVA_AGENT_PATH"], c.config.DefaultAgentPath)
+
+	ctx := context.Background()
+	processes, err := discovery.FindAllJavaProcesses(ctx)
+	if err != nil {
+		return fmt.Errorf("❌ Error discovering processes: %v", err)
+	}
+	processes = filterProcesses(c.config.Filter, processes)
+
+	fmt.Printf("🩺 Checking host readiness (%d Java processes)\n\n", len(processes))
+	report := runDoctor(agentPath, processes, vars, c.config.Filter.Empty())
+	for _, f := range report.Findings {
+		printFinding(f)
+	}
+
+	errors, warnings := report.Count(doctor.Error), report.Count(doctor.Warning)
+	fmt.Printf("\n📊 %d errors, %d warnings\n", errors, warnings)
+	if errors > 0 {
+		return fmt.Errorf("❌ %d problems must be fixed before instrumenting", errors)
+	}
+	return nil
+}
+
+func (c *DoctorCommand) GetDescription() string {
+	return "Check the host is ready to be instrumented"
+}
+
+// runDoctor runs the checks this user can run, with the settings of the
+// config file
+func runDoctor(agentPath string, processes []discovery.JavaProcess, vars systemd.ConfigVars, docker bool) *doctor.Report {
+	return doctor.Run(doctor.Options{
+		AgentPath:  agentPath,
+		ConfigRoot: configRoot(),
+		Processes:  processes,
+		Verify:     verifyOptions(vars),
+		Root:       !userMode(),
+		SkipAccess: vars["SKIP_SE_CHECK"] == "true",
+		Docker:     docker && !userMode(),
+		Store:      !userMode(),
+	})
+}
+
+// preflight runs the checks before auto-instrument changes anything,
+// printing only the problems. Errors stop the run unless confirmed.
+func preflight(config *types.CommandConfig, agentPath string, processes []discovery.JavaProcess, vars systemd.ConfigVars) error {
+	report := runDoctor(agentPath, processes, vars, false)
+
+	problems := 0
+	for _, f := range report.Findings {
+		if f.Severity == doctor.Warning || f.Severity == doctor.Error {
+			if problems == 0 {
+				fmt.Printf("\n🩺 Preflight checks found problems:\n")
+			}
+			problems++
+			printFinding(f)
+		}
+	}
+	if problems == 0 {
+		fmt.Printf("\n🩺 Preflight checks passed\n")
+		return nil
+	}
+
+	if errors := report.Count(doctor.Error); errors > 0 &&
+		!confirm(config, fmt.Sprintf("\n%d preflight checks failed, instrument anyway?", errors)) {
+		return fmt.Errorf("❌ Preflight checks failed, fix them first (see mw-injector doctor) or pass --skip-doctor")
+	}
+	return nil
+}
+
+// printFinding prints a finding and how to fix it
+func printFinding(f doctor.Finding) {
+	fmt.Printf("%s %-10s %s\n", severityIcons[f.Severity], f.Check, f.Message)
+	if f.Fix != "" {
+		fmt.Printf("   └── Fix: %s\n", f.Fix)
+	}
+}
+
+// existingPath returns the first path that exists, the last one otherwise
+func existingPath(paths ...string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return paths[len(paths)-1]
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// matchesAny tells whether any value matches any of the glob patterns, no
+// patterns match everything
+func matchesAny(patterns []string, values ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unitNames returns a unit as given and without its .service suffix, so
+// --unit billing matches billing.service
+func unitNames(unit string) []string {
+	return []string{unit, strings.TrimSuffix(unit, ".service")}
+}
+
+// matchesProcess tells whether a process passes the --pid, --service,
+// --owner and --unit filters
+func matchesProcess(f types.Filter, proc *discovery.JavaProcess) bool {
+	if len(f.PIDs) > 0 && !slices.Contains(f.PIDs, int(proc.ProcessPID)) {
+		return false
+	}
+	if !matchesAny(f.Services, proc.ServiceName, naming.GenerateServiceName(proc)) {
+		return false
+	}
+	if !matchesAny(f.Owners, proc.ProcessOwner) {
+		return false
+	}
+	// Resolving the unit asks systemd, only do it when filtering on it
+	if len(f.Units) > 0 {
+		unit := systemd.GetServiceName(proc)
+		if proc.IsTomcat() {
+			unit = systemd.GetTomcatServiceName()
+		}
+		return matchesAny(f.Units, unitNames(unit)...)
+	}
+	return true
+}
+
+// matchesOrphan tells whether the config of a service that isn't running
+// passes the filters, PID and owner filters never match it
+func matchesOrphan(f types.Filter, serviceName, unit string) bool {
+	if len(f.PIDs) > 0 || len(f.Owners) > 0 {
+		return false
+	}
+	if unit == "" {
+		unit = serviceName + ".service"
+	}
+	return matchesAny(f.Services, serviceName) && matchesAny(f.Units, unitNames(unit)...)
+}
+
+// matchesContainer tells whether a container passes the --container and
+// --image filters
+func matchesContainer(f types.Filter, c *discovery.DockerContainer) bool {
+	return matchesAny(f.Containers, c.ContainerName) &&
+		matchesAny(f.Images, c.ImageName, c.ImageName+":"+c.ImageTag)
+}
+
+// matchesSavedContainer tells whether an instrumented container passes the
+// --container and --image filters, its image is recorded without the tag
+func matchesSavedContainer(f types.Filter, c *docker.ContainerState) bool {
+	return matchesAny(f.Containers, c.ContainerName) && matchesAny(f.Images, c.ImageName)
+}
+
+// filterProcesses keeps the processes that pass the filters
+func filterProcesses(f types.Filter, processes []discovery.JavaProcess) []discovery.JavaProcess {
+	if f.Empty() {
+		return processes
+	}
+	var matched []discovery.JavaProcess
+	for i := range processes {
+		if matchesProcess(f, &processes[i]) {
+			matched = append(matched, processes[i])
+		}
+	}
+	return matched
+}
+
+// filterContainers keeps the containers that pass the filters
+func filterContainers(f types.Filter, containers []discovery.DockerContainer) []discovery.DockerContainer {
+	if f.Empty() {
+		return containers
+	}
+	var matched []discovery.DockerContainer
+	for i := range containers {
+		if matchesContainer(f, &containers[i]) {
+			matched = append(matched, containers[i])
+		}
+	}
+	return matched
+}
@@ -0,0 +1,382 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// txIcons are printed before each transaction of an operation
+var txIcons = map[string]string{
+	changeset.TxInProgress:     "⏳",
+	changeset.TxApplied:        "🔧",
+	changeset.TxVerified:       "✅",
+	changeset.TxRolledBack:     "⏪",
+	changeset.TxRollbackFailed: "❌",
+}
+
+// HistoryCommand lists the operations recorded in the journal
+type HistoryCommand struct {
+	config *types.CommandConfig
+	opID   string
+}
+
+func NewHistoryCommand(config *types.CommandConfig, opID string) *HistoryCommand {
+	return &HistoryCommand{config: config, opID: opID}
+}
+
+func (c *HistoryCommand) Execute() error {
+	journals, err := changeset.LoadJournals(journalDir())
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	undoneBy := make(map[string]string)
+	for _, j := range journals {
+		if j.Undoes != "" {
+			undoneBy[j.Undoes] = j.ID
+		}
+	}
+
+	if c.opID != "" {
+		j := findOperation(journals, c.opID)
+		if j == nil {
+			return fmt.Errorf("❌ Operation %s not found, see mw-injector history", c.opID)
+		}
+		printOperation(j, undoneBy[j.ID], true)
+		return nil
+	}
+
+	if len(journals) == 0 {
+		fmt.Println("No operations recorded")
+		return nil
+	}
+
+	fmt.Printf("📜 Operation history (newest first)\n\n")
+	for _, j := range journals {
+		printOperation(j, undoneBy[j.ID], false)
+	}
+	fmt.Println("💡 Details: mw-injector history <op-id>, revert: sudo mw-injector undo <op-id>")
+	return nil
+}
+
+func (c *HistoryCommand) GetDescription() string {
+	return "List the operations that changed services and containers"
+}
+
+// findOperation returns the journal of an operation, nil if there's none
+func findOperation(journals []*changeset.Journal, id string) *changeset.Journal {
+	for _, j := range journals {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// printOperation prints an operation and its targets, with every step when
+// detailed
+func printOperation(j *changeset.Journal, undoneBy string, detailed bool) {
+	user := j.User
+	if user == "" {
+		user = "unknown"
+	}
+	fmt.Printf("%s  %s  %-12s %s\n", j.ID, j.StartedAt.Local().Format("2006-01-02 15:04:05"), user, j.Command)
+	if detailed && len(j.Args) > 0 {
+		fmt.Printf("   └── Args: %s\n", strings.Join(j.Args, " "))
+	}
+	if j.Undoes != "" {
+		fmt.Printf("   └── Undoes %s\n", j.Undoes)
+	}
+
+	for _, tx := range j.Transactions {
+		fmt.Printf("   %s %s (%s", txIcons[tx.Status], tx.Target, tx.Status)
+		if tx.Unit != "" {
+			fmt.Printf(", %s", tx.Unit)
+		}
+		fmt.Println(")")
+		if tx.Error != "" {
+			fmt.Printf("      └── %s\n", tx.Error)
+		}
+		if detailed {
+			printSteps(tx.Steps, "      ")
+		}
+	}
+	if detailed && len(j.Steps) > 0 {
+		fmt.Println("   Outside any service:")
+		printSteps(j.Steps, "      ")
+	}
+
+	if undoneBy != "" {
+		fmt.Printf("   ↩️  Undone by %s\n", undoneBy)
+	}
+	fmt.Println()
+}
+
+// printSteps prints the operations of a transaction and their results
+func printSteps(steps []*changeset.Step, indent string) {
+	for _, step := range steps {
+		icon := "✅"
+		switch step.Status {
+		case changeset.StepFailed:
+			icon = "❌"
+		case changeset.StepIntended:
+			icon = "⏳"
+		}
+		what := step.Path
+		switch {
+		case len(step.Args) > 0:
+			what = strings.Join(step.Args, " ")
+		case step.Target != "":
+			what += " → " + step.Target
+		}
+		fmt.Printf("%s%s %-6s %s\n", indent, icon, step.Kind, what)
+		if step.Error != "" {
+			fmt.Printf("%s   └── %s\n", indent, step.Error)
+		}
+	}
+}
+
+// UndoCommand reverts the changes of one operation from the originals
+// captured in its journal
+type UndoCommand struct {
+	config    *types.CommandConfig
+	opID      string
+	noRestart bool
+}
+
+func NewUndoCommand(config *types.CommandConfig, opID string, noRestart bool) *UndoCommand {
+	return &UndoCommand{config: config, opID: opID, noRestart: noRestart}
+}
+
+func (c *UndoCommand) Execute() error {
+	if os.Geteuid() != 0 && !userMode() {
+		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector undo %s", c.opID)
+	}
+
+	journals, err := changeset.LoadJournals(journalDir())
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	// Journals are newest first, the ones before the operation came later
+	var op *changeset.Journal
+	var later []*changeset.Journal
+	own := changeset.CurrentJournal()
+	for _, j := range journals {
+		if own != nil && j.ID == own.ID {
+			continue
+		}
+		if j.Undoes == c.opID {
+			return fmt.Errorf("❌ Operation %s was already undone by %s", c.opID, j.ID)
+		}
+		if j.ID == c.opID {
+			op = j
+			break
+		}
+		later = append(later, j)
+	}
+	if op == nil {
+		return fmt.Errorf("❌ Operation %s not found, see mw-injector history", c.opID)
+	}
+
+	// Files changed again later belong to those operations now
+	changedBy := make(map[string]string)
+	for i := len(later) - 1; i >= 0; i-- {
+		for _, path := range later[i].Touched() {
+			changedBy[path] = later[i].ID
+		}
+	}
+
+	fmt.Printf("↩️  Undoing %s (%s, %s)\n\n", op.ID, op.Command, op.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	changeset.MarkUndo(op.ID)
+
+	u := &undo{stateOriginals: make(map[string][]byte)}
+	for _, tx := range op.Transactions {
+		for _, o := range tx.Originals {
+			if _, seen := u.stateOriginals[o.Path]; state.IsStateFile(o.Path) && !seen {
+				u.stateOriginals[o.Path] = nil
+				if o.Existed {
+					u.stateOriginals[o.Path] = []byte(o.Content)
+				}
+			}
+		}
+	}
+	for i := len(op.Transactions) - 1; i >= 0; i-- {
+		u.transaction(op.Transactions[i], changedBy)
+	}
+
+	if len(u.stateOriginals) > 0 {
+		if err := u.revertState(op); err != nil {
+			fmt.Printf("⚠️  Failed to update the state store: %v\n", err)
+			u.failed++
+		}
+	}
+
+	var outside []string
+	for _, step := range op.Steps {
+		if step.Path != "" && !state.IsStateFile(step.Path) {
+			outside = append(outside, step.Path)
+		}
+	}
+	if len(outside) > 0 {
+		fmt.Printf("ℹ️  Changes outside any service aren't undone: %s\n", strings.Join(outside, ", "))
+	}
+
+	u.restart(c.noRestart)
+
+	fmt.Printf("\n🎉 Undo of %s complete!\n", op.ID)
+	fmt.Printf("   Undone: %d\n", u.undone)
+	fmt.Printf("   Skipped: %d\n", u.skipped)
+	fmt.Printf("   Failed: %d\n", u.failed)
+	if u.skipped > 0 {
+		fmt.Printf("\n⚠️  Targets changed again later were left as is, undo those operations first\n")
+	}
+	if u.failed > 0 {
+		return fmt.Errorf("operation %s was only partly undone", op.ID)
+	}
+	return nil
+}
+
+func (c *UndoCommand) GetDescription() string {
+	return "Revert the changes of one operation from history"
+}
+
+// undo collects what reverting an operation's transactions restored
+type undo struct {
+	stateOriginals map[string][]byte // state files as the operation found them
+	restored       []string
+	containers     []string
+	units          []string
+	supervised     []string
+
+	undone, skipped, failed int
+}
+
+// transaction reverts one target, unless a later operation changed its
+// files again
+func (u *undo) transaction(tx *changeset.Transaction, changedBy map[string]string) {
+	if tx.Status == changeset.TxRolledBack || tx.Status == changeset.TxRollbackFailed {
+		return
+	}
+
+	for _, path := range tx.Touched() {
+		if id := changedBy[path]; id != "" && !state.IsStateFile(path) {
+			fmt.Printf("⚠️  Skipping %s: %s was changed again by %s\n", tx.Target, path, id)
+			u.skipped++
+			return
+		}
+	}
+
+	fmt.Printf("⏪ %s\n", tx.Target)
+	undoTx := changeset.Begin(tx.Target)
+	defer changeset.End()
+	undoTx.SetUnit(tx.Unit)
+
+	if err := tx.RunUndo(true); err != nil {
+		fmt.Printf("   ❌ %v\n", err)
+		u.failed++
+		return
+	}
+	for i := len(tx.Originals) - 1; i >= 0; i-- {
+		o := tx.Originals[i]
+		if state.IsStateFile(o.Path) {
+			continue
+		}
+		if err := o.Restore(); err != nil {
+			fmt.Printf("   ❌ %v\n", err)
+			u.failed++
+			return
+		}
+		if !o.Dir {
+			fmt.Printf("   Restored: %s\n", o.Path)
+		}
+		u.restored = append(u.restored, o.Path)
+	}
+	if err := tx.RunUndo(false); err != nil {
+		fmt.Printf("   ❌ %v\n", err)
+		u.failed++
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(tx.Target, "container "):
+		name, _, _ := strings.Cut(strings.TrimPrefix(tx.Target, "container "), " ")
+		u.containers = append(u.containers, name)
+	case tx.Target == shimTarget:
+		// The shim only sees JVMs started from now on, nothing to restart
+	case tx.Unit != "":
+		if !slices.Contains(u.units, tx.Unit) {
+			u.units = append(u.units, tx.Unit)
+		}
+	default:
+		u.supervised = append(u.supervised, strings.TrimPrefix(tx.Target, "process "))
+	}
+	u.undone++
+}
+
+// revertState puts back the records of what was undone, and drops the
+// operation's deferred restarts
+func (u *undo) revertState(op *changeset.Journal) error {
+	earlier, err := state.EarlierStore(u.stateOriginals)
+	if err != nil {
+		return err
+	}
+	if err := state.RevertStore(earlier, u.restored, u.containers); err != nil {
+		return err
+	}
+
+	pending, err := state.LoadPendingRestarts()
+	if err != nil || len(pending.Restarts) == 0 {
+		return err
+	}
+	var remaining []state.PendingRestart
+	for _, p := range pending.Restarts {
+		if p.Journal != op.Path() {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(remaining) == len(pending.Restarts) {
+		return nil
+	}
+	pending.Restarts = remaining
+	return state.SavePendingRestarts(pending)
+}
+
+// restart restarts the units that were undone, so they run as before
+func (u *undo) restart(noRestart bool) {
+	for _, name := range u.supervised {
+		fmt.Printf("💡 Restart %s through its supervisor to apply the undo\n", name)
+	}
+	if len(u.units) == 0 {
+		return
+	}
+
+	if noRestart {
+		fmt.Println("\n💡 Restart the services to apply the undo:")
+		for _, unit := range u.units {
+			fmt.Printf("   %s\n", systemd.ManualRestartCommand(unit))
+		}
+		return
+	}
+
+	fmt.Printf("\n🔄 Restarting %d service(s)...\n\n", len(u.units))
+	systemd.ReloadSystemd()
+	for _, unit := range u.units {
+		fmt.Printf("   Restarting %s...", unit)
+		if err := systemd.RestartService(unit); err != nil {
+			fmt.Printf(" ❌ Failed\n")
+			fmt.Printf("       Error: %v\n", err)
+			fmt.Printf("       Try manually: %s\n", systemd.ManualRestartCommand(unit))
+			u.failed++
+		} else {
+			fmt.Printf(" ✅ Done\n")
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// DefaultTarget is the endpoint used when none is configured
+const DefaultTarget = "https://prod.middleware.io:443"
+
+// stdin is shared by all prompts, so input buffered for one isn't lost to
+// the next
+var stdin = bufio.NewReader(os.Stdin)
+
+// ask prompts for a value, returning def when nothing is entered or prompts
+// are disabled
+func ask(config *types.CommandConfig, prompt, def string) string {
+	if config.NonInteractive {
+		return def
+	}
+	fmt.Print(prompt)
+	answer, _ := stdin.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a y/N question, --yes answers yes and --non-interactive no
+func confirm(config *types.CommandConfig, question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	switch {
+	case config.Yes:
+		fmt.Println("y (--yes)")
+		return true
+	case config.NonInteractive:
+		fmt.Println("n (--non-interactive)")
+		return false
+	}
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// runSettings are the API key, endpoint and agent an instrument command uses
+type runSettings struct {
+	apiKey    string
+	target    string
+	agentPath string
+	logLevel  string
+	vars      systemd.ConfigVars // the config file, empty without one
+}
+
+// UseConfigFile makes the instrument commands read their settings from a
+// config file, the default one when path is empty, and update services that
+// are already configured without prompting, as the *-config commands do
+func UseConfigFile(config *types.CommandConfig, path, command string) error {
+	if path == "" {
+		path = firstNonEmpty(config.ConfigFile, findDefaultConfigFile())
+	}
+	if path == "" {
+		return fmt.Errorf("❌ No config file found. Please create /etc/mw-injector.conf or provide path:\n   Usage: mw-injector %s <config-file>", command)
+	}
+	config.ConfigFile = path
+	config.Yes = true
+	config.NonInteractive = true
+	return nil
+}
+
+// resolveSettings takes each setting from its flag, the config file or a
+// prompt, in that order, and stores the API key
+func resolveSettings(config *types.CommandConfig) (*runSettings, error) {
+	s := &runSettings{vars: systemd.ConfigVars{}}
+	if config.ConfigFile != "" {
+		vars, err := systemd.ReadConfigFile(config.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Failed to load config from %s: %v", config.ConfigFile, err)
+		}
+		s.vars = vars
+	}
+	// Without a config file the missing settings are prompted for
+	prompts := *config
+	prompts.NonInteractive = config.NonInteractive || config.ConfigFile != ""
+
+	apiKey, err := readAPIKey(config, s.vars, "Middleware.io API Key: ")
+	if err != nil {
+		return nil, err
+	}
+	s.apiKey = apiKey
+
+	s.target = firstNonEmpty(config.Target, s.vars["MW_TARGET"])
+	if s.target == "" {
+		s.target = ask(&prompts, fmt.Sprintf("Target endpoint [%s]: ", DefaultTarget), DefaultTarget)
+	}
+	s.agentPath = firstNonEmpty(config.AgentPath, s.vars["MW_JAVA_AGENT_PATH"])
+	if s.agentPath == "" {
+		s.agentPath = ask(&prompts, fmt.Sprintf("Java agent path [%s]: ", config.DefaultAgentPath), config.DefaultAgentPath)
+	}
+	s.logLevel = firstNonEmpty(config.LogLevel, s.vars["MW_LOG_LEVEL"])
+
+	if config.ConfigFile != "" {
+		fmt.Printf("🔧 Using configuration from: %s\n", config.ConfigFile)
+		fmt.Printf("   API Key: %s\n", secrets.Mask(s.apiKey))
+		fmt.Printf("   Target: %s\n", s.target)
+		fmt.Printf("   Agent Path: %s\n", s.agentPath)
+	}
+
+	if err := storeAPIKey(s.apiKey); err != nil {
+		return nil, fmt.Errorf("❌ %v", err)
+	}
+	return s, nil
+}
+
+// readAPIKey reads the API key from --api-key-file or MW_API_KEY in the
+// config file, prompting for it when neither is given
+func readAPIKey(config *types.CommandConfig, vars systemd.ConfigVars, prompt string) (string, error) {
+	var apiKey string
+	switch {
+	case config.APIKeyFile != "":
+		data, err := os.ReadFile(config.APIKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("❌ Failed to read API key: %v", err)
+		}
+		apiKey = strings.TrimSpace(string(data))
+	case vars["MW_API_KEY"] != "":
+		apiKey = vars["MW_API_KEY"]
+	case config.ConfigFile != "":
+		return "", fmt.Errorf("❌ MW_API_KEY is required in config file")
+	case config.NonInteractive:
+		return "", fmt.Errorf("❌ API key is required, pass --api-key-file or --config")
+	default:
+		apiKey = ask(config, prompt, "")
+	}
+
+	if apiKey == "" {
+		return "", fmt.Errorf("❌ API key is required")
+	}
+	return apiKey, nil
+}
+
+// firstNonEmpty returns the first value that's set
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
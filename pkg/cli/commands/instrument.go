@@ -1,20 +1,19 @@
 package commands
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"os"
-	"strings"
-
-	"github.com/k0kubun/pp"
+	"sort"
 
 	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 	"github.com/middleware-labs/java-injector/pkg/cli/types"
-	"github.com/middleware-labs/java-injector/pkg/config"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
 	"github.com/middleware-labs/java-injector/pkg/docker"
 	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
 	"github.com/middleware-labs/java-injector/pkg/systemd"
 )
 
@@ -30,54 +29,61 @@ func NewAutoInstrumentCommand(config *types.CommandConfig) *AutoInstrumentComman
 func (c *AutoInstrumentCommand) Execute() error {
 	ctx := context.Background()
 
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector auto-instrument")
+	// Without root only the caller's own systemd --user units are instrumented
+	if userMode() {
+		fmt.Println("👤 Running without root: only your systemd --user units will be instrumented")
 	}
 
-	// Get API key and target
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Print("Middleware.io API Key: ")
-	apiKey, _ := reader.ReadString('\n')
-	apiKey = strings.TrimSpace(apiKey)
-
-	if apiKey == "" {
-		return fmt.Errorf("❌ API key is required")
+	run, err := resolveSettings(c.config)
+	if err != nil {
+		return err
 	}
+	apiKey, target, agentPath := run.apiKey, run.target, run.agentPath
 
-	fmt.Print("Target endpoint [https://prod.middleware.io:443]: ")
-	target, _ := reader.ReadString('\n')
-	target = strings.TrimSpace(target)
-	if target == "" {
-		target = "https://prod.middleware.io:443"
+	servicePattern := run.vars["MW_SERVICE_NAME_PATTERN"]
+	if servicePattern == "" {
+		servicePattern = naming.DefaultTomcatWebappPattern
 	}
 
-	fmt.Printf("Java agent path [%s]: \n", c.config.DefaultAgentPath)
-	agentPath, _ := reader.ReadString('\n')
-	agentPath = strings.TrimSpace(agentPath)
-	if agentPath == "" {
-		agentPath = c.config.DefaultAgentPath
+	// Ensure agent is installed and accessible, user mode can only use it
+	var installedPath string
+	if userMode() {
+		installedPath, err = agentPath, checkUserAgent(agentPath)
+	} else {
+		installedPath, err = installAgent(c.config, run.vars, agentPath)
 	}
-
-	// Ensure agent is installed and accessible
-	installedPath, err := agent.EnsureInstalled(agentPath, c.config.DefaultAgentPath)
 	if err != nil {
 		return fmt.Errorf("failed to prepare agent: %w", err)
 	}
 
+	pol, err := loadPolicy(run.vars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if err := setRestartTimeout(run.vars["MW_RESTART_TIMEOUT"]); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
 	// Discover processes
 	processes, err := discovery.FindAllJavaProcesses(ctx)
 	if err != nil {
 		return fmt.Errorf("❌ Error discovering processes: %v", err)
 	}
 
+	processes = filterProcesses(c.config.Filter, processes)
 	if len(processes) == 0 {
 		fmt.Println("No Java processes found")
 		return nil
 	}
 
-	fmt.Printf("\n🔍 Found %d Java processes\n\n", len(processes))
+	fmt.Printf("\n🔍 Found %d Java processes\n", len(processes))
+
+	// In a dry run the agent isn't installed yet, check the one it's copied from
+	if !c.config.SkipDoctor {
+		if err := preflight(c.config, existingPath(installedPath, agentPath), processes, run.vars); err != nil {
+			return err
+		}
+	}
 
 	fmt.Printf("\n✅ Using agent at: %s\n", installedPath)
 	fmt.Printf("   Permissions: world-readable (0644)\n")
@@ -87,36 +93,69 @@ func (c *AutoInstrumentCommand) Execute() error {
 	configured := 0
 	updated := 0
 	skipped := 0
-	var servicesToRestart []string
+	restarts := newRestartQueue(pol)
+	fixer := newAccessFixer(c.config)
 
 	for _, proc := range processes {
-		// Check if agent is accessible by systemd for this specific process
-		if _, err := os.Stat(agentPath); err != nil {
-			pp.Printf("❌ agent file does not exist: %s\n", agentPath)
+		decision := pol.EvaluateProcess(&proc)
+		if !decision.Include {
+			fmt.Printf("⭐️  Skipping PID %d (%s): %s\n\n", proc.ProcessPID, proc.ServiceName, decision.Reason)
 			skipped++
 			continue
 		}
-		if err := agent.CheckAccessibleBySystemd(agentPath, proc.ProcessOwner); err != nil {
-			fmt.Printf("❌ Skipping PID %d (%s) due to a permission issue.\n", proc.ProcessPID, proc.ServiceName)
-			fmt.Printf("   └── Reason: The service user '%s' cannot access the agent file within the systemd security context.\n", proc.ProcessOwner)
-			fmt.Printf("   └── To fix, check file permissions and SELinux/AppArmor policies.\n\n")
-			fmt.Println("err:", err)
+
+		// Configs reference the current agent, or the version policy pins
+		procAgent := installedPath
+		if decision.AgentVersion != "" && !userMode() {
+			procAgent = agent.NewStore(c.config.DefaultAgentDir).Path(decision.AgentVersion)
+			if !changeset.Exists(procAgent) && run.vars["MW_AGENT_REPOSITORY"] != "" {
+				if _, err := installRelease(c.config, run.vars, decision.AgentVersion); err != nil {
+					fmt.Printf("⚠️  %v\n", err)
+				}
+			}
+			if !changeset.Exists(procAgent) {
+				fmt.Printf("❌ Skipping PID %d (%s): rule %s pins agent %s, which isn't installed\n", proc.ProcessPID, proc.ServiceName, decision.Rule, decision.AgentVersion)
+				fmt.Printf("   └── Install it with: mw-injector agent install <jar|%s>\n\n", decision.AgentVersion)
+				skipped++
+				continue
+			}
+		}
+
+		// In a dry run the agent isn't installed yet, check the one it's copied from
+		checkedAgent := existingPath(procAgent, agentPath)
+		if !changeset.Exists(checkedAgent) {
+			fmt.Printf("❌ agent file does not exist: %s\n", checkedAgent)
 			skipped++
 			continue
 		}
 
+		if userMode() {
+			if reason := userModeSkipReason(&proc); reason != "" {
+				fmt.Printf("⭐️  Skipping PID %d (%s): %s\n\n", proc.ProcessPID, proc.ServiceName, reason)
+				skipped++
+				continue
+			}
+		} else if err := agent.CheckAccessibleBySystemd(checkedAgent, proc.ProcessOwner); err != nil && run.vars["SKIP_SE_CHECK"] != "true" {
+			fmt.Printf("⚠️  PID %d (%s) has a permission issue.\n", proc.ProcessPID, proc.ServiceName)
+			fmt.Printf("   └── Reason: The service user '%s' cannot access the agent file within the systemd security context.\n", proc.ProcessOwner)
+			if !fixer.fix(checkedAgent, &proc) {
+				fmt.Printf("❌ Skipping PID %d (%s)\n", proc.ProcessPID, proc.ServiceName)
+				fmt.Printf("   └── To fix, check file permissions and SELinux/AppArmor policies, see mw-injector doctor.\n\n")
+				skipped++
+				continue
+			}
+			fmt.Printf("   ✅ The service can read the agent now\n")
+		}
+
 		configPath := c.getConfigPath(&proc)
+		changeset.AddTarget(processTarget(&proc))
+		tx := changeset.Begin(processTarget(&proc))
 		shouldUpdate := false
 
 		// Check if already configured
 		if c.fileExists(configPath) {
 			fmt.Printf("⚠️  PID %d (%s) is already configured\n", proc.ProcessPID, proc.ServiceName)
-			fmt.Print("   Update configuration? [y/N]: ")
-
-			response, _ := reader.ReadString('\n')
-			response = strings.TrimSpace(strings.ToLower(response))
-
-			if response != "y" && response != "yes" {
+			if !confirm(c.config, "   Update configuration?") {
 				fmt.Printf("⭐️  Skipping PID %d (%s)\n\n", proc.ProcessPID, proc.ServiceName)
 				skipped++
 				continue
@@ -126,37 +165,70 @@ func (c *AutoInstrumentCommand) Execute() error {
 
 		// Generate service name and config
 		var systemdServiceName string
-		if proc.IsTomcat() {
-			serviceName := naming.GenerateServiceName(&proc)
+		settings := hostSettings(decision, target, procAgent, run.logLevel)
+		if proc.IsWildFly() {
+			serviceName := decision.ServiceName
 
-			tomcatConfig := &systemd.TomcatConfig{
-				InstanceName: serviceName,
-				Pattern:      serviceName,
-				APIKey:       apiKey,
-				Target:       target,
-				AgentPath:    agentPath,
+			unit, err := configureService(tx, func() (string, error) {
+				return instrumentWildFly(&proc, configPath, serviceName, target, procAgent)
+			})
+			if err != nil {
+				fmt.Printf("❌ Failed to configure WildFly PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
+				continue
+			}
+			systemdServiceName = unit
+
+			if shouldUpdate {
+				fmt.Printf("🔄 Updated WildFly: %s (service: %s)\n", serviceName, systemdServiceName)
+				updated++
+			} else {
+				fmt.Printf("✅ Configured WildFly: %s (service: %s)\n", serviceName, systemdServiceName)
+				configured++
 			}
+		} else if proc.IsJetty() {
+			serviceName := decision.ServiceName
 
-			err := systemd.CreateTomcatConfig(configPath, tomcatConfig)
+			unit, err := configureService(tx, func() (string, error) {
+				return instrumentJetty(&proc, configPath, serviceName, apiKey, target, procAgent)
+			})
 			if err != nil {
-				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
+				fmt.Printf("❌ Failed to configure Jetty PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
 				continue
 			}
+			systemdServiceName = unit
+
+			if shouldUpdate {
+				fmt.Printf("🔄 Updated Jetty: %s\n", serviceName)
+				updated++
+			} else {
+				fmt.Printf("✅ Configured Jetty: %s\n", serviceName)
+				configured++
+			}
+		} else if proc.IsTomcat() {
+			serviceName := decision.ServiceName
 
-			systemdServiceName = systemd.GetTomcatServiceName()
+			webappNames := naming.GenerateForTomcatWebapps(&proc, servicePattern)
 
-			dropInConfig := &systemd.DropInConfig{
-				ServiceName: systemdServiceName,
-				ConfigPath:  configPath,
-				IsTomcat:    true,
-				AgentPath:   agentPath,
+			tomcatConfig := &systemd.TomcatConfig{
+				InstanceName:       serviceName,
+				Pattern:            servicePattern,
+				WebappServiceNames: webappNames,
+				Target:             target,
+				AgentPath:          procAgent,
+				Settings:           settings,
 			}
 
-			err = systemd.CreateDropIn(dropInConfig)
+			unit, err := configureService(tx, func() (string, error) {
+				return instrumentTomcat(configPath, tomcatConfig)
+			})
 			if err != nil {
-				fmt.Printf("❌ Failed to create systemd drop-in for PID %d: %v\n", proc.ProcessPID, err)
+				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
 				continue
 			}
+			systemdServiceName = unit
 
 			if shouldUpdate {
 				fmt.Printf("🔄 Updated Tomcat: %s\n", serviceName)
@@ -165,33 +237,79 @@ func (c *AutoInstrumentCommand) Execute() error {
 				fmt.Printf("✅ Configured Tomcat: %s\n", serviceName)
 				configured++
 			}
-		} else {
-			serviceName := naming.GenerateServiceName(&proc)
-			systemdServiceName = systemd.GetServiceName(&proc)
+			if agent.SupportsContextMap(procAgent) {
+				printWebappServiceNames(webappNames)
+			}
+		} else if proc.IsLauncherScript() {
+			serviceName := decision.ServiceName
 
-			standardConfig := &systemd.StandardConfig{
-				ServiceName: serviceName,
-				APIKey:      apiKey,
-				Target:      target,
-				AgentPath:   agentPath,
+			unit, err := configureService(tx, func() (string, error) {
+				return instrumentLauncher(&proc, configPath, serviceName, target, procAgent)
+			})
+			if err != nil {
+				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
+				continue
+			}
+			systemdServiceName = unit
+
+			if shouldUpdate {
+				fmt.Printf("🔄 Updated: %s (service: %s)\n", serviceName, systemdServiceName)
+				updated++
+			} else {
+				fmt.Printf("✅ Configured: %s (service: %s)\n", serviceName, systemdServiceName)
+				configured++
 			}
+		} else if supervisorInfo := proc.DetectSupervisor(); supervisorInfo.Kind != discovery.SupervisorSystemd {
+			serviceName := decision.ServiceName
 
-			err := systemd.CreateStandardConfig(configPath, standardConfig)
+			if !isSupervised(supervisorInfo) {
+				fmt.Printf("⭐️  Skipping PID %d (%s): not run by systemd or a supported supervisor\n", proc.ProcessPID, proc.ServiceName)
+				fmt.Printf("   └── Add -javaagent:%s to the command that starts it\n\n", procAgent)
+				skipped++
+				continue
+			}
+
+			var injector supervisor.Injector
+			_, err := configureService(tx, func() (string, error) {
+				var err error
+				injector, err = instrumentSupervised(&proc, supervisorInfo, configPath, serviceName, apiKey, target, procAgent, shouldUpdate)
+				return "", err
+			})
 			if err != nil {
 				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
 				continue
 			}
+			if decision.Restart != policy.RestartManual {
+				restarts.addSupervised(&proc, injector, supervisorInfo, decision, tx)
+			}
+
+			if shouldUpdate {
+				fmt.Printf("🔄 Updated: %s (%s)\n", serviceName, injector)
+				updated++
+			} else {
+				fmt.Printf("✅ Configured: %s (%s)\n", serviceName, injector)
+				configured++
+			}
+		} else {
+			serviceName := decision.ServiceName
+			systemdServiceName = systemd.GetServiceName(&proc)
 
-			dropInConfig := &systemd.DropInConfig{
-				ServiceName: systemdServiceName,
-				ConfigPath:  configPath,
-				IsTomcat:    false,
-				AgentPath:   agentPath,
+			standardConfig := &systemd.StandardConfig{
+				ServiceName: serviceName,
+				SystemdUnit: systemdServiceName,
+				Target:      target,
+				AgentPath:   procAgent,
+				Settings:    settings,
 			}
 
-			err = systemd.CreateDropIn(dropInConfig)
+			_, err := configureService(tx, func() (string, error) {
+				return instrumentStandard(configPath, standardConfig)
+			})
 			if err != nil {
-				fmt.Printf("❌ Failed to create systemd drop-in for PID %d: %v\n", proc.ProcessPID, err)
+				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
+				skipped++
 				continue
 			}
 
@@ -204,19 +322,19 @@ func (c *AutoInstrumentCommand) Execute() error {
 			}
 		}
 
-		// Add to restart list if not already there
-		found := false
-		for _, s := range servicesToRestart {
-			if s == systemdServiceName {
-				found = true
-				break
-			}
+		tx.SetUnit(systemdServiceName)
+		if decision.Restart == policy.RestartManual {
+			restartManually(decision, systemdServiceName)
+			fmt.Println()
+			continue
 		}
-		if !found && systemdServiceName != "" {
-			servicesToRestart = append(servicesToRestart, systemdServiceName)
+
+		if systemdServiceName != "" {
+			restarts.addUnit(&proc, systemdServiceName, decision, tx)
 		}
 		fmt.Println()
 	}
+	changeset.End()
 
 	if skipped > 0 {
 		fmt.Printf("\n Auto-instrumentation complete! Skipped %d services \n", skipped)
@@ -228,28 +346,64 @@ func (c *AutoInstrumentCommand) Execute() error {
 	fmt.Printf("   Skipped:    %d\n", skipped)
 	fmt.Printf("   Total:      %d\n", len(processes))
 
-	// Restart services
-	if len(servicesToRestart) > 0 {
-		fmt.Printf("\n🔄 Restarting %d service(s)...\n\n", len(servicesToRestart))
+	restarts.run()
 
-		systemd.ReloadSystemd()
+	return nil
+}
 
-		for _, service := range servicesToRestart {
-			fmt.Printf("   Restarting %s...", service)
-			err := systemd.RestartService(service)
+// createDropIn writes the drop-in of a unit, tests make it fail
+var createDropIn = systemd.CreateDropIn
 
-			if err != nil {
-				fmt.Printf(" ❌ Failed\n")
-				fmt.Printf("       Error: %v\n", err)
-				fmt.Printf("       Try manually: sudo systemctl restart %s\n", service)
-			} else {
-				fmt.Printf(" ✅ Done\n")
-			}
+// configureService runs the writes configuring one service. When one fails
+// the transaction is rolled back, so the service isn't left half-configured
+// and taken for an instrumented one by the next run.
+func configureService(tx *changeset.Transaction, configure func() (string, error)) (string, error) {
+	unit, err := configure()
+	if err != nil && tx != nil {
+		if rbErr := tx.Rollback(err); rbErr != nil {
+			fmt.Printf("   ⚠️  Rollback incomplete: %v\n", rbErr)
+		}
+		for _, path := range tx.Restored() {
+			fmt.Printf("   ⏪ Restored: %s\n", path)
 		}
-		fmt.Println("\n✅ All services restarted!")
 	}
+	return unit, err
+}
 
-	return nil
+// instrumentTomcat writes the config of a Tomcat instance and the drop-in
+// of its unit, and returns the unit
+func instrumentTomcat(configPath string, config *systemd.TomcatConfig) (string, error) {
+	if err := systemd.CreateTomcatConfig(configPath, config); err != nil {
+		return "", err
+	}
+	unit := systemd.GetTomcatServiceName()
+	if err := createDropIn(&systemd.DropInConfig{
+		ServiceName: unit,
+		ConfigPath:  configPath,
+		IsTomcat:    true,
+		AgentPath:   config.AgentPath,
+		Settings:    config.Settings,
+	}); err != nil {
+		return "", fmt.Errorf("failed to create systemd drop-in: %w", err)
+	}
+	return unit, nil
+}
+
+// instrumentStandard writes the config of a systemd service and its
+// drop-in, and returns the unit
+func instrumentStandard(configPath string, config *systemd.StandardConfig) (string, error) {
+	if err := systemd.CreateStandardConfig(configPath, config); err != nil {
+		return "", err
+	}
+	if err := createDropIn(&systemd.DropInConfig{
+		ServiceName: config.SystemdUnit,
+		ConfigPath:  configPath,
+		AgentPath:   config.AgentPath,
+		Settings:    config.Settings,
+	}); err != nil {
+		return "", fmt.Errorf("failed to create systemd drop-in: %w", err)
+	}
+	return config.SystemdUnit, nil
 }
 
 func (c *AutoInstrumentCommand) GetDescription() string {
@@ -273,37 +427,26 @@ func (c *InstrumentDockerCommand) Execute() error {
 		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector instrument-docker")
 	}
 
-	// Get API key and target
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Print("Middleware.io API Key: ")
-	apiKey, _ := reader.ReadString('\n')
-	apiKey = strings.TrimSpace(apiKey)
-
-	if apiKey == "" {
-		return fmt.Errorf("❌ API key is required")
-	}
-
-	fmt.Print("Target endpoint [https://prod.middleware.io:443]: ")
-	target, _ := reader.ReadString('\n')
-	target = strings.TrimSpace(target)
-	if target == "" {
-		target = "https://prod.middleware.io:443"
-	}
-
-	fmt.Printf("Java agent path [%s]: ", c.config.DefaultAgentPath)
-	agentPath, _ := reader.ReadString('\n')
-	agentPath = strings.TrimSpace(agentPath)
-	if agentPath == "" {
-		agentPath = c.config.DefaultAgentPath
+	run, err := resolveSettings(c.config)
+	if err != nil {
+		return err
 	}
+	agentPath := run.agentPath
 
 	// Ensure agent is installed and accessible
-	installedPath, err := agent.EnsureInstalled(agentPath, c.config.DefaultAgentPath)
+	installedPath, err := installAgent(c.config, run.vars, agentPath)
 	if err != nil {
 		return fmt.Errorf("❌ Failed to prepare agent: %v", err)
 	}
 
+	pol, err := loadPolicy(run.vars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if err := setRestartTimeout(run.vars["MW_RESTART_TIMEOUT"]); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
 	// Discover Docker containers
 	discoverer := discovery.NewDockerDiscoverer(ctx)
 	containers, err := discoverer.DiscoverJavaContainers()
@@ -311,6 +454,7 @@ func (c *InstrumentDockerCommand) Execute() error {
 		return fmt.Errorf("❌ Error discovering containers: %v", err)
 	}
 
+	containers = filterContainers(c.config.Filter, containers)
 	if len(containers) == 0 {
 		fmt.Println("No Java Docker containers found")
 		return nil
@@ -324,17 +468,21 @@ func (c *InstrumentDockerCommand) Execute() error {
 	skipped := 0
 
 	dockerOps := docker.NewDockerOperations(ctx, installedPath)
+	dockerOps.Confirm = func(question string) bool { return confirm(c.config, question) }
 
 	for _, container := range containers {
+		decision := pol.EvaluateContainer(&container)
+		if !decision.Include {
+			fmt.Printf("⭐️  Skipping container %s: %s\n\n", container.ContainerName, decision.Reason)
+			skipped++
+			continue
+		}
+		changeset.AddTarget(containerTarget(&container))
+
 		// Skip if already instrumented
 		if container.Instrumented && container.IsMiddlewareAgent {
 			fmt.Printf("✅ Container %s is already instrumented\n", container.ContainerName)
-			fmt.Print("   Update configuration? [y/N]: ")
-
-			response, _ := reader.ReadString('\n')
-			response = strings.TrimSpace(strings.ToLower(response))
-
-			if response != "y" && response != "yes" {
+			if !confirm(c.config, "   Update configuration?") {
 				fmt.Printf("⭐️  Skipping container %s\n\n", container.ContainerName)
 				skipped++
 				continue
@@ -342,24 +490,27 @@ func (c *InstrumentDockerCommand) Execute() error {
 		}
 
 		// Create configuration
-		cfg := config.DefaultConfiguration()
-		cfg.MWAPIKey = apiKey
-		cfg.MWTarget = target
-		cfg.MWServiceName = container.GetServiceName()
-		cfg.JavaAgentPath = docker.DefaultContainerAgentPath
-
-		if _, err := os.Stat(agentPath); err != nil {
-			pp.Printf("❌ agent file does not exist: %s\n", agentPath)
+		cfg := containerSettings(decision, run.apiKey, run.target, run.logLevel)
+
+		// In a dry run the agent isn't installed yet, check the one it's copied from
+		checkedAgent := installedPath
+		if changeset.Recording() {
+			checkedAgent = agentPath
+		}
+		if !changeset.Exists(checkedAgent) {
+			fmt.Printf("❌ agent file does not exist: %s\n", checkedAgent)
 			skipped++
 			continue
 		}
 
 		// Instrument container
-		err := dockerOps.InstrumentContainer(container.ContainerName, &cfg)
+		tx := changeset.Begin(containerTarget(&container))
+		err := dockerOps.InstrumentContainer(container.ContainerName, cfg)
 		if err != nil {
 			fmt.Printf("❌ Failed to instrument container %s: %v\n", container.ContainerName, err)
 			skipped++
 		} else {
+			tx.Verify()
 			if container.Instrumented {
 				updated++
 			} else {
@@ -368,6 +519,7 @@ func (c *InstrumentDockerCommand) Execute() error {
 		}
 		fmt.Println()
 	}
+	changeset.End()
 
 	fmt.Printf("\n🎉 Docker instrumentation complete!\n")
 	fmt.Printf("   Configured: %d\n", configured)
@@ -407,33 +559,13 @@ func (c *InstrumentContainerCommand) Execute() error {
 		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector instrument-container %s", c.containerName)
 	}
 
-	// Get API key and target
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Print("Middleware.io API Key: ")
-	apiKey, _ := reader.ReadString('\n')
-	apiKey = strings.TrimSpace(apiKey)
-
-	if apiKey == "" {
-		return fmt.Errorf("❌ API key is required")
-	}
-
-	fmt.Print("Target endpoint [https://prod.middleware.io:443]: ")
-	target, _ := reader.ReadString('\n')
-	target = strings.TrimSpace(target)
-	if target == "" {
-		target = "https://prod.middleware.io:443"
-	}
-
-	fmt.Printf("Java agent path [%s]: ", c.config.DefaultAgentPath)
-	agentPath, _ := reader.ReadString('\n')
-	agentPath = strings.TrimSpace(agentPath)
-	if agentPath == "" {
-		agentPath = c.config.DefaultAgentPath
+	run, err := resolveSettings(c.config)
+	if err != nil {
+		return err
 	}
 
 	// Ensure agent is installed
-	installedPath, err := agent.EnsureInstalled(agentPath, c.config.DefaultAgentPath)
+	installedPath, err := installAgent(c.config, run.vars, run.agentPath)
 	if err != nil {
 		return fmt.Errorf("❌ Failed to prepare agent: %v", err)
 	}
@@ -449,18 +581,28 @@ func (c *InstrumentContainerCommand) Execute() error {
 	fmt.Printf("   Image: %s:%s\n", container.ImageName, container.ImageTag)
 	fmt.Printf("   Status: %s\n\n", container.Status)
 
-	// Create configuration
-	cfg := config.DefaultConfiguration()
-	cfg.MWAPIKey = apiKey
-	cfg.MWTarget = target
-	cfg.MWServiceName = container.GetServiceName()
-	cfg.JavaAgentPath = docker.DefaultContainerAgentPath
+	// Create configuration, the policy applies even when a container is named explicitly
+	pol, err := loadPolicy(run.vars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	decision := pol.EvaluateContainer(container)
+	if !decision.Include {
+		return fmt.Errorf("❌ Container %s is %s", container.ContainerName, decision.Reason)
+	}
+	changeset.AddTarget(containerTarget(container))
+
+	cfg := containerSettings(decision, run.apiKey, run.target, run.logLevel)
 
 	// Instrument
 	dockerOps := docker.NewDockerOperations(ctx, installedPath)
-	if err := dockerOps.InstrumentContainer(c.containerName, &cfg); err != nil {
+	dockerOps.Confirm = func(question string) bool { return confirm(c.config, question) }
+	tx := changeset.Begin(containerTarget(container))
+	defer changeset.End()
+	if err := dockerOps.InstrumentContainer(c.containerName, cfg); err != nil {
 		return fmt.Errorf("❌ Failed to instrument container: %v", err)
 	}
+	tx.Verify()
 
 	fmt.Println("\n🎉 Container instrumented successfully!")
 	fmt.Println("📊 Container is now sending telemetry data to Middleware.io")
@@ -476,37 +618,24 @@ func (c *InstrumentContainerCommand) GetDescription() string {
 func (c *AutoInstrumentCommand) getConfigPath(proc *discovery.JavaProcess) string {
 	serviceName := naming.GenerateServiceName(proc)
 
-	if proc.IsTomcat() {
-		return fmt.Sprintf("/etc/middleware/tomcat/%s.conf", serviceName)
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
 	}
 
-	deploymentType := c.detectDeploymentType(proc)
-	return fmt.Sprintf("/etc/middleware/%s/%s.conf", deploymentType, serviceName)
-}
-
-func (c *ConfigAutoInstrumentCommand) getConfigPath(proc *discovery.JavaProcess) string {
-	serviceName := naming.GenerateServiceName(proc)
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
 
 	if proc.IsTomcat() {
-		return fmt.Sprintf("/etc/middleware/tomcat/%s.conf", serviceName)
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
 	}
 
 	deploymentType := c.detectDeploymentType(proc)
-	return fmt.Sprintf("/etc/middleware/%s/%s.conf", deploymentType, serviceName)
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), deploymentType, serviceName)
 }
 
 func (c *AutoInstrumentCommand) detectDeploymentType(proc *discovery.JavaProcess) string {
-	if proc.ProcessOwner != "root" && proc.ProcessOwner != os.Getenv("USER") {
-		return "systemd"
-	}
-	return "standalone"
-}
-
-func (c *ConfigAutoInstrumentCommand) detectDeploymentType(proc *discovery.JavaProcess) string {
-	if proc.ProcessOwner != "root" && proc.ProcessOwner != os.Getenv("USER") {
-		return "systemd"
-	}
-	return "standalone"
+	return proc.DetectSupervisor().Kind
 }
 
 func (c *AutoInstrumentCommand) generateServiceName(proc *discovery.JavaProcess) string {
@@ -515,13 +644,7 @@ func (c *AutoInstrumentCommand) generateServiceName(proc *discovery.JavaProcess)
 }
 
 func (c *AutoInstrumentCommand) fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
-}
-
-func (c *ConfigAutoInstrumentCommand) fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	return changeset.Exists(path)
 }
 
 func (c *AutoInstrumentCommand) getSystemdServiceName(proc *discovery.JavaProcess) string {
@@ -529,412 +652,16 @@ func (c *AutoInstrumentCommand) getSystemdServiceName(proc *discovery.JavaProces
 	return systemd.GetServiceName(proc)
 }
 
-// ConfigAutoInstrumentCommand auto-instruments all uninstrumented processes using config file
-type ConfigAutoInstrumentCommand struct {
-	config     *types.CommandConfig
-	configPath string
-}
-
-// func NewConfigAutoInstrumentCommand(config *types.CommandConfig, configPath string) *ConfigAutoInstrumentCommand {
-// 	return &ConfigAutoInstrumentCommand{
-// 		config:     config,
-// 		configPath: configPath,
-// 	}
-// }
-
-func NewConfigAutoInstrumentCommand(config *types.CommandConfig, configPath string) *ConfigAutoInstrumentCommand {
-	// If no config path provided, try to find default
-	if configPath == "" {
-		configPath = findDefaultConfigFile()
-	}
-
-	return &ConfigAutoInstrumentCommand{
-		config:     config,
-		configPath: configPath,
-	}
-}
-
-func (c *ConfigAutoInstrumentCommand) Execute() error {
-	ctx := context.Background()
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector auto-instrument-config [config-file]")
-	}
-
-	// Check if config file was found/provided
-	if c.configPath == "" {
-		return fmt.Errorf("❌ No config file found. Please create /etc/mw-injector.conf or provide path:\n   Usage: mw-injector auto-instrument-config <config-file>")
-	}
-
-	// Load configuration from file
-	configVars, err := c.loadConfig()
-	if err != nil {
-		return fmt.Errorf("❌ Failed to load config from %s: %v", c.configPath, err)
-	}
-
-	// Validate required config
-	apiKey := configVars["MW_API_KEY"]
-	if apiKey == "" {
-		return fmt.Errorf("❌ MW_API_KEY is required in config file")
-	}
-
-	target := configVars["MW_TARGET"]
-	if target == "" {
-		target = "https://prod.middleware.io:443"
-	}
-
-	agentPath := configVars["MW_JAVA_AGENT_PATH"]
-	if agentPath == "" {
-		agentPath = c.config.DefaultAgentPath
-	}
-
-	skipSECheck := configVars["SKIP_SE_CHECK"]
-
-	fmt.Printf("🔧 Using configuration from: %s\n", c.configPath)
-	fmt.Printf("   API Key: %s...\n", apiKey[:min(8, len(apiKey))])
-	fmt.Printf("   Target: %s\n", target)
-	fmt.Printf("   Agent Path: %s\n", agentPath)
-
-	// Ensure agent is installed and accessible
-	installedPath, err := agent.EnsureInstalled(agentPath, c.config.DefaultAgentPath)
-	if err != nil {
-		return fmt.Errorf("failed to prepare agent: %w", err)
-	}
-
-	// Discover processes
-	processes, err := discovery.FindAllJavaProcesses(ctx)
-	if err != nil {
-		return fmt.Errorf("❌ Error discovering processes: %v", err)
-	}
-
-	if len(processes) == 0 {
-		fmt.Println("No Java processes found")
-		return nil
-	}
-
-	fmt.Printf("\n🔍 Found %d Java processes\n\n", len(processes))
-	fmt.Printf("✅ Using agent at: %s\n", installedPath)
-	fmt.Printf("   Permissions: world-readable (0644)\n")
-	fmt.Printf("   Owner: root:root\n")
-	fmt.Printf("   Accessible by: ALL users\n\n")
-
-	configured := 0
-	updated := 0
-	skipped := 0
-	var servicesToRestart []string
-
-	for _, proc := range processes {
-
-		// Check if agent is accessible by systemd for this specific process
-		if _, err := os.Stat(agentPath); err != nil {
-			pp.Printf("❌ agent file does not exist: %s\n", agentPath)
-			skipped++
-			continue
-		}
-		if err := agent.CheckAccessibleBySystemd(agentPath, proc.ProcessOwner); err != nil && skipSECheck != "true" {
-			fmt.Printf("❌ Skipping PID %d (%s) due to a permission issue.\n", proc.ProcessPID, proc.ServiceName)
-			fmt.Printf("   └── Reason: The service user '%s' cannot access the agent file within the systemd security context.\n", proc.ProcessOwner)
-			fmt.Printf("   └── To fix, check file permissions and SELinux/AppArmor policies.\n\n")
-			// fmt.Println("", err)
-			skipped++
-			continue
-		}
-
-		configPath := c.getConfigPath(&proc)
-		shouldUpdate := false
-
-		// Check if already configured (auto-update without asking)
-		if c.fileExists(configPath) {
-			fmt.Printf("⚠️  PID %d (%s) is already configured\n", proc.ProcessPID, proc.ServiceName)
-			fmt.Printf("   📝 Auto-updating configuration...\n")
-			shouldUpdate = true
-		}
-
-		// Generate service name and config
-		var systemdServiceName string
-		if proc.IsTomcat() {
-			serviceName := naming.GenerateServiceName(&proc)
-
-			tomcatConfig := &systemd.TomcatConfig{
-				InstanceName: serviceName,
-				Pattern:      serviceName,
-				APIKey:       apiKey,
-				Target:       target,
-				AgentPath:    agentPath,
-			}
-
-			err := systemd.CreateTomcatConfig(configPath, tomcatConfig)
-			if err != nil {
-				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
-				skipped++
-				continue
-			}
-
-			systemdServiceName = systemd.GetTomcatServiceName()
-
-			dropInConfig := &systemd.DropInConfig{
-				ServiceName: systemdServiceName,
-				ConfigPath:  configPath,
-				IsTomcat:    true,
-				AgentPath:   agentPath,
-			}
-
-			err = systemd.CreateDropIn(dropInConfig)
-			if err != nil {
-				fmt.Printf("❌ Failed to create systemd drop-in for PID %d: %v\n", proc.ProcessPID, err)
-				skipped++
-				continue
-			}
-
-			if shouldUpdate {
-				fmt.Printf("🔄 Updated Tomcat: %s\n", serviceName)
-				updated++
-			} else {
-				fmt.Printf("✅ Configured Tomcat: %s\n", serviceName)
-				configured++
-			}
-		} else {
-			serviceName := naming.GenerateServiceName(&proc)
-			systemdServiceName = systemd.GetServiceName(&proc)
-
-			standardConfig := &systemd.StandardConfig{
-				ServiceName: serviceName,
-				APIKey:      apiKey,
-				Target:      target,
-				AgentPath:   agentPath,
-			}
-
-			err := systemd.CreateStandardConfig(configPath, standardConfig)
-			if err != nil {
-				fmt.Printf("❌ Failed to configure PID %d: %v\n", proc.ProcessPID, err)
-				skipped++
-				continue
-			}
-
-			dropInConfig := &systemd.DropInConfig{
-				ServiceName: systemdServiceName,
-				ConfigPath:  configPath,
-				IsTomcat:    false,
-				AgentPath:   agentPath,
-			}
-
-			err = systemd.CreateDropIn(dropInConfig)
-			if err != nil {
-				fmt.Printf("❌ Failed to create systemd drop-in for PID %d: %v\n", proc.ProcessPID, err)
-				skipped++
-				continue
-			}
-
-			if shouldUpdate {
-				fmt.Printf("🔄 Updated: %s (service: %s)\n", serviceName, systemdServiceName)
-				updated++
-			} else {
-				fmt.Printf("✅ Configured: %s (service: %s)\n", serviceName, systemdServiceName)
-				configured++
-			}
-		}
-
-		// Add to restart list if not already there
-		found := false
-		for _, s := range servicesToRestart {
-			if s == systemdServiceName {
-				found = true
-				break
-			}
-		}
-		if !found && systemdServiceName != "" {
-			servicesToRestart = append(servicesToRestart, systemdServiceName)
-		}
-		fmt.Println()
-	}
-
-	if skipped > 0 {
-		fmt.Printf("\n Auto-instrumentation complete! Skipped %d services\n", skipped)
-	} else if skipped == 0 {
-		fmt.Printf("\n🎉 Auto-instrumentation complete!\n")
-	}
-
-	fmt.Printf("   Configured: %d\n", configured)
-	fmt.Printf("   Updated:    %d\n", updated)
-	fmt.Printf("   Skipped:    %d\n", skipped)
-	fmt.Printf("   Total:      %d\n", len(processes))
-
-	// Auto-restart services without asking
-	if len(servicesToRestart) > 0 {
-		fmt.Printf("\n🔄 Auto-restarting %d service(s)...\n\n", len(servicesToRestart))
-
-		systemd.ReloadSystemd()
-
-		for _, service := range servicesToRestart {
-			fmt.Printf("   Restarting %s...", service)
-			err := systemd.RestartService(service)
-
-			if err != nil {
-				fmt.Printf(" ❌ Failed\n")
-				fmt.Printf("       Error: %v\n", err)
-				fmt.Printf("       Try manually: sudo systemctl restart %s\n", service)
-			} else {
-				fmt.Printf(" ✅ Done\n")
-			}
-		}
-		fmt.Println("\n✅ All services restarted!")
-	}
-
-	return nil
-}
-
-func (c *ConfigAutoInstrumentCommand) GetDescription() string {
-	return "Auto-instrument all uninstrumented Java processes using config file"
-}
-
-// ConfigInstrumentDockerCommand auto-instruments all Java Docker containers using config file
-type ConfigInstrumentDockerCommand struct {
-	config     *types.CommandConfig
-	configPath string
-}
-
-//	func NewConfigInstrumentDockerCommand(config *types.CommandConfig, configPath string) *ConfigInstrumentDockerCommand {
-//		return &ConfigInstrumentDockerCommand{
-//			config:     config,
-//			configPath: configPath,
-//		}
-//	}
-func NewConfigInstrumentDockerCommand(config *types.CommandConfig, configPath string) *ConfigInstrumentDockerCommand {
-	// If no config path provided, try to find default
-	if configPath == "" {
-		configPath = findDefaultConfigFile()
-	}
-
-	return &ConfigInstrumentDockerCommand{
-		config:     config,
-		configPath: configPath,
-	}
-}
-
-func (c *ConfigInstrumentDockerCommand) Execute() error {
-	ctx := context.Background()
-
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector instrument-docker-config [config-file]")
+// printWebappServiceNames prints the effective per-webapp service names of a Tomcat instance
+func printWebappServiceNames(names naming.ContextServiceNames) {
+	contexts := make([]string, 0, len(names))
+	for context := range names {
+		contexts = append(contexts, context)
 	}
-
-	// Check if config file was found/provided
-	if c.configPath == "" {
-		return fmt.Errorf("❌ No config file found. Please create /etc/mw-injector.conf or provide path:\n   Usage: mw-injector instrument-docker-config <config-file>")
+	sort.Strings(contexts)
+	for _, context := range contexts {
+		fmt.Printf("   └── %s → %s\n", context, names[context])
 	}
-
-	// Load configuration from file
-	configVars, err := c.loadConfig()
-	if err != nil {
-		return fmt.Errorf("❌ Failed to load config from %s: %v", c.configPath, err)
-	}
-
-	// Validate required config
-	apiKey := configVars["MW_API_KEY"]
-	if apiKey == "" {
-		return fmt.Errorf("❌ MW_API_KEY is required in config file")
-	}
-
-	target := configVars["MW_TARGET"]
-	if target == "" {
-		target = "https://prod.middleware.io:443"
-	}
-
-	agentPath := configVars["MW_JAVA_AGENT_PATH"]
-	if agentPath == "" {
-		agentPath = c.config.DefaultAgentPath
-	}
-
-	fmt.Printf("🔧 Using configuration from: %s\n", c.configPath)
-	fmt.Printf("   API Key: %s...\n", apiKey[:min(8, len(apiKey))])
-	fmt.Printf("   Target: %s\n", target)
-	fmt.Printf("   Agent Path: %s\n", agentPath)
-
-	// Ensure agent is installed and accessible
-	installedPath, err := agent.EnsureInstalled(agentPath, c.config.DefaultAgentPath)
-	if err != nil {
-		return fmt.Errorf("❌ Failed to prepare agent: %v", err)
-	}
-
-	// Discover Docker containers
-	discoverer := discovery.NewDockerDiscoverer(ctx)
-	containers, err := discoverer.DiscoverJavaContainers()
-	if err != nil {
-		return fmt.Errorf("❌ Error discovering containers: %v", err)
-	}
-
-	if len(containers) == 0 {
-		fmt.Println("No Java Docker containers found")
-		return nil
-	}
-
-	fmt.Printf("\n🔍 Found %d Java Docker containers\n\n", len(containers))
-	fmt.Printf("✅ Using agent at: %s\n\n", installedPath)
-
-	configured := 0
-	updated := 0
-	skipped := 0
-
-	dockerOps := docker.NewDockerOperations(ctx, installedPath)
-
-	for _, container := range containers {
-		// Auto-update if already instrumented (no prompts)
-		if container.Instrumented && container.IsMiddlewareAgent {
-			fmt.Printf("✅ Container %s is already instrumented\n", container.ContainerName)
-			fmt.Printf("   📝 Auto-updating configuration...\n")
-			updated++
-		} else {
-			fmt.Printf("🎯 Container %s - %s:%s\n", container.ContainerName, container.ImageName, container.ImageTag)
-			fmt.Printf("   📝 Auto-configuring for instrumentation...\n")
-			configured++
-		}
-		if _, err := os.Stat(agentPath); err != nil {
-			pp.Printf("❌ agent file does not exist: %s", agentPath)
-			skipped++
-			continue
-		}
-		// Create configuration
-		cfg := config.DefaultConfiguration()
-		cfg.MWAPIKey = apiKey
-		cfg.MWTarget = target
-		cfg.MWServiceName = container.GetServiceName()
-		cfg.JavaAgentPath = docker.DefaultContainerAgentPath
-
-		// Instrument container
-		err := dockerOps.InstrumentContainer(container.ContainerName, &cfg)
-		if err != nil {
-			fmt.Printf("❌ Failed to instrument container %s: %v\n", container.ContainerName, err)
-			skipped++
-		} else {
-			fmt.Printf("   ✅ Successfully instrumented container\n")
-		}
-		fmt.Println()
-	}
-
-	fmt.Printf("\n🎉 Docker instrumentation complete!\n")
-	fmt.Printf("   Configured: %d\n", configured)
-	fmt.Printf("   Updated: %d\n", updated)
-	fmt.Printf("   Skipped: %d\n", skipped)
-
-	if configured > 0 || updated > 0 {
-		fmt.Println("\n📊 Containers are now sending telemetry data to Middleware.io")
-	}
-
-	return nil
-}
-
-func (c *ConfigInstrumentDockerCommand) GetDescription() string {
-	return "Auto-instrument all Java Docker containers using config file"
-}
-
-// loadConfig loads configuration from the specified file path
-func (c *ConfigAutoInstrumentCommand) loadConfig() (map[string]string, error) {
-	return systemd.ReadConfigFile(c.configPath)
-}
-
-func (c *ConfigInstrumentDockerCommand) loadConfig() (map[string]string, error) {
-	return systemd.ReadConfigFile(c.configPath)
 }
 
 // Helper function for Go versions that don't have min built-in
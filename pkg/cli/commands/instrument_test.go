@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+func TestConfigureServiceRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := changeset.OpenJournal(filepath.Join(dir, "journal"), "auto-instrument"); err != nil {
+		t.Fatal(err)
+	}
+	defer changeset.CloseJournal()
+
+	createDropIn = func(*systemd.DropInConfig) error { return errors.New("no space left on device") }
+	defer func() { createDropIn = systemd.CreateDropIn }()
+
+	configPath := filepath.Join(dir, "systemd", "billing.conf")
+	tx := changeset.Begin("pid:1234")
+	_, err := configureService(tx, func() (string, error) {
+		return instrumentStandard(configPath, &systemd.StandardConfig{
+			ServiceName: "billing",
+			SystemdUnit: "billing.service",
+			Target:      "https://example.middleware.io:443",
+			AgentPath:   "/opt/middleware/agents/current/middleware-javaagent.jar",
+		})
+	})
+	if err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Fatalf("configureService() error = %v, want the drop-in failure", err)
+	}
+
+	// Written before the drop-in failed, then rolled back
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Errorf("config %s left behind", configPath)
+	}
+	if _, err := os.Stat(filepath.Dir(configPath)); !os.IsNotExist(err) {
+		t.Errorf("config directory left behind")
+	}
+	if tx.Status != changeset.TxRolledBack {
+		t.Errorf("transaction status = %s, want %s", tx.Status, changeset.TxRolledBack)
+	}
+}
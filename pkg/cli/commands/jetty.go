@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/jetty"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// instrumentJetty configures a Jetty server and returns the systemd unit to
+// restart, or "" when Jetty isn't run by systemd
+func instrumentJetty(proc *discovery.JavaProcess, configPath, serviceName, apiKey, target, agentPath string) (string, error) {
+
+	unit := systemd.GetServiceName(proc)
+	if !systemd.ServiceExists(unit) {
+		unit = ""
+	}
+
+	// Under systemd the API key comes from the unit's EnvironmentFile=
+	iniAPIKey := apiKey
+	if unit != "" {
+		iniAPIKey = ""
+	}
+
+	iniPath, err := jetty.Instrument(&jetty.Config{
+		ServiceName: serviceName,
+		Info:        proc.ExtractJettyInfo(),
+		APIKey:      iniAPIKey,
+		Target:      target,
+		AgentPath:   agentPath,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := state.RecordArtifact(state.Artifact{
+		Kind:        state.ArtifactManagedBlock,
+		Path:        iniPath,
+		ConfigPath:  configPath,
+		ServiceName: serviceName,
+	}); err != nil {
+		return "", fmt.Errorf("failed to record %s: %w", iniPath, err)
+	}
+
+	if err := systemd.CreateStandardConfig(configPath, &systemd.StandardConfig{
+		ServiceName: serviceName,
+		Target:      target,
+		AgentPath:   agentPath,
+	}); err != nil {
+		return "", fmt.Errorf("failed to write config: %w", err)
+	}
+
+	if unit == "" {
+		fmt.Printf("   ⚠️  No systemd unit found, the API key is stored in %s\n", iniPath)
+		fmt.Printf("   ⚠️  Restart Jetty to load the agent\n")
+		return "", nil
+	}
+
+	if err := attachSecrets(unit, configPath, serviceName); err != nil {
+		return "", err
+	}
+	return unit, nil
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// journalDir returns the directory that holds the journals of past runs
+func journalDir() string {
+	return filepath.Join(configRoot(), "journal")
+}
+
+// Locked runs a command holding the injector lock, so two runs never change
+// the same services or the state store at once
+func Locked(execute func() error) error {
+	lock, err := state.AcquireLock(filepath.Join(configRoot(), state.LockFile))
+	if errors.Is(err, state.ErrLocked) {
+		return fmt.Errorf("❌ %w, try again when it finishes", err)
+	}
+	if err != nil {
+		return fmt.Errorf("❌ Failed to take the injector lock: %v", err)
+	}
+	defer lock.Release()
+	return execute()
+}
+
+// Journaled runs a command that changes the host, recording every step and
+// the original file contents so failed services can be rolled back
+func Journaled(command string, execute func() error) error {
+	if _, err := changeset.OpenJournal(journalDir(), command); err != nil {
+		fmt.Printf("⚠️  Running without a journal, failed restarts can't be rolled back: %v\n", err)
+		return execute()
+	}
+	defer changeset.CloseJournal()
+	return execute()
+}
+
+// setRestartTimeout sets how long restarted services and containers get to
+// come up before they're rolled back, e.g. MW_RESTART_TIMEOUT=2m
+func setRestartTimeout(value string) error {
+	if value == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid MW_RESTART_TIMEOUT %q, use a duration like 90s", value)
+	}
+	useRestartTimeout(timeout)
+	return nil
+}
+
+// useRestartTimeout sets the timeout of the health checks after restarts
+func useRestartTimeout(timeout time.Duration) {
+	systemd.RestartTimeout = timeout
+	docker.StartTimeout = timeout
+}
+
+// transactions holds the transactions of the services to restart, keyed by
+// systemd unit or supervised service
+type transactions map[string][]*changeset.Transaction
+
+func (t transactions) add(key string, tx *changeset.Transaction) {
+	if tx != nil {
+		t[key] = append(t[key], tx)
+	}
+}
+
+// rollback restores the files changed for a service, newest first. The
+// report is printed at once so restarts running in parallel don't split it.
+func rollback(service string, txs []*changeset.Transaction, cause error, printf func(string, ...any)) {
+	var report strings.Builder
+	fmt.Fprintf(&report, "   ⏪ Rolling back %s\n", service)
+	for i := len(txs) - 1; i >= 0; i-- {
+		if err := txs[i].Rollback(cause); err != nil {
+			fmt.Fprintf(&report, "      ⚠️  Rollback incomplete: %v\n", err)
+		}
+		for _, path := range txs[i].Restored() {
+			fmt.Fprintf(&report, "      Restored: %s\n", path)
+		}
+	}
+	if len(txs) > 0 {
+		fmt.Fprintf(&report, "      Journal: %s\n", txs[0].JournalPath())
+	}
+	printf("%s", report.String())
+}
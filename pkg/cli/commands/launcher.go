@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/launcher"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// instrumentLauncher configures an application started by a bin/<app> script
+// and returns the systemd unit to restart
+func instrumentLauncher(proc *discovery.JavaProcess, configPath, serviceName, target, agentPath string) (string, error) {
+	info := proc.ExtractLauncherInfo()
+
+	unit := systemd.GetServiceName(proc)
+	if !systemd.ServiceExists(unit) {
+		unit = ""
+	}
+
+	hook, err := launcher.Instrument(&launcher.Config{
+		ServiceName: serviceName,
+		Info:        info,
+		SystemdUnit: unit,
+		Target:      target,
+		AgentPath:   agentPath,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	artifact := state.Artifact{
+		Kind:        state.ArtifactManagedBlock,
+		Path:        hook.Path,
+		ConfigPath:  configPath,
+		ServiceName: serviceName,
+	}
+	if hook.DropIn {
+		artifact.Kind = state.ArtifactDropIn
+		artifact.Unit = unit
+	}
+	if err := state.RecordArtifact(artifact); err != nil {
+		return "", fmt.Errorf("failed to record %s: %w", hook.Path, err)
+	}
+
+	if err := attachSecrets(unit, configPath, serviceName); err != nil {
+		return "", err
+	}
+
+	if err := systemd.CreateStandardConfig(configPath, &systemd.StandardConfig{
+		ServiceName: serviceName,
+		SystemdUnit: unit,
+		Target:      target,
+		AgentPath:   agentPath,
+	}); err != nil {
+		return "", fmt.Errorf("failed to write config: %w", err)
+	}
+
+	fmt.Printf("   Launcher: %s (%s)\n", info.Script, info.OptsVar)
+	return unit, nil
+}
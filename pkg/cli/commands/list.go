@@ -6,8 +6,12 @@ import (
 	"os"
 	"strings"
 
+	"github.com/middleware-labs/java-injector/pkg/agent"
 	"github.com/middleware-labs/java-injector/pkg/cli/types"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/inventory"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
 )
 
 // ListCommand lists all Java processes on the host
@@ -22,16 +26,32 @@ func NewListCommand(config *types.CommandConfig) *ListCommand {
 func (c *ListCommand) Execute() error {
 	ctx := context.Background()
 
+	if machineOutput(c.config) {
+		processes, err := c.inventoryProcesses(ctx)
+		if err != nil {
+			return fmt.Errorf("error: %v", err)
+		}
+		inv := inventory.New()
+		inv.SetProcesses(processes)
+		return writeInventory(c.config, inv)
+	}
+
 	processes, err := discovery.FindAllJavaProcesses(ctx)
 	if err != nil {
 		return fmt.Errorf("error: %v", err)
 	}
+	processes = filterProcesses(c.config.Filter, processes)
 
 	if len(processes) == 0 {
 		fmt.Println("No Java processes found")
 		return nil
 	}
 
+	pol, err := loadPolicy("")
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
 	fmt.Printf("Found %d Java processes:\n\n", len(processes))
 
 	for _, proc := range processes {
@@ -40,9 +60,50 @@ func (c *ListCommand) Execute() error {
 		fmt.Printf("  Owner: %s\n", proc.ProcessOwner)
 		fmt.Printf("  Agent: %s\n", proc.FormatAgentStatus())
 
+		if supervisorInfo := proc.DetectSupervisor(); supervisorInfo.Name != "" {
+			fmt.Printf("  Supervisor: %s (%s)\n", supervisorInfo.Kind, supervisorInfo.Name)
+		} else {
+			fmt.Printf("  Supervisor: %s\n", supervisorInfo.Kind)
+		}
+
 		if proc.HasJavaAgent {
 			agentInfo := proc.GetAgentInfo()
 			fmt.Printf("  Agent Path: %s\n", agentInfo.Path)
+			fmt.Printf("  Agent Version: %s\n", agentInfo.Version)
+			if agentInfo.SDKVersion != "" {
+				fmt.Printf("  OpenTelemetry SDK: %s\n", agentInfo.SDKVersion)
+			}
+		}
+
+		// Check if configured
+		configPath := c.getConfigPath(&proc)
+
+		// Check if WildFly
+		if proc.IsWildFly() {
+			wildflyInfo := proc.ExtractWildFlyInfo()
+			fmt.Printf("  Type: WildFly (%s)\n", wildflyInfo.Mode)
+			if wildflyInfo.HomeDir != "" {
+				fmt.Printf("  Home: %s\n", wildflyInfo.HomeDir)
+			}
+			if wildflyInfo.ServerConfig != "" {
+				fmt.Printf("  Server Config: %s\n", wildflyInfo.ServerConfig)
+			}
+		}
+
+		// Check if Jetty
+		if proc.IsJetty() {
+			jettyInfo := proc.ExtractJettyInfo()
+			fmt.Printf("  Type: Jetty\n")
+			if jettyInfo.HomeDir != "" {
+				fmt.Printf("  Jetty Home: %s\n", jettyInfo.HomeDir)
+			}
+			fmt.Printf("  Jetty Base: %s\n", jettyInfo.BaseDir)
+		} else if !proc.IsTomcat() && !proc.IsWildFly() {
+			if launcherInfo := proc.ExtractLauncherInfo(); launcherInfo.IsLauncher {
+				fmt.Printf("  Type: Launcher script (%s)\n", launcherInfo.Generator)
+				fmt.Printf("  Script: %s\n", launcherInfo.Script)
+				fmt.Printf("  Options: %s\n", launcherInfo.OptsVar)
+			}
 		}
 
 		// Check if Tomcat
@@ -50,19 +111,36 @@ func (c *ListCommand) Execute() error {
 			tomcatInfo := proc.ExtractTomcatInfo()
 			fmt.Printf("  Type: Tomcat\n")
 			fmt.Printf("  Instance: %s\n", tomcatInfo.InstanceName)
+			if tomcatInfo.Version != "" {
+				fmt.Printf("  Tomcat Version: %s\n", tomcatInfo.Version)
+			}
+			if len(tomcatInfo.Connectors) > 0 {
+				var connectors []string
+				for _, connector := range tomcatInfo.Connectors {
+					connectors = append(connectors, fmt.Sprintf("%s:%d", connector.Scheme, connector.Port))
+				}
+				fmt.Printf("  Connectors: %s\n", strings.Join(connectors, ", "))
+			}
 			if len(tomcatInfo.Webapps) > 0 {
-				fmt.Printf("  Webapps: %v\n", tomcatInfo.Webapps)
+				fmt.Printf("  Webapps:\n")
+				webappNames := c.getWebappServiceNames(&proc, configPath)
+				for _, webapp := range tomcatInfo.Webapps {
+					context := naming.ContextPath(webapp)
+					fmt.Printf("    %s → %s\n", context, webappNames[context])
+				}
 			}
 		}
 
-		// Check if configured
-		configPath := c.getConfigPath(&proc)
 		if c.fileExists(configPath) {
 			fmt.Printf("  Config: ✅ %s\n", configPath)
 		} else {
 			fmt.Printf("  Config: ❌ Not configured\n")
 		}
 
+		if pol != nil {
+			printPolicyDecision(pol.EvaluateProcess(&proc))
+		}
+
 		fmt.Println()
 	}
 
@@ -84,18 +162,35 @@ func NewListDockerCommand(config *types.CommandConfig) *ListDockerCommand {
 
 func (c *ListDockerCommand) Execute() error {
 	ctx := context.Background()
+
+	if machineOutput(c.config) {
+		containers, err := c.inventoryContainers(ctx)
+		if err != nil {
+			return fmt.Errorf("error: %v", err)
+		}
+		inv := inventory.New()
+		inv.SetContainers(containers)
+		return writeInventory(c.config, inv)
+	}
+
 	discoverer := discovery.NewDockerDiscoverer(ctx)
 
 	containers, err := discoverer.DiscoverJavaContainers()
 	if err != nil {
 		return fmt.Errorf("error: %v", err)
 	}
+	containers = filterContainers(c.config.Filter, containers)
 
 	if len(containers) == 0 {
 		fmt.Println("No Java Docker containers found")
 		return nil
 	}
 
+	pol, err := loadPolicy("")
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
 	fmt.Printf("Found %d Java Docker containers:\n\n", len(containers))
 
 	for _, container := range containers {
@@ -125,6 +220,10 @@ func (c *ListDockerCommand) Execute() error {
 			fmt.Printf("  Status: ⚠️  Not instrumented\n")
 		}
 
+		if pol != nil {
+			printPolicyDecision(pol.EvaluateContainer(&container))
+		}
+
 		fmt.Println()
 	}
 
@@ -137,18 +236,24 @@ func (c *ListDockerCommand) GetDescription() string {
 
 // ListAllCommand lists both host processes and Docker containers
 type ListAllCommand struct {
+	config            *types.CommandConfig
 	listCommand       *ListCommand
 	listDockerCommand *ListDockerCommand
 }
 
 func NewListAllCommand(config *types.CommandConfig) *ListAllCommand {
 	return &ListAllCommand{
+		config:            config,
 		listCommand:       NewListCommand(config),
 		listDockerCommand: NewListDockerCommand(config),
 	}
 }
 
 func (c *ListAllCommand) Execute() error {
+	if machineOutput(c.config) {
+		return c.writeInventory()
+	}
+
 	fmt.Println("=" + strings.Repeat("=", 70))
 	fmt.Println("HOST JAVA PROCESSES")
 	fmt.Println("=" + strings.Repeat("=", 70))
@@ -168,34 +273,88 @@ func (c *ListAllCommand) GetDescription() string {
 	return "List both host processes and Docker containers"
 }
 
+// writeInventory writes processes and containers as one document, without
+// containers when Docker isn't available
+func (c *ListAllCommand) writeInventory() error {
+	ctx := context.Background()
+	inv := inventory.New()
+
+	processes, err := c.listCommand.inventoryProcesses(ctx)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	inv.SetProcesses(processes)
+
+	if discovery.NewDockerDiscoverer(ctx).IsDockerAvailable() {
+		containers, err := c.listDockerCommand.inventoryContainers(ctx)
+		if err != nil {
+			return fmt.Errorf("error: %v", err)
+		}
+		inv.SetContainers(containers)
+	}
+	return writeInventory(c.config, inv)
+}
+
 // Helper methods (these will be moved to appropriate packages in later steps)
 func (c *ListCommand) getConfigPath(proc *discovery.JavaProcess) string {
 	serviceName := c.generateServiceName(proc)
 
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
+
 	if proc.IsTomcat() {
-		return fmt.Sprintf("/etc/middleware/tomcat/%s.conf", serviceName)
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
 	}
 
 	deploymentType := c.detectDeploymentType(proc)
-	return fmt.Sprintf("/etc/middleware/%s/%s.conf", deploymentType, serviceName)
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), deploymentType, serviceName)
 }
 
 func (c *ListCommand) detectDeploymentType(proc *discovery.JavaProcess) string {
-	if proc.ProcessOwner != "root" && proc.ProcessOwner != os.Getenv("USER") {
-		return "systemd"
-	}
-	return "standalone"
+	return proc.DetectSupervisor().Kind
 }
 
 func (c *ListCommand) generateServiceName(proc *discovery.JavaProcess) string {
-	// Simplified version - full implementation will be moved to naming package
-	if proc.JarFile != "" {
-		return strings.TrimSuffix(proc.JarFile, ".jar")
+	// Use the naming package so paths match what auto-instrument writes
+	return naming.GenerateServiceName(proc)
+}
+
+// getWebappServiceNames returns the effective per-webapp service names, preferring
+// the mapping written to the instance config over freshly generated defaults
+func (c *ListCommand) getWebappServiceNames(proc *discovery.JavaProcess, configPath string) naming.ContextServiceNames {
+	pattern := naming.DefaultTomcatWebappPattern
+	names := naming.ContextServiceNames{}
+
+	if configVars, err := systemd.ReadConfigFile(configPath); err == nil {
+		if configVars["MW_SERVICE_NAME_PATTERN"] != "" {
+			pattern = configVars["MW_SERVICE_NAME_PATTERN"]
+		}
+		names = naming.ParseContextMap(configVars["MW_SERVICE_NAME_CONTEXT_MAP"])
+
+		// Agents without context map support report every webapp as the instance
+		if !agent.SupportsContextMap(configVars["MW_JAVA_AGENT_PATH"]) {
+			instance := naming.GenerateForTomcat(proc)
+			names = naming.ContextServiceNames{}
+			for context := range naming.GenerateForTomcatWebapps(proc, pattern) {
+				names[context] = instance
+			}
+			return names
+		}
 	}
-	if proc.ServiceName != "" && proc.ServiceName != "java-service" {
-		return proc.ServiceName
+
+	// Webapps deployed after instrumentation are not in the mapping yet
+	for context, name := range naming.GenerateForTomcatWebapps(proc, pattern) {
+		if _, exists := names[context]; !exists {
+			names[context] = name
+		}
 	}
-	return fmt.Sprintf("java-app-%d", proc.ProcessPID)
+
+	return names
 }
 
 func (c *ListCommand) fileExists(path string) bool {
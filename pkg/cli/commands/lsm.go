@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/lsm"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// errDeclined is returned when the operator doesn't want a rule added
+var errDeclined = errors.New("declined")
+
+// accessFixer finds out whether SELinux or AppArmor keeps a service from
+// reading the agent and offers to add the rule that allows it
+type accessFixer struct {
+	config  *types.CommandConfig
+	applied map[string]bool // rules added by this run, by module and profile
+}
+
+func newAccessFixer(config *types.CommandConfig) *accessFixer {
+	return &accessFixer{config: config, applied: make(map[string]bool)}
+}
+
+// fix tells whether the service can read the agent after adding the rule
+func (f *accessFixer) fix(agentPath string, proc *discovery.JavaProcess) bool {
+	denial := lsm.Diagnose(agentPath, proc.ProcessPID)
+	if denial == nil {
+		return false
+	}
+	fmt.Printf("   └── Cause: %s\n", denial)
+
+	key := denial.Module + ":" + denial.Profile
+	if !f.applied[key] {
+		var err error
+		switch denial.Module {
+		case lsm.SELinux:
+			err = f.allowSELinux(agentPath)
+		case lsm.AppArmor:
+			err = f.allowAppArmor(denial.Profile)
+		}
+		if err != nil {
+			if err != errDeclined {
+				fmt.Printf("   └── %v\n", err)
+			}
+			return false
+		}
+		f.applied[key] = true
+	}
+
+	// Dry runs only record the rule, assume it works
+	if changeset.Recording() {
+		return true
+	}
+	return agent.CheckAccessibleBySystemd(agentPath, proc.ProcessOwner) == nil
+}
+
+func (f *accessFixer) allowSELinux(agentPath string) error {
+	if !strings.HasPrefix(agentPath, lsm.AgentDir+"/") {
+		return fmt.Errorf("move the agent into %s, the directory the SELinux rule labels", lsm.AgentDir)
+	}
+	if !confirm(f.config, fmt.Sprintf("   Label %s %s with a persistent SELinux file context rule?", lsm.AgentDir, lsm.AgentLabel)) {
+		return errDeclined
+	}
+	added, err := lsm.AllowSELinux()
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Printf("   ✅ Relabelled %s with the existing file context rule\n", lsm.AgentDir)
+		return nil
+	}
+	fmt.Printf("   ✅ Added SELinux file context rule %s\n", lsm.AgentDirPattern)
+	return recordLSMArtifact(state.ArtifactSELinuxRule, lsm.AgentDirPattern)
+}
+
+func (f *accessFixer) allowAppArmor(profile string) error {
+	if !confirm(f.config, fmt.Sprintf("   Allow the AppArmor profile %s to read %s?", profile, lsm.AgentDir)) {
+		return errDeclined
+	}
+	local, err := lsm.AllowAppArmor(profile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   ✅ Added %q to %s\n", lsm.AppArmorRule, local)
+	return recordLSMArtifact(state.ArtifactAppArmorRule, local)
+}
+
+// recordLSMArtifact records a rule so uninstrument can remove it once no
+// service is instrumented
+func recordLSMArtifact(kind, path string) error {
+	if err := state.RecordArtifact(state.Artifact{Kind: kind, Path: path}); err != nil {
+		return fmt.Errorf("failed to record %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeLSMRules offers to remove the SELinux and AppArmor rules once no
+// service is instrumented anymore
+func removeLSMRules(config *types.CommandConfig) bool {
+	rules, err := state.LSMArtifacts()
+	if err != nil || len(rules) == 0 {
+		return false
+	}
+	if remaining, err := state.FindOrphanedConfigs(configRoot(), nil); err != nil || len(remaining) > 0 {
+		return false
+	}
+
+	fmt.Printf("⚠️  %d SELinux/AppArmor rule(s) were added so services could read the agent\n", len(rules))
+	if !confirm(config, "   Remove them?") {
+		return false
+	}
+	n, err := state.RevertLSMArtifacts()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+	}
+	return n > 0
+}
@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/inventory"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+)
+
+// machineOutput tells whether --output asks for something other than the
+// emoji text, in which case nothing else may be printed to stdout
+func machineOutput(config *types.CommandConfig) bool {
+	return config.Output != "" && config.Output != inventory.FormatText
+}
+
+// writeInventory writes the inventory in the --output format
+func writeInventory(config *types.CommandConfig, inv *inventory.Inventory) error {
+	if err := inventory.Write(os.Stdout, config.Output, inv); err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	return nil
+}
+
+// inventoryProcesses describes the Java processes on the host
+func (c *ListCommand) inventoryProcesses(ctx context.Context) ([]inventory.Process, error) {
+	processes, err := discovery.FindAllJavaProcesses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pol, _, err := readPolicy("")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []inventory.Process
+	for _, proc := range filterProcesses(c.config.Filter, processes) {
+		configPath := c.getConfigPath(&proc)
+		supervisor := proc.DetectSupervisor()
+		agent := proc.GetAgentInfo()
+
+		p := inventory.Process{
+			PID:            proc.ProcessPID,
+			ServiceName:    naming.GenerateServiceName(&proc),
+			Owner:          proc.ProcessOwner,
+			DeploymentType: deploymentType(&proc),
+			Supervisor:     supervisor.Kind,
+			SupervisorName: supervisor.Name,
+			Unit:           discovery.SystemdUnitForPID(proc.ProcessPID),
+			ConfigPath:     configPath,
+			Configured:     c.fileExists(configPath),
+			JavaVersion:    proc.ProcessRuntimeVersion,
+			JarFile:        proc.JarFile,
+			MainClass:      proc.MainClass,
+			Agent: inventory.Agent{
+				Type:       agent.Type.String(),
+				Path:       agent.Path,
+				Name:       agent.Name,
+				Version:    agent.Version,
+				SDKVersion: agent.SDKVersion,
+				Serverless: agent.IsServerless,
+			},
+		}
+		if proc.IsTomcat() {
+			p.Webapps = c.getWebappServiceNames(&proc, configPath)
+		}
+		if pol != nil {
+			decision := pol.EvaluateProcess(&proc)
+			p.Policy = inventoryPolicy(decision)
+			if decision.Include && decision.ServiceName != "" {
+				p.ServiceName = decision.ServiceName
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// inventoryContainers describes the Java containers
+func (c *ListDockerCommand) inventoryContainers(ctx context.Context) ([]inventory.Container, error) {
+	containers, err := discovery.NewDockerDiscoverer(ctx).DiscoverJavaContainers()
+	if err != nil {
+		return nil, err
+	}
+	pol, _, err := readPolicy("")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []inventory.Container
+	for _, container := range filterContainers(c.config.Filter, containers) {
+		ic := inventory.Container{
+			ID:             container.ContainerID,
+			Name:           container.ContainerName,
+			ServiceName:    container.GetServiceName(),
+			Image:          container.ImageName + ":" + container.ImageTag,
+			Status:         container.Status,
+			DeploymentType: "container",
+			Instrumented:   container.Instrumented,
+			JarFiles:       container.JarFiles,
+			Agent:          inventory.Agent{Type: discovery.AgentNone.String()},
+		}
+		if container.IsCompose {
+			ic.DeploymentType = "compose"
+			ic.ComposeProject = container.ComposeProject
+			ic.ComposeService = container.ComposeService
+			ic.ConfigPath = container.ComposeFile
+		}
+		if container.HasJavaAgent {
+			ic.Agent.Type = discovery.AgentOther.String()
+			if container.IsMiddlewareAgent {
+				ic.Agent.Type = discovery.AgentMiddleware.String()
+			}
+			ic.Agent.Path = container.JavaAgentPath
+		}
+		if pol != nil {
+			decision := pol.EvaluateContainer(&container)
+			ic.Policy = inventoryPolicy(decision)
+			if decision.Include && decision.ServiceName != "" {
+				ic.ServiceName = decision.ServiceName
+			}
+		}
+		result = append(result, ic)
+	}
+	return result, nil
+}
+
+// deploymentType names how a process is deployed: its server, a launcher
+// script or else its supervisor
+func deploymentType(proc *discovery.JavaProcess) string {
+	switch {
+	case proc.IsWildFly():
+		return "wildfly"
+	case proc.IsJetty():
+		return "jetty"
+	case proc.IsTomcat():
+		return "tomcat"
+	case proc.ExtractLauncherInfo().IsLauncher:
+		return "launcher"
+	default:
+		return proc.DetectSupervisor().Kind
+	}
+}
+
+func inventoryPolicy(decision policy.Decision) *inventory.Policy {
+	return &inventory.Policy{
+		Include: decision.Include,
+		Rule:    decision.Rule,
+		Reason:  decision.Reason,
+		Restart: decision.Restart,
+	}
+}
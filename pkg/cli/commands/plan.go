@@ -0,0 +1,234 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// PlanCommand shows what auto-instrument and instrument-docker would change
+// with a config file, without changing anything
+type PlanCommand struct {
+	config     *types.CommandConfig
+	configPath string
+	outPath    string
+}
+
+func NewPlanCommand(config *types.CommandConfig, configPath, outPath string) *PlanCommand {
+	return &PlanCommand{config: config, configPath: configPath, outPath: outPath}
+}
+
+func (c *PlanCommand) Execute() error {
+	command := strings.TrimSpace("plan " + c.configPath)
+	if err := UseConfigFile(c.config, c.configPath, "plan"); err != nil {
+		return err
+	}
+	return DryRun(command, c.outPath, func() error {
+		if err := NewAutoInstrumentCommand(c.config).Execute(); err != nil {
+			return err
+		}
+		if userMode() || !discovery.NewDockerDiscoverer(context.Background()).IsDockerAvailable() {
+			return nil
+		}
+		fmt.Println()
+		return NewInstrumentDockerCommand(c.config).Execute()
+	})
+}
+
+func (c *PlanCommand) GetDescription() string {
+	return "Show the changes auto-instrument-config and instrument-docker-config would make"
+}
+
+// DryRun runs a mutating command while recording its changes instead of
+// performing them, prints the plan and saves it to outPath if given
+func DryRun(command, outPath string, execute func() error) error {
+	fmt.Println("🧪 Dry run: changes are recorded, nothing is written or restarted")
+	fmt.Println()
+
+	recorder := changeset.Record()
+	err := execute()
+	changeset.Stop()
+	if err != nil {
+		return err
+	}
+
+	plan := recorder.Plan(command)
+	fmt.Println()
+	printPlan(plan)
+
+	if outPath == "" || plan.Empty() {
+		return nil
+	}
+	if err := plan.Save(outPath); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	fmt.Printf("\n💾 Plan saved to %s\n", outPath)
+	fmt.Printf("   Apply it with: mw-injector apply --plan %s\n", outPath)
+	return nil
+}
+
+// ApplyCommand performs a saved plan verbatim
+type ApplyCommand struct {
+	config   *types.CommandConfig
+	planPath string
+}
+
+func NewApplyCommand(config *types.CommandConfig, planPath string) *ApplyCommand {
+	return &ApplyCommand{config: config, planPath: planPath}
+}
+
+func (c *ApplyCommand) Execute() error {
+	plan, err := changeset.Load(c.planPath)
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	fmt.Printf("📋 Applying plan %s\n", c.planPath)
+	fmt.Printf("   Command: %s\n", plan.Command)
+	fmt.Printf("   Created: %s\n\n", plan.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	if plan.Empty() {
+		fmt.Println("✅ Plan has no changes")
+		return nil
+	}
+
+	// Refuse when the host no longer looks like it did when planning
+	if drift := plan.Drift(currentTargets(plan.Targets)); len(drift) > 0 {
+		fmt.Println("❌ The host changed since the plan was made:")
+		for _, d := range drift {
+			fmt.Printf("   - %s\n", d)
+		}
+		return fmt.Errorf("❌ Plan is out of date, run the plan again")
+	}
+
+	failed := plan.Apply()
+	for _, err := range failed {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("❌ %d of %d operation(s) failed", len(failed), len(plan.Ops))
+	}
+
+	fmt.Printf("🎉 Applied %d file change(s) and %d command(s)\n", len(plan.Files), len(plan.Commands()))
+	return nil
+}
+
+func (c *ApplyCommand) GetDescription() string {
+	return "Apply a plan saved with plan --out or --dry-run --out"
+}
+
+// processTarget identifies a host process across restarts
+func processTarget(proc *discovery.JavaProcess) string {
+	return "process " + naming.GenerateServiceName(proc)
+}
+
+// containerTarget identifies a container, recreating it changes the ID
+func containerTarget(c *discovery.DockerContainer) string {
+	return fmt.Sprintf("container %s (%s)", c.ContainerName, c.ContainerID)
+}
+
+// savedContainerTarget identifies an instrumented container by its state
+func savedContainerTarget(c *docker.ContainerState) string {
+	return fmt.Sprintf("container %s (%s)", c.ContainerName, c.ContainerID)
+}
+
+// currentTargets finds the running processes and containers of the kinds
+// a plan was made for
+func currentTargets(planned []string) []string {
+	ctx := context.Background()
+	var hosts, containers bool
+	for _, target := range planned {
+		hosts = hosts || strings.HasPrefix(target, "process ")
+		containers = containers || strings.HasPrefix(target, "container ")
+	}
+
+	var current []string
+	if hosts {
+		if processes, err := discovery.FindAllJavaProcesses(ctx); err == nil {
+			for i := range processes {
+				current = append(current, processTarget(&processes[i]))
+			}
+		}
+	}
+	if containers {
+		if found, err := discovery.NewDockerDiscoverer(ctx).DiscoverJavaContainers(); err == nil {
+			for i := range found {
+				current = append(current, containerTarget(&found[i]))
+			}
+		}
+	}
+	return current
+}
+
+// printPlan prints the files and commands of a plan
+func printPlan(plan *changeset.Plan) {
+	if plan.Empty() {
+		fmt.Println("✅ No changes")
+		return
+	}
+
+	fmt.Printf("📋 Plan: %d file change(s), %d command(s)\n", len(plan.Files), len(plan.Commands()))
+
+	if len(plan.Files) > 0 {
+		fmt.Println("\n📄 Files:")
+		for _, f := range plan.Files {
+			fmt.Printf("   %s %s %s\n", actionSymbol(f.Action), f.Action, f.Path)
+		}
+		for _, f := range plan.Files {
+			if note := hiddenDiff(f.Path); note != "" {
+				fmt.Printf("\n%s: %s\n", f.Path, note)
+				continue
+			}
+			fmt.Printf("\n%s", f.Diff)
+		}
+	}
+
+	if cmds := plan.Commands(); len(cmds) > 0 {
+		fmt.Println("\n🔄 Commands (restarts and container recreations):")
+		for _, op := range plan.Ops {
+			if op.Kind != changeset.OpExec {
+				continue
+			}
+			line := strings.Join(op.Args, " ")
+			if op.Dir != "" {
+				line += "   (in " + op.Dir + ")"
+			}
+			fmt.Printf("   $ %s\n", line)
+		}
+	}
+}
+
+func actionSymbol(action string) string {
+	switch action {
+	case "create":
+		return "+"
+	case "delete":
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// hiddenDiff explains why the diff of a file isn't shown: credentials,
+// bookkeeping and backups
+func hiddenDiff(path string) string {
+	switch {
+	case strings.HasPrefix(path, secrets.Dir+"/") || strings.HasSuffix(path, ".env"):
+		return "contents hidden, may contain credentials"
+	case state.IsStateFile(path):
+		return "mw-injector state"
+	case strings.HasSuffix(path, ".backup"):
+		return "backup of " + strings.TrimSuffix(path, ".backup")
+	case strings.HasSuffix(path, ".jar"):
+		return "agent JAR"
+	}
+	return ""
+}
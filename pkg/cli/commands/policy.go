@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/middleware-labs/java-injector/pkg/config"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// loadPolicy loads the instrumentation policy from path, or from the default
+// location when path is empty. Without a policy everything is instrumented.
+func loadPolicy(path string) (*policy.Policy, error) {
+	p, path, err := readPolicy(path)
+	if p == nil {
+		return nil, err
+	}
+	fmt.Printf("📜 Using policy %s (%d rules, default: %s)\n", path, len(p.Rules), p.DefaultAction)
+	if p.Restarts.Timeout > 0 {
+		useRestartTimeout(p.Restarts.Timeout)
+	}
+	return p, nil
+}
+
+// readPolicy loads the policy like loadPolicy without printing anything,
+// returning the path it was read from
+func readPolicy(path string) (*policy.Policy, string, error) {
+	if path == "" {
+		path = policy.DefaultPath
+		if userMode() {
+			path = filepath.Join(configRoot(), "injector.yaml")
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, path, nil
+		}
+	}
+
+	p, err := policy.Load(path)
+	if err != nil {
+		return nil, path, fmt.Errorf("failed to load policy: %w", err)
+	}
+	return p, path, nil
+}
+
+// hostSettings builds the agent settings of a host service from the defaults
+// and the matching policy rule
+func hostSettings(decision policy.Decision, target, agentPath, logLevel string) *config.ProcessConfiguration {
+	settings := config.DefaultConfiguration()
+	settings.MWTarget = target
+	settings.JavaAgentPath = agentPath
+	if logLevel != "" {
+		settings.MWLogLevel = logLevel
+	}
+	decision.Apply(&settings)
+	return &settings
+}
+
+// containerSettings builds the agent settings of a container from the
+// defaults and the matching policy rule
+func containerSettings(decision policy.Decision, apiKey, target, logLevel string) *config.ProcessConfiguration {
+	settings := config.DefaultConfiguration()
+	settings.MWAPIKey = apiKey
+	settings.MWTarget = target
+	if logLevel != "" {
+		settings.MWLogLevel = logLevel
+	}
+	decision.Apply(&settings)
+	settings.JavaAgentPath = docker.DefaultContainerAgentPath
+	return &settings
+}
+
+// restartManually tells the operator how to restart a service whose rule
+// asks for a manual restart
+func restartManually(decision policy.Decision, unit string) {
+	if unit != "" {
+		fmt.Printf("   └── Restart it when ready: %s (rule %s)\n", systemd.ManualRestartCommand(unit), decision.Rule)
+	} else {
+		fmt.Printf("   └── Restart it when ready (rule %s)\n", decision.Rule)
+	}
+}
+
+// printPolicyDecision explains which rule matched a process or container
+func printPolicyDecision(decision policy.Decision) {
+	if !decision.Include {
+		fmt.Printf("  Policy: ⭐️  %s\n", decision.Reason)
+		return
+	}
+	fmt.Printf("  Policy: ✅ %s\n", decision.Reason)
+	fmt.Printf("  Service Name: %s\n", decision.ServiceName)
+	switch decision.Restart {
+	case policy.RestartManual:
+		fmt.Printf("  Restart: manual\n")
+	case policy.RestartWindow:
+		fmt.Printf("  Restart: in the maintenance window\n")
+	}
+	if decision.Group != "" {
+		fmt.Printf("  Restart Group: %s\n", decision.Group)
+	}
+}
@@ -0,0 +1,570 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/drift"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// reconcileTimer is the systemd timer running reconcile periodically
+const reconcileTimer = "mw-injector-reconcile"
+
+// ReconcileCommand compares the instrumentation the policy and the recorded
+// state call for with what's on disk and running, repairs the drift it can
+// and reports the rest
+type ReconcileCommand struct {
+	config     *types.CommandConfig
+	configPath string
+	check      bool   // report only
+	reportPath string // "" for the state directory
+	timer      string // install a timer with this OnCalendar instead of reconciling
+}
+
+func NewReconcileCommand(config *types.CommandConfig, configPath string, check bool, reportPath, timer string) *ReconcileCommand {
+	if configPath == "" {
+		configPath = findDefaultConfigFile()
+	}
+	return &ReconcileCommand{config: config, configPath: configPath, check: check, reportPath: reportPath, timer: timer}
+}
+
+func (c *ReconcileCommand) Execute() error {
+	ctx := context.Background()
+
+	if userMode() {
+		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector reconcile [config-file]")
+	}
+	if c.timer != "" {
+		return c.installTimer()
+	}
+
+	configVars := map[string]string{}
+	if c.configPath != "" {
+		vars, err := systemd.ReadConfigFile(c.configPath)
+		if err != nil {
+			return fmt.Errorf("❌ Failed to load config from %s: %v", c.configPath, err)
+		}
+		configVars = vars
+		fmt.Printf("🔧 Using configuration from: %s\n", c.configPath)
+	}
+
+	// Services reference the current agent of the store, hosts instrumented
+	// before it existed the configured agent until it's installed there
+	agentPath := firstNonEmpty(c.config.AgentPath, configVars["MW_JAVA_AGENT_PATH"], c.config.DefaultAgentPath)
+	agentDir := ""
+	if !userMode() {
+		agentPath = existingPath(c.config.DefaultAgentPath, agentPath)
+		agentDir = c.config.DefaultAgentDir
+	}
+	apiKey := configVars["MW_API_KEY"]
+	if c.config.APIKeyFile != "" {
+		key, err := readAPIKey(c.config, configVars, "")
+		if err != nil {
+			return err
+		}
+		apiKey = key
+	}
+	pol, err := loadPolicy(configVars["MW_POLICY_FILE"])
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if err := setRestartTimeout(configVars["MW_RESTART_TIMEOUT"]); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	r := &reconciler{
+		report:    drift.NewReport(!c.check),
+		pol:       pol,
+		agentPath: agentPath,
+		agentDir:  agentDir,
+		target:    firstNonEmpty(c.config.Target, configVars["MW_TARGET"]),
+		apiKey:    apiKey,
+		logLevel:  firstNonEmpty(c.config.LogLevel, configVars["MW_LOG_LEVEL"]),
+		restarts:  newRestartQueue(pol),
+	}
+	if c.check {
+		fmt.Println("🔍 Checking for drift, nothing is repaired")
+	} else {
+		fmt.Println("🔍 Checking for drift")
+	}
+	fmt.Println()
+
+	processes, err := discovery.FindAllJavaProcesses(ctx)
+	if err != nil {
+		return fmt.Errorf("❌ Error discovering processes: %v", err)
+	}
+	for i := range processes {
+		r.checkProcess(&processes[i], c.getConfigPath(&processes[i]))
+	}
+
+	if discovery.NewDockerDiscoverer(ctx).IsDockerAvailable() {
+		if err := r.checkContainers(ctx); err != nil {
+			fmt.Printf("⚠️  Skipping containers: %v\n", err)
+		}
+	}
+
+	if r.report.Checked > 0 {
+		r.checkAgent()
+	}
+	if !c.check {
+		r.restarts.run()
+	}
+
+	reportPath := c.reportPath
+	if reportPath == "" {
+		reportPath = filepath.Join(state.DefaultStateDir, drift.ReportFile)
+	}
+	if err := r.report.Save(reportPath); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	findings := len(r.report.Findings)
+	unresolved := r.report.Unresolved()
+	if findings > 0 {
+		fmt.Println()
+	}
+	switch {
+	case findings == 0:
+		fmt.Printf("✅ No drift in %d instrumented service(s) and container(s)\n", r.report.Checked)
+	case unresolved == 0:
+		fmt.Printf("🔧 Repaired %d drift(s) in %d instrumented service(s) and container(s)\n", findings, r.report.Checked)
+	default:
+		fmt.Printf("⚠️  %d drift(s) in %d instrumented service(s) and container(s), %d need attention\n", findings, r.report.Checked, unresolved)
+	}
+	fmt.Printf("📄 Report: %s\n", reportPath)
+
+	if unresolved > 0 {
+		return fmt.Errorf("❌ %d drift(s) need attention", unresolved)
+	}
+	return nil
+}
+
+// installTimer runs reconcile periodically
+func (c *ReconcileCommand) installTimer() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("❌ Failed to find the mw-injector binary: %v", err)
+	}
+	command := []string{exe, "reconcile"}
+	if c.configPath != "" {
+		configPath, err := filepath.Abs(c.configPath)
+		if err != nil {
+			return fmt.Errorf("❌ %v", err)
+		}
+		command = append(command, configPath)
+	}
+	if c.check {
+		command = append(command, "--check")
+	}
+
+	if err := systemd.InstallTimer(reconcileTimer, "Middleware injector drift reconciliation", c.timer, command); err != nil {
+		return fmt.Errorf("❌ Failed to install the reconcile timer: %v", err)
+	}
+	fmt.Printf("⏰ Installed %s.timer (%s)\n", reconcileTimer, c.timer)
+	fmt.Printf("   Reports: %s\n", filepath.Join(state.DefaultStateDir, drift.ReportFile))
+	return nil
+}
+
+func (c *ReconcileCommand) GetDescription() string {
+	return "Repair and report drift between the desired and the actual instrumentation"
+}
+
+func (c *ReconcileCommand) getConfigPath(proc *discovery.JavaProcess) string {
+	serviceName := naming.GenerateServiceName(proc)
+
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsTomcat() {
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
+	}
+
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), proc.DetectSupervisor().Kind, serviceName)
+}
+
+// reconciler checks and repairs the services of one reconcile run
+type reconciler struct {
+	report    *drift.Report
+	pol       *policy.Policy
+	agentPath string
+	agentDir  string // agent store, empty in user mode
+	target    string // from the config file, the service's own when empty
+	apiKey    string // containers are only repaired with the API key
+	logLevel  string // agent log level, the default when empty
+	restarts  *restartQueue
+}
+
+// agentFor returns the agent a service is configured with: the version its
+// policy rule pins, or the current one
+func (r *reconciler) agentFor(decision policy.Decision) string {
+	if decision.AgentVersion != "" && r.agentDir != "" {
+		return agent.NewStore(r.agentDir).Path(decision.AgentVersion)
+	}
+	return r.agentPath
+}
+
+// add records and prints a finding
+func (r *reconciler) add(f *drift.Finding) *drift.Finding {
+	r.report.Add(f)
+	fmt.Printf("⚠️  %s: %s\n", f.Target, f.Detail)
+	if f.Path != "" {
+		fmt.Printf("   └── %s\n", f.Path)
+	}
+	if f.Expected != "" || f.Actual != "" {
+		fmt.Printf("   └── Expected %q, found %q\n", f.Expected, f.Actual)
+	}
+	if f.Repair == drift.Manual {
+		fmt.Println("   └── 👉 Needs a manual fix")
+	}
+	return f
+}
+
+// resolve records the outcome of a repair covering several findings
+func (r *reconciler) resolve(findings []*drift.Finding, err error) {
+	for _, f := range findings {
+		f.Resolve(err)
+	}
+	if err != nil {
+		fmt.Printf("   └── ❌ Repair failed: %v\n", err)
+		return
+	}
+	fmt.Println("   └── 🔧 Repaired")
+}
+
+// checkAgent checks the agent JAR services are configured with
+func (r *reconciler) checkAgent() {
+	if changeset.Exists(r.agentPath) {
+		return
+	}
+	r.add(&drift.Finding{
+		Target: "host",
+		Kind:   drift.AgentMissing,
+		Path:   r.agentPath,
+		Detail: "the agent JAR is missing, run auto-instrument-config to install it again",
+		Repair: drift.Manual,
+	})
+}
+
+// checkProcess compares an instrumented process with its config
+func (r *reconciler) checkProcess(proc *discovery.JavaProcess, configPath string) {
+	if !changeset.Exists(configPath) {
+		return
+	}
+	configVars, err := systemd.ReadConfigFile(configPath)
+	if err != nil {
+		return
+	}
+	r.report.Checked++
+	target := processTarget(proc)
+
+	decision := r.pol.EvaluateProcess(proc)
+	if !decision.Include {
+		r.add(&drift.Finding{
+			Target: target,
+			Kind:   drift.ExcludedByPolicy,
+			Path:   configPath,
+			Detail: decision.Reason + ", run uninstrument to remove the agent",
+			Repair: drift.Manual,
+		})
+		return
+	}
+
+	expected := r.agentFor(decision)
+
+	// Launchers and supervisors are instrumented through recorded changes,
+	// auto-instrument-config writes them again
+	artifacts, _ := state.ArtifactsForConfig(configPath)
+	for _, a := range artifacts {
+		f := &drift.Finding{Target: target, Path: a.Path, Unit: a.Unit, Repair: drift.Manual}
+		switch {
+		case a.Kind == state.ArtifactManagedBlock && !managed.HasBlock(a.Path):
+			f.Kind = drift.ManagedBlockRemoved
+			f.Detail = "the Middleware block was removed, run auto-instrument-config to add it again"
+		case a.Kind == state.ArtifactDropIn && !changeset.Exists(a.Path):
+			f.Kind = drift.DropInMissing
+			f.Detail = "the drop-in was removed, run auto-instrument-config to write it again"
+		case a.Kind == state.ArtifactFile && !changeset.Exists(a.Path):
+			f.Kind = drift.FileRemoved
+			f.Detail = "a file written for the service was removed, run auto-instrument-config to write it again"
+		default:
+			continue
+		}
+		r.add(f)
+	}
+
+	unit := configVars["MW_SYSTEMD_UNIT"]
+	isTomcat := filepath.Base(filepath.Dir(configPath)) == "tomcat"
+	if isTomcat {
+		unit = systemd.GetTomcatServiceName()
+	}
+	if len(artifacts) > 0 || unit == "" {
+		if configured := configVars["MW_JAVA_AGENT_PATH"]; configured != expected {
+			r.add(&drift.Finding{
+				Target: target, Kind: drift.AgentPathChanged, Path: configPath,
+				Expected: expected, Actual: configured,
+				Detail: "configured for another agent, run auto-instrument-config to update it",
+				Repair: drift.Manual,
+			})
+		}
+		return
+	}
+
+	// Standard and Tomcat services load the agent from the Middleware drop-in,
+	// which is written again from the config
+	var rewrite []*drift.Finding
+	if configured := configVars["MW_JAVA_AGENT_PATH"]; configured != expected {
+		rewrite = append(rewrite, r.add(&drift.Finding{
+			Target: target, Kind: drift.AgentPathChanged, Path: configPath, Unit: unit,
+			Expected: expected, Actual: configured,
+			Detail: "the config points at another agent",
+		}))
+	}
+	dropIn, err := systemd.DropInPath(unit)
+	if err != nil {
+		return
+	}
+	content, err := os.ReadFile(dropIn)
+	if err != nil {
+		rewrite = append(rewrite, r.add(&drift.Finding{
+			Target: target, Kind: drift.DropInMissing, Path: dropIn, Unit: unit,
+			Detail: "the Middleware drop-in was removed",
+		}))
+	} else if agent := drift.AgentOption(string(content)); agent != expected {
+		rewrite = append(rewrite, r.add(&drift.Finding{
+			Target: target, Kind: drift.AgentPathChanged, Path: dropIn, Unit: unit,
+			Expected: expected, Actual: agent,
+			Detail: "the drop-in loads another agent",
+		}))
+	}
+
+	if len(rewrite) > 0 {
+		if !r.report.Repair {
+			return
+		}
+		tx := changeset.Begin(target)
+		err := r.rewriteHostConfig(proc, configPath, configVars, unit, isTomcat, decision)
+		changeset.End()
+		r.resolve(rewrite, err)
+		if err == nil {
+			systemd.ReloadSystemd()
+			r.restartIfNeeded(proc, unit, decision, tx)
+		}
+		return
+	}
+
+	// The files are in place, check that systemd applies them
+	properties := systemd.Show(unit, "DropInPaths", "NeedDaemonReload", "Environment")
+	if len(properties) == 0 {
+		return
+	}
+	variable := "JAVA_TOOL_OPTIONS"
+	if isTomcat {
+		variable = "CATALINA_OPTS"
+	}
+	loaded := false
+	dropInPaths := strings.Fields(properties["DropInPaths"])
+	for _, path := range dropInPaths {
+		loaded = loaded || path == dropIn
+	}
+
+	switch {
+	case properties["NeedDaemonReload"] == "yes":
+		f := r.add(&drift.Finding{
+			Target: target, Kind: drift.DropInNotLoaded, Path: dropIn, Unit: unit,
+			Detail: "systemd hasn't reloaded the changed unit files",
+		})
+		if r.report.Repair {
+			r.resolve([]*drift.Finding{f}, systemd.ReloadSystemd())
+			r.restartIfNeeded(proc, unit, decision, nil)
+		}
+	case !loaded:
+		r.add(&drift.Finding{
+			Target: target, Kind: drift.DropInNotLoaded, Path: dropIn, Unit: unit,
+			Detail: "systemd doesn't load the Middleware drop-in, check for a masking unit in /run or /etc",
+			Repair: drift.Manual,
+		})
+	default:
+		env := drift.ParseEnvironment(properties["Environment"])
+		if drift.AgentOption(env[variable]) == expected {
+			return
+		}
+		detail := variable + " is set again by a later setting"
+		if by := drift.OverriddenBy(dropInPaths, dropIn, variable); by != "" {
+			detail = variable + " is overridden by " + by
+		}
+		r.add(&drift.Finding{
+			Target: target, Kind: drift.OptionsOverridden, Unit: unit,
+			Expected: "-javaagent:" + expected, Actual: env[variable],
+			Detail: detail + ", append -javaagent:" + expected + " there",
+			Repair: drift.Manual,
+		})
+	}
+}
+
+// rewriteHostConfig writes the config and drop-in of a standard or Tomcat
+// service again, as auto-instrument-config does
+func (r *reconciler) rewriteHostConfig(proc *discovery.JavaProcess, configPath string, configVars systemd.ConfigVars, unit string, isTomcat bool, decision policy.Decision) error {
+	target := r.target
+	if target == "" {
+		target = configVars["MW_TARGET"]
+	}
+	agentPath := r.agentFor(decision)
+	settings := hostSettings(decision, target, agentPath, r.logLevel)
+
+	var err error
+	if isTomcat {
+		pattern := configVars["MW_SERVICE_NAME_PATTERN"]
+		if pattern == "" {
+			pattern = naming.DefaultTomcatWebappPattern
+		}
+		err = systemd.CreateTomcatConfig(configPath, &systemd.TomcatConfig{
+			InstanceName:       decision.ServiceName,
+			Pattern:            pattern,
+			WebappServiceNames: naming.GenerateForTomcatWebapps(proc, pattern),
+			Target:             target,
+			AgentPath:          agentPath,
+			Settings:           settings,
+		})
+	} else {
+		err = systemd.CreateStandardConfig(configPath, &systemd.StandardConfig{
+			ServiceName: decision.ServiceName,
+			SystemdUnit: unit,
+			Target:      target,
+			AgentPath:   agentPath,
+			Settings:    settings,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return systemd.CreateDropIn(&systemd.DropInConfig{
+		ServiceName: unit,
+		ConfigPath:  configPath,
+		IsTomcat:    isTomcat,
+		AgentPath:   agentPath,
+		Settings:    settings,
+	})
+}
+
+// restartIfNeeded queues the restart of a repaired service that doesn't
+// run with the agent, following its policy rule
+func (r *reconciler) restartIfNeeded(proc *discovery.JavaProcess, unit string, decision policy.Decision, tx *changeset.Transaction) {
+	tx.SetUnit(unit)
+	if proc.HasJavaAgent && proc.JavaAgentPath == r.agentFor(decision) {
+		return
+	}
+	if decision.Restart == policy.RestartManual {
+		restartManually(decision, unit)
+		return
+	}
+	r.restarts.addUnit(proc, unit, decision, tx)
+}
+
+// checkContainers compares instrumented containers with their saved state
+func (r *reconciler) checkContainers(ctx context.Context) error {
+	dockerOps := docker.NewDockerOperations(ctx, r.agentPath)
+	saved, err := dockerOps.ListInstrumentedContainers()
+	if err != nil {
+		return err
+	}
+	containers, err := discovery.NewDockerDiscoverer(ctx).DiscoverJavaContainers()
+	if err != nil {
+		return err
+	}
+	running := make(map[string]*discovery.DockerContainer)
+	for i := range containers {
+		running[containers[i].ContainerName] = &containers[i]
+	}
+
+	for _, s := range saved {
+		r.report.Checked++
+		container := running[s.ContainerName]
+		target := "container " + s.ContainerName
+		if container != nil {
+			target = containerTarget(container)
+		}
+
+		if s.EnvFile != "" && !changeset.Exists(s.EnvFile) {
+			r.add(&drift.Finding{
+				Target: target, Kind: drift.FileRemoved, Path: s.EnvFile,
+				Detail: "the API key env file was removed, run rotate-key to write it again",
+				Repair: drift.Manual,
+			})
+		}
+
+		var f *drift.Finding
+		switch {
+		case s.ComposeFile != "" && !changeset.Exists(s.ComposeFile):
+			r.add(&drift.Finding{
+				Target: target, Kind: drift.FileRemoved, Path: s.ComposeFile,
+				Detail: "the compose file was removed", Repair: drift.Manual,
+			})
+		case s.ComposeFile != "":
+			instrumented, err := docker.NewComposeModifier(s.ComposeFile).ServiceInstrumented(s.ComposeService)
+			if err == nil && !instrumented {
+				f = &drift.Finding{
+					Target: target, Kind: drift.ComposeRemoved, Path: s.ComposeFile,
+					Detail: fmt.Sprintf("compose service %s lost its Middleware settings", s.ComposeService),
+				}
+			}
+		case container != nil && !container.HasJavaAgent:
+			f = &drift.Finding{
+				Target: target, Kind: drift.ContainerRecreated,
+				Detail: "the container was recreated without the agent",
+			}
+		}
+		if f == nil {
+			continue
+		}
+
+		if container == nil || r.apiKey == "" {
+			f.Repair = drift.Manual
+			f.Detail += ", run instrument-docker-config to instrument it again"
+			r.add(f)
+			continue
+		}
+		r.add(f)
+		if r.report.Repair {
+			r.resolve([]*drift.Finding{f}, r.reinstrumentContainer(dockerOps, container))
+		}
+	}
+	return nil
+}
+
+// reinstrumentContainer instruments a drifted container again with its
+// policy settings
+func (r *reconciler) reinstrumentContainer(dockerOps *docker.DockerOperations, container *discovery.DockerContainer) error {
+	decision := r.pol.EvaluateContainer(container)
+	if !decision.Include {
+		return fmt.Errorf("%s, run uninstrument-container to remove the agent", decision.Reason)
+	}
+
+	cfg := containerSettings(decision, r.apiKey, firstNonEmpty(r.target, DefaultTarget), r.logLevel)
+
+	tx := changeset.Begin(containerTarget(container))
+	defer changeset.End()
+	if err := dockerOps.InstrumentContainer(container.ContainerName, cfg); err != nil {
+		return err
+	}
+	tx.Verify()
+	return nil
+}
@@ -0,0 +1,404 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/restart"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// restartTimer is the systemd timer running deferred restarts
+const restartTimer = "mw-injector-restart"
+
+// restartEntry is a configured service waiting for its restart
+type restartEntry struct {
+	state.PendingRestart
+	injector supervisor.Injector
+	deferred bool
+}
+
+// restartQueue collects the services configured in a run and restarts them
+// once all are configured
+type restartQueue struct {
+	settings policy.Restarts
+	entries  []*restartEntry
+	byName   map[string]*restartEntry
+	txs      transactions
+}
+
+func newRestartQueue(pol *policy.Policy) *restartQueue {
+	return &restartQueue{
+		settings: pol.RestartSettings(),
+		byName:   make(map[string]*restartEntry),
+		txs:      transactions{},
+	}
+}
+
+// addUnit queues the restart of a systemd unit
+func (q *restartQueue) addUnit(proc *discovery.JavaProcess, unit string, decision policy.Decision, tx *changeset.Transaction) {
+	e := q.entry(unit, proc, decision, tx)
+	e.Unit = unit
+}
+
+// addSupervised queues the restart of a service run by another supervisor
+func (q *restartQueue) addSupervised(proc *discovery.JavaProcess, injector supervisor.Injector, info *discovery.SupervisorInfo, decision policy.Decision, tx *changeset.Transaction) {
+	e := q.entry(injector.String(), proc, decision, tx)
+	e.injector = injector
+	e.Supervisor = info
+}
+
+// entry returns the queued restart of a service, several processes can
+// share one unit
+func (q *restartQueue) entry(name string, proc *discovery.JavaProcess, decision policy.Decision, tx *changeset.Transaction) *restartEntry {
+	q.txs.add(name, tx)
+	e, ok := q.byName[name]
+	if !ok {
+		e = &restartEntry{PendingRestart: state.PendingRestart{Name: name, Group: restartGroup(name, decision)}}
+		q.byName[name] = e
+		q.entries = append(q.entries, e)
+	}
+	e.Targets = append(e.Targets, processTarget(proc))
+	if decision.Health.Port != 0 {
+		e.HealthPort = decision.Health.Port
+	}
+	if decision.Health.URL != "" {
+		e.HealthURL = decision.Health.URL
+	}
+	if decision.Restart == policy.RestartWindow {
+		e.deferred = true
+	}
+	return e
+}
+
+// restartGroup returns the group a service restarts in. Replicas of a
+// templated unit, like app@1.service and app@2.service, share one unless
+// the policy rule sets restart_group.
+func restartGroup(name string, decision policy.Decision) string {
+	if decision.Group != "" {
+		return decision.Group
+	}
+	base := strings.LastIndex(name, "/") + 1
+	if at := strings.Index(name[base:], "@"); at > 0 {
+		return name[:base+at+1]
+	}
+	return name
+}
+
+// run restarts the queued services and defers the ones waiting for the
+// maintenance window
+func (q *restartQueue) run() {
+	var now, deferred []*restartEntry
+	for _, e := range q.entries {
+		if e.deferred {
+			deferred = append(deferred, e)
+		} else {
+			now = append(now, e)
+		}
+	}
+
+	if len(deferred) > 0 {
+		q.deferRestarts(deferred)
+	}
+	if len(now) == 0 {
+		return
+	}
+
+	fmt.Printf("\n🔄 Restarting %d service(s)", len(now))
+	if q.settings.MaxParallel > 1 {
+		fmt.Printf(", up to %d groups at a time", q.settings.MaxParallel)
+	}
+	if q.settings.Delay > 0 {
+		fmt.Printf(", %s apart", q.settings.Delay)
+	}
+	fmt.Printf("...\n\n")
+	runRestarts(now, q.txs, q.settings)
+}
+
+// deferRestarts queues restarts for the maintenance window and schedules
+// the timer that runs them
+func (q *restartQueue) deferRestarts(entries []*restartEntry) {
+	window := q.settings.Window()
+	if userMode() {
+		fmt.Printf("\n⚠️  Maintenance windows require root, restart these services when ready:\n")
+		for _, e := range entries {
+			fmt.Printf("   %s\n", manualRestart(e))
+		}
+		return
+	}
+
+	if err := queuePendingRestarts(entries, window); err != nil {
+		fmt.Printf("\n❌ Failed to defer restarts: %v\n", err)
+		fmt.Println("   Restart these services when ready:")
+		for _, e := range entries {
+			fmt.Printf("   %s\n", manualRestart(e))
+		}
+		return
+	}
+
+	fmt.Printf("\n🕑 Deferred %d restart(s) to the maintenance window (%s)\n", len(entries), window)
+	for _, e := range entries {
+		fmt.Printf("   %s\n", e.Name)
+	}
+	fmt.Printf("   Next window: %s\n", window.Next(time.Now()).Format("Mon Jan 2 15:04"))
+	fmt.Println("   Restart them now with: mw-injector restart-pending --now")
+}
+
+// queuePendingRestarts saves deferred restarts and installs the timer
+func queuePendingRestarts(entries []*restartEntry, window *restart.Window) error {
+	pending, err := state.LoadPendingRestarts()
+	if err != nil {
+		return err
+	}
+
+	var journalPath string
+	if j := changeset.CurrentJournal(); j != nil {
+		journalPath = j.Path()
+	}
+	for _, e := range entries {
+		e.Journal = journalPath
+		e.QueuedAt = time.Now()
+
+		// A service queued again replaces its earlier entry
+		replaced := false
+		for i := range pending.Restarts {
+			if pending.Restarts[i].Name == e.Name {
+				pending.Restarts[i] = e.PendingRestart
+				replaced = true
+			}
+		}
+		if !replaced {
+			pending.Restarts = append(pending.Restarts, e.PendingRestart)
+		}
+	}
+	pending.Window = window.String()
+	if err := state.SavePendingRestarts(pending); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the mw-injector binary: %w", err)
+	}
+	return systemd.InstallTimer(restartTimer, "Middleware injector restarts deferred to the maintenance window",
+		window.OnCalendar(), []string{exe, "restart-pending"})
+}
+
+// runRestarts restarts services through the orchestrator and returns their
+// results. Services that fail a health gate are rolled back to their
+// previous configuration.
+func runRestarts(entries []*restartEntry, txs transactions, settings policy.Restarts) []restart.Result {
+	for _, e := range entries {
+		if e.Unit != "" {
+			systemd.ReloadSystemd()
+			break
+		}
+	}
+
+	orchestrator := &restart.Orchestrator{
+		MaxParallel: settings.MaxParallel,
+		Delay:       settings.Delay,
+		Timeout:     systemd.RestartTimeout,
+	}
+	tasks := make([]restart.Task, len(entries))
+	for i, e := range entries {
+		tasks[i] = restartTask(e, txs[e.Name], orchestrator)
+	}
+	results := orchestrator.Run(tasks)
+
+	rolledBack := 0
+	var failed []*restartEntry
+	for i, result := range results {
+		switch {
+		case result.Err == nil:
+			for _, tx := range txs[result.Name] {
+				tx.Verify()
+			}
+		case result.RolledBack:
+			rolledBack++
+		default:
+			failed = append(failed, entries[i])
+		}
+	}
+
+	if rolledBack == 0 && len(failed) == 0 {
+		fmt.Println("\n✅ All services restarted!")
+		return results
+	}
+	if rolledBack > 0 {
+		fmt.Printf("\n⏪ Rolled back %d service(s) that didn't come up with the agent\n", rolledBack)
+	}
+	if len(failed) > 0 {
+		fmt.Printf("\n❌ %d service(s) need attention:\n", len(failed))
+		for _, e := range failed {
+			fmt.Printf("   %s\n", manualRestart(e))
+		}
+	}
+	return results
+}
+
+// restartTask builds the orchestrator task of a service. Units must become
+// active, and pass the policy's port and URL checks when set.
+func restartTask(e *restartEntry, txs []*changeset.Transaction, orchestrator *restart.Orchestrator) restart.Task {
+	task := restart.Task{Name: e.Name, Group: e.Group}
+	if e.Unit != "" {
+		unit := e.Unit
+		task.Restart = func() error { return systemd.RestartService(unit) }
+		task.Gates = append(task.Gates, func(timeout time.Duration) error {
+			return systemd.WaitActive(unit, timeout)
+		})
+	} else {
+		task.Restart = e.injector.Restart
+	}
+	if e.HealthPort > 0 {
+		task.Gates = append(task.Gates, restart.PortGate(e.HealthPort))
+	}
+	if e.HealthURL != "" {
+		task.Gates = append(task.Gates, restart.HTTPGate(e.HealthURL))
+	}
+	if len(txs) == 0 {
+		return task
+	}
+
+	restartService, gates := task.Restart, task.Gates
+	task.Rollback = func(cause error, again bool) error {
+		rollback(e.Name, txs, cause, orchestrator.Printf)
+		if e.Unit != "" {
+			systemd.ReloadSystemd()
+		}
+		if !again {
+			return nil
+		}
+		if err := restartService(); err != nil {
+			return err
+		}
+		for _, gate := range gates {
+			if err := gate(systemd.RestartTimeout); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return task
+}
+
+// manualRestart describes how to restart a service by hand
+func manualRestart(e *restartEntry) string {
+	if e.Unit != "" {
+		return systemd.ManualRestartCommand(e.Unit)
+	}
+	return "restart " + e.Name
+}
+
+// RestartPendingCommand runs the restarts deferred to the maintenance window
+type RestartPendingCommand struct {
+	config *types.CommandConfig
+	now    bool
+}
+
+func NewRestartPendingCommand(config *types.CommandConfig, now bool) *RestartPendingCommand {
+	return &RestartPendingCommand{config: config, now: now}
+}
+
+func (c *RestartPendingCommand) Execute() error {
+	pending, err := state.LoadPendingRestarts()
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if len(pending.Restarts) == 0 {
+		fmt.Println("No restarts pending")
+		return nil
+	}
+
+	pol, err := loadPolicy("")
+	if err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	settings := pol.RestartSettings()
+	window := settings.Window()
+	if window == nil && pending.Window != "" {
+		window, _ = restart.ParseWindow(pending.Window)
+	}
+	if !c.now && window != nil && !window.Contains(time.Now()) {
+		fmt.Printf("🕑 %d restart(s) wait for the maintenance window (%s)\n", len(pending.Restarts), window)
+		fmt.Printf("   Next window: %s\n", window.Next(time.Now()).Format("Mon Jan 2 15:04"))
+		fmt.Println("   Restart them now with: mw-injector restart-pending --now")
+		return nil
+	}
+
+	// Failed restarts are rolled back with the journal of the run that
+	// configured the service
+	journals := make(map[string]*changeset.Journal)
+	txs := transactions{}
+	var entries []*restartEntry
+	for _, p := range pending.Restarts {
+		e := &restartEntry{PendingRestart: p}
+		if p.Unit == "" {
+			if p.Supervisor == nil {
+				continue
+			}
+			if e.injector, err = supervisor.NewInjector(p.Supervisor); err != nil {
+				fmt.Printf("❌ Skipping %s: %v\n", p.Name, err)
+				continue
+			}
+		}
+
+		if p.Journal != "" {
+			j, ok := journals[p.Journal]
+			if !ok {
+				if j, err = changeset.LoadJournal(p.Journal); err != nil {
+					fmt.Printf("⚠️  %s can't be rolled back: %v\n", p.Name, err)
+				}
+				journals[p.Journal] = j
+			}
+			if j != nil {
+				for _, target := range p.Targets {
+					txs.add(p.Name, j.Transaction(target))
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	fmt.Printf("🔄 Restarting %d service(s) deferred to the maintenance window...\n\n", len(entries))
+	remaining := stillPending(pending.Restarts, runRestarts(entries, txs, settings))
+	pending.Restarts = remaining
+	if err := state.SavePendingRestarts(pending); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+	if len(remaining) > 0 {
+		fmt.Printf("\n🕑 %d restart(s) stay pending for the next window\n", len(remaining))
+	}
+	return nil
+}
+
+// stillPending returns the restarts to keep for the next window: the ones
+// that never ran and the ones that failed without being rolled back. A
+// rolled back service runs its previous configuration, nothing is left to
+// restart.
+func stillPending(restarts []state.PendingRestart, results []restart.Result) []state.PendingRestart {
+	done := make(map[string]bool)
+	for _, result := range results {
+		done[result.Name] = result.Err == nil || result.RolledBack
+	}
+
+	var remaining []state.PendingRestart
+	for _, p := range restarts {
+		if !done[p.Name] {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+func (c *RestartPendingCommand) GetDescription() string {
+	return "Restart services deferred to the maintenance window"
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// flakyService listens on its health port from its second restart on, when
+// it runs its previous configuration
+type flakyService struct {
+	port     int
+	restarts int
+	listener net.Listener
+}
+
+func (s *flakyService) Inject([]supervisor.EnvVar) ([]state.Artifact, error) { return nil, nil }
+func (s *flakyService) String() string                                       { return "runit:billing" }
+
+func (s *flakyService) Restart() error {
+	s.restarts++
+	if s.restarts < 2 {
+		return nil
+	}
+	var err error
+	s.listener, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.port)))
+	return err
+}
+
+func TestRestartPendingDropsRolledBack(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := changeset.OpenJournal(filepath.Join(dir, "journal"), "auto-instrument"); err != nil {
+		t.Fatal(err)
+	}
+	defer changeset.CloseJournal()
+
+	configPath := filepath.Join(dir, "billing.conf")
+	tx := changeset.Begin("pid:1234")
+	if err := changeset.WriteFile(configPath, []byte("MW_SERVICE_NAME=billing\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changeset.End()
+
+	// A free port nothing listens on yet
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	service := &flakyService{port: port}
+	defer func() {
+		if service.listener != nil {
+			service.listener.Close()
+		}
+	}()
+	defer useRestartTimeout(systemd.RestartTimeout)
+	useRestartTimeout(time.Nanosecond)
+
+	e := &restartEntry{PendingRestart: state.PendingRestart{Name: service.String(), HealthPort: port}, injector: service}
+	txs := transactions{}
+	txs.add(e.Name, tx)
+	results := runRestarts([]*restartEntry{e}, txs, policy.Restarts{})
+	if len(results) != 1 || !results[0].RolledBack {
+		t.Fatalf("runRestarts() = %+v, want the failed health gate rolled back", results)
+	}
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Errorf("config %s not rolled back", configPath)
+	}
+
+	pending := []state.PendingRestart{e.PendingRestart, {Name: "runit:orders"}}
+	remaining := stillPending(pending, results)
+	if len(remaining) != 1 || remaining[0].Name != "runit:orders" {
+		t.Errorf("stillPending() = %+v, want only the restart that never ran", remaining)
+	}
+}
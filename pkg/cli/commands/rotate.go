@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/shim"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// RotateKeyCommand replaces the API key of every instrumented unit and
+// container in place
+type RotateKeyCommand struct {
+	config     *types.CommandConfig
+	configPath string
+}
+
+func NewRotateKeyCommand(config *types.CommandConfig, configPath string) *RotateKeyCommand {
+	return &RotateKeyCommand{config: config, configPath: configPath}
+}
+
+func (c *RotateKeyCommand) Execute() error {
+	ctx := context.Background()
+
+	if userMode() {
+		fmt.Println("👤 Running without root: only your systemd --user units will be updated")
+	}
+
+	oldKey, err := secrets.LoadAPIKey(secrets.DefaultEnvFile())
+	if err != nil {
+		return fmt.Errorf("❌ No stored API key found, instrument a service first: %v", err)
+	}
+
+	newKey, err := c.readNewKey()
+	if err != nil {
+		return err
+	}
+	if newKey == oldKey {
+		fmt.Println("✅ API key is unchanged, nothing to rotate")
+		return nil
+	}
+
+	fmt.Printf("🔑 Rotating API key %s -> %s\n", secrets.Mask(oldKey), secrets.Mask(newKey))
+	if err := storeAPIKey(newKey); err != nil {
+		return fmt.Errorf("❌ %v", err)
+	}
+
+	// Files that can't load the key file carry it inline
+	updated := 0
+	for _, path := range c.inlineKeyFiles() {
+		changed, err := secrets.ReplaceInFile(path, oldKey, newKey)
+		if err != nil {
+			fmt.Printf("❌ Failed to update %s: %v\n", path, err)
+			continue
+		}
+		if changed {
+			fmt.Printf("   Updated %s\n", path)
+			updated++
+		}
+	}
+
+	processes, err := discovery.FindAllJavaProcesses(ctx)
+	if err != nil {
+		return fmt.Errorf("❌ Error discovering processes: %v", err)
+	}
+
+	var servicesToRestart []string
+	var supervisedToRestart []supervisor.Injector
+	for _, proc := range processes {
+		if !c.fileExists(c.getConfigPath(&proc)) {
+			continue
+		}
+
+		if supervisorInfo := proc.DetectSupervisor(); isSupervised(supervisorInfo) {
+			if injector, err := supervisor.NewInjector(supervisorInfo); err == nil {
+				supervisedToRestart = append(supervisedToRestart, injector)
+			}
+			continue
+		}
+
+		unit := systemd.GetServiceName(&proc)
+		if proc.IsTomcat() {
+			unit = systemd.GetTomcatServiceName()
+		}
+		if !systemd.ServiceExists(unit) {
+			fmt.Printf("⚠️  PID %d (%s) has no unit, restart it yourself\n", proc.ProcessPID, proc.ServiceName)
+			continue
+		}
+
+		// Root hands user managers their own copy of the key file
+		if systemd.IsUserUnit(unit) {
+			if _, err := systemd.SecretsEnvFile(unit); err != nil {
+				fmt.Printf("❌ Failed to update the key of %s: %v\n", unit, err)
+				continue
+			}
+		}
+		servicesToRestart = append(servicesToRestart, unit)
+	}
+
+	if len(servicesToRestart) > 0 {
+		fmt.Printf("\n🔄 Restarting %d service(s)...\n\n", len(servicesToRestart))
+		for _, service := range servicesToRestart {
+			fmt.Printf("   Restarting %s...", service)
+			if err := systemd.RestartService(service); err != nil {
+				fmt.Printf(" ❌ Failed\n")
+				fmt.Printf("       Error: %v\n", err)
+				fmt.Printf("       Try manually: %s\n", systemd.ManualRestartCommand(service))
+			} else {
+				fmt.Printf(" ✅ Done\n")
+			}
+		}
+	}
+	restartSupervised(supervisedToRestart)
+
+	containers := 0
+	if !userMode() {
+		fmt.Println("\n🐳 Updating instrumented containers...")
+		dockerOps := docker.NewDockerOperations(ctx, c.config.DefaultAgentPath)
+		containers, err = dockerOps.RotateAPIKey(oldKey, newKey)
+		if err != nil {
+			fmt.Printf("❌ Failed to update containers: %v\n", err)
+		}
+	}
+
+	fmt.Printf("\n🎉 API key rotated!\n")
+	fmt.Printf("   Files updated: %d\n", updated)
+	fmt.Printf("   Services restarted: %d\n", len(servicesToRestart)+len(supervisedToRestart))
+	fmt.Printf("   Containers recreated: %d\n", containers)
+	return nil
+}
+
+func (c *RotateKeyCommand) GetDescription() string {
+	return "Replace the API key of all instrumented services and containers"
+}
+
+// readNewKey reads the new key from --api-key-file or the config file, or
+// prompts for it
+func (c *RotateKeyCommand) readNewKey() (string, error) {
+	configVars := systemd.ConfigVars{}
+	if c.configPath != "" {
+		vars, err := systemd.ReadConfigFile(c.configPath)
+		if err != nil {
+			return "", fmt.Errorf("❌ Failed to load config from %s: %v", c.configPath, err)
+		}
+		configVars = vars
+	}
+	config := *c.config
+	config.ConfigFile = c.configPath
+	newKey, err := readAPIKey(&config, configVars, "New Middleware.io API Key: ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := secrets.ValidateAPIKey(newKey); err != nil {
+		return "", fmt.Errorf("❌ %v", err)
+	}
+	return newKey, nil
+}
+
+// inlineKeyFiles lists the files that may hold the key itself: changes
+// recorded in the host state, WildFly launch configs and key files, and the
+// shim's key file
+func (c *RotateKeyCommand) inlineKeyFiles() []string {
+	if userMode() {
+		return nil
+	}
+
+	var files []string
+	if hostState, err := state.LoadHostState(); err == nil {
+		for _, artifact := range hostState.Artifacts {
+			if artifact.Container != "" {
+				continue // containers read the key from their env files
+			}
+			files = append(files, artifact.Path)
+		}
+	}
+
+	configs, _ := filepath.Glob(filepath.Join(configRoot(), "wildfly", "*.conf"))
+	for _, configPath := range configs {
+		configVars, err := systemd.ReadConfigFile(configPath)
+		if err != nil {
+			continue
+		}
+		for _, name := range []string{"MW_INSTRUMENTED_FILE", "MW_API_KEY_FILE"} {
+			if configVars[name] != "" {
+				files = append(files, configVars[name])
+			}
+		}
+	}
+
+	return append(files, shim.KeyFile)
+}
+
+func (c *RotateKeyCommand) getConfigPath(proc *discovery.JavaProcess) string {
+	serviceName := naming.GenerateServiceName(proc)
+
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsTomcat() {
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
+	}
+
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), proc.DetectSupervisor().Kind, serviceName)
+}
+
+func (c *RotateKeyCommand) fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
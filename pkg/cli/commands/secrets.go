@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// storeAPIKey keeps the API key in the root-only env file that drop-ins,
+// containers and init scripts load it from
+func storeAPIKey(apiKey string) error {
+	envFile := secrets.DefaultEnvFile()
+	if err := secrets.StoreAPIKey(envFile, apiKey, -1, -1); err != nil {
+		return fmt.Errorf("failed to store API key: %w", err)
+	}
+	fmt.Printf("🔐 API key %s stored in %s (0600)\n", secrets.Mask(apiKey), envFile)
+	return nil
+}
+
+// storeOwnedAPIKey writes the API key to a 0600 env file owned by the user
+// a service runs as, for launch scripts that source it themselves
+func storeOwnedAPIKey(path, apiKey, owner string) error {
+	uid, gid := -1, -1
+	if !userMode() {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return fmt.Errorf("failed to look up user %s: %w", owner, err)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("invalid user %s: %w", owner, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("invalid group of user %s: %w", owner, err)
+		}
+	}
+	if err := secrets.StoreAPIKey(path, apiKey, uid, gid); err != nil {
+		return fmt.Errorf("failed to store API key: %w", err)
+	}
+	return nil
+}
+
+// attachSecrets loads the API key file into a unit configured outside the
+// Middleware drop-in and records the drop-in so uninstrument removes it
+func attachSecrets(unit, configPath, serviceName string) error {
+	path, err := systemd.WriteSecretsDropIn(unit)
+	if err != nil {
+		return fmt.Errorf("failed to load API key into %s: %w", unit, err)
+	}
+
+	if userMode() {
+		return nil
+	}
+	if err := state.RecordArtifact(state.Artifact{
+		Kind:        state.ArtifactDropIn,
+		Path:        path,
+		Unit:        unit,
+		ConfigPath:  configPath,
+		ServiceName: serviceName,
+	}); err != nil {
+		return fmt.Errorf("failed to record %s: %w", path, err)
+	}
+	return nil
+}
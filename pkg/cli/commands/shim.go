@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/shim"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// shimTarget is the journal target of the shim's changes
+const shimTarget = "shim"
+
+// ShimCommand manages the opt-in Java launcher shim
+type ShimCommand struct {
+	config     *types.CommandConfig
+	action     string
+	configPath string
+}
+
+func NewShimCommand(config *types.CommandConfig, action, configPath string) *ShimCommand {
+	if configPath == "" {
+		configPath = findDefaultConfigFile()
+	}
+
+	return &ShimCommand{
+		config:     config,
+		action:     action,
+		configPath: configPath,
+	}
+}
+
+func (c *ShimCommand) Execute() error {
+	if os.Geteuid() != 0 && c.action != "status" {
+		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector shim %s", c.action)
+	}
+
+	// The shim's files and registration are one transaction, undo reverts
+	// them together
+	if c.action != "status" {
+		changeset.Begin(shimTarget)
+		defer changeset.End()
+	}
+
+	switch c.action {
+	case "install":
+		return c.install()
+	case "remove":
+		if !shim.IsInstalled() {
+			fmt.Println("Java launcher shim is not installed")
+			return nil
+		}
+		if err := shim.Remove(); err != nil {
+			return fmt.Errorf("❌ Failed to remove shim: %v", err)
+		}
+		fmt.Println("🗑️  Java launcher shim removed")
+		return nil
+	case "enable", "disable":
+		if err := shim.SetEnabled(c.action == "enable"); err != nil {
+			return fmt.Errorf("❌ Failed to %s shim: %v", c.action, err)
+		}
+		fmt.Printf("✅ Java launcher shim %sd\n", c.action)
+		return nil
+	case "status":
+		return c.status()
+	default:
+		return fmt.Errorf("❌ Unknown shim action: %s\n   Usage: mw-injector shim <install|remove|enable|disable|status> [config-file]", c.action)
+	}
+}
+
+func (c *ShimCommand) GetDescription() string {
+	return "Manage the Java launcher shim for JVMs without a supervisor"
+}
+
+// install reads the shim policy from the config file and installs the shim
+func (c *ShimCommand) install() error {
+	if c.configPath == "" {
+		return fmt.Errorf("❌ No config file found. Please create /etc/mw-injector.conf or provide path:\n   Usage: mw-injector shim install <config-file>")
+	}
+
+	configVars, err := systemd.ReadConfigFile(c.configPath)
+	if err != nil {
+		return fmt.Errorf("❌ Failed to load config from %s: %v", c.configPath, err)
+	}
+
+	apiKey := configVars["MW_API_KEY"]
+	if apiKey == "" {
+		return fmt.Errorf("❌ MW_API_KEY is required in config file")
+	}
+
+	// The shim is opt-in per command, an empty policy would do nothing
+	include, err := shim.ParseRules(configVars["MW_SHIM_INCLUDE"])
+	if err != nil {
+		return fmt.Errorf("❌ Invalid MW_SHIM_INCLUDE: %v", err)
+	}
+	if len(include) == 0 {
+		return fmt.Errorf("❌ MW_SHIM_INCLUDE is required, e.g. MW_SHIM_INCLUDE=jar:billing-*.jar,main:com.example.*")
+	}
+	exclude, err := shim.ParseRules(configVars["MW_SHIM_EXCLUDE"])
+	if err != nil {
+		return fmt.Errorf("❌ Invalid MW_SHIM_EXCLUDE: %v", err)
+	}
+
+	target := configVars["MW_TARGET"]
+	if target == "" {
+		target = "https://prod.middleware.io:443"
+	}
+
+	agentPath := configVars["MW_JAVA_AGENT_PATH"]
+	if agentPath == "" {
+		agentPath = c.config.DefaultAgentPath
+	}
+	installedPath, err := installAgent(c.config, configVars, agentPath)
+	if err != nil {
+		return fmt.Errorf("failed to prepare agent: %w", err)
+	}
+
+	mode := configVars["MW_SHIM_MODE"]
+	if mode == "" {
+		mode = shim.ModePath
+		if shim.HasAlternatives() {
+			mode = shim.ModeAlternatives
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("❌ Cannot locate mw-injector binary: %v", err)
+	}
+
+	fmt.Printf("🔧 Installing Java launcher shim (%s mode)\n", mode)
+	cfg := &shim.Config{
+		Enabled:   true,
+		Mode:      mode,
+		RealJava:  configVars["MW_SHIM_REAL_JAVA"],
+		AgentPath: installedPath,
+		APIKey:    apiKey,
+		KeyGroup:  configVars["MW_SHIM_KEY_GROUP"],
+		Target:    target,
+		Include:   include,
+		Exclude:   exclude,
+	}
+	if err := shim.Install(cfg, self); err != nil {
+		return fmt.Errorf("❌ Failed to install shim: %v", err)
+	}
+
+	fmt.Printf("\n✅ Java launcher shim installed\n")
+	fmt.Printf("   Real java: %s\n", cfg.RealJava)
+	fmt.Printf("   Include:   %s\n", shim.FormatRules(include))
+	if len(exclude) > 0 {
+		fmt.Printf("   Exclude:   %s\n", shim.FormatRules(exclude))
+	}
+	if cfg.KeyGroup == "" {
+		fmt.Printf("   ⚠️  MW_SHIM_KEY_GROUP is not set, only JVMs run by root get the API key\n")
+	}
+	fmt.Printf("   New JVMs matching the policy are instrumented, running ones need a restart\n")
+	return nil
+}
+
+// status prints the shim configuration
+func (c *ShimCommand) status() error {
+	if !shim.IsInstalled() {
+		fmt.Println("Java launcher shim is not installed")
+		return nil
+	}
+
+	cfg, err := shim.LoadConfig(shim.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("❌ Failed to read shim config: %v", err)
+	}
+
+	state := "✅ Enabled"
+	if !cfg.Enabled {
+		state = "⏸️  Disabled"
+	}
+	fmt.Printf("Java launcher shim: %s\n", state)
+	fmt.Printf("  Mode: %s\n", cfg.Mode)
+	fmt.Printf("  Real java: %s\n", cfg.RealJava)
+	fmt.Printf("  Agent: %s\n", cfg.AgentPath)
+	if cfg.KeyGroup != "" {
+		fmt.Printf("  Key group: %s\n", cfg.KeyGroup)
+	}
+	fmt.Printf("  Include: %s\n", shim.FormatRules(cfg.Include))
+	fmt.Printf("  Exclude: %s\n", shim.FormatRules(cfg.Exclude))
+	return nil
+}
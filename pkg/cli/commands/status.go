@@ -0,0 +1,236 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/cli/types"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/inventory"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/status"
+)
+
+// statusIcons are printed before each state
+var statusIcons = map[string]string{
+	status.Instrumented:    "✅",
+	status.PendingRestart:  "🔄",
+	status.Drifted:         "⚠️ ",
+	status.Orphaned:        "👻",
+	status.Failed:          "❌",
+	status.NotInstrumented: "➖",
+}
+
+// StatusCommand reports whether the agent is actually loaded, comparing
+// the configuration written for each service with what it runs
+type StatusCommand struct {
+	config *types.CommandConfig
+
+	pending   map[string]bool   // targets with a restart deferred to the maintenance window
+	rollbacks map[string]string // targets whose latest change was rolled back
+}
+
+func NewStatusCommand(config *types.CommandConfig) *StatusCommand {
+	return &StatusCommand{config: config}
+}
+
+func (c *StatusCommand) Execute() error {
+	ctx := context.Background()
+
+	processes, err := discovery.FindAllJavaProcesses(ctx)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	c.loadHistory()
+
+	machine := machineOutput(c.config)
+	counts := make(map[string]int)
+	var services []inventory.Service
+	report := func(s inventory.Service, detail string, o status.Observation) {
+		state, reasons := status.Classify(o)
+		counts[state]++
+		s.State, s.Reasons, s.Unit = state, reasons, o.Unit
+		services = append(services, s)
+		if machine {
+			return
+		}
+		fmt.Printf("%s %-17s %s (%s)\n", statusIcons[state], state, s.Name, detail)
+		if state != status.NotInstrumented && s.ConfigPath != "" {
+			fmt.Printf("   └── Config: %s\n", s.ConfigPath)
+		}
+		for _, reason := range reasons {
+			fmt.Printf("   └── %s\n", reason)
+		}
+	}
+
+	if !machine {
+		fmt.Printf("🔍 Instrumentation status\n\n")
+	}
+
+	for _, proc := range filterProcesses(c.config.Filter, processes) {
+		target := processTarget(&proc)
+		configPath := c.getConfigPath(&proc)
+		o := status.ObserveProcess(&proc, configPath)
+		o.Queued = c.pending[target]
+		o.RolledBack = c.rollbacks[target]
+
+		detail := fmt.Sprintf("PID %d", proc.ProcessPID)
+		if o.Unit != "" {
+			detail += ", " + o.Unit
+		}
+		s := inventory.Service{Name: naming.GenerateServiceName(&proc), Kind: "process", PID: proc.ProcessPID, ConfigPath: configPath}
+		if proc.HasJavaAgent {
+			s.AgentVersion = proc.GetAgentInfo().Version
+			detail += ", agent " + s.AgentVersion
+		}
+		report(s, detail, o)
+	}
+
+	// Configs of services that aren't running
+	orphaned, err := state.FindOrphanedConfigs(configRoot(), processes)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	for _, oc := range orphaned {
+		o := status.ObserveConfig(oc.ConfigPath)
+		if !c.config.Filter.Empty() && !matchesOrphan(c.config.Filter, oc.ServiceName, o.Unit) {
+			continue
+		}
+		detail := "not running"
+		if o.Unit != "" {
+			detail += ", " + o.Unit
+		}
+		report(inventory.Service{Name: oc.ServiceName, Kind: "config", ConfigPath: oc.ConfigPath}, detail, o)
+	}
+
+	// Process filters leave containers out
+	if !userMode() && c.config.Filter.Empty() && discovery.NewDockerDiscoverer(ctx).IsDockerAvailable() {
+		if err := c.reportContainers(ctx, report); err != nil {
+			return fmt.Errorf("error: %v", err)
+		}
+	}
+
+	if machine {
+		inv := inventory.New()
+		inv.SetServices(services)
+		return writeInventory(c.config, inv)
+	}
+
+	if len(counts) == 0 {
+		fmt.Println("No Java processes or instrumented services found")
+		return nil
+	}
+
+	var summary []string
+	for _, s := range status.States {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], strings.ReplaceAll(s, "-", " ")))
+		}
+	}
+	fmt.Printf("\n📊 %s\n", strings.Join(summary, ", "))
+	return nil
+}
+
+// reportContainers reports instrumented containers and running Java
+// containers
+func (c *StatusCommand) reportContainers(ctx context.Context, report func(s inventory.Service, detail string, o status.Observation)) error {
+	containers, err := discovery.NewDockerDiscoverer(ctx).DiscoverJavaContainers()
+	if err != nil {
+		return err
+	}
+	saved, err := docker.NewDockerOperations(ctx, c.config.DefaultAgentPath).ListInstrumentedContainers()
+	if err != nil {
+		return err
+	}
+
+	running := make(map[string]*discovery.DockerContainer)
+	for i := range containers {
+		running[containers[i].ContainerName] = &containers[i]
+	}
+
+	for i := range saved {
+		container := running[saved[i].ContainerName]
+		delete(running, saved[i].ContainerName)
+		s := inventory.Service{Name: saved[i].ContainerName, Kind: "container", ConfigPath: saved[i].ComposeFile}
+		report(s, "container", status.ObserveContainer(&saved[i], container))
+	}
+
+	// Recreating a container changes its ID, rollbacks are found by name
+	for _, container := range containers {
+		if running[container.ContainerName] == nil {
+			continue
+		}
+		o := status.Observation{Running: true}
+		if container.HasJavaAgent {
+			o.LoadedAgent = container.JavaAgentPath
+		}
+		prefix := fmt.Sprintf("container %s (", container.ContainerName)
+		for target, reason := range c.rollbacks {
+			if strings.HasPrefix(target, prefix) {
+				o.RolledBack = reason
+			}
+		}
+		report(inventory.Service{Name: container.ContainerName, Kind: "container"}, "container", o)
+	}
+	return nil
+}
+
+// loadHistory finds deferred restarts and the services rolled back by the
+// latest run that changed them
+func (c *StatusCommand) loadHistory() {
+	c.pending = make(map[string]bool)
+	c.rollbacks = make(map[string]string)
+
+	if pending, err := state.LoadPendingRestarts(); err == nil {
+		for _, p := range pending.Restarts {
+			for _, target := range p.Targets {
+				c.pending[target] = true
+			}
+		}
+	}
+
+	journals, _ := changeset.LoadJournals(journalDir())
+	seen := make(map[string]bool)
+	for _, j := range journals {
+		for _, tx := range j.Transactions {
+			if seen[tx.Target] {
+				continue
+			}
+			tx = j.LastTransaction(tx.Target)
+			seen[tx.Target] = true
+			if tx.Status == changeset.TxRolledBack || tx.Status == changeset.TxRollbackFailed {
+				reason := tx.Error
+				if reason == "" {
+					reason = "the service didn't come up with the agent"
+				}
+				c.rollbacks[tx.Target] = reason
+			}
+		}
+	}
+}
+
+func (c *StatusCommand) GetDescription() string {
+	return "Report whether each service actually runs with the agent"
+}
+
+func (c *StatusCommand) getConfigPath(proc *discovery.JavaProcess) string {
+	serviceName := naming.GenerateServiceName(proc)
+
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsTomcat() {
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
+	}
+
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), proc.DetectSupervisor().Kind, serviceName)
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// supervisedKinds are the supervisors handled by the supervisor package
+var supervisedKinds = []string{
+	discovery.SupervisorSupervisord,
+	discovery.SupervisorRunit,
+	discovery.SupervisorS6,
+	discovery.SupervisorOpenRC,
+	discovery.SupervisorSysV,
+}
+
+// isSupervised checks if a process is run by a supervisor other than systemd
+func isSupervised(info *discovery.SupervisorInfo) bool {
+	for _, kind := range supervisedKinds {
+		if info.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// instrumentSupervised configures a process run by supervisord, runit, s6,
+// OpenRC or an init script and returns the injector used to restart it
+func instrumentSupervised(proc *discovery.JavaProcess, info *discovery.SupervisorInfo, configPath, serviceName, apiKey, target, agentPath string, update bool) (supervisor.Injector, error) {
+	injector, err := supervisor.NewInjector(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the original files so values aren't appended twice
+	if update {
+		if _, err := state.RevertArtifacts(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	env := supervisor.AgentEnvironment(serviceName, agentPath, apiKey, target)
+
+	artifacts, injectErr := injector.Inject(env)
+	for _, artifact := range artifacts {
+		artifact.ConfigPath = configPath
+		artifact.ServiceName = serviceName
+		if err := state.RecordArtifact(artifact); err != nil {
+			return nil, fmt.Errorf("failed to record %s: %w", artifact.Path, err)
+		}
+	}
+	if injectErr != nil {
+		return nil, injectErr
+	}
+
+	if err := systemd.CreateStandardConfig(configPath, &systemd.StandardConfig{
+		ServiceName: serviceName,
+		Target:      target,
+		AgentPath:   agentPath,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return injector, nil
+}
+
+// restartSupervised restarts processes run by non-systemd supervisors
+func restartSupervised(injectors []supervisor.Injector) {
+	if len(injectors) == 0 {
+		return
+	}
+
+	fmt.Printf("\n🔄 Restarting %d supervised service(s)...\n\n", len(injectors))
+	for _, injector := range injectors {
+		fmt.Printf("   Restarting %s...", injector)
+		if err := injector.Restart(); err != nil {
+			fmt.Printf(" ❌ Failed\n")
+			fmt.Printf("       Error: %v\n", err)
+		} else {
+			fmt.Printf(" ✅ Done\n")
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package commands
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 	"github.com/middleware-labs/java-injector/pkg/cli/types"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
 	"github.com/middleware-labs/java-injector/pkg/docker"
 	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/shim"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
 	"github.com/middleware-labs/java-injector/pkg/systemd"
 )
 
@@ -26,19 +28,22 @@ func NewUninstrumentCommand(config *types.CommandConfig) *UninstrumentCommand {
 func (c *UninstrumentCommand) Execute() error {
 	ctx := context.Background()
 
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector uninstrument")
+	// Without root only configs under ~/.config/middleware are found, i.e.
+	// the caller's own systemd --user units
+	if userMode() {
+		fmt.Println("👤 Running without root: only your systemd --user units will be uninstrumented")
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-
 	// Discover processes
 	processes, err := discovery.FindAllJavaProcesses(ctx)
 	if err != nil {
 		return fmt.Errorf("❌ Error discovering processes: %v", err)
 	}
 
+	// Orphans are found among all processes, before filtering
+	orphanedConfigs := c.findOrphanedConfigs(processes)
+	processes = filterProcesses(c.config.Filter, processes)
+
 	if len(processes) == 0 {
 		fmt.Println("No Running Java processes found")
 	}
@@ -46,10 +51,24 @@ func (c *UninstrumentCommand) Execute() error {
 	fmt.Printf("\n🔍 Found %d Java processes\n\n", len(processes))
 
 	// Check for orphaned configs (services that are stopped/crashed)
-	orphanedConfigs := c.findOrphanedConfigs(processes)
+	if !c.config.Filter.Empty() {
+		var matched []OrphanedConfig
+		for _, orphan := range orphanedConfigs {
+			if matchesOrphan(c.config.Filter, orphan.ServiceName, orphan.SystemdUnit) {
+				matched = append(matched, orphan)
+			}
+		}
+		orphanedConfigs = matched
+	}
+
+	// The shim is host-wide, only root can remove it and filters leave it be
+	shimInstalled := shim.IsInstalled() && !userMode() && c.config.Filter.Empty()
 
-	if len(processes) == 0 && len(orphanedConfigs) == 0 {
+	if len(processes) == 0 && len(orphanedConfigs) == 0 && !shimInstalled {
 		fmt.Println("\nNo instrumented services found")
+		if !userMode() && c.config.Filter.Empty() {
+			removeLSMRules(c.config)
+		}
 		return nil
 	}
 
@@ -60,6 +79,7 @@ func (c *UninstrumentCommand) Execute() error {
 	removed := 0
 	skipped := 0
 	servicesToRestart := []string{}
+	var supervisedToRestart []supervisor.Injector
 
 	// Process orphaned configs first
 	for _, orphan := range orphanedConfigs {
@@ -67,23 +87,31 @@ func (c *UninstrumentCommand) Execute() error {
 		fmt.Printf("   Service: %s (%s)\n", orphan.ServiceName, orphan.ConfigPath)
 		if orphan.IsTomcat {
 			fmt.Printf("   Type: Tomcat (service may be crashed)\n")
+		} else if orphan.IsWildFly {
+			fmt.Printf("   Type: WildFly (server may be stopped)\n")
+		} else if orphan.Supervised {
+			fmt.Printf("   Type: Supervised service (service may be stopped)\n")
 		} else {
 			fmt.Printf("   Type: Systemd service (service may be stopped)\n")
 		}
-		fmt.Print("   Remove instrumentation? [y/N]: ")
-
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response == "y" || response == "yes" {
+		if confirm(c.config, "   Remove instrumentation?") {
+			tx := changeset.Begin("process " + orphan.ServiceName)
 			c.removeOrphanedConfig(orphan)
 			removed++
 
-			// Add to restart list
+			// Add to restart list; stopped WildFly and supervised services
+			// pick up the change on next start
+			var unit string
 			if orphan.IsTomcat {
-				servicesToRestart = append(servicesToRestart, "tomcat.service")
-			} else {
-				servicesToRestart = append(servicesToRestart, orphan.ServiceName+".service")
+				unit = "tomcat.service"
+			} else if orphan.SystemdUnit != "" {
+				unit = orphan.SystemdUnit
+			} else if !orphan.IsWildFly && !orphan.Supervised {
+				unit = orphan.ServiceName + ".service"
+			}
+			if unit != "" {
+				tx.SetUnit(unit)
+				servicesToRestart = append(servicesToRestart, unit)
 			}
 		} else {
 			skipped++
@@ -107,19 +135,30 @@ func (c *UninstrumentCommand) Execute() error {
 		}
 
 		fmt.Printf("⚠️  PID %d (%s) is instrumented\n", proc.ProcessPID, proc.ServiceName)
-		fmt.Print("   Remove instrumentation? [y/N]: ")
-
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response != "y" && response != "yes" {
+		if !confirm(c.config, "   Remove instrumentation?") {
 			fmt.Printf("⭐️  Skipping PID %d (%s)\n\n", proc.ProcessPID, proc.ServiceName)
 			skipped++
 			continue
 		}
 
+		tx := changeset.Begin(processTarget(&proc))
+
+		// Restore the WildFly launch config before its record is removed
+		if proc.IsWildFly() {
+			if err := uninstrumentWildFly(configPath); err != nil {
+				fmt.Printf("❌ Failed to restore WildFly config for PID %d: %v\n", proc.ProcessPID, err)
+				continue
+			}
+		}
+
+		// Revert changes recorded in the host state (start.d files, env files)
+		if err := revertArtifacts(configPath); err != nil {
+			fmt.Printf("❌ Failed to revert changes for PID %d: %v\n", proc.ProcessPID, err)
+			continue
+		}
+
 		// Remove config file
-		if err := os.Remove(configPath); err != nil {
+		if err := changeset.Remove(configPath); err != nil {
 			fmt.Printf("❌ Failed to remove config for PID %d: %v\n", proc.ProcessPID, err)
 			continue
 		}
@@ -145,7 +184,41 @@ func (c *UninstrumentCommand) Execute() error {
 			fmt.Printf("🗑️  Removed instrumentation from: %s\n", serviceName)
 		}
 
-		servicesToRestart = append(servicesToRestart, systemdServiceName)
+		// Supervised processes are restarted through their supervisor
+		if supervisorInfo := proc.DetectSupervisor(); isSupervised(supervisorInfo) {
+			if injector, err := supervisor.NewInjector(supervisorInfo); err == nil {
+				supervisedToRestart = append(supervisedToRestart, injector)
+			}
+		} else {
+			tx.SetUnit(systemdServiceName)
+			servicesToRestart = append(servicesToRestart, systemdServiceName)
+		}
+		removed++
+		fmt.Println()
+	}
+	changeset.End()
+
+	// The launcher shim instruments JVMs as they start, remove it last
+	if shimInstalled {
+		fmt.Printf("⚠️  Java launcher shim is installed (%s)\n", shim.ShimBinary)
+		if confirm(c.config, "   Remove it?") {
+			changeset.Begin(shimTarget)
+			err := shim.Remove()
+			changeset.End()
+			if err != nil {
+				fmt.Printf("❌ Failed to remove shim: %v\n", err)
+			} else {
+				fmt.Printf("🗑️  Removed Java launcher shim\n")
+				removed++
+			}
+		} else {
+			skipped++
+		}
+		fmt.Println()
+	}
+
+	// The SELinux and AppArmor rules are shared, they go with the last service
+	if !userMode() && c.config.Filter.Empty() && removeLSMRules(c.config) {
 		removed++
 		fmt.Println()
 	}
@@ -168,7 +241,7 @@ func (c *UninstrumentCommand) Execute() error {
 			if err != nil {
 				fmt.Printf(" ❌ Failed\n")
 				fmt.Printf("       Error: %v\n", err)
-				fmt.Printf("       Try manually: sudo systemctl restart %s\n", service)
+				fmt.Printf("       Try manually: %s\n", systemd.ManualRestartCommand(service))
 			} else {
 				fmt.Printf(" ✅ Done\n")
 			}
@@ -176,6 +249,8 @@ func (c *UninstrumentCommand) Execute() error {
 		fmt.Println("\n✅ All services restarted!")
 	}
 
+	restartSupervised(supervisedToRestart)
+
 	return nil
 }
 
@@ -200,13 +275,11 @@ func (c *UninstrumentDockerCommand) Execute() error {
 		return fmt.Errorf("❌ This command requires root privileges\n   Run with: sudo mw-injector uninstrument-docker")
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Print("Uninstrument ALL Docker containers? [y/N]: ")
-	response, _ := reader.ReadString('\n')
-	response = strings.TrimSpace(strings.ToLower(response))
-
-	if response != "y" && response != "yes" {
+	question := "Uninstrument ALL Docker containers?"
+	if !c.config.Filter.Empty() {
+		question = "Uninstrument the Docker containers matching the filters?"
+	}
+	if !confirm(c.config, question) {
 		fmt.Println("Cancelled")
 		return nil
 	}
@@ -214,10 +287,16 @@ func (c *UninstrumentDockerCommand) Execute() error {
 	dockerOps := docker.NewDockerOperations(ctx, c.config.DefaultAgentPath)
 
 	// List instrumented containers
-	instrumented, err := dockerOps.ListInstrumentedContainers()
+	saved, err := dockerOps.ListInstrumentedContainers()
 	if err != nil {
 		return fmt.Errorf("❌ Error listing instrumented containers: %v", err)
 	}
+	var instrumented []docker.ContainerState
+	for i := range saved {
+		if matchesSavedContainer(c.config.Filter, &saved[i]) {
+			instrumented = append(instrumented, saved[i])
+		}
+	}
 
 	if len(instrumented) == 0 {
 		fmt.Println("No instrumented Docker containers found")
@@ -230,7 +309,9 @@ func (c *UninstrumentDockerCommand) Execute() error {
 	failed := 0
 
 	for _, container := range instrumented {
+		changeset.Begin(savedContainerTarget(&container))
 		err := dockerOps.UninstrumentContainer(container.ContainerName)
+		changeset.End()
 		if err != nil {
 			fmt.Printf("❌ Failed to uninstrument %s: %v\n", container.ContainerName, err)
 			failed++
@@ -276,6 +357,14 @@ func (c *UninstrumentContainerCommand) Execute() error {
 
 	fmt.Printf("🔧 Uninstrumenting container: %s\n\n", c.containerName)
 
+	saved, _ := dockerOps.ListInstrumentedContainers()
+	for i := range saved {
+		if saved[i].ContainerName == c.containerName {
+			changeset.Begin(savedContainerTarget(&saved[i]))
+		}
+	}
+	defer changeset.End()
+
 	if err := dockerOps.UninstrumentContainer(c.containerName); err != nil {
 		return fmt.Errorf("❌ Failed to uninstrument container: %v", err)
 	}
@@ -293,25 +382,33 @@ type OrphanedConfig struct {
 	ConfigPath  string
 	ServiceName string
 	IsTomcat    bool
+	IsWildFly   bool
+	Supervised  bool
+	SystemdUnit string // recorded unit, qualified for systemd --user units
 }
 
 // Helper methods (these will be moved to appropriate packages in later steps)
 func (c *UninstrumentCommand) getConfigPath(proc *discovery.JavaProcess) string {
 	serviceName := naming.GenerateServiceName(proc)
 
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot(), serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot(), serviceName)
+	}
+
 	if proc.IsTomcat() {
-		return fmt.Sprintf("/etc/middleware/tomcat/%s.conf", serviceName)
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot(), serviceName)
 	}
 
 	deploymentType := c.detectDeploymentType(proc)
-	return fmt.Sprintf("/etc/middleware/%s/%s.conf", deploymentType, serviceName)
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot(), deploymentType, serviceName)
 }
 
 func (c *UninstrumentCommand) detectDeploymentType(proc *discovery.JavaProcess) string {
-	if proc.ProcessOwner != "root" && proc.ProcessOwner != os.Getenv("USER") {
-		return "systemd"
-	}
-	return "standalone"
+	return proc.DetectSupervisor().Kind
 }
 
 func (c *UninstrumentCommand) generateServiceName(proc *discovery.JavaProcess) string {
@@ -320,8 +417,7 @@ func (c *UninstrumentCommand) generateServiceName(proc *discovery.JavaProcess) s
 }
 
 func (c *UninstrumentCommand) fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	return changeset.Exists(path)
 }
 
 func (c *UninstrumentCommand) getSystemdServiceName(proc *discovery.JavaProcess) string {
@@ -340,7 +436,7 @@ func (c *UninstrumentCommand) findOrphanedConfigs(runningProcesses []discovery.J
 	}
 
 	// Check systemd configs
-	systemdDir := "/etc/middleware/systemd"
+	systemdDir := configRoot() + "/systemd"
 	if c.fileExists(systemdDir) {
 		files, _ := os.ReadDir(systemdDir)
 		for _, file := range files {
@@ -348,10 +444,12 @@ func (c *UninstrumentCommand) findOrphanedConfigs(runningProcesses []discovery.J
 				configPath := fmt.Sprintf("%s/%s", systemdDir, file.Name())
 				if !runningConfigs[configPath] {
 					serviceName := strings.TrimSuffix(file.Name(), ".conf")
+					configVars, _ := systemd.ReadConfigFile(configPath)
 					orphaned = append(orphaned, OrphanedConfig{
 						ConfigPath:  configPath,
 						ServiceName: serviceName,
 						IsTomcat:    false,
+						SystemdUnit: configVars["MW_SYSTEMD_UNIT"],
 					})
 				}
 			}
@@ -359,7 +457,7 @@ func (c *UninstrumentCommand) findOrphanedConfigs(runningProcesses []discovery.J
 	}
 
 	// Check tomcat configs
-	tomcatDir := "/etc/middleware/tomcat"
+	tomcatDir := configRoot() + "/tomcat"
 	if c.fileExists(tomcatDir) {
 		files, _ := os.ReadDir(tomcatDir)
 		for _, file := range files {
@@ -377,8 +475,72 @@ func (c *UninstrumentCommand) findOrphanedConfigs(runningProcesses []discovery.J
 		}
 	}
 
+	// Check wildfly configs
+	wildflyDir := configRoot() + "/wildfly"
+	if c.fileExists(wildflyDir) {
+		files, _ := os.ReadDir(wildflyDir)
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".conf") {
+				configPath := fmt.Sprintf("%s/%s", wildflyDir, file.Name())
+				if !runningConfigs[configPath] {
+					serviceName := strings.TrimSuffix(file.Name(), ".conf")
+					// The unit carries the drop-ins written when the launch
+					// config was missing, and the one loading the API key
+					configVars, _ := systemd.ReadConfigFile(configPath)
+					orphaned = append(orphaned, OrphanedConfig{
+						ConfigPath:  configPath,
+						ServiceName: serviceName,
+						IsWildFly:   true,
+						SystemdUnit: configVars["MW_SYSTEMD_UNIT"],
+					})
+				}
+			}
+		}
+	}
+
+	// Check jetty configs
+	jettyDir := configRoot() + "/jetty"
+	if c.fileExists(jettyDir) {
+		files, _ := os.ReadDir(jettyDir)
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".conf") {
+				configPath := fmt.Sprintf("%s/%s", jettyDir, file.Name())
+				if !runningConfigs[configPath] {
+					serviceName := strings.TrimSuffix(file.Name(), ".conf")
+					orphaned = append(orphaned, OrphanedConfig{
+						ConfigPath:  configPath,
+						ServiceName: serviceName,
+						IsTomcat:    false,
+					})
+				}
+			}
+		}
+	}
+
+	// Check configs of processes run by other supervisors
+	for _, kind := range supervisedKinds {
+		supervisorDir := configRoot() + "/" + kind
+		if !c.fileExists(supervisorDir) {
+			continue
+		}
+		files, _ := os.ReadDir(supervisorDir)
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".conf") {
+				configPath := fmt.Sprintf("%s/%s", supervisorDir, file.Name())
+				if !runningConfigs[configPath] {
+					serviceName := strings.TrimSuffix(file.Name(), ".conf")
+					orphaned = append(orphaned, OrphanedConfig{
+						ConfigPath:  configPath,
+						ServiceName: serviceName,
+						Supervised:  true,
+					})
+				}
+			}
+		}
+	}
+
 	// Check standalone configs
-	standaloneDir := "/etc/middleware/standalone"
+	standaloneDir := configRoot() + "/standalone"
 	if c.fileExists(standaloneDir) {
 		files, _ := os.ReadDir(standaloneDir)
 		for _, file := range files {
@@ -400,15 +562,31 @@ func (c *UninstrumentCommand) findOrphanedConfigs(runningProcesses []discovery.J
 }
 
 func (c *UninstrumentCommand) removeOrphanedConfig(config OrphanedConfig) {
+	if config.IsWildFly {
+		if err := uninstrumentWildFly(config.ConfigPath); err != nil {
+			fmt.Printf("   ❌ Failed to restore WildFly config: %v\n", err)
+			return
+		}
+	}
+
+	if err := revertArtifacts(config.ConfigPath); err != nil {
+		fmt.Printf("   ❌ Failed to revert changes: %v\n", err)
+		return
+	}
+
 	// Remove config file
-	if err := os.Remove(config.ConfigPath); err != nil {
+	if err := changeset.Remove(config.ConfigPath); err != nil {
 		fmt.Printf("   ❌ Failed to remove config: %v\n", err)
 		return
 	}
 	fmt.Printf("   Removed config: %s\n", config.ConfigPath)
 
-	// TODO: Remove systemd drop-in files
-	// This will be implemented when we create the systemd package
+	// Drop-ins are only removed for configs that record their unit
+	if config.SystemdUnit != "" {
+		if err := systemd.RemoveDropIn(config.SystemdUnit); err != nil {
+			fmt.Printf("   ⚠️  Warning: Failed to remove systemd drop-in: %v\n", err)
+		}
+	}
 
 	fmt.Printf("   🗑️  Removed orphaned instrumentation for: %s\n", config.ServiceName)
 }
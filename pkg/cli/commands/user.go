@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// userMode reports whether a host command runs without root. Only the
+// caller's own systemd --user units are instrumented in user mode.
+func userMode() bool {
+	return os.Geteuid() != 0
+}
+
+// configRoot returns the directory that holds per-service configs:
+// /etc/middleware for root, ~/.config/middleware in user mode
+func configRoot() string {
+	if !userMode() {
+		return "/etc/middleware"
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "middleware")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "middleware")
+}
+
+// userModeSkipReason explains why a process can't be instrumented in user
+// mode, or returns "" if it can
+func userModeSkipReason(proc *discovery.JavaProcess) string {
+	unit := systemd.GetServiceName(proc)
+	if !systemd.IsOwnUserUnit(unit) {
+		return "not one of your systemd --user units, run with sudo"
+	}
+	if proc.IsWildFly() || proc.IsJetty() || proc.IsTomcat() || proc.IsLauncherScript() {
+		return "application servers and launcher scripts require root, run with sudo"
+	}
+	return ""
+}
+
+// checkUserAgent verifies that the agent is readable in user mode, where it
+// can't be installed to a shared location
+func checkUserAgent(agentPath string) error {
+	file, err := os.Open(agentPath)
+	if err != nil {
+		return fmt.Errorf("agent is not readable at %s: %w\n   Install it with sudo first, or set MW_JAVA_AGENT_PATH to a copy you own", agentPath, err)
+	}
+	return file.Close()
+}
+
+// revertArtifacts reverts the changes recorded for a config in the host
+// state. User mode never records any and can't read the host state.
+func revertArtifacts(configPath string) error {
+	if userMode() {
+		return nil
+	}
+	_, err := state.RevertArtifacts(configPath)
+	return err
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+	"github.com/middleware-labs/java-injector/pkg/wildfly"
+)
+
+// instrumentWildFly configures a WildFly server and returns the systemd unit to restart
+func instrumentWildFly(proc *discovery.JavaProcess, configPath, serviceName, target, agentPath string) (string, error) {
+	unit := systemd.GetServiceName(proc)
+	hasUnit := systemd.ServiceExists(unit)
+
+	cfg := &wildfly.Config{
+		ServiceName: serviceName,
+		Info:        proc.ExtractWildFlyInfo(),
+		ConfigPath:  configPath,
+		SystemdUnit: unit,
+		Target:      target,
+		AgentPath:   agentPath,
+	}
+
+	// Under systemd the API key comes from the unit's EnvironmentFile=,
+	// otherwise the launch config sources a copy owned by the server's user
+	if !hasUnit {
+		apiKey, err := secrets.LoadAPIKey(secrets.DefaultEnvFile())
+		if err != nil {
+			return "", fmt.Errorf("failed to read API key: %w", err)
+		}
+		cfg.APIKeyFile = wildflyKeyFile(configPath)
+		if err := storeOwnedAPIKey(cfg.APIKeyFile, apiKey, proc.ProcessOwner); err != nil {
+			return "", err
+		}
+	}
+
+	result, err := wildfly.Instrument(cfg)
+	if err != nil {
+		return "", err
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("   ⚠️  %s\n", warning)
+	}
+	if !result.DropIn {
+		fmt.Printf("   Updated launch config: %s\n", result.File)
+	}
+	instrumentedFile := result.File
+
+	if hasUnit {
+		if err := attachSecrets(unit, configPath, serviceName); err != nil {
+			return "", err
+		}
+	} else {
+		fmt.Printf("   ⚠️  No systemd unit found, %s loads the API key from %s\n", instrumentedFile, cfg.APIKeyFile)
+	}
+
+	if err := wildfly.CreateConfig(cfg, instrumentedFile); err != nil {
+		return "", fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return unit, nil
+}
+
+// uninstrumentWildFly removes the instrumentation recorded in a WildFly config
+func uninstrumentWildFly(configPath string) error {
+	configVars, err := systemd.ReadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	if file := configVars["MW_INSTRUMENTED_FILE"]; file != "" {
+		restored, err := wildfly.Uninstrument(file)
+		if err != nil {
+			return err
+		}
+		if restored {
+			fmt.Printf("   Restored launch config: %s\n", file)
+		}
+	}
+	if keyFile := wildflyKeyFile(configPath); configVars["MW_API_KEY_FILE"] == keyFile {
+		if err := changeset.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", keyFile, err)
+		}
+		changeset.Remove(filepath.Dir(keyFile))
+	}
+	return nil
+}
+
+// wildflyKeyFile returns the API key file of a server without a systemd
+// unit, in a directory of its own so it can belong to the server's user
+func wildflyKeyFile(configPath string) string {
+	return filepath.Join(strings.TrimSuffix(configPath, ".conf")+".secrets", "middleware.env")
+}
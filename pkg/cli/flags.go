@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/inventory"
+)
+
+// options are the flags of a single command, the global ones are kept in
+// the CommandConfig
+type options struct {
+	dryRun    bool
+	out       string
+	plan      string
+	now       bool
+	check     bool
+	report    string
+	timer     string
+	noRestart bool
+}
+
+// logLevels are the agent log levels --log-level accepts
+var logLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}
+
+// processFilterCommands take --pid, --service, --owner and --unit
+var processFilterCommands = map[string]bool{
+	"list":                   true,
+	"list-all":               true,
+	"status":                 true,
+	"auto-instrument":        true,
+	"auto-instrument-config": true,
+	"uninstrument":           true,
+	"plan":                   true,
+	"doctor":                 true,
+	"agent":                  true,
+}
+
+// containerFilterCommands take --container and --image
+var containerFilterCommands = map[string]bool{
+	"list-docker":              true,
+	"list-all":                 true,
+	"instrument-docker":        true,
+	"instrument-docker-config": true,
+	"uninstrument-docker":      true,
+	"plan":                     true,
+}
+
+// outputCommands take --output
+var outputCommands = map[string]bool{
+	"list":        true,
+	"list-docker": true,
+	"list-all":    true,
+	"status":      true,
+}
+
+// installCommands install the agent and take --allow-unverified
+var installCommands = map[string]bool{
+	"auto-instrument":          true,
+	"auto-instrument-config":   true,
+	"instrument-docker":        true,
+	"instrument-docker-config": true,
+	"instrument-container":     true,
+	"shim":                     true,
+	"agent":                    true,
+}
+
+// listFlag collects a flag given several times or with comma separated values
+type listFlag struct {
+	values *[]string
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.values = append(*l.values, v)
+		}
+	}
+	return nil
+}
+
+// pidFlag collects PIDs like listFlag
+type pidFlag struct {
+	pids *[]int
+}
+
+func (p pidFlag) String() string {
+	if p.pids == nil {
+		return ""
+	}
+	var pids []string
+	for _, pid := range *p.pids {
+		pids = append(pids, strconv.Itoa(pid))
+	}
+	return strings.Join(pids, ",")
+}
+
+func (p pidFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		pid, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || pid <= 0 {
+			return fmt.Errorf("invalid PID %q", v)
+		}
+		*p.pids = append(*p.pids, pid)
+	}
+	return nil
+}
+
+// globalFlags registers the flags every command accepts
+func globalFlags(fs *flag.FlagSet, config *CommandConfig) {
+	fs.BoolVar(&config.Yes, "yes", config.Yes, "Answer yes to every confirmation")
+	fs.BoolVar(&config.Yes, "y", config.Yes, "Shorthand for --yes")
+	fs.BoolVar(&config.NonInteractive, "non-interactive", config.NonInteractive, "Never prompt, fail when a required value is missing")
+	fs.StringVar(&config.ConfigFile, "config", config.ConfigFile, "Read settings from the config `file`")
+	fs.StringVar(&config.APIKeyFile, "api-key-file", config.APIKeyFile, "Read the API key from `file`")
+	fs.StringVar(&config.Target, "target", config.Target, "Middleware.io endpoint `url`")
+	fs.StringVar(&config.AgentPath, "agent-path", config.AgentPath, "Java agent `jar`")
+	fs.StringVar(&config.LogLevel, "log-level", config.LogLevel, "Agent log `level`: "+strings.ToLower(strings.Join(logLevels, ", ")))
+}
+
+// commandFlags registers the flags of one command
+func commandFlags(fs *flag.FlagSet, commandName string, config *CommandConfig, opts *options) {
+	dryRun := mutatingCommands[commandName]
+	if dryRun {
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "Only show the changes, don't make them")
+	}
+	if dryRun || commandName == "plan" {
+		fs.StringVar(&opts.out, "out", "", "Save the planned changes to `file`")
+	}
+
+	switch commandName {
+	case "apply":
+		fs.StringVar(&opts.plan, "plan", "", "Plan `file` to apply")
+	case "restart-pending":
+		fs.BoolVar(&opts.now, "now", false, "Restart now instead of waiting for the maintenance window")
+	case "reconcile":
+		fs.BoolVar(&opts.check, "check", false, "Only report drift, repair nothing")
+		fs.StringVar(&opts.report, "report", "", "Write the drift report to `file`")
+		fs.StringVar(&opts.timer, "timer", "", "Install a systemd timer reconciling on this `calendar`")
+	case "auto-instrument", "auto-instrument-config":
+		fs.BoolVar(&config.SkipDoctor, "skip-doctor", false, "Don't run the doctor checks first")
+	case "undo":
+		fs.BoolVar(&opts.noRestart, "no-restart", false, "Don't restart the services that were undone")
+	}
+
+	if installCommands[commandName] {
+		fs.BoolVar(&config.AllowUnverified, "allow-unverified", false, "Install an agent no signature or checksum verifies")
+	}
+
+	if outputCommands[commandName] {
+		usage := "Output `format`: " + strings.Join(inventory.Formats, ", ")
+		fs.StringVar(&config.Output, "output", config.Output, usage)
+		fs.StringVar(&config.Output, "o", config.Output, "Shorthand for --output")
+	}
+
+	filter := &config.Filter
+	if processFilterCommands[commandName] {
+		fs.Var(pidFlag{&filter.PIDs}, "pid", "Only processes with this `pid`")
+		fs.Var(listFlag{&filter.Services}, "service", "Only services whose name matches `pattern`")
+		fs.Var(listFlag{&filter.Owners}, "owner", "Only processes run by `user`")
+		fs.Var(listFlag{&filter.Units}, "unit", "Only services of the systemd `unit`")
+	}
+	if containerFilterCommands[commandName] {
+		fs.Var(listFlag{&filter.Containers}, "container", "Only containers whose name matches `pattern`")
+		fs.Var(listFlag{&filter.Images}, "image", "Only containers whose image matches `pattern`")
+	}
+}
+
+// newFlagSet returns the flags of a command, errors are returned rather
+// than printed
+func newFlagSet(commandName string, config *CommandConfig, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(commandName, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	globalFlags(fs, config)
+	commandFlags(fs, commandName, config, opts)
+	return fs
+}
+
+// parseFlags parses the flags of a command, which may come before, between
+// or after its arguments, and returns the arguments
+func parseFlags(commandName string, args []string, config *CommandConfig) ([]string, *options, error) {
+	opts := &options{}
+	fs := newFlagSet(commandName, config, opts)
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCommandUsage(commandName, fs)
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("❌ %v\n   See: mw-injector %s --help", err, commandName)
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		// Everything after -- is an argument
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	if err := checkGlobalFlags(config); err != nil {
+		return nil, nil, err
+	}
+	if config.Output != "" && !slices.Contains(inventory.Formats, config.Output) {
+		return nil, nil, fmt.Errorf("❌ Invalid --output %q, use one of: %s", config.Output, strings.Join(inventory.Formats, ", "))
+	}
+	return positional, opts, nil
+}
+
+// parseLeadingFlags parses the global flags given before the command name,
+// e.g. mw-injector --yes uninstrument, and returns the rest
+func parseLeadingFlags(args []string, config *CommandConfig) ([]string, error) {
+	fs := flag.NewFlagSet("mw-injector", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	globalFlags(fs, config)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("❌ %v\n   See: mw-injector help", err)
+	}
+	return fs.Args(), nil
+}
+
+// checkGlobalFlags validates the global flags once parsed
+func checkGlobalFlags(config *CommandConfig) error {
+	if config.LogLevel != "" {
+		level := strings.ToUpper(config.LogLevel)
+		if !slices.Contains(logLevels, level) {
+			return fmt.Errorf("❌ Invalid --log-level %q, use one of: %s", config.LogLevel, strings.ToLower(strings.Join(logLevels, ", ")))
+		}
+		config.LogLevel = level
+	}
+	if config.APIKeyFile != "" {
+		if _, err := os.Stat(config.APIKeyFile); err != nil {
+			return fmt.Errorf("❌ API key file: %v", err)
+		}
+	}
+	return nil
+}
+
+// printCommandUsage prints the flags of a command
+func printCommandUsage(commandName string, fs *flag.FlagSet) {
+	fmt.Printf("Usage: mw-injector %s [flags]\n", commandName)
+	fmt.Printf("  %s\n\nFlags:\n", GetCommandDescription(commandName))
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+}
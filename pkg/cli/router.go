@@ -1,7 +1,10 @@
 package cli
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/middleware-labs/java-injector/pkg/cli/commands"
 )
@@ -41,6 +44,7 @@ func (r *Router) registerCommands() {
 	r.commands["list"] = commands.NewListCommand(r.config)
 	r.commands["list-docker"] = commands.NewListDockerCommand(r.config)
 	r.commands["list-all"] = commands.NewListAllCommand(r.config)
+	r.commands["status"] = commands.NewStatusCommand(r.config)
 
 	// Instrument commands
 	r.commands["auto-instrument"] = commands.NewAutoInstrumentCommand(r.config)
@@ -60,18 +64,101 @@ func (r *Router) Run(args []string) error {
 		return fmt.Errorf("no command specified")
 	}
 
-	commandName := args[1]
-	commandArgs := args[2:]
+	// Global flags may also come before the command
+	rest := args[1:]
+	if strings.HasPrefix(rest[0], "-") && !isHelp(rest[0]) {
+		var err error
+		if rest, err = parseLeadingFlags(rest, r.config); err != nil {
+			return err
+		}
+		if len(rest) == 0 {
+			PrintUsage()
+			return fmt.Errorf("no command specified")
+		}
+	}
+	commandName := rest[0]
 
 	// Handle help requests
-	if commandName == "help" || commandName == "--help" || commandName == "-h" {
+	if isHelp(commandName) {
 		PrintUsage()
 		return nil
 	}
 
-	// Route to appropriate command
+	commandArgs, opts, err := parseFlags(commandName, rest[1:], r.config)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if commandName == "plan" {
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector plan [config-file] [--out <file>]")
+		}
+		return commands.NewPlanCommand(r.config, r.configArg(commandArgs), opts.out).Execute()
+	}
+
+	if opts.dryRun {
+		command := strings.Join(append([]string{commandName}, commandArgs...), " ")
+		return commands.DryRun(command, opts.out, func() error {
+			return r.route(commandName, commandArgs, opts)
+		})
+	}
+	if opts.out != "" {
+		return fmt.Errorf("❌ --out is only used with plan or --dry-run")
+	}
+
+	readOnly := len(commandArgs) > 0 &&
+		(commandName == "agent" && commandArgs[0] == "list" || commandName == "shim" && commandArgs[0] == "status")
+	if (mutatingCommands[commandName] && !readOnly) || commandName == "apply" {
+		command := strings.Join(append([]string{commandName}, commandArgs...), " ")
+		return commands.Locked(func() error {
+			return commands.Journaled(command, func() error {
+				return r.route(commandName, commandArgs, opts)
+			})
+		})
+	}
+	return r.route(commandName, commandArgs, opts)
+}
+
+// isHelp tells whether an argument asks for the usage
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "--help" || arg == "-h"
+}
+
+// mutatingCommands are the commands that support --dry-run, and are locked
+// and journaled
+var mutatingCommands = map[string]bool{
+	"auto-instrument":          true,
+	"instrument-docker":        true,
+	"instrument-container":     true,
+	"uninstrument":             true,
+	"uninstrument-docker":      true,
+	"uninstrument-container":   true,
+	"auto-instrument-config":   true,
+	"instrument-docker-config": true,
+	"rotate-key":               true,
+	"restart-pending":          true,
+	"reconcile":                true,
+	"undo":                     true,
+	"agent":                    true,
+	"shim":                     true,
+}
+
+// configArg returns the optional config file argument, --config when it's
+// not given
+func (r *Router) configArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return r.config.ConfigFile
+}
+
+// route executes a command with its arguments
+func (r *Router) route(commandName string, commandArgs []string, opts *options) error {
 	switch commandName {
-	case "list", "list-docker", "list-all":
+	case "list", "list-docker", "list-all", "status":
 		return r.executeNoArgsCommand(commandName, commandArgs)
 
 	case "instrument-container", "uninstrument-container":
@@ -80,12 +167,71 @@ func (r *Router) Run(args []string) error {
 	case "auto-instrument", "instrument-docker", "uninstrument", "uninstrument-docker":
 		return r.executeNoArgsCommand(commandName, commandArgs)
 
-	// Add these cases to the switch statement in pkg/cli/router.go
-	case "auto-instrument-config":
-		return r.executeOptionalArgCommand(commandName, commandArgs)
-
-	case "instrument-docker-config":
-		return r.executeOptionalArgCommand(commandName, commandArgs)
+	// The config variants are the commands reading a config file, without
+	// prompts
+	case "auto-instrument-config", "instrument-docker-config":
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector %s [config-file]", commandName)
+		}
+		if err := commands.UseConfigFile(r.config, r.configArg(commandArgs), commandName); err != nil {
+			return err
+		}
+		return r.executeNoArgsCommand(strings.TrimSuffix(commandName, "-config"), nil)
+
+	case "shim":
+		return r.executeShimCommand(commandArgs)
+
+	case "agent":
+		if len(commandArgs) < 1 {
+			return fmt.Errorf("❌ Agent action required\nUsage: mw-injector agent <install <jar|version|latest>|list|use <version>|prune|upgrade [version|jar|latest]>")
+		}
+		return commands.NewAgentCommand(r.config, commandArgs[0], commandArgs[1:]).Execute()
+
+	case "rotate-key":
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector rotate-key [config-file]")
+		}
+		return commands.NewRotateKeyCommand(r.config, r.configArg(commandArgs)).Execute()
+
+	case "apply":
+		if opts.plan == "" || len(commandArgs) > 0 {
+			return fmt.Errorf("❌ Plan file required\nUsage: mw-injector apply --plan <file>")
+		}
+		return commands.NewApplyCommand(r.config, opts.plan).Execute()
+
+	case "restart-pending":
+		if len(commandArgs) > 0 {
+			return fmt.Errorf("❌ Usage: mw-injector restart-pending [--now]")
+		}
+		return commands.NewRestartPendingCommand(r.config, opts.now).Execute()
+
+	case "reconcile":
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector reconcile [config-file] [--check] [--report <file>] [--timer <calendar>]")
+		}
+		return commands.NewReconcileCommand(r.config, r.configArg(commandArgs), opts.check, opts.report, opts.timer).Execute()
+
+	case "doctor":
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector doctor [config-file]")
+		}
+		return commands.NewDoctorCommand(r.config, r.configArg(commandArgs)).Execute()
+
+	case "history":
+		if len(commandArgs) > 1 {
+			return fmt.Errorf("❌ Usage: mw-injector history [op-id]")
+		}
+		var opID string
+		if len(commandArgs) > 0 {
+			opID = commandArgs[0]
+		}
+		return commands.NewHistoryCommand(r.config, opID).Execute()
+
+	case "undo":
+		if len(commandArgs) != 1 {
+			return fmt.Errorf("❌ Operation required\nUsage: mw-injector undo <op-id> [--no-restart]\n   See mw-injector history")
+		}
+		return commands.NewUndoCommand(r.config, commandArgs[0], opts.noRestart).Execute()
 
 	default:
 		PrintUsage()
@@ -131,22 +277,13 @@ func (r *Router) executeSingleArgCommand(commandName string, args []string) erro
 	return fmt.Errorf("command '%s' does not support single argument execution", commandName)
 }
 
-func (r *Router) executeOptionalArgCommand(commandName string, args []string) error {
-	var configPath string
-	if len(args) > 0 {
-		configPath = args[0]
+// executeShimCommand executes the shim command: an action and an optional config file
+func (r *Router) executeShimCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("❌ Shim action required\nUsage: mw-injector shim <install|remove|enable|disable|status> [config-file]")
 	}
 
-	switch commandName {
-	case "auto-instrument-config":
-		cmd := commands.NewConfigAutoInstrumentCommand(r.config, configPath)
-		return cmd.Execute()
-	case "instrument-docker-config":
-		cmd := commands.NewConfigInstrumentDockerCommand(r.config, configPath)
-		return cmd.Execute()
-	default:
-		return fmt.Errorf("unknown optional arg command: %s", commandName)
-	}
+	return commands.NewShimCommand(r.config, args[0], r.configArg(args[1:])).Execute()
 }
 
 // getSingleArgUsageError returns appropriate usage error for single-arg commands
@@ -0,0 +1,60 @@
+package cli_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/cli"
+)
+
+func TestRunFlags(t *testing.T) {
+	config := cli.NewDefaultConfig()
+	router := cli.NewRouterWithConfig(config)
+
+	// Flags before, between and after the arguments, which list-all rejects
+	// before running
+	err := router.Run([]string{"mw-injector", "--yes", "list-all", "--pid", "10,11", "extra",
+		"--pid=12", "--container", "web-*", "--log-level", "debug", "--target=https://example:443", "--non-interactive"})
+	if err == nil {
+		t.Fatal("list-all with an argument succeeded")
+	}
+	if !config.Yes || !config.NonInteractive {
+		t.Errorf("Yes, NonInteractive = %v, %v, want true", config.Yes, config.NonInteractive)
+	}
+	if config.LogLevel != "DEBUG" || config.Target != "https://example:443" {
+		t.Errorf("LogLevel, Target = %q, %q", config.LogLevel, config.Target)
+	}
+	if !slices.Equal(config.Filter.PIDs, []int{10, 11, 12}) {
+		t.Errorf("PIDs = %v, want [10 11 12]", config.Filter.PIDs)
+	}
+	if !slices.Equal(config.Filter.Containers, []string{"web-*"}) {
+		t.Errorf("Containers = %v, want [web-*]", config.Filter.Containers)
+	}
+}
+
+func TestRunFlagErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"Unknown flag", []string{"list", "--bogus"}},
+		{"Flag of another command", []string{"list", "--now"}},
+		{"Container filter on processes", []string{"auto-instrument", "--image", "app"}},
+		{"Invalid PID", []string{"uninstrument", "--pid", "abc"}},
+		{"Dry run of a read-only command", []string{"status", "--dry-run"}},
+		{"Invalid log level", []string{"list", "--log-level", "verbose"}},
+		{"Missing flag value", []string{"undo", "--config"}},
+		{"Missing API key file", []string{"list", "--api-key-file", "/nonexistent/key"}},
+		{"Invalid output format", []string{"status", "-o", "xml"}},
+		{"Output of a command without it", []string{"uninstrument", "--output", "json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := cli.NewRouterWithConfig(cli.NewDefaultConfig())
+			if err := router.Run(append([]string{"mw-injector"}, tt.args...)); err == nil {
+				t.Errorf("Run(%v) succeeded, want an error", tt.args)
+			}
+		})
+	}
+}
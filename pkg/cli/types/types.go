@@ -1,5 +1,7 @@
 package types
 
+import "github.com/middleware-labs/java-injector/pkg/agent"
+
 // CommandHandler defines the interface for all CLI commands
 type CommandHandler interface {
 	Execute() error
@@ -11,14 +13,45 @@ type CommandConfig struct {
 	DefaultAgentDir  string
 	DefaultAgentName string
 	DefaultAgentPath string
+
+	// Global flags, they take precedence over the config file and prompts
+	Yes            bool   // answer yes to every confirmation
+	NonInteractive bool   // never prompt, fail when a required value is missing
+	ConfigFile     string // MW_* config file to read settings from
+	APIKeyFile     string // file holding the API key
+	Target         string
+	AgentPath      string
+	LogLevel       string // agent log level, MW_LOG_LEVEL
+
+	Output          string // list and status output format, text when empty
+	SkipDoctor      bool   // auto-instrument skips its preflight checks
+	AllowUnverified bool   // install agents no signature or checksum vouches for
+	Filter          Filter
+}
+
+// Filter narrows a command to some processes or containers. Values may be
+// glob patterns, an empty field matches everything.
+type Filter struct {
+	PIDs       []int
+	Services   []string
+	Owners     []string
+	Units      []string
+	Containers []string
+	Images     []string
+}
+
+// Empty tells whether the filter matches everything
+func (f Filter) Empty() bool {
+	return len(f.PIDs) == 0 && len(f.Services) == 0 && len(f.Owners) == 0 &&
+		len(f.Units) == 0 && len(f.Containers) == 0 && len(f.Images) == 0
 }
 
 // NewDefaultConfig returns default configuration values
 func NewDefaultConfig() *CommandConfig {
 	return &CommandConfig{
-		DefaultAgentDir:  "/opt/middleware/agents",
-		DefaultAgentName: "middleware-javaagent-1.8.1.jar",
-		DefaultAgentPath: "/opt/middleware/agents/middleware-javaagent-1.8.1.jar",
+		DefaultAgentDir:  agent.DefaultDir,
+		DefaultAgentName: agent.DefaultName,
+		DefaultAgentPath: agent.DefaultPath,
 	}
 }
 
@@ -9,17 +9,61 @@ Usage:
   mw-injector list                          List all Java processes (host)
   mw-injector list-docker                   List all Java Docker containers
   mw-injector list-all                      List both host processes and Docker containers
+  mw-injector status                        Show whether each service actually runs with the agent
+  mw-injector doctor [config-file]          Check the host is ready to be instrumented
   mw-injector auto-instrument               Auto-instrument all uninstrumented processes (host)
   mw-injector instrument-docker             Auto-instrument all Java Docker containers
   mw-injector instrument-container <name>   Instrument specific Docker container
   mw-injector uninstrument                  Uninstrument all host processes
   mw-injector uninstrument-docker           Uninstrument all Docker containers
   mw-injector uninstrument-container <name> Uninstrument specific Docker container
+  mw-injector shim <action> [config-file]   Manage the Java launcher shim (install|remove|enable|disable|status)
+  mw-injector agent <action> [arg]          Manage agent versions (install <jar|version|latest>|list|use <version>|prune|upgrade [version|jar|latest])
+  mw-injector rotate-key [config-file]      Replace the API key of all instrumented services and containers
+  mw-injector plan [config-file]            Show the changes auto-instrument-config would make (--out <file> saves them)
+  mw-injector apply --plan <file>           Apply a saved plan, refusing if the host changed since
+  mw-injector restart-pending [--now]       Run the restarts deferred to the maintenance window
+  mw-injector reconcile [config-file]       Repair drifted instrumentation (--check only reports, --report <file>, --timer <calendar>)
+  mw-injector history [op-id]               List past operations, or the steps of one
+  mw-injector undo <op-id> [--no-restart]   Revert the changes of one operation
+
+  mw-injector auto-instrument-config [file] auto-instrument --config <file> --yes --non-interactive
+  mw-injector instrument-docker-config [file] instrument-docker --config <file> --yes --non-interactive
+
+Commands that change the host accept --dry-run [--out <file>] to only show the changes.
+Commands that install the agent refuse one no signature or checksum verifies, unless given --allow-unverified.
+
+Global flags, before or after the command:
+  --yes, -y                 Answer yes to every confirmation
+  --non-interactive         Never prompt, fail when a required value is missing
+  --config <file>           Read MW_* settings from a config file
+  --api-key-file <file>     Read the API key from a file
+  --target <url>            Middleware.io endpoint
+  --agent-path <jar>        Java agent to use
+  --log-level <level>       Agent log level: trace, debug, info, warn, error
+
+Filters (values may be repeated, comma separated or glob patterns):
+  --pid, --service, --owner, --unit    list, status, doctor, auto-instrument, uninstrument, plan, agent upgrade
+  --container, --image                 list-docker, instrument-docker, uninstrument-docker, plan
+
+Output of list, list-docker, list-all and status:
+  --output, -o <format>     text (default), json, yaml, table or wide
+
+Run mw-injector <command> --help for the flags of a command.
 
 Examples:
   # Host Java processes
   sudo mw-injector list
+  sudo mw-injector doctor
   sudo mw-injector auto-instrument
+
+  # Without prompts, e.g. from Ansible or CI
+  sudo mw-injector auto-instrument --api-key-file /run/secrets/mw-key --non-interactive --yes --service 'billing-*'
+  sudo mw-injector uninstrument --yes --unit payments
+
+  # Your own systemd --user units, no sudo needed
+  mw-injector auto-instrument-config ~/.mw-injector.conf
+  mw-injector uninstrument
   
   # Docker containers
   sudo mw-injector list-docker
@@ -27,6 +71,41 @@ Examples:
   sudo mw-injector instrument-container my-java-app
   sudo mw-injector uninstrument-container my-java-app
   
+  # JVMs started by cron, nohup or vendor binaries (opt-in)
+  sudo mw-injector shim install /etc/mw-injector.conf
+  sudo mw-injector shim disable
+
+  # Install a new agent next to the current one, then restart services onto it
+  sudo mw-injector agent install /tmp/middleware-javaagent-1.9.0.jar
+  sudo mw-injector agent upgrade 1.9.0
+  sudo mw-injector agent prune
+
+  # Fetch the latest agent from the repository MW_AGENT_REPOSITORY names and roll it out
+  sudo mw-injector agent upgrade latest
+
+  # Rotate the API key, read from MW_API_KEY or prompted for
+  sudo mw-injector rotate-key /etc/mw-injector.conf
+
+  # Review changes first, then apply exactly those
+  sudo mw-injector plan /etc/mw-injector.conf --out /root/mw.plan
+  sudo mw-injector apply --plan /root/mw.plan
+  sudo mw-injector uninstrument --dry-run
+
+  # Restarts deferred with "restart: window" in the policy
+  sudo mw-injector restart-pending --now
+
+  # Repair drift every hour, or only report it
+  sudo mw-injector reconcile /etc/mw-injector.conf --timer hourly
+  sudo mw-injector reconcile --check --report /var/tmp/drift.json
+
+  # See who changed what, and revert one operation
+  sudo mw-injector history
+  sudo mw-injector undo 20261019-141909-4242
+
+  # Check the agent is loaded everywhere it was configured
+  sudo mw-injector status
+  sudo mw-injector status -o json | jq '.services[] | select(.state != "instrumented")'
+
   # List everything
   sudo mw-injector list-all`)
 }
@@ -34,15 +113,28 @@ Examples:
 // GetCommandDescription returns a description for the given command
 func GetCommandDescription(command string) string {
 	descriptions := map[string]string{
-		"list":                   "List all Java processes running on the host",
-		"list-docker":            "List all Java Docker containers",
-		"list-all":               "List both host processes and Docker containers",
-		"auto-instrument":        "Auto-instrument all uninstrumented Java processes on the host",
-		"instrument-docker":      "Auto-instrument all Java Docker containers",
-		"instrument-container":   "Instrument a specific Docker container",
-		"uninstrument":           "Uninstrument all host Java processes",
-		"uninstrument-docker":    "Uninstrument all Docker containers",
-		"uninstrument-container": "Uninstrument a specific Docker container",
+		"list":                     "List all Java processes running on the host",
+		"list-docker":              "List all Java Docker containers",
+		"list-all":                 "List both host processes and Docker containers",
+		"status":                   "Show whether each service actually runs with the agent, or is pending a restart, drifted, orphaned or failed",
+		"doctor":                   "Check the agent, access to it from each service, SELinux/AppArmor, systemd, Docker, state and free resources",
+		"auto-instrument":          "Auto-instrument all uninstrumented Java processes on the host, after the doctor checks",
+		"instrument-docker":        "Auto-instrument all Java Docker containers",
+		"instrument-container":     "Instrument a specific Docker container",
+		"uninstrument":             "Uninstrument all host Java processes",
+		"uninstrument-docker":      "Uninstrument all Docker containers",
+		"uninstrument-container":   "Uninstrument a specific Docker container",
+		"shim":                     "Manage the Java launcher shim for JVMs without a supervisor",
+		"agent":                    "Install, fetch, list, switch, prune and upgrade the agent versions kept side by side",
+		"rotate-key":               "Replace the API key of all instrumented services and containers",
+		"plan":                     "Show the changes auto-instrument and instrument-docker would make with a config file",
+		"apply":                    "Apply a saved plan, refusing if the host changed since planning",
+		"restart-pending":          "Run the restarts deferred to the maintenance window",
+		"reconcile":                "Repair and report drift between the policy, the recorded state and what's on disk and running",
+		"history":                  "List the operations that changed services and containers, who ran them and their results",
+		"undo":                     "Revert the changes of one operation, leaving targets changed again later alone",
+		"auto-instrument-config":   "Auto-instrument host processes from a config file, without prompts",
+		"instrument-docker-config": "Auto-instrument Docker containers from a config file, without prompts",
 	}
 
 	if desc, exists := descriptions[command]; exists {
@@ -146,9 +146,38 @@ func (c *ProcessConfiguration) ToEnvironmentVariables() map[string]string {
 		env["MW_DISABLE_TELEMETRY"] = "true"
 	}
 
+	// OTEL settings, for environments where -D flags can't be passed
+	if c.OtelTracesSampler != "" {
+		env["OTEL_TRACES_SAMPLER"] = c.OtelTracesSampler
+	}
+	if c.OtelTracesSamplerArg != "" {
+		env["OTEL_TRACES_SAMPLER_ARG"] = c.OtelTracesSamplerArg
+	}
+	if c.OtelResourceAttributes != "" {
+		env["OTEL_RESOURCE_ATTRIBUTES"] = c.OtelResourceAttributes
+	}
+
 	return env
 }
 
+// SystemProperties returns the OTEL settings as -D options
+func (c *ProcessConfiguration) SystemProperties() []string {
+	var props []string
+	if c.OtelServiceName != "" {
+		props = append(props, fmt.Sprintf("-Dotel.service.name=%s", c.OtelServiceName))
+	}
+	if c.OtelResourceAttributes != "" {
+		props = append(props, fmt.Sprintf("-Dotel.resource.attributes=%s", c.OtelResourceAttributes))
+	}
+	if c.OtelTracesSampler != "" {
+		props = append(props, fmt.Sprintf("-Dotel.traces.sampler=%s", c.OtelTracesSampler))
+	}
+	if c.OtelTracesSamplerArg != "" {
+		props = append(props, fmt.Sprintf("-Dotel.traces.sampler.arg=%s", c.OtelTracesSamplerArg))
+	}
+	return props
+}
+
 // ToJavaCommandLine generates the java command line with all settings
 func (c *ProcessConfiguration) ToJavaCommandLine(jarFile string) string {
 	parts := []string{}
@@ -166,18 +195,7 @@ func (c *ProcessConfiguration) ToJavaCommandLine(jarFile string) string {
 	}
 
 	// Add OTEL system properties
-	if c.OtelServiceName != "" {
-		javaParts = append(javaParts, fmt.Sprintf("-Dotel.service.name=%s", c.OtelServiceName))
-	}
-	if c.OtelResourceAttributes != "" {
-		javaParts = append(javaParts, fmt.Sprintf("-Dotel.resource.attributes=%s", c.OtelResourceAttributes))
-	}
-	if c.OtelTracesSampler != "" {
-		javaParts = append(javaParts, fmt.Sprintf("-Dotel.traces.sampler=%s", c.OtelTracesSampler))
-	}
-	if c.OtelTracesSamplerArg != "" {
-		javaParts = append(javaParts, fmt.Sprintf("-Dotel.traces.sampler.arg=%s", c.OtelTracesSamplerArg))
-	}
+	javaParts = append(javaParts, c.SystemProperties()...)
 
 	javaParts = append(javaParts, "-jar", jarFile)
 
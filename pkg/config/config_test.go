@@ -0,0 +1,43 @@
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/config"
+)
+
+func TestSystemProperties(t *testing.T) {
+	cfg := config.DefaultConfiguration()
+	cfg.OtelResourceAttributes = "env=prod"
+	cfg.OtelTracesSamplerArg = ""
+
+	expected := []string{
+		"-Dotel.resource.attributes=env=prod",
+		"-Dotel.traces.sampler=parentbased_always_on",
+	}
+	if got := cfg.SystemProperties(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("SystemProperties() = %v, expected %v", got, expected)
+	}
+}
+
+func TestToEnvironmentVariables(t *testing.T) {
+	cfg := config.DefaultConfiguration()
+	cfg.MWServiceName = "billing"
+	cfg.MWAPMCollectProfiling = false
+
+	env := cfg.ToEnvironmentVariables()
+	for key, expected := range map[string]string{
+		"MW_SERVICE_NAME":          "billing",
+		"MW_APM_COLLECT_PROFILING": "false",
+		"OTEL_TRACES_SAMPLER":      "parentbased_always_on",
+		"OTEL_TRACES_SAMPLER_ARG":  "1.0",
+	} {
+		if env[key] != expected {
+			t.Errorf("%s = %q, expected %q", key, env[key], expected)
+		}
+	}
+	if _, ok := env["OTEL_RESOURCE_ATTRIBUTES"]; ok {
+		t.Errorf("OTEL_RESOURCE_ATTRIBUTES set without resource attributes")
+	}
+}
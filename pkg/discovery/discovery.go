@@ -4,6 +4,7 @@ package discovery
 
 import (
 	"context"
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,7 @@ type JavaProcess struct {
 	ProcessCommandArgs    []string  `json:"process.command_args"`
 	ProcessOwner          string    `json:"process.owner"`
 	ProcessCreateTime     time.Time `json:"process.create_time"`
+	ProcessWorkingDir     string    `json:"process.working_directory,omitempty"`
 
 	// OTEL Process Runtime semantic conventions
 	ProcessRuntimeName        string `json:"process.runtime.name"`
@@ -122,6 +124,7 @@ type AgentInfo struct {
 	Path         string    `json:"path"`
 	Name         string    `json:"name"`
 	Version      string    `json:"version,omitempty"`
+	SDKVersion   string    `json:"sdk_version,omitempty"` // bundled OpenTelemetry SDK
 	IsServerless bool      `json:"is_serverless,omitempty"`
 }
 
@@ -204,3 +207,22 @@ func FindMiddlewareProcesses(ctx context.Context) ([]JavaProcess, error) {
 	defer d.Close()
 	return d.DiscoverWithOptions(ctx, opts)
 }
+
+// ParseJavaCommand builds a JavaProcess from a java command line that hasn't
+// started yet, e.g. one intercepted by the launcher shim
+func ParseJavaCommand(pid int32, args []string) *JavaProcess {
+	d := &discoverer{}
+	javaProc := &JavaProcess{
+		ProcessPID:         pid,
+		ProcessCommand:     strings.Join(args, " "),
+		ProcessCommandLine: strings.Join(args, " "),
+		ProcessCommandArgs: args,
+		ProcessRuntimeName: "java",
+	}
+
+	d.extractJavaInfo(javaProc, args)
+	d.extractServiceName(javaProc, args)
+	d.detectInstrumentation(javaProc, args)
+
+	return javaProc
+}
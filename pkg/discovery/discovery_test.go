@@ -1,10 +1,14 @@
 package discovery_test
 
 import (
+	"archive/zip"
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
 )
 
@@ -217,3 +221,40 @@ func TestDiscoveryWithFilters(t *testing.T) {
 		}
 	}
 }
+
+func TestGetAgentInfoLoadedVersion(t *testing.T) {
+	store := t.TempDir()
+	for _, version := range []string{"1.8.1", "1.9.0"} {
+		os.Mkdir(filepath.Join(store, version), 0o755)
+		file, err := os.Create(filepath.Join(store, version, "middleware-javaagent.jar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := zip.NewWriter(file)
+		w, _ := archive.Create(agentjar.ManifestPath)
+		w.Write([]byte("Premain-Class: Agent\nImplementation-Version: " + version + "\n"))
+		archive.Close()
+		file.Close()
+	}
+	link := filepath.Join(store, "current")
+	os.Symlink("1.8.1", link)
+
+	// This process stands in for a JVM that loaded 1.8.1 before current moved on
+	loaded, err := os.Open(filepath.Join(link, "middleware-javaagent.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	os.Remove(link)
+	os.Symlink("1.9.0", link)
+
+	proc := discovery.JavaProcess{
+		ProcessPID:        int32(os.Getpid()),
+		HasJavaAgent:      true,
+		IsMiddlewareAgent: true,
+		JavaAgentPath:     filepath.Join(link, "middleware-javaagent.jar"),
+	}
+	if info := proc.GetAgentInfo(); info.Version != "1.8.1" {
+		t.Errorf("GetAgentInfo().Version = %q, want the loaded 1.8.1", info.Version)
+	}
+}
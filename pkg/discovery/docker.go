@@ -84,7 +84,7 @@ func NewDockerDiscoverer(ctx context.Context) *DockerDiscoverer {
 // DiscoverJavaContainers finds all running Docker containers with Java
 func (dd *DockerDiscoverer) DiscoverJavaContainers() ([]DockerContainer, error) {
 	// Check if Docker is available
-	if !dd.isDockerAvailable() {
+	if !dd.IsDockerAvailable() {
 		return nil, fmt.Errorf("docker is not available or not running")
 	}
 
@@ -111,8 +111,8 @@ func (dd *DockerDiscoverer) DiscoverJavaContainers() ([]DockerContainer, error)
 	return javaContainers, nil
 }
 
-// isDockerAvailable checks if Docker daemon is accessible
-func (dd *DockerDiscoverer) isDockerAvailable() bool {
+// IsDockerAvailable checks if Docker daemon is accessible
+func (dd *DockerDiscoverer) IsDockerAvailable() bool {
 	cmd := exec.CommandContext(dd.ctx, "docker", "info")
 	err := cmd.Run()
 	return err == nil
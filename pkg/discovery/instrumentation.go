@@ -5,7 +5,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
 )
 
 // detectInstrumentation detects Java agents and instrumentation in the process
@@ -186,7 +189,8 @@ func (d *discoverer) isMiddlewareServerlessAgent(agentPath string) bool {
 	return false
 }
 
-// extractMiddlewareAgentVersion attempts to extract version from Middleware agent path
+// extractMiddlewareAgentVersion attempts to extract version from Middleware
+// agent path, for agents whose manifest can't be read
 func (d *discoverer) extractMiddlewareAgentVersion(agentPath string) string {
 	// Common version patterns in agent paths
 	versionPatterns := []*regexp.Regexp{
@@ -220,7 +224,9 @@ func (d *discoverer) detectEnvironmentVariables(javaProc *JavaProcess) {
 	// 3. Use system calls if available
 }
 
-// GetAgentInfo returns detailed information about the detected agent
+// GetAgentInfo returns detailed information about the detected agent. The
+// version comes from the agent's manifest, or its file name when the JAR
+// can't be read.
 func (jp *JavaProcess) GetAgentInfo() *AgentInfo {
 	if !jp.HasJavaAgent {
 		return &AgentInfo{
@@ -231,31 +237,83 @@ func (jp *JavaProcess) GetAgentInfo() *AgentInfo {
 	agentType := AgentOther
 	version := "unknown"
 	isServerless := false
+	d := &discoverer{} // Create temporary discoverer for utility methods
 
 	// Determine agent type
 	if jp.IsMiddlewareAgent {
 		agentType = AgentMiddleware
 
 		// Extract version and serverless detection for Middleware agents
-		d := &discoverer{} // Create temporary discoverer for utility methods
 		version = d.extractMiddlewareAgentVersion(jp.JavaAgentPath)
 		isServerless = d.isMiddlewareServerlessAgent(jp.JavaAgentPath)
 	} else {
 		// Check for other agent types
-		d := &discoverer{}
 		detectedType := d.detectAgentType(jp.JavaAgentPath)
 		if detectedType != AgentOther {
 			agentType = detectedType
 		}
 	}
 
-	return &AgentInfo{
+	info := &AgentInfo{
 		Type:         agentType,
 		Path:         jp.JavaAgentPath,
 		Name:         jp.JavaAgentName,
 		Version:      version,
 		IsServerless: isServerless,
 	}
+	if jar, err := agentjar.Read(jp.agentJarPath()); err == nil {
+		if jar.Version != "" {
+			info.Version = jar.Version
+		}
+		info.SDKVersion = jar.SDKVersion
+	}
+	return info
+}
+
+// agentJarPath returns where the agent can be read from here: relative
+// paths are relative to the JVM's working directory, and a JVM in another
+// mount namespace sees its own files
+func (jp *JavaProcess) agentJarPath() string {
+	path := jp.JavaAgentPath
+	if !filepath.IsAbs(path) && jp.ProcessWorkingDir != "" {
+		path = filepath.Join(jp.ProcessWorkingDir, path)
+	}
+	if open := jp.openAgentJar(path); open != "" {
+		return open
+	}
+	root := filepath.Join("/proc", strconv.Itoa(int(jp.ProcessPID)), "root")
+	if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+		return filepath.Join(root, path)
+	}
+	return path
+}
+
+// openAgentJar returns the agent the JVM has open when the path it was
+// given is a symlink, like the current link of the agent store. The link
+// may point at another version since the JVM started.
+func (jp *JavaProcess) openAgentJar(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || resolved == path {
+		return ""
+	}
+	fdDir := filepath.Join("/proc", strconv.Itoa(int(jp.ProcessPID)), "fd")
+	fds, err := os.ReadDir(fdDir)
+	if err != nil {
+		return ""
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		// Another version of the agent, in a sibling directory
+		target = strings.TrimSuffix(target, " (deleted)")
+		if filepath.Base(target) == filepath.Base(resolved) &&
+			filepath.Dir(filepath.Dir(target)) == filepath.Dir(filepath.Dir(resolved)) {
+			return filepath.Join(fdDir, fd.Name())
+		}
+	}
+	return ""
 }
 
 // FormatAgentStatus returns a human-readable agent status string
@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// JettyInfo contains information about a Jetty deployment
+type JettyInfo struct {
+	IsJetty  bool
+	HomeDir  string // jetty.home, where start.jar lives
+	BaseDir  string // jetty.base, where start.ini and start.d/ live
+	StartJar string
+	Forked   bool // JVM forked by start.jar because of --exec
+}
+
+// detectJettyDeployment checks if this is a Jetty process and extracts info
+func (d *discoverer) detectJettyDeployment(javaProc *JavaProcess, cmdArgs []string) *JettyInfo {
+	info := &JettyInfo{}
+
+	for _, arg := range cmdArgs {
+		switch {
+		case filepath.Base(arg) == "start.jar":
+			info.IsJetty = true
+			info.StartJar = arg
+		case arg == "org.eclipse.jetty.start.Main":
+			info.IsJetty = true
+		case arg == "org.eclipse.jetty.xml.XmlConfiguration":
+			// Main class of the JVM forked by start.jar --exec
+			info.IsJetty = true
+			info.Forked = true
+		case strings.HasPrefix(arg, "-Djetty.home="):
+			info.IsJetty = true
+			info.HomeDir = strings.TrimPrefix(arg, "-Djetty.home=")
+		case strings.HasPrefix(arg, "-Djetty.base="):
+			info.IsJetty = true
+			info.BaseDir = strings.TrimPrefix(arg, "-Djetty.base=")
+		case strings.HasPrefix(arg, "jetty.home="):
+			info.HomeDir = strings.TrimPrefix(arg, "jetty.home=")
+		case strings.HasPrefix(arg, "jetty.base="):
+			info.BaseDir = strings.TrimPrefix(arg, "jetty.base=")
+		}
+	}
+
+	if !info.IsJetty {
+		return info
+	}
+
+	if info.HomeDir == "" && info.StartJar != "" && filepath.IsAbs(info.StartJar) {
+		info.HomeDir = filepath.Dir(info.StartJar)
+	}
+
+	// start.jar defaults jetty.base to the working directory
+	if info.BaseDir == "" {
+		info.BaseDir = javaProc.ProcessWorkingDir
+	}
+	if info.BaseDir == "" {
+		info.BaseDir = info.HomeDir
+	}
+
+	return info
+}
+
+// ExtractJettyInfo is a public method to get Jetty information
+func (jp *JavaProcess) ExtractJettyInfo() *JettyInfo {
+	d := &discoverer{}
+	return d.detectJettyDeployment(jp, jp.ProcessCommandArgs)
+}
+
+// IsJetty checks if this is a Jetty process
+func (jp *JavaProcess) IsJetty() bool {
+	return jp.ExtractJettyInfo().IsJetty
+}
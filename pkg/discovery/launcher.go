@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// Launcher script generators
+const (
+	LauncherGradle = "gradle"
+	LauncherMaven  = "maven"
+	LauncherScript = "script"
+)
+
+// LauncherInfo describes an application started through a bin/<app> shell
+// script, such as the ones generated by Gradle's application plugin or the
+// Maven appassembler plugin
+type LauncherInfo struct {
+	IsLauncher bool
+	Script     string // path to bin/<app>
+	AppHome    string
+	AppName    string
+	OptsVar    string // <APP>_OPTS or JAVA_OPTS, read by the script
+	Generator  string // gradle, maven or script
+}
+
+var (
+	optsVarRegex = regexp.MustCompile(`\b([A-Z][A-Z0-9_]*_OPTS)\b`)
+	shellNames   = map[string]bool{"sh": true, "bash": true, "dash": true, "ksh": true, "zsh": true}
+)
+
+// detectLauncherScript finds the start script of a process, either from a
+// parent shell that is still running it or from the layout of the classpath
+func (d *discoverer) detectLauncherScript(javaProc *JavaProcess, cmdArgs []string) *LauncherInfo {
+	info := &LauncherInfo{}
+
+	script := parentScript(javaProc.ProcessParentPID)
+	if script == "" {
+		script = scriptFromArgs(cmdArgs)
+	}
+	if script == "" {
+		return info
+	}
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return info
+	}
+	content := string(data)
+
+	info.Script = script
+	info.AppName = filepath.Base(script)
+	info.AppHome = filepath.Dir(filepath.Dir(script))
+	info.OptsVar = selectOptsVar(content, info.AppName)
+	info.IsLauncher = info.OptsVar != ""
+
+	switch {
+	case strings.Contains(content, "Gradle start up script"):
+		info.Generator = LauncherGradle
+	case strings.Contains(content, "appassembler") || strings.Contains(content, "-Dapp.repo"):
+		info.Generator = LauncherMaven
+	default:
+		info.Generator = LauncherScript
+	}
+
+	return info
+}
+
+// parentScript returns the script run by a parent shell, e.g. "bash bin/app".
+// Scripts that exec java are replaced by the JVM and are found via the classpath.
+func parentScript(ppid int32) string {
+	if ppid <= 1 {
+		return ""
+	}
+
+	parent, err := process.NewProcess(ppid)
+	if err != nil {
+		return ""
+	}
+	args, err := parent.CmdlineSlice()
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+
+	candidate := args[0]
+	if shellNames[filepath.Base(args[0])] {
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return ""
+		}
+		candidate = args[1]
+	}
+
+	if !filepath.IsAbs(candidate) {
+		if cwd, err := parent.Cwd(); err == nil {
+			candidate = filepath.Join(cwd, candidate)
+		}
+	}
+
+	if !isShellScript(candidate) {
+		return ""
+	}
+	return candidate
+}
+
+// scriptFromArgs locates bin/<app> next to the lib/ directory on the classpath
+func scriptFromArgs(cmdArgs []string) string {
+	appHome := ""
+	appName := ""
+
+	for i, arg := range cmdArgs {
+		switch {
+		case strings.HasPrefix(arg, "-Dapp.home="):
+			appHome = strings.TrimPrefix(arg, "-Dapp.home=")
+		case strings.HasPrefix(arg, "-Dapp.name="):
+			appName = strings.TrimPrefix(arg, "-Dapp.name=")
+		case (arg == "-classpath" || arg == "-cp" || arg == "--class-path") && i+1 < len(cmdArgs) && appHome == "":
+			for _, entry := range strings.Split(cmdArgs[i+1], ":") {
+				if filepath.Base(filepath.Dir(entry)) == "lib" {
+					appHome = filepath.Dir(filepath.Dir(entry))
+					break
+				}
+			}
+		}
+	}
+
+	if appHome == "" || !filepath.IsAbs(appHome) {
+		return ""
+	}
+
+	binDir := filepath.Join(appHome, "bin")
+	if appName != "" {
+		if script := filepath.Join(binDir, appName); isShellScript(script) {
+			return script
+		}
+	}
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return ""
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		script := filepath.Join(binDir, entry.Name())
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".bat") || !isShellScript(script) {
+			continue
+		}
+		candidates = append(candidates, script)
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	for _, script := range candidates {
+		if filepath.Base(script) == filepath.Base(appHome) {
+			return script
+		}
+	}
+
+	return ""
+}
+
+// selectOptsVar picks the options variable a start script honours, preferring
+// the application specific <APP>_OPTS over JAVA_OPTS
+func selectOptsVar(content, appName string) string {
+	preferred := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(appName)) + "_OPTS"
+
+	found := make(map[string]bool)
+	var ordered []string
+	for _, match := range optsVarRegex.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if name == "DEFAULT_JVM_OPTS" || found[name] {
+			continue
+		}
+		found[name] = true
+		ordered = append(ordered, name)
+	}
+
+	if found[preferred] {
+		return preferred
+	}
+	for _, name := range ordered {
+		if name != "JAVA_OPTS" {
+			return name
+		}
+	}
+	if found["JAVA_OPTS"] {
+		return "JAVA_OPTS"
+	}
+	return ""
+}
+
+// isShellScript checks if a file starts with a shell shebang
+func isShellScript(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, 64)
+	n, _ := file.Read(header)
+	line := string(header[:n])
+	return strings.HasPrefix(line, "#!") && strings.Contains(strings.SplitN(line, "\n", 2)[0], "sh")
+}
+
+// ExtractLauncherInfo is a public method to get launcher script information
+func (jp *JavaProcess) ExtractLauncherInfo() *LauncherInfo {
+	d := &discoverer{}
+	return d.detectLauncherScript(jp, jp.ProcessCommandArgs)
+}
+
+// IsLauncherScript checks if this process was started by a bin/<app> script
+// that honours an *_OPTS variable
+func (jp *JavaProcess) IsLauncherScript() bool {
+	return jp.ExtractLauncherInfo().IsLauncher
+}
@@ -0,0 +1,103 @@
+package discovery_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+const gradleStartScript = `#!/bin/sh
+#
+##############################################################################
+##
+##  billing-api start up script for UN*X
+##
+##############################################################################
+
+# Add default JVM options here. You can also use JAVA_OPTS and BILLING_API_OPTS to pass JVM options to this script.
+DEFAULT_JVM_OPTS=""
+
+# Gradle start up script generated by the application plugin
+exec "$JAVACMD" "$@"
+`
+
+func TestExtractLauncherInfo(t *testing.T) {
+	appHome := filepath.Join(t.TempDir(), "billing-api")
+	script := filepath.Join(appHome, "bin", "billing-api")
+	writeTestFile(t, script, gradleStartScript)
+	writeTestFile(t, filepath.Join(appHome, "bin", "billing-api.bat"), "@echo off\n")
+
+	proc := &discovery.JavaProcess{
+		ProcessCommandArgs: []string{
+			"java", "-classpath",
+			filepath.Join(appHome, "lib", "billing-api-1.4.0.jar") + ":" + filepath.Join(appHome, "lib", "jackson-core.jar"),
+			"com.example.billing.Main",
+		},
+	}
+
+	info := proc.ExtractLauncherInfo()
+	if !info.IsLauncher {
+		t.Fatalf("expected a launcher script to be detected")
+	}
+	if info.Script != script {
+		t.Errorf("Script = %q, want %q", info.Script, script)
+	}
+	if info.OptsVar != "BILLING_API_OPTS" {
+		t.Errorf("OptsVar = %q, want BILLING_API_OPTS", info.OptsVar)
+	}
+	if info.Generator != discovery.LauncherGradle {
+		t.Errorf("Generator = %q, want %q", info.Generator, discovery.LauncherGradle)
+	}
+}
+
+func TestExtractLauncherInfoWithoutScript(t *testing.T) {
+	proc := &discovery.JavaProcess{
+		ProcessCommandArgs: []string{"java", "-jar", "/opt/app/app.jar"},
+	}
+
+	if proc.IsLauncherScript() {
+		t.Error("plain java -jar process should not be a launcher script")
+	}
+}
+
+func TestExtractJettyInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		cwd      string
+		wantHome string
+		wantBase string
+	}{
+		{
+			name:     "jetty.sh",
+			args:     []string{"java", "-Djetty.home=/opt/jetty", "-Djetty.base=/srv/jetty/shop", "-jar", "/opt/jetty/start.jar"},
+			wantHome: "/opt/jetty",
+			wantBase: "/srv/jetty/shop",
+		},
+		{
+			name:     "Base from working directory",
+			args:     []string{"java", "-jar", "/opt/jetty-home-12.0.5/start.jar"},
+			cwd:      "/srv/jetty/catalog",
+			wantHome: "/opt/jetty-home-12.0.5",
+			wantBase: "/srv/jetty/catalog",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := &discovery.JavaProcess{ProcessCommandArgs: tt.args, ProcessWorkingDir: tt.cwd}
+
+			info := proc.ExtractJettyInfo()
+			if !info.IsJetty {
+				t.Fatalf("expected Jetty to be detected")
+			}
+			if info.HomeDir != tt.wantHome {
+				t.Errorf("HomeDir = %q, want %q", info.HomeDir, tt.wantHome)
+			}
+			if info.BaseDir != tt.wantBase {
+				t.Errorf("BaseDir = %q, want %q", info.BaseDir, tt.wantBase)
+			}
+		})
+	}
+}
@@ -223,6 +223,12 @@ func (d *discoverer) processOne(ctx context.Context, proc *process.Process, opts
 		owner = "unknown"
 	}
 
+	// Get working directory, Jetty uses it as the default jetty.base
+	workingDir, err := proc.Cwd()
+	if err != nil {
+		workingDir = ""
+	}
+
 	// Get process create time
 	createTime, err := proc.CreateTime()
 	if err != nil {
@@ -251,6 +257,7 @@ func (d *discoverer) processOne(ctx context.Context, proc *process.Process, opts
 		ProcessCommandArgs:    cmdArgs,
 		ProcessOwner:          owner,
 		ProcessCreateTime:     createTimeStamp,
+		ProcessWorkingDir:     workingDir,
 		Status:                statusStr,
 
 		// Java runtime information
@@ -276,6 +283,32 @@ func (d *discoverer) processOne(ctx context.Context, proc *process.Process, opts
 			}
 		}
 	}
+
+	wildFlyInfo := d.detectWildFlyDeployment(cmdArgs)
+	if wildFlyInfo.IsWildFly {
+		// jboss-modules.jar is the launcher, not the application
+		if javaProc.ServiceName == "" || javaProc.ServiceName == "java-service" || javaProc.ServiceName == "jboss-modules" {
+			javaProc.ServiceName = "wildfly"
+		}
+	}
+
+	jettyInfo := d.detectJettyDeployment(javaProc, cmdArgs)
+	if jettyInfo.IsJetty {
+		// start.jar is the launcher, not the application
+		if javaProc.ServiceName == "" || javaProc.ServiceName == "java-service" || javaProc.ServiceName == "start" {
+			javaProc.ServiceName = "jetty"
+		}
+	}
+
+	if !tomcatInfo.IsTomcat && !wildFlyInfo.IsWildFly && !jettyInfo.IsJetty {
+		launcherInfo := d.detectLauncherScript(javaProc, cmdArgs)
+		if launcherInfo.IsLauncher && launcherInfo.AppName != "" {
+			if javaProc.ServiceName == "" || javaProc.ServiceName == "java-service" {
+				javaProc.ServiceName = launcherInfo.AppName
+			}
+		}
+	}
+
 	// Detect instrumentation
 	d.detectInstrumentation(javaProc, cmdArgs)
 
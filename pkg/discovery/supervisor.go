@@ -0,0 +1,245 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// Process supervisors
+const (
+	SupervisorNone        = "standalone"
+	SupervisorSystemd     = "systemd"
+	SupervisorSupervisord = "supervisord"
+	SupervisorRunit       = "runit"
+	SupervisorS6          = "s6"
+	SupervisorOpenRC      = "openrc"
+	SupervisorSysV        = "sysv"
+)
+
+// maxParentDepth bounds the walk up the process tree
+const maxParentDepth = 16
+
+// SupervisorInfo describes what keeps a process running
+type SupervisorInfo struct {
+	Kind       string
+	Name       string // unit, program, service or init script name
+	ServiceDir string // runit / s6 service directory
+	ConfigPath string // supervisord.conf or /etc/init.d script
+}
+
+// DetectSupervisor finds the supervisor of a running process from its
+// environment, its parent chain and finally its systemd cgroup
+func (jp *JavaProcess) DetectSupervisor() *SupervisorInfo {
+	proc, err := process.NewProcess(jp.ProcessPID)
+	if err != nil {
+		return &SupervisorInfo{Kind: SupervisorNone}
+	}
+
+	// supervisord and OpenRC export the service name to their children
+	if env, err := proc.Environ(); err == nil {
+		vars := environMap(env)
+		if name := vars["SUPERVISOR_GROUP_NAME"]; name != "" {
+			return &SupervisorInfo{
+				Kind:       SupervisorSupervisord,
+				Name:       name,
+				ConfigPath: findSupervisordConfig(proc),
+			}
+		}
+		if name := vars["RC_SVCNAME"]; name != "" {
+			return &SupervisorInfo{
+				Kind:       SupervisorOpenRC,
+				Name:       name,
+				ConfigPath: filepath.Join("/etc/init.d", name),
+			}
+		}
+	}
+
+	if info := supervisorFromParents(proc); info != nil {
+		return info
+	}
+
+	if unit := SystemdUnitForPID(jp.ProcessPID); unit != "" {
+		return &SupervisorInfo{Kind: SupervisorSystemd, Name: unit}
+	}
+
+	return &SupervisorInfo{Kind: SupervisorNone}
+}
+
+// supervisorFromParents walks up the process tree looking for a supervisor
+func supervisorFromParents(proc *process.Process) *SupervisorInfo {
+	current := proc
+	for depth := 0; depth < maxParentDepth; depth++ {
+		parent, err := current.Parent()
+		if err != nil || parent == nil || parent.Pid <= 1 {
+			return nil
+		}
+
+		args, _ := parent.CmdlineSlice()
+		name, _ := parent.Name()
+		if len(args) > 0 {
+			name = filepath.Base(args[0])
+		}
+
+		switch name {
+		case "supervisord":
+			// The program name is only known from SUPERVISOR_GROUP_NAME
+			return &SupervisorInfo{
+				Kind:       SupervisorSupervisord,
+				ConfigPath: supervisordConfigArg(args),
+			}
+		case "runsv":
+			return &SupervisorInfo{
+				Kind:       SupervisorRunit,
+				Name:       serviceNameFromArgs(args),
+				ServiceDir: serviceDir(parent, args),
+			}
+		case "s6-supervise":
+			return &SupervisorInfo{
+				Kind:       SupervisorS6,
+				Name:       serviceNameFromArgs(args),
+				ServiceDir: serviceDir(parent, args),
+			}
+		case "supervise-daemon", "openrc-run", "openrc-run.sh":
+			return &SupervisorInfo{
+				Kind:       SupervisorOpenRC,
+				Name:       serviceNameFromArgs(args),
+				ConfigPath: filepath.Join("/etc/init.d", serviceNameFromArgs(args)),
+			}
+		}
+
+		// SysV init scripts that run the JVM in the foreground
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "/etc/init.d/") || strings.HasPrefix(arg, "/etc/rc.d/init.d/") {
+				return &SupervisorInfo{
+					Kind:       SupervisorSysV,
+					Name:       filepath.Base(arg),
+					ConfigPath: arg,
+				}
+			}
+		}
+
+		current = parent
+	}
+
+	return nil
+}
+
+// serviceNameFromArgs returns the service argument of a supervisor process,
+// e.g. "runsv myapp" or "supervise-daemon myapp --start ..."
+func serviceNameFromArgs(args []string) string {
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return filepath.Base(arg)
+		}
+	}
+	return ""
+}
+
+// serviceDir returns the service directory of runsv / s6-supervise, both of
+// which chdir into it
+func serviceDir(proc *process.Process, args []string) string {
+	if cwd, err := proc.Cwd(); err == nil && cwd != "/" {
+		return cwd
+	}
+	for _, arg := range args[1:] {
+		if filepath.IsAbs(arg) {
+			return arg
+		}
+	}
+	return ""
+}
+
+// findSupervisordConfig finds the config of the supervisord running a process
+func findSupervisordConfig(proc *process.Process) string {
+	current := proc
+	for depth := 0; depth < maxParentDepth; depth++ {
+		parent, err := current.Parent()
+		if err != nil || parent == nil || parent.Pid <= 1 {
+			break
+		}
+		args, _ := parent.CmdlineSlice()
+		for _, arg := range args {
+			if strings.Contains(filepath.Base(arg), "supervisord") {
+				return supervisordConfigArg(args)
+			}
+		}
+		current = parent
+	}
+	return supervisordConfigArg(nil)
+}
+
+// supervisordConfigArg returns the -c / --configuration argument of
+// supervisord, falling back to the default locations
+func supervisordConfigArg(args []string) string {
+	for i, arg := range args {
+		switch {
+		case (arg == "-c" || arg == "--configuration") && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--configuration="):
+			return strings.TrimPrefix(arg, "--configuration=")
+		}
+	}
+
+	for _, path := range []string{"/etc/supervisor/supervisord.conf", "/etc/supervisord.conf"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// SystemdUnitForPID returns the .service unit a process belongs to
+func SystemdUnitForPID(pid int32) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	return UnitFromCgroup(string(data))
+}
+
+// UnitFromCgroup extracts the innermost .service unit from /proc/<pid>/cgroup.
+// Units run by a user manager are returned with their manager, e.g.
+// "user@1000.service/app.service".
+func UnitFromCgroup(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		unit := ""
+		manager := ""
+		for _, segment := range strings.Split(parts[2], "/") {
+			switch {
+			case strings.HasPrefix(segment, "user@") && strings.HasSuffix(segment, ".service"):
+				manager = segment
+				unit = ""
+			case strings.HasSuffix(segment, ".service"):
+				unit = segment
+			}
+		}
+
+		if unit != "" && manager != "" {
+			return manager + "/" + unit
+		}
+		if unit != "" {
+			return unit
+		}
+	}
+	return ""
+}
+
+// environMap converts KEY=VALUE pairs into a map
+func environMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
@@ -0,0 +1,29 @@
+package discovery_test
+
+import (
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+func TestUnitFromCgroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{"System service", "0::/system.slice/billing.service\n", "billing.service"},
+		{"User service", "0::/user.slice/user-1000.slice/user@1000.service/app.slice/catalog.service\n", "user@1000.service/catalog.service"},
+		{"User manager", "0::/user.slice/user-1000.slice/user@1000.service/init.scope\n", ""},
+		{"Login session", "0::/user.slice/user-1000.slice/session-4.scope\n", ""},
+		{"cgroup v1", "12:pids:/system.slice/orders.service\n1:name=systemd:/system.slice/orders.service\n", "orders.service"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discovery.UnitFromCgroup(tt.cgroup); got != tt.want {
+				t.Errorf("UnitFromCgroup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
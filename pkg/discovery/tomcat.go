@@ -1,11 +1,11 @@
 package discovery
 
 import (
-	"fmt"
-	"io/ioutil"
+	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery/internal"
 )
 
 // TomcatInfo contains information about a Tomcat deployment
@@ -14,9 +14,12 @@ type TomcatInfo struct {
 	CatalinaBase  string
 	CatalinaHome  string
 	InstanceName  string
+	Version       string
 	Webapps       []string
 	ServerXMLPath string
 	Port          int
+	Connectors    []TomcatConnector
+	Hosts         []TomcatHost
 }
 
 // detectTomcatDeployment checks if this is a Tomcat process and extracts info
@@ -59,23 +62,30 @@ func (d *discoverer) detectTomcatDeployment(javaProc *JavaProcess, cmdArgs []str
 		}
 	}
 
-	// Discover webapps
-	if tomcatInfo.CatalinaBase != "" {
-		webapps := d.discoverTomcatWebapps(tomcatInfo.CatalinaBase)
-		tomcatInfo.Webapps = webapps
+	if tomcatInfo.CatalinaBase == "" {
+		return tomcatInfo
 	}
 
-	// Get server.xml path
-	if tomcatInfo.CatalinaBase != "" {
-		tomcatInfo.ServerXMLPath = filepath.Join(tomcatInfo.CatalinaBase, "conf", "server.xml")
+	home := tomcatInfo.CatalinaHome
+	if home == "" {
+		home = tomcatInfo.CatalinaBase
+	}
+	tomcatInfo.Version = DetectTomcatVersion(home)
+	tomcatInfo.ServerXMLPath = filepath.Join(tomcatInfo.CatalinaBase, "conf", "server.xml")
 
-		// Try to extract port from server.xml
-		port := d.extractTomcatPort(tomcatInfo.ServerXMLPath)
-		if port > 0 {
-			tomcatInfo.Port = port
-		}
+	// Parse server.xml and context descriptors for the full deployment model
+	server, err := ParseTomcatServer(tomcatInfo.CatalinaBase, tomcatInfo.CatalinaHome, internal.ExtractSystemProperties(cmdArgs))
+	if err != nil {
+		// Fall back to listing the default appBase
+		tomcatInfo.Webapps = d.discoverTomcatWebapps(tomcatInfo.CatalinaBase)
+		return tomcatInfo
 	}
 
+	tomcatInfo.Connectors = server.Connectors
+	tomcatInfo.Hosts = server.Hosts
+	tomcatInfo.Webapps = server.Webapps()
+	tomcatInfo.Port = server.HTTPPort()
+
 	return tomcatInfo
 }
 
@@ -83,7 +93,7 @@ func (d *discoverer) detectTomcatDeployment(javaProc *JavaProcess, cmdArgs []str
 func (d *discoverer) discoverTomcatWebapps(catalinaBase string) []string {
 	webappsDir := filepath.Join(catalinaBase, "webapps")
 
-	dirs, err := ioutil.ReadDir(webappsDir)
+	dirs, err := os.ReadDir(webappsDir)
 	if err != nil {
 		return []string{}
 	}
@@ -107,26 +117,6 @@ func (d *discoverer) discoverTomcatWebapps(catalinaBase string) []string {
 	return webapps
 }
 
-// extractTomcatPort tries to extract the HTTP port from server.xml
-func (d *discoverer) extractTomcatPort(serverXMLPath string) int {
-	content, err := ioutil.ReadFile(serverXMLPath)
-	if err != nil {
-		return 0
-	}
-
-	// Look for <Connector port="8080" protocol="HTTP/1.1"
-	re := regexp.MustCompile(`<Connector[^>]*port="(\d+)"[^>]*protocol="HTTP`)
-	matches := re.FindSubmatch(content)
-
-	if len(matches) > 1 {
-		var port int
-		fmt.Sscanf(string(matches[1]), "%d", &port)
-		return port
-	}
-
-	return 0
-}
-
 // ExtractTomcatInfo is a public method to get Tomcat information
 func (jp *JavaProcess) ExtractTomcatInfo() *TomcatInfo {
 	d := &discoverer{}
@@ -0,0 +1,416 @@
+package discovery
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TomcatConnector describes a <Connector> declared in server.xml
+type TomcatConnector struct {
+	Service  string `json:"service"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Scheme   string `json:"scheme"`
+	TLS      bool   `json:"tls"`
+	IsAJP    bool   `json:"ajp"`
+}
+
+// TomcatHost describes a virtual <Host> and the contexts deployed to it
+type TomcatHost struct {
+	Service  string          `json:"service"`
+	Engine   string          `json:"engine"`
+	Name     string          `json:"name"`
+	AppBase  string          `json:"app_base"`
+	Contexts []TomcatContext `json:"contexts"`
+}
+
+// TomcatContext describes a deployed web application
+type TomcatContext struct {
+	Path    string `json:"path"`
+	DocBase string `json:"doc_base"`
+	Source  string `json:"source"` // server.xml, context file or appBase
+}
+
+// TomcatServer is the parsed model of a Tomcat instance's configuration
+type TomcatServer struct {
+	Connectors []TomcatConnector `json:"connectors"`
+	Hosts      []TomcatHost      `json:"hosts"`
+}
+
+// Context sources
+const (
+	ContextSourceServerXML   = "server.xml"
+	ContextSourceContextFile = "context file"
+	ContextSourceAppBase     = "appBase"
+)
+
+// Raw server.xml structure, only the elements we care about
+type serverXML struct {
+	Services []struct {
+		Name       string `xml:"name,attr"`
+		Connectors []struct {
+			Port          string     `xml:"port,attr"`
+			Protocol      string     `xml:"protocol,attr"`
+			Scheme        string     `xml:"scheme,attr"`
+			Secure        string     `xml:"secure,attr"`
+			SSLEnabled    string     `xml:"SSLEnabled,attr"`
+			SSLHostConfig []struct{} `xml:"SSLHostConfig"`
+		} `xml:"Connector"`
+		Engine struct {
+			Name  string `xml:"name,attr"`
+			Hosts []struct {
+				Name     string       `xml:"name,attr"`
+				AppBase  string       `xml:"appBase,attr"`
+				Contexts []contextXML `xml:"Context"`
+			} `xml:"Host"`
+		} `xml:"Engine"`
+	} `xml:"Service"`
+}
+
+type contextXML struct {
+	Path    string `xml:"path,attr"`
+	DocBase string `xml:"docBase,attr"`
+}
+
+var propertyRefPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// ParseTomcatServer parses conf/server.xml and per-host context files of a
+// Tomcat instance. sysProps holds -D system properties of the running JVM and
+// takes precedence over conf/catalina.properties for ${} substitution.
+func ParseTomcatServer(catalinaBase, catalinaHome string, sysProps map[string]string) (*TomcatServer, error) {
+	if catalinaHome == "" {
+		catalinaHome = catalinaBase
+	}
+
+	props := readJavaProperties(filepath.Join(catalinaBase, "conf", "catalina.properties"))
+	props["catalina.base"] = catalinaBase
+	props["catalina.home"] = catalinaHome
+	for k, v := range sysProps {
+		props[k] = v
+	}
+	expand := func(value string) string {
+		return expandProperties(value, props)
+	}
+
+	content, err := os.ReadFile(filepath.Join(catalinaBase, "conf", "server.xml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server.xml: %w", err)
+	}
+
+	var raw serverXML
+	if err := xml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse server.xml: %w", err)
+	}
+
+	server := &TomcatServer{}
+	for _, svc := range raw.Services {
+		for _, c := range svc.Connectors {
+			port, err := strconv.Atoi(expand(c.Port))
+			if err != nil {
+				continue
+			}
+
+			connector := TomcatConnector{
+				Service:  svc.Name,
+				Port:     port,
+				Protocol: expand(c.Protocol),
+				Scheme:   expand(c.Scheme),
+				TLS:      isTrue(expand(c.SSLEnabled)) || len(c.SSLHostConfig) > 0,
+			}
+			if connector.Protocol == "" {
+				connector.Protocol = "HTTP/1.1"
+			}
+			connector.IsAJP = strings.Contains(strings.ToUpper(connector.Protocol), "AJP")
+			if connector.Scheme == "" {
+				switch {
+				case connector.IsAJP:
+					connector.Scheme = "ajp"
+				case connector.TLS || isTrue(expand(c.Secure)):
+					connector.Scheme = "https"
+				default:
+					connector.Scheme = "http"
+				}
+			}
+			server.Connectors = append(server.Connectors, connector)
+		}
+
+		engine := svc.Engine.Name
+		if engine == "" {
+			engine = "Catalina"
+		}
+
+		for _, h := range svc.Engine.Hosts {
+			host := TomcatHost{
+				Service: svc.Name,
+				Engine:  engine,
+				Name:    expand(h.Name),
+				AppBase: resolveTomcatPath(catalinaBase, expand(h.AppBase)),
+			}
+			if host.AppBase == "" {
+				host.AppBase = filepath.Join(catalinaBase, "webapps")
+			}
+
+			seen := make(map[string]bool)
+			add := func(ctx TomcatContext) {
+				if !seen[ctx.Path] {
+					seen[ctx.Path] = true
+					host.Contexts = append(host.Contexts, ctx)
+				}
+			}
+
+			// Contexts declared inline have the highest precedence
+			for _, c := range h.Contexts {
+				add(TomcatContext{
+					Path:    normalizeContextPath(expand(c.Path)),
+					DocBase: resolveTomcatPath(host.AppBase, expand(c.DocBase)),
+					Source:  ContextSourceServerXML,
+				})
+			}
+
+			// conf/<Engine>/<Host>/*.xml context descriptors
+			for _, ctx := range readContextFiles(filepath.Join(catalinaBase, "conf", engine, host.Name), host.AppBase, expand) {
+				add(ctx)
+			}
+
+			// Auto-deployed directories and WARs in appBase
+			for _, ctx := range scanAppBase(host.AppBase) {
+				add(ctx)
+			}
+
+			sort.Slice(host.Contexts, func(i, j int) bool {
+				return host.Contexts[i].Path < host.Contexts[j].Path
+			})
+			server.Hosts = append(server.Hosts, host)
+		}
+	}
+
+	return server, nil
+}
+
+// HTTPPort returns the first plain HTTP connector port, falling back to the
+// first HTTPS connector, or 0 if neither exists
+func (s *TomcatServer) HTTPPort() int {
+	fallback := 0
+	for _, c := range s.Connectors {
+		if c.IsAJP {
+			continue
+		}
+		if !c.TLS {
+			return c.Port
+		}
+		if fallback == 0 {
+			fallback = c.Port
+		}
+	}
+	return fallback
+}
+
+// Webapps returns the deployed webapp names across all hosts, using Tomcat's
+// naming convention ('#' for nested paths) and skipping ROOT and the
+// management applications
+func (s *TomcatServer) Webapps() []string {
+	var webapps []string
+	seen := make(map[string]bool)
+
+	for _, host := range s.Hosts {
+		for _, ctx := range host.Contexts {
+			name := strings.ReplaceAll(strings.TrimPrefix(ctx.Path, "/"), "/", "#")
+			if name == "" || name == "manager" || name == "host-manager" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			webapps = append(webapps, name)
+		}
+	}
+
+	return webapps
+}
+
+// DetectTomcatVersion reads the version from lib/catalina.jar's
+// ServerInfo.properties, returning "" if it cannot be determined
+func DetectTomcatVersion(catalinaHome string) string {
+	reader, err := zip.OpenReader(filepath.Join(catalinaHome, "lib", "catalina.jar"))
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if file.Name != "org/apache/catalina/util/ServerInfo.properties" {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return ""
+		}
+		defer rc.Close()
+
+		props := parseJavaProperties(rc)
+		// server.info=Apache Tomcat/9.0.80
+		if info := props["server.info"]; strings.Contains(info, "/") {
+			return info[strings.LastIndex(info, "/")+1:]
+		}
+		return props["server.number"]
+	}
+
+	return ""
+}
+
+// readContextFiles parses context descriptors in conf/<Engine>/<Host>
+func readContextFiles(dir, appBase string, expand func(string) string) []TomcatContext {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var contexts []TomcatContext
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".xml") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var ctx contextXML
+		if err := xml.Unmarshal(content, &ctx); err != nil {
+			continue
+		}
+
+		// The context path is derived from the file name, path attributes are ignored
+		name := strings.TrimSuffix(entry.Name(), ".xml")
+		docBase := resolveTomcatPath(appBase, expand(ctx.DocBase))
+		if docBase == "" {
+			docBase = filepath.Join(appBase, name)
+		}
+
+		contexts = append(contexts, TomcatContext{
+			Path:    contextPathFromName(name),
+			DocBase: docBase,
+			Source:  ContextSourceContextFile,
+		})
+	}
+
+	return contexts
+}
+
+// scanAppBase lists auto-deployed directories and WAR files in appBase
+func scanAppBase(appBase string) []TomcatContext {
+	entries, err := os.ReadDir(appBase)
+	if err != nil {
+		return nil
+	}
+
+	var contexts []TomcatContext
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			if !strings.HasSuffix(name, ".war") {
+				continue
+			}
+			name = strings.TrimSuffix(name, ".war")
+		}
+
+		contexts = append(contexts, TomcatContext{
+			Path:    contextPathFromName(name),
+			DocBase: filepath.Join(appBase, entry.Name()),
+			Source:  ContextSourceAppBase,
+		})
+	}
+
+	return contexts
+}
+
+// contextPathFromName converts a Tomcat base name (ROOT, api, v2#admin) to a context path
+func contextPathFromName(name string) string {
+	// Parallel deployment versions: app##2 -> app
+	if idx := strings.Index(name, "##"); idx != -1 {
+		name = name[:idx]
+	}
+	if name == "ROOT" || name == "" {
+		return "/"
+	}
+	return "/" + strings.ReplaceAll(name, "#", "/")
+}
+
+// normalizeContextPath normalizes a path attribute ("" and "/" are both ROOT)
+func normalizeContextPath(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// resolveTomcatPath resolves a possibly relative path against base
+func resolveTomcatPath(base, path string) string {
+	if path == "" {
+		return ""
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// expandProperties substitutes ${name} references, leaving unknown ones intact
+func expandProperties(value string, props map[string]string) string {
+	return propertyRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if v, ok := props[ref[2:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// readJavaProperties reads a .properties file, returning an empty map on error
+func readJavaProperties(path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return map[string]string{}
+	}
+	defer file.Close()
+
+	return parseJavaProperties(file)
+}
+
+// parseJavaProperties parses the simple key=value / key:value subset of the
+// .properties format used by Tomcat
+func parseJavaProperties(r io.Reader) map[string]string {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		idx := strings.IndexAny(line, "=:")
+		if idx == -1 {
+			continue
+		}
+		props[strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+	}
+
+	return props
+}
+
+func isTrue(value string) bool {
+	return strings.EqualFold(value, "true")
+}
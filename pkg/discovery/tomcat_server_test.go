@@ -0,0 +1,126 @@
+package discovery_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+const testServerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Server port="8005" shutdown="SHUTDOWN">
+  <Service name="Catalina">
+    <Connector protocol="HTTP/1.1" port="${http.port}" connectionTimeout="20000" redirectPort="8443"/>
+    <Connector port="8443" protocol="org.apache.coyote.http11.Http11NioProtocol" SSLEnabled="true">
+      <SSLHostConfig>
+        <Certificate certificateKeystoreFile="conf/localhost-rsa.jks" type="RSA"/>
+      </SSLHostConfig>
+    </Connector>
+    <Connector protocol="AJP/1.3" port="8009"/>
+    <Engine name="Catalina" defaultHost="localhost">
+      <Host name="localhost" appBase="webapps">
+        <Context path="/legacy" docBase="/srv/legacy-app"/>
+      </Host>
+    </Engine>
+  </Service>
+  <Service name="Internal">
+    <Connector port="9080"/>
+    <Engine name="Internal" defaultHost="admin.local">
+      <Host name="admin.local" appBase="${catalina.base}/admin-apps"/>
+    </Engine>
+  </Service>
+</Server>
+`
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTomcatServer(t *testing.T) {
+	base := t.TempDir()
+	writeTestFile(t, filepath.Join(base, "conf", "server.xml"), testServerXML)
+	writeTestFile(t, filepath.Join(base, "conf", "catalina.properties"), "http.port=8081\n")
+	writeTestFile(t, filepath.Join(base, "conf", "Catalina", "localhost", "reports.xml"),
+		`<Context docBase="/data/reports.war"/>`)
+	writeTestFile(t, filepath.Join(base, "webapps", "shop", "index.html"), "")
+	writeTestFile(t, filepath.Join(base, "webapps", "ROOT", "index.html"), "")
+	writeTestFile(t, filepath.Join(base, "webapps", "api.war"), "")
+	writeTestFile(t, filepath.Join(base, "admin-apps", "console", "index.html"), "")
+
+	server, err := discovery.ParseTomcatServer(base, "", map[string]string{})
+	if err != nil {
+		t.Fatalf("ParseTomcatServer failed: %v", err)
+	}
+
+	expectedConnectors := []discovery.TomcatConnector{
+		{Service: "Catalina", Port: 8081, Protocol: "HTTP/1.1", Scheme: "http"},
+		{Service: "Catalina", Port: 8443, Protocol: "org.apache.coyote.http11.Http11NioProtocol", Scheme: "https", TLS: true},
+		{Service: "Catalina", Port: 8009, Protocol: "AJP/1.3", Scheme: "ajp", IsAJP: true},
+		{Service: "Internal", Port: 9080, Protocol: "HTTP/1.1", Scheme: "http"},
+	}
+	if !reflect.DeepEqual(server.Connectors, expectedConnectors) {
+		t.Errorf("Connectors = %+v, expected %+v", server.Connectors, expectedConnectors)
+	}
+
+	if port := server.HTTPPort(); port != 8081 {
+		t.Errorf("HTTPPort() = %d, expected 8081", port)
+	}
+
+	if len(server.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(server.Hosts))
+	}
+
+	expectedContexts := []discovery.TomcatContext{
+		{Path: "/", DocBase: filepath.Join(base, "webapps", "ROOT"), Source: discovery.ContextSourceAppBase},
+		{Path: "/api", DocBase: filepath.Join(base, "webapps", "api.war"), Source: discovery.ContextSourceAppBase},
+		{Path: "/legacy", DocBase: "/srv/legacy-app", Source: discovery.ContextSourceServerXML},
+		{Path: "/reports", DocBase: "/data/reports.war", Source: discovery.ContextSourceContextFile},
+		{Path: "/shop", DocBase: filepath.Join(base, "webapps", "shop"), Source: discovery.ContextSourceAppBase},
+	}
+	if !reflect.DeepEqual(server.Hosts[0].Contexts, expectedContexts) {
+		t.Errorf("localhost contexts = %+v, expected %+v", server.Hosts[0].Contexts, expectedContexts)
+	}
+
+	if appBase := server.Hosts[1].AppBase; appBase != filepath.Join(base, "admin-apps") {
+		t.Errorf("admin.local appBase = %s, expected property to be expanded", appBase)
+	}
+
+	expectedWebapps := []string{"api", "legacy", "reports", "shop", "console"}
+	if webapps := server.Webapps(); !reflect.DeepEqual(webapps, expectedWebapps) {
+		t.Errorf("Webapps() = %v, expected %v", webapps, expectedWebapps)
+	}
+}
+
+func TestDetectTomcatVersion(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := os.Create(filepath.Join(home, "lib", "catalina.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(jar)
+	f, _ := w.Create("org/apache/catalina/util/ServerInfo.properties")
+	f.Write([]byte("server.info=Apache Tomcat/9.0.80\nserver.number=9.0.80.0\n"))
+	w.Close()
+	jar.Close()
+
+	if version := discovery.DetectTomcatVersion(home); version != "9.0.80" {
+		t.Errorf("DetectTomcatVersion() = %q, expected 9.0.80", version)
+	}
+
+	if version := discovery.DetectTomcatVersion(t.TempDir()); version != "" {
+		t.Errorf("DetectTomcatVersion() without catalina.jar = %q, expected empty", version)
+	}
+}
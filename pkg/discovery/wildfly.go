@@ -0,0 +1,99 @@
+package discovery
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// WildFly operating modes
+const (
+	WildFlyModeStandalone = "standalone"
+	WildFlyModeDomain     = "domain"
+)
+
+// WildFlyInfo contains information about a WildFly / JBoss EAP deployment
+type WildFlyInfo struct {
+	IsWildFly    bool
+	HomeDir      string // jboss.home.dir
+	BaseDir      string // jboss.server.base.dir or jboss.domain.base.dir
+	Mode         string // standalone or domain
+	ServerConfig string // standalone.xml, standalone-ha.xml, ...
+	ProcessType  string // Standalone, Server:<name>, Host Controller, Process Controller
+	ModulesJar   string // path to jboss-modules.jar
+	ConfigDir    string
+}
+
+// detectWildFlyDeployment checks if this is a WildFly / JBoss EAP process and extracts info
+func (d *discoverer) detectWildFlyDeployment(cmdArgs []string) *WildFlyInfo {
+	info := &WildFlyInfo{}
+
+	for i, arg := range cmdArgs {
+		switch {
+		case strings.HasSuffix(arg, "jboss-modules.jar"):
+			info.IsWildFly = true
+			info.ModulesJar = arg
+		case arg == "org.jboss.modules.Main" || arg == "org.jboss.as.standalone" ||
+			arg == "org.jboss.as.host-controller" || arg == "org.jboss.as.process-controller":
+			info.IsWildFly = true
+		case strings.HasPrefix(arg, "-Djboss.home.dir="):
+			info.IsWildFly = true
+			info.HomeDir = strings.TrimPrefix(arg, "-Djboss.home.dir=")
+		case strings.HasPrefix(arg, "-Djboss.server.base.dir="):
+			info.BaseDir = strings.TrimPrefix(arg, "-Djboss.server.base.dir=")
+		case strings.HasPrefix(arg, "-Djboss.domain.base.dir="):
+			info.BaseDir = strings.TrimPrefix(arg, "-Djboss.domain.base.dir=")
+			info.Mode = WildFlyModeDomain
+		case strings.HasPrefix(arg, "-Djboss.server.config.dir="):
+			info.ConfigDir = strings.TrimPrefix(arg, "-Djboss.server.config.dir=")
+		case strings.HasPrefix(arg, "-D[") && strings.HasSuffix(arg, "]"):
+			// Process markers added by the launch scripts: -D[Standalone], -D[Server:server-one]
+			info.ProcessType = strings.TrimSuffix(strings.TrimPrefix(arg, "-D["), "]")
+		case strings.HasPrefix(arg, "--server-config="):
+			info.ServerConfig = strings.TrimPrefix(arg, "--server-config=")
+		case (arg == "-c" || arg == "--server-config") && i+1 < len(cmdArgs):
+			info.ServerConfig = cmdArgs[i+1]
+		}
+	}
+
+	if !info.IsWildFly {
+		return info
+	}
+
+	if info.Mode == "" {
+		switch {
+		case info.ProcessType == "Standalone":
+			info.Mode = WildFlyModeStandalone
+		case strings.HasPrefix(info.ProcessType, "Server:") ||
+			info.ProcessType == "Host Controller" || info.ProcessType == "Process Controller":
+			info.Mode = WildFlyModeDomain
+		default:
+			info.Mode = WildFlyModeStandalone
+		}
+	}
+
+	// jboss-modules.jar lives directly in jboss.home.dir
+	if info.HomeDir == "" && info.ModulesJar != "" {
+		info.HomeDir = filepath.Dir(info.ModulesJar)
+	}
+
+	if info.BaseDir == "" && info.HomeDir != "" {
+		info.BaseDir = filepath.Join(info.HomeDir, info.Mode)
+	}
+
+	if info.ServerConfig == "" && info.Mode == WildFlyModeStandalone {
+		info.ServerConfig = "standalone.xml"
+	}
+
+	return info
+}
+
+// ExtractWildFlyInfo is a public method to get WildFly information
+func (jp *JavaProcess) ExtractWildFlyInfo() *WildFlyInfo {
+	d := &discoverer{}
+	return d.detectWildFlyDeployment(jp.ProcessCommandArgs)
+}
+
+// IsWildFly checks if this is a WildFly / JBoss EAP process
+func (jp *JavaProcess) IsWildFly() bool {
+	return jp.ExtractWildFlyInfo().IsWildFly
+}
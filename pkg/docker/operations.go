@@ -7,21 +7,21 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/k0kubun/pp"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 	"github.com/middleware-labs/java-injector/pkg/config"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	// DefaultAgentPath is the default path to mount the agent in containers
 	DefaultContainerAgentPath = "/opt/middleware/agents/middleware-javaagent.jar"
-
-	// StateFile stores instrumented container information
-	StateFile = "/etc/middleware/docker/instrumented.json"
 )
 
 // DockerOperations handles Docker container instrumentation operations
@@ -29,6 +29,10 @@ type DockerOperations struct {
 	ctx           context.Context
 	discoverer    *discovery.DockerDiscoverer
 	hostAgentPath string
+
+	// Confirm asks whether to instrument a compose service that already
+	// looks instrumented, nil declines
+	Confirm func(question string) bool
 }
 
 // NewDockerOperations creates a new Docker operations handler
@@ -109,6 +113,11 @@ type ContainerState struct {
 
 	RecreationCommand string `json:"recreation_command,omitempty"`
 	OriginalConfig    string `json:"original_config,omitempty"`
+
+	// Secrets are kept in root-only env files, never in this state file
+	EnvFile             string `json:"env_file,omitempty"`
+	OriginalEnvFile     string `json:"original_env_file,omitempty"`
+	InstrumentedCommand string `json:"instrumented_command,omitempty"`
 }
 
 // InstrumentContainer instruments a specific Docker container
@@ -143,13 +152,23 @@ func (do *DockerOperations) instrumentStandaloneContainer(container *discovery.D
 	}
 
 	// Save original configuration as JSON string for restoration
-	originalConfigBytes, err := json.Marshal(containerConfig)
+	originalConfig, err := redactedConfig(containerConfig)
 	if err != nil {
 		return fmt.Errorf("failed to serialize original config: %w", err)
 	}
 
-	// Build original recreation command from current state (before instrumentation)
-	originalRecreationCommand := do.buildOriginalDockerRunCommand(containerConfig, container.ContainerName)
+	// Build original recreation command from current state (before instrumentation).
+	// The original environment may hold secrets, so it goes to a root-only env file.
+	originalEnvFile := filepath.Join(secrets.ContainerDir, container.ContainerName+".original.env")
+	if err := secrets.WriteEnvFile(originalEnvFile, originalEnv(containerConfig), -1, -1); err != nil {
+		return fmt.Errorf("failed to save original environment: %w", err)
+	}
+	originalRecreationCommand := do.buildOriginalDockerRunCommand(containerConfig, originalEnvFile, container.ContainerName)
+
+	// Undo recreates the original container before its env file is removed
+	tx := changeset.Current()
+	tx.OnUndoFirst(exec.Command("docker", "rm", "-f", container.ContainerName))
+	tx.OnUndoFirst(exec.Command("sh", "-c", originalRecreationCommand))
 
 	// Step 2: Copy agent to container
 	if err := do.copyAgentToContainer(container.ContainerID); err != nil {
@@ -159,6 +178,10 @@ func (do *DockerOperations) instrumentStandaloneContainer(container *discovery.D
 
 	// Step 3: Build new environment variables with instrumentation
 	newEnv := do.buildInstrumentationEnv(container, cfg)
+	envFile := filepath.Join(secrets.ContainerDir, container.ContainerName+".env")
+	if err := secrets.WriteEnvFile(envFile, sortedEnv(newEnv), -1, -1); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
 
 	// Step 4: Stop the container
 	fmt.Println("   🛑 Stopping container...")
@@ -180,13 +203,22 @@ func (do *DockerOperations) instrumentStandaloneContainer(container *discovery.D
 	}
 
 	// Step 7: Recreate container with instrumentation using the committed image
-	instrumentedRunCommand := do.buildInstrumentedDockerRunCommand(containerConfig, newEnv, container.ContainerName, newImageName)
+	instrumentedRunCommand := do.buildInstrumentedDockerRunCommand(containerConfig, envFile, container.ContainerName, newImageName)
 	if err := do.runContainer(instrumentedRunCommand); err != nil {
-		return fmt.Errorf("failed to recreate container: %w", err)
+		return do.rollbackStandalone(container.ContainerName, originalRecreationCommand, fmt.Errorf("failed to recreate container: %w", err))
+	}
+	if err := do.waitRunning(container.ContainerName); err != nil {
+		return do.rollbackStandalone(container.ContainerName, originalRecreationCommand, err)
 	}
 
 	// Step 8: Save state with ORIGINAL recreation command for proper restoration
-	if err := do.saveContainerStateWithCommand(container, cfg, originalRecreationCommand, string(originalConfigBytes)); err != nil {
+	if err := do.saveContainerStateWithCommand(container, cfg, ContainerState{
+		RecreationCommand:   originalRecreationCommand,
+		OriginalConfig:      originalConfig,
+		EnvFile:             envFile,
+		OriginalEnvFile:     originalEnvFile,
+		InstrumentedCommand: instrumentedRunCommand,
+	}); err != nil {
 		fmt.Printf("   ⚠️  Warning: Could not save state: %v\n", err)
 	}
 
@@ -195,15 +227,13 @@ func (do *DockerOperations) instrumentStandaloneContainer(container *discovery.D
 }
 
 // buildInstrumentedDockerRunCommand creates docker run command with instrumentation
-func (do *DockerOperations) buildInstrumentedDockerRunCommand(config map[string]interface{}, env map[string]string, containerName, imageName string) string {
+func (do *DockerOperations) buildInstrumentedDockerRunCommand(config map[string]interface{}, envFile, containerName, imageName string) string {
 	var cmdParts []string
 	cmdParts = append(cmdParts, "docker", "run", "-d")
 	cmdParts = append(cmdParts, "--name", containerName)
 
-	// Add environment variables (with instrumentation)
-	for k, v := range env {
-		cmdParts = append(cmdParts, "-e", fmt.Sprintf("%s=%s", k, v))
-	}
+	// Add environment variables (with instrumentation) from the root-only env file
+	cmdParts = append(cmdParts, "--env-file", envFile)
 
 	// Add original volume mounts
 	if mounts, ok := config["Mounts"].([]interface{}); ok {
@@ -322,6 +352,8 @@ func (do *DockerOperations) instrumentComposeContainer(container *discovery.Dock
 		fmt.Printf("   ✅ Backup created: %s\n", filepath.Base(backupPath))
 	}
 
+	do.undoRecreatesCompose(container.ComposeService, container.ComposeWorkDir)
+
 	// Step 3: Modify compose file
 	if err := do.modifyComposeFile(container, cfg); err != nil {
 		// Restore backup on failure if we have one
@@ -343,16 +375,16 @@ func (do *DockerOperations) instrumentComposeContainer(container *discovery.Dock
 	// Step 5: Recreate service using docker-compose
 	fmt.Println("   🔄 Recreating service...")
 	if err := do.recreateComposeService(container); err != nil {
-		// Restore backup on failure
-		if backupPath != "" {
-			modifier.RestoreFromBackup(backupPath)
-			fmt.Println("   🔙 Restored original compose file due to recreation failure")
-		}
-		return fmt.Errorf("failed to recreate service: %w", err)
+		return do.rollbackCompose(container, modifier, backupPath, fmt.Errorf("failed to recreate service: %w", err))
+	}
+	if err := do.waitRunning(container.ContainerName); err != nil {
+		return do.rollbackCompose(container, modifier, backupPath, err)
 	}
 
 	// Step 6: Verify instrumentation worked
-	if err := do.verifyContainerInstrumentation(container.ContainerName); err != nil {
+	if changeset.Recording() {
+		fmt.Println("   📋 Recreation planned, skipping verification")
+	} else if err := do.verifyContainerInstrumentation(container.ContainerName); err != nil {
 		fmt.Printf("   ⚠️  Warning: Instrumentation verification failed: %v\n", err)
 		fmt.Println("   🔍 Check container logs for issues")
 	} else {
@@ -369,7 +401,7 @@ func (do *DockerOperations) instrumentComposeContainer(container *discovery.Dock
 }
 
 // buildOriginalDockerRunCommand creates the original docker run command before instrumentation
-func (do *DockerOperations) buildOriginalDockerRunCommand(config map[string]interface{}, containerName string) string {
+func (do *DockerOperations) buildOriginalDockerRunCommand(config map[string]interface{}, envFile, containerName string) string {
 	var cmdParts []string
 	cmdParts = append(cmdParts, "docker", "run", "-d")
 	cmdParts = append(cmdParts, "--name", containerName)
@@ -380,18 +412,7 @@ func (do *DockerOperations) buildOriginalDockerRunCommand(config map[string]inte
 	}
 
 	// Add original environment variables (without instrumentation)
-	if env, ok := configSection["Env"].([]interface{}); ok {
-		for _, e := range env {
-			if envStr, ok := e.(string); ok {
-				// Skip any existing MW_ or OTEL_ variables and JAVA_TOOL_OPTIONS with javaagent
-				if !strings.HasPrefix(envStr, "MW_") &&
-					!strings.HasPrefix(envStr, "OTEL_") &&
-					!(strings.HasPrefix(envStr, "JAVA_TOOL_OPTIONS=") && strings.Contains(envStr, "javaagent")) {
-					cmdParts = append(cmdParts, "-e", envStr)
-				}
-			}
-		}
-	}
+	cmdParts = append(cmdParts, "--env-file", envFile)
 
 	// Add original volume mounts (excluding our agent mount)
 	if mounts, ok := config["Mounts"].([]interface{}); ok {
@@ -486,24 +507,28 @@ func (do *DockerOperations) buildOriginalDockerRunCommand(config map[string]inte
 	return strings.Join(cmdParts, " ")
 }
 
-// saveContainerStateWithCommand saves container state with recreation command
-func (do *DockerOperations) saveContainerStateWithCommand(container *discovery.DockerContainer, cfg *config.ProcessConfiguration, recreationCommand, originalConfig string) error {
+// saveContainerStateWithCommand saves container state with the recreation
+// commands and env files of a standalone container
+func (do *DockerOperations) saveContainerStateWithCommand(container *discovery.DockerContainer, cfg *config.ProcessConfiguration, recreation ContainerState) error {
 	state, _ := do.loadState()
 	if state.Containers == nil {
 		state.Containers = make(map[string]ContainerState)
 	}
 
 	state.Containers[container.ContainerName] = ContainerState{
-		ContainerID:       container.ContainerID,
-		ContainerName:     container.ContainerName,
-		ImageName:         container.ImageName,
-		InstrumentedAt:    time.Now(),
-		AgentPath:         do.hostAgentPath,
-		OriginalEnv:       container.Environment,
-		ComposeFile:       container.ComposeFile,
-		ComposeService:    container.ComposeService,
-		RecreationCommand: recreationCommand, // Now properly set!
-		OriginalConfig:    originalConfig,    // Full original config for debugging
+		ContainerID:         container.ContainerID,
+		ContainerName:       container.ContainerName,
+		ImageName:           container.ImageName,
+		InstrumentedAt:      time.Now(),
+		AgentPath:           do.hostAgentPath,
+		OriginalEnv:         secrets.RedactEnv(container.Environment),
+		ComposeFile:         container.ComposeFile,
+		ComposeService:      container.ComposeService,
+		RecreationCommand:   recreation.RecreationCommand,
+		OriginalConfig:      recreation.OriginalConfig, // Full original config for debugging
+		EnvFile:             recreation.EnvFile,
+		OriginalEnvFile:     recreation.OriginalEnvFile,
+		InstrumentedCommand: recreation.InstrumentedCommand,
 	}
 	state.UpdatedAt = time.Now()
 
@@ -554,6 +579,13 @@ func (do *DockerOperations) uninstrumentStandaloneContainer(state *ContainerStat
 		fmt.Printf("   ⚠️  Warning: Could not remove container: %v\n", err)
 	}
 
+	// Undo recreates the instrumented container once its env file is back
+	if state.InstrumentedCommand != "" {
+		tx := changeset.Current()
+		tx.OnUndo(exec.Command("docker", "rm", "-f", state.ContainerName))
+		tx.OnUndo(exec.Command("sh", "-c", state.InstrumentedCommand))
+	}
+
 	// Recreate with original command
 	fmt.Printf("   Executing: %s\n", state.RecreationCommand)
 	if err := do.runContainer(state.RecreationCommand); err != nil {
@@ -563,14 +595,15 @@ func (do *DockerOperations) uninstrumentStandaloneContainer(state *ContainerStat
 	fmt.Printf("   ✅ Container %s restored to original configuration\n", state.ContainerName)
 
 	// Remove from state
+	removeEnvFiles(state)
 	return do.removeContainerState(state.ContainerName)
 }
 
 // uninstrumentComposeContainer removes instrumentation from compose container
 func (do *DockerOperations) uninstrumentComposeContainer(state *ContainerState) error {
 	// Restore backup compose file
-	backupFile := state.ComposeFile + ".backup"
-	if _, err := os.Stat(backupFile); err == nil {
+	backupFile := composeBackup(state)
+	if changeset.Exists(backupFile) {
 		if err := do.copyFile(backupFile, state.ComposeFile); err != nil {
 			return fmt.Errorf("failed to restore compose file: %w", err)
 		}
@@ -582,6 +615,7 @@ func (do *DockerOperations) uninstrumentComposeContainer(state *ContainerState)
 		// Get container to recreate
 		container, err := do.discoverer.GetContainerByName(state.ContainerName)
 		if err == nil {
+			do.undoRecreatesCompose(container.ComposeService, container.ComposeWorkDir)
 			do.recreateComposeService(container)
 		}
 	} else {
@@ -590,14 +624,26 @@ func (do *DockerOperations) uninstrumentComposeContainer(state *ContainerState)
 	}
 
 	// Remove from state
+	removeEnvFiles(state)
 	return do.removeContainerState(state.ContainerName)
 }
 
+// undoRecreatesCompose records how undo recreates a compose service once
+// its compose and env files are restored
+func (do *DockerOperations) undoRecreatesCompose(service, workDir string) {
+	tx := changeset.Current()
+	for _, args := range [][]string{{"stop", service}, {"rm", "-f", service}, {"up", "-d", service}} {
+		cmd := exec.Command("docker-compose", args...)
+		cmd.Dir = workDir
+		tx.OnUndo(cmd)
+	}
+}
+
 // copyAgentToContainer copies the agent JAR to a running container
 func (do *DockerOperations) copyAgentToContainer(containerID string) error {
 	// Create directory in container
 	mkdirCmd := exec.CommandContext(do.ctx, "docker", "exec", containerID, "mkdir", "-p", "/opt/middleware/agents")
-	if err := mkdirCmd.Run(); err != nil {
+	if err := changeset.Run(mkdirCmd); err != nil {
 		// Try without mkdir if it fails (some distroless images don't have mkdir)
 		fmt.Println("   ⚠️  Could not create directory, trying direct copy...")
 	}
@@ -605,7 +651,7 @@ func (do *DockerOperations) copyAgentToContainer(containerID string) error {
 	// Copy agent file
 	containerPath := containerID + ":" + DefaultContainerAgentPath
 	cmd := exec.CommandContext(do.ctx, "docker", "cp", do.hostAgentPath, containerPath)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // buildInstrumentationEnv builds environment variables for instrumentation
@@ -663,31 +709,31 @@ func (do *DockerOperations) getContainerConfig(containerID string) (map[string]i
 // stopContainer stops a running container
 func (do *DockerOperations) stopContainer(containerID string) error {
 	cmd := exec.CommandContext(do.ctx, "docker", "stop", containerID)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // stopContainerByName stops a container by name
 func (do *DockerOperations) stopContainerByName(name string) error {
 	cmd := exec.CommandContext(do.ctx, "docker", "stop", name)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // removeContainer removes a container
 func (do *DockerOperations) removeContainer(containerID string) error {
 	cmd := exec.CommandContext(do.ctx, "docker", "rm", containerID)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // removeContainerByName removes a container by name
 func (do *DockerOperations) removeContainerByName(name string) error {
 	cmd := exec.CommandContext(do.ctx, "docker", "rm", name)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // commitContainer commits a container to a new image
 func (do *DockerOperations) commitContainer(containerID, imageName string) error {
 	cmd := exec.CommandContext(do.ctx, "docker", "commit", containerID, imageName)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // buildDockerRunCommand builds a docker run command from container config
@@ -746,7 +792,7 @@ func (do *DockerOperations) buildDockerRunCommand(config map[string]interface{},
 // runContainer runs a docker run command
 func (do *DockerOperations) runContainer(command string) error {
 	cmd := exec.CommandContext(do.ctx, "sh", "-c", command)
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // modifyComposeFile modifies a docker-compose.yml file to add instrumentation
@@ -768,17 +814,14 @@ func (do *DockerOperations) modifyComposeFile(container *discovery.DockerContain
 	// Check if already instrumented
 	if modifier.isServiceInstrumented(&service) {
 		fmt.Printf("   ⚠️  Service '%s' appears to already be instrumented\n", container.ComposeService)
-		fmt.Print("   Continue with instrumentation? [y/N]: ")
-
-		var response string
-		fmt.Scanln(&response)
-		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+		if do.Confirm == nil || !do.Confirm("   Continue with instrumentation?") {
 			return fmt.Errorf("instrumentation cancelled by user")
 		}
 	}
 
 	// Add instrumentation to service
-	if err := modifier.addInstrumentation(&service, cfg, do.hostAgentPath); err != nil {
+	envFile := filepath.Join(secrets.ContainerDir, composeEnvFileName(container))
+	if err := modifier.addInstrumentation(&service, cfg, do.hostAgentPath, envFile); err != nil {
 		return fmt.Errorf("failed to add instrumentation: %w", err)
 	}
 
@@ -796,7 +839,7 @@ func (do *DockerOperations) modifyComposeFile(container *discovery.DockerContain
 
 // Parse reads and parses the docker-compose file
 func (cm *ComposeModifier) Parse() (*ComposeFile, error) {
-	data, err := os.ReadFile(cm.filePath)
+	data, err := changeset.ReadFile(cm.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read compose file: %w", err)
 	}
@@ -827,12 +870,12 @@ func (cm *ComposeModifier) Write(composeFile *ComposeFile) error {
 	pp.Println("=== END MODIFIED CONTENT ===")
 
 	// Write to file
-	if err := os.WriteFile(cm.filePath, data, 0o644); err != nil {
+	if err := changeset.WriteFile(cm.filePath, data, 0o644); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	// DEBUG: Read back what was actually written to disk
-	actualContent, readErr := os.ReadFile(cm.filePath)
+	actualContent, readErr := changeset.ReadFile(cm.filePath)
 	if readErr == nil {
 		fmt.Println("=== ACTUAL FILE CONTENT ON DISK ===")
 		fmt.Println(string(actualContent))
@@ -842,6 +885,20 @@ func (cm *ComposeModifier) Write(composeFile *ComposeFile) error {
 	return nil
 }
 
+// ServiceInstrumented reports whether a compose service still carries the
+// Middleware instrumentation
+func (cm *ComposeModifier) ServiceInstrumented(name string) (bool, error) {
+	composeData, err := cm.Parse()
+	if err != nil {
+		return false, err
+	}
+	service, exists := composeData.Services[name]
+	if !exists {
+		return false, fmt.Errorf("service '%s' not found in compose file", name)
+	}
+	return cm.isServiceInstrumented(&service), nil
+}
+
 // isServiceInstrumented checks if service already has MW instrumentation
 func (cm *ComposeModifier) isServiceInstrumented(service *Service) bool {
 	// Check environment variables for existing instrumentation
@@ -853,6 +910,13 @@ func (cm *ComposeModifier) isServiceInstrumented(service *Service) bool {
 		}
 	}
 
+	// Check env_file for our secrets file
+	for _, envFile := range envFiles(service.Extra["env_file"]) {
+		if strings.HasPrefix(envFile, secrets.ContainerDir) {
+			return true
+		}
+	}
+
 	// Check volumes for agent mount
 	for _, volume := range service.Volumes {
 		if strings.Contains(volume, "/opt/middleware/agents/") {
@@ -863,8 +927,9 @@ func (cm *ComposeModifier) isServiceInstrumented(service *Service) bool {
 	return false
 }
 
-// addInstrumentation adds MW instrumentation to a service
-func (cm *ComposeModifier) addInstrumentation(service *Service, cfg *config.ProcessConfiguration, hostAgentPath string) error {
+// addInstrumentation adds MW instrumentation to a service. Secrets are written
+// to envFile and referenced through env_file instead of inline environment.
+func (cm *ComposeModifier) addInstrumentation(service *Service, cfg *config.ProcessConfiguration, hostAgentPath, envFile string) error {
 	// Build environment variables
 	mwEnv := cfg.ToEnvironmentVariables()
 
@@ -883,12 +948,31 @@ func (cm *ComposeModifier) addInstrumentation(service *Service, cfg *config.Proc
 
 	// Add new environment variables
 	cleanedEnv = append(cleanedEnv, javaToolOptions)
-	for key, value := range mwEnv {
-		cleanedEnv = append(cleanedEnv, fmt.Sprintf("%s=%s", key, value))
+	var secretEnv [][2]string
+	for _, kv := range sortedEnv(mwEnv) {
+		if secrets.IsSecret(kv[0]) {
+			secretEnv = append(secretEnv, kv)
+			continue
+		}
+		cleanedEnv = append(cleanedEnv, kv[0]+"="+kv[1])
 	}
 
 	service.Environment = cleanedEnv
 
+	if len(secretEnv) > 0 {
+		if err := secrets.WriteEnvFile(envFile, secretEnv, -1, -1); err != nil {
+			return err
+		}
+		files := envFiles(service.Extra["env_file"])
+		if !slices.Contains(files, envFile) {
+			files = append(files, envFile)
+		}
+		if service.Extra == nil {
+			service.Extra = make(map[string]interface{})
+		}
+		service.Extra["env_file"] = files
+	}
+
 	// Add agent volume mount
 	agentMount := fmt.Sprintf("%s:%s:ro", hostAgentPath, DefaultContainerAgentPath)
 
@@ -915,12 +999,12 @@ func (cm *ComposeModifier) addInstrumentation(service *Service, cfg *config.Proc
 func (cm *ComposeModifier) BackupComposeFile() (string, error) {
 	backupPath := cm.filePath + ".backup"
 
-	data, err := os.ReadFile(cm.filePath)
+	data, err := changeset.ReadFile(cm.filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read original file: %w", err)
 	}
 
-	if err := os.WriteFile(backupPath, data, 0o644); err != nil {
+	if err := changeset.WriteFile(backupPath, data, 0o644); err != nil {
 		return "", fmt.Errorf("failed to create backup: %w", err)
 	}
 
@@ -929,12 +1013,12 @@ func (cm *ComposeModifier) BackupComposeFile() (string, error) {
 
 // RestoreFromBackup restores the compose file from backup
 func (cm *ComposeModifier) RestoreFromBackup(backupPath string) error {
-	data, err := os.ReadFile(backupPath)
+	data, err := changeset.ReadFile(backupPath)
 	if err != nil {
 		return fmt.Errorf("failed to read backup file: %w", err)
 	}
 
-	if err := os.WriteFile(cm.filePath, data, 0o644); err != nil {
+	if err := changeset.WriteFile(cm.filePath, data, 0o644); err != nil {
 		return fmt.Errorf("failed to restore from backup: %w", err)
 	}
 
@@ -952,10 +1036,7 @@ func (do *DockerOperations) recreateComposeService(container *discovery.DockerCo
 		return fmt.Errorf("compose working directory not found")
 	}
 
-	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
-
-	if err := os.Chdir(container.ComposeWorkDir); err != nil {
+	if _, err := os.Stat(container.ComposeWorkDir); err != nil {
 		return err
 	}
 
@@ -964,21 +1045,24 @@ func (do *DockerOperations) recreateComposeService(container *discovery.DockerCo
 	// SOLUTION: Stop and remove the existing container first
 	fmt.Println("   Stopping existing container...")
 	stopCmd := exec.CommandContext(do.ctx, "docker-compose", "stop", container.ComposeService)
-	if output, err := stopCmd.CombinedOutput(); err != nil {
+	stopCmd.Dir = container.ComposeWorkDir
+	if output, err := changeset.CombinedOutput(stopCmd); err != nil {
 		fmt.Printf("   Warning: Failed to stop container: %s\n", string(output))
 	}
 
 	fmt.Println("   Removing existing container...")
 	rmCmd := exec.CommandContext(do.ctx, "docker-compose", "rm", "-f", container.ComposeService)
-	if output, err := rmCmd.CombinedOutput(); err != nil {
+	rmCmd.Dir = container.ComposeWorkDir
+	if output, err := changeset.CombinedOutput(rmCmd); err != nil {
 		fmt.Printf("   Warning: Failed to remove container: %s\n", string(output))
 	}
 
 	// Now recreate with fresh container
 	fmt.Println("   Creating new container...")
 	cmd := exec.CommandContext(do.ctx, "docker-compose", "up", "-d", container.ComposeService)
+	cmd.Dir = container.ComposeWorkDir
 
-	output, err := cmd.CombinedOutput()
+	output, err := changeset.CombinedOutput(cmd)
 	if err != nil {
 		fmt.Printf("   Docker-compose error output: %s\n", string(output))
 		return err
@@ -1000,9 +1084,10 @@ func (do *DockerOperations) saveContainerState(container *discovery.DockerContai
 		ImageName:      container.ImageName,
 		InstrumentedAt: time.Now(),
 		AgentPath:      do.hostAgentPath,
-		OriginalEnv:    container.Environment,
+		OriginalEnv:    secrets.RedactEnv(container.Environment),
 		ComposeFile:    container.ComposeFile,
 		ComposeService: container.ComposeService,
+		EnvFile:        filepath.Join(secrets.ContainerDir, composeEnvFileName(container)),
 	}
 	state.UpdatedAt = time.Now()
 
@@ -1017,50 +1102,13 @@ func (do *DockerOperations) removeContainerState(containerName string) error {
 	return do.saveState(state)
 }
 
-// loadState loads the instrumented containers state
-func (do *DockerOperations) loadState() (*InstrumentedState, error) {
-	if _, err := os.Stat(StateFile); os.IsNotExist(err) {
-		return &InstrumentedState{
-			Containers: make(map[string]ContainerState),
-		}, nil
-	}
-
-	data, err := os.ReadFile(StateFile)
-	if err != nil {
-		return nil, err
-	}
-
-	var state InstrumentedState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, err
-	}
-
-	return &state, nil
-}
-
-// saveState saves the instrumented containers state
-func (do *DockerOperations) saveState(state *InstrumentedState) error {
-	// Ensure directory exists
-	dir := filepath.Dir(StateFile)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return err
-	}
-
-	data, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(StateFile, data, 0o644)
-}
-
 // copyFile copies a file from src to dst
 func (do *DockerOperations) copyFile(src, dst string) error {
-	data, err := os.ReadFile(src)
+	data, err := changeset.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(dst, data, 0o644)
+	return changeset.WriteFile(dst, data, 0o644)
 }
 
 // ListInstrumentedContainers lists all instrumented containers
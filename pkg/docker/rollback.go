@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+// StartTimeout is how long a recreated container gets to start running
+var StartTimeout = 60 * time.Second
+
+// startSettle is how long a container must keep running to count as started
+const startSettle = 5 * time.Second
+
+// waitRunning waits for a recreated container to run and stay up. A
+// container that exits, or that docker restarts while we wait, is
+// crash-looping.
+func (do *DockerOperations) waitRunning(name string) error {
+	if changeset.Recording() {
+		return nil
+	}
+
+	restarts := -1
+	deadline := time.Now().Add(StartTimeout)
+	var runningSince time.Time
+	for {
+		output, err := exec.CommandContext(do.ctx, "docker", "inspect", "-f", "{{.State.Status}} {{.State.RestartCount}}", name).Output()
+		if err != nil {
+			return fmt.Errorf("container %s not found: %w", name, err)
+		}
+		var status string
+		var count int
+		fmt.Sscan(strings.TrimSpace(string(output)), &status, &count)
+		if restarts < 0 {
+			restarts = count
+		}
+
+		switch status {
+		case "running":
+			if runningSince.IsZero() {
+				runningSince = time.Now()
+			}
+			if time.Since(runningSince) >= startSettle {
+				return nil
+			}
+		case "exited", "dead":
+			return fmt.Errorf("container %s %s after the restart", name, status)
+		default:
+			runningSince = time.Time{}
+		}
+
+		if count > restarts {
+			return fmt.Errorf("container %s is crash-looping (restarted %d time(s))", name, count-restarts)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s isn't running after %s (%s)", name, StartTimeout, status)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// rollbackCompose restores the compose and env files changed for a service
+// and recreates it as it was
+func (do *DockerOperations) rollbackCompose(container *discovery.DockerContainer, modifier *ComposeModifier, backupPath string, cause error) error {
+	fmt.Printf("   ⏪ Rolling back %s: %v\n", container.ContainerName, cause)
+
+	if tx := changeset.Current(); tx != nil {
+		if err := tx.Rollback(cause); err != nil {
+			fmt.Printf("   ⚠️  Rollback incomplete: %v\n", err)
+		}
+		for _, path := range tx.Restored() {
+			fmt.Printf("      Restored: %s\n", path)
+		}
+	} else if backupPath != "" {
+		modifier.RestoreFromBackup(backupPath)
+		fmt.Println("   🔙 Restored original compose file")
+	}
+
+	err := do.recreateComposeService(container)
+	if err == nil {
+		err = do.waitRunning(container.ContainerName)
+	}
+	if err != nil {
+		return fmt.Errorf("%v, and the rollback failed too: %w", cause, err)
+	}
+	fmt.Printf("   ✅ %s is running again without instrumentation\n", container.ContainerName)
+	return fmt.Errorf("rolled back: %w", cause)
+}
+
+// rollbackStandalone replaces an instrumented container that doesn't start
+// with one created from its original configuration
+func (do *DockerOperations) rollbackStandalone(name, originalRecreationCommand string, cause error) error {
+	fmt.Printf("   ⏪ Rolling back %s: %v\n", name, cause)
+
+	changeset.Run(exec.CommandContext(do.ctx, "docker", "rm", "-f", name))
+	err := do.runContainer(originalRecreationCommand)
+	if err == nil {
+		err = do.waitRunning(name)
+	}
+
+	// The original env file is only read by docker run, so it's restored
+	// (removed) after the container is recreated
+	if tx := changeset.Current(); tx != nil {
+		if rbErr := tx.Rollback(cause); rbErr != nil {
+			fmt.Printf("   ⚠️  Rollback incomplete: %v\n", rbErr)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v, and the rollback failed too: %w", cause, err)
+	}
+	fmt.Printf("   ✅ %s is running again without instrumentation\n", name)
+	return fmt.Errorf("rolled back: %w", cause)
+}
@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+)
+
+// RotateAPIKey replaces the API key in the env files of instrumented
+// containers and recreates them. It returns the number of containers updated.
+func (do *DockerOperations) RotateAPIKey(oldKey, newKey string) (int, error) {
+	state, err := do.loadState()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	rotated := 0
+	for _, c := range state.Containers {
+		if c.EnvFile == "" {
+			fmt.Printf("   ⚠️  %s was instrumented with an inline key, re-instrument it\n", c.ContainerName)
+			continue
+		}
+
+		changed, err := secrets.ReplaceInFile(c.EnvFile, oldKey, newKey)
+		if err != nil {
+			return rotated, err
+		}
+		if !changed {
+			continue
+		}
+
+		if err := do.recreate(&c); err != nil {
+			fmt.Printf("   ❌ %s: %v\n", c.ContainerName, err)
+			continue
+		}
+		fmt.Printf("   ✅ %s\n", c.ContainerName)
+		rotated++
+	}
+
+	return rotated, nil
+}
+
+// recreate restarts an instrumented container so it reads its env file again
+func (do *DockerOperations) recreate(c *ContainerState) error {
+	if c.ComposeFile != "" {
+		container, err := do.discoverer.GetContainerByName(c.ContainerName)
+		if err != nil {
+			return fmt.Errorf("container not found: %w", err)
+		}
+		return do.recreateComposeService(container)
+	}
+
+	if c.InstrumentedCommand == "" {
+		return fmt.Errorf("no recreation command recorded")
+	}
+	changeset.Run(exec.CommandContext(do.ctx, "docker", "rm", "-f", c.ContainerName))
+	return do.runContainer(c.InstrumentedCommand)
+}
+
+// redactedConfig serializes a docker inspect result without secret env values
+func redactedConfig(config map[string]interface{}) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	// Round-trip to get a copy the caller's config isn't affected by
+	var clone map[string]interface{}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return "", err
+	}
+	if section, ok := clone["Config"].(map[string]interface{}); ok {
+		if env, ok := section["Env"].([]interface{}); ok {
+			var list []string
+			for _, e := range env {
+				if s, ok := e.(string); ok {
+					list = append(list, s)
+				}
+			}
+			section["Env"] = secrets.RedactEnvList(list)
+		}
+	}
+
+	data, err = json.Marshal(clone)
+	return string(data), err
+}
+
+// originalEnv returns the environment of a container without instrumentation
+func originalEnv(config map[string]interface{}) [][2]string {
+	var vars [][2]string
+	section, ok := config["Config"].(map[string]interface{})
+	if !ok {
+		return vars
+	}
+	env, _ := section["Env"].([]interface{})
+	for _, e := range env {
+		envStr, ok := e.(string)
+		if !ok {
+			continue
+		}
+		// Skip any existing MW_ or OTEL_ variables and JAVA_TOOL_OPTIONS with javaagent
+		if strings.HasPrefix(envStr, "MW_") || strings.HasPrefix(envStr, "OTEL_") ||
+			(strings.HasPrefix(envStr, "JAVA_TOOL_OPTIONS=") && strings.Contains(envStr, "javaagent")) {
+			continue
+		}
+		if key, value, found := strings.Cut(envStr, "="); found {
+			vars = append(vars, [2]string{key, value})
+		}
+	}
+	return vars
+}
+
+// sortedEnv returns an environment as sorted KEY/VALUE pairs
+func sortedEnv(env map[string]string) [][2]string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, [2]string{k, env[k]})
+	}
+	return vars
+}
+
+// envFiles normalizes the env_file of a compose service, a string or a list
+func envFiles(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		var files []string
+		for _, f := range v {
+			switch entry := f.(type) {
+			case string:
+				files = append(files, entry)
+			case map[string]interface{}:
+				// Long syntax: {path: ..., required: ...}
+				if path, ok := entry["path"].(string); ok {
+					files = append(files, path)
+				}
+			}
+		}
+		return files
+	}
+	return nil
+}
+
+// composeEnvFileName names the env file of a compose service
+func composeEnvFileName(container *discovery.DockerContainer) string {
+	return container.ContainerName + ".env"
+}
+
+// removeEnvFiles deletes the env files of a container once it's restored
+func removeEnvFiles(c *ContainerState) {
+	for _, path := range []string{c.EnvFile, c.OriginalEnvFile} {
+		if path != "" {
+			changeset.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// loadState loads the instrumented containers from the state store
+func (do *DockerOperations) loadState() (*InstrumentedState, error) {
+	store, err := state.LoadStore()
+	if err != nil {
+		return nil, err
+	}
+
+	instrumented := &InstrumentedState{
+		Containers: make(map[string]ContainerState),
+		UpdatedAt:  store.UpdatedAt,
+	}
+	for name, raw := range store.Containers {
+		var c ContainerState
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("failed to parse state of container %s: %w", name, err)
+		}
+		instrumented.Containers[name] = c
+	}
+	return instrumented, nil
+}
+
+// saveState saves the instrumented containers to the state store, with the
+// compose files they changed
+func (do *DockerOperations) saveState(instrumented *InstrumentedState) error {
+	return state.UpdateStore(func(store *state.Store) error {
+		for name := range store.Containers {
+			if _, ok := instrumented.Containers[name]; !ok {
+				store.ForgetContainer(name)
+			}
+		}
+
+		for name, c := range instrumented.Containers {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return fmt.Errorf("failed to encode state of container %s: %w", name, err)
+			}
+			store.Containers[name] = data
+
+			backup := c.ComposeFile + ".backup"
+			if c.ComposeFile == "" || !changeset.Exists(backup) || len(store.ContainerArtifacts(name)) > 0 {
+				continue
+			}
+			store.RecordArtifact(state.Artifact{
+				Kind:      state.ArtifactComposeFile,
+				Path:      c.ComposeFile,
+				Backup:    backup,
+				Container: name,
+			})
+		}
+		return nil
+	})
+}
+
+// composeBackup returns the copy of the original compose file of a container
+func composeBackup(c *ContainerState) string {
+	if store, err := state.LoadStore(); err == nil {
+		for _, a := range store.ContainerArtifacts(c.ContainerName) {
+			if a.Kind == state.ArtifactComposeFile && a.Backup != "" {
+				return a.Backup
+			}
+		}
+	}
+	return c.ComposeFile + ".backup"
+}
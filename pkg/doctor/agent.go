@@ -0,0 +1,182 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/agentjar"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/lsm"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// checkAgent checks the agent is there, is an intact JAR and declares a
+// Java agent
+func checkAgent(r *Report, agentPath string) {
+	if agentPath == "" {
+		r.add(CheckAgent, Error, "No agent path configured", "Pass --agent-path or set MW_JAVA_AGENT_PATH in the config file")
+		return
+	}
+	info, err := os.Stat(agentPath)
+	if err != nil {
+		r.add(CheckAgent, Error, fmt.Sprintf("Agent %s not found", agentPath),
+			"Run mw-injector agent install <jar|latest>, latest being fetched from MW_AGENT_REPOSITORY, or pass --agent-path")
+		return
+	}
+	if !strings.HasSuffix(agentPath, ".jar") {
+		r.add(CheckAgent, Error, fmt.Sprintf("Agent %s is not a .jar file", agentPath), "Point --agent-path at the agent JAR")
+		return
+	}
+
+	jar, err := agentjar.Read(agentPath)
+	if err != nil {
+		r.add(CheckAgent, Error, fmt.Sprintf("Agent %s is corrupt: %v", agentPath, err), "Download the agent again")
+		return
+	}
+	if !jar.IsAgent() {
+		r.add(CheckAgent, Error, fmt.Sprintf("%s has no Premain-Class, it is not a Java agent", agentPath),
+			"Point --agent-path at the Middleware Java agent")
+		return
+	}
+
+	version := jar.Version
+	if version == "" {
+		version = "unknown"
+	}
+	message := fmt.Sprintf("%s, version %s", agentPath, version)
+	if jar.SDKVersion != "" {
+		message += fmt.Sprintf(", OpenTelemetry SDK %s", jar.SDKVersion)
+	}
+	r.add(CheckAgent, OK, message, "")
+
+	if info.Mode()&0o004 == 0 {
+		r.add(CheckAgent, Warning, fmt.Sprintf("Agent is not world-readable (%s)", info.Mode()),
+			fmt.Sprintf("chmod 644 %s", agentPath))
+	}
+}
+
+// checkIntegrity checks an agent mw-injector installed still has the
+// checksum recorded then, and that a signature or checksum verifies any
+// other agent
+func checkIntegrity(r *Report, opts Options) {
+	if _, err := os.Stat(opts.AgentPath); err != nil {
+		return // already reported
+	}
+	reinstall := fmt.Sprintf("Remove %s and install the agent from a verified release", opts.AgentPath)
+
+	if opts.Store {
+		if s, err := state.LoadStore(); err == nil {
+			if recorded := s.Agent(opts.AgentPath); recorded != nil && recorded.SHA256 != "" {
+				sum, err := agent.Checksum(opts.AgentPath)
+				switch {
+				case err != nil:
+					r.add(CheckIntegrity, Error, fmt.Sprintf("Cannot read %s: %v", opts.AgentPath, err), reinstall)
+				case sum != recorded.SHA256:
+					r.add(CheckIntegrity, Error, fmt.Sprintf("Agent changed since it was installed on %s (SHA-256 %s, recorded %s), it may have been tampered with",
+						recorded.InstalledAt.Format(time.DateTime), sum, recorded.SHA256), reinstall)
+				case recorded.Verification == agent.Unverified:
+					r.add(CheckIntegrity, Warning, "Agent was installed unverified with --allow-unverified",
+						fmt.Sprintf("Publish a signed %s next to the agent or set MW_AGENT_SHA256, then reinstall it", agent.ChecksumsFile))
+				default:
+					r.add(CheckIntegrity, OK, fmt.Sprintf("Agent unchanged since it was installed, verified by %s", recorded.Verification), "")
+				}
+				return
+			}
+		}
+	}
+
+	v, err := agent.Verify(opts.AgentPath, opts.Verify)
+	switch {
+	case err == nil:
+		r.add(CheckIntegrity, OK, fmt.Sprintf("Agent verified by %s", v.Method), "")
+	case errors.Is(err, agent.ErrUnverified):
+		r.add(CheckIntegrity, Warning, fmt.Sprintf("%v, auto-instrument refuses it without --allow-unverified", err),
+			fmt.Sprintf("Publish a signed %s next to the agent, or set MW_AGENT_SHA256 or MW_AGENT_CHECKSUMS", agent.ChecksumsFile))
+	default:
+		r.add(CheckIntegrity, Error, fmt.Sprintf("Agent verification failed: %v", err), reinstall)
+	}
+}
+
+// checkAccess checks every service user, and every unit within its sandbox,
+// can read the agent
+func checkAccess(r *Report, opts Options) {
+	if _, err := os.Stat(opts.AgentPath); err != nil {
+		return // already reported
+	}
+	if !opts.Root {
+		if file, err := os.Open(opts.AgentPath); err != nil {
+			r.add(CheckAccess, Error, fmt.Sprintf("You cannot read %s", opts.AgentPath),
+				"Use an agent in a directory you can read, or run with sudo")
+		} else {
+			file.Close()
+			r.add(CheckAccess, OK, "Agent readable by you", "")
+		}
+		return
+	}
+
+	failed := false
+	users := make(map[string]bool)
+	for _, proc := range opts.Processes {
+		if users[proc.ProcessOwner] {
+			continue
+		}
+		users[proc.ProcessOwner] = true
+		if err := agent.CheckAccessibleByUser(opts.AgentPath, proc.ProcessOwner); err != nil {
+			failed = true
+			r.add(CheckAccess, Error, fmt.Sprintf("User %s cannot read %s", proc.ProcessOwner, opts.AgentPath),
+				fmt.Sprintf("Make the agent world-readable and its directories searchable, or keep it in %s", lsm.AgentDir))
+		}
+	}
+
+	units := 0
+	if _, err := exec.LookPath("systemd-run"); err == nil {
+		checked := make(map[string]bool)
+		for _, proc := range opts.Processes {
+			unit := discovery.SystemdUnitForPID(proc.ProcessPID)
+			// Units of user managers run unsandboxed as their user, checked above
+			if unit == "" || strings.Contains(unit, "/") || checked[unit] {
+				continue
+			}
+			checked[unit] = true
+			units++
+
+			properties := systemd.Show(unit, agent.SandboxProperties...)
+			if properties["User"] == "" && properties["DynamicUser"] != "yes" {
+				properties["User"] = proc.ProcessOwner
+			}
+			if err := agent.CheckAccessibleInSandbox(opts.AgentPath, properties); err != nil {
+				failed = true
+				r.add(CheckAccess, Error, fmt.Sprintf("%s cannot read the agent within its sandbox (%s)", unit, sandboxSummary(properties)),
+					fmt.Sprintf("Allow it in a drop-in of %s, e.g. BindReadOnlyPaths=%s, or relax InaccessiblePaths, TemporaryFileSystem or RootDirectory",
+						unit, filepath.Dir(opts.AgentPath)))
+			}
+		}
+	}
+
+	if !failed {
+		r.add(CheckAccess, OK, fmt.Sprintf("Agent readable by %d service users and %d systemd units", len(users), units), "")
+	}
+}
+
+// sandboxSummary lists the sandbox settings of a unit that are turned on
+func sandboxSummary(properties map[string]string) string {
+	var settings []string
+	for _, name := range agent.SandboxProperties {
+		switch value := properties[name]; value {
+		case "", "no":
+		default:
+			settings = append(settings, name+"="+value)
+		}
+	}
+	if len(settings) == 0 {
+		return "no sandboxing"
+	}
+	return strings.Join(settings, ", ")
+}
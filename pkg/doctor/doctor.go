@@ -0,0 +1,90 @@
+// Package doctor checks that a host is ready to be instrumented, so problems
+// like an unreadable agent or a full disk show up before any service is
+// changed rather than as a vague failure halfway through
+package doctor
+
+import (
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+// Severities, from fine to blocking
+const (
+	OK      = "ok"
+	Info    = "info"
+	Warning = "warning" // instrumentation may fail or degrade
+	Error   = "error"   // instrumentation will fail
+)
+
+// Checks, in the order they run
+const (
+	CheckAgent     = "agent"
+	CheckIntegrity = "integrity"
+	CheckAccess    = "access"
+	CheckLSM       = "lsm"
+	CheckSystemd   = "systemd"
+	CheckDocker    = "docker"
+	CheckState     = "state"
+	CheckResources = "resources"
+)
+
+// Finding is the outcome of one check, with how to fix it when it's not OK
+type Finding struct {
+	Check    string
+	Severity string
+	Message  string
+	Fix      string
+}
+
+// Options is what the checks look at
+type Options struct {
+	AgentPath  string
+	ConfigRoot string // where per-service configs are written
+	Processes  []discovery.JavaProcess
+	Verify     agent.VerifyOptions // trust anchors of the config file
+
+	Root       bool // checks that switch users or run transient units need root
+	SkipAccess bool // the access checks are turned off, e.g. SKIP_SE_CHECK
+	Docker     bool // check Docker and compose
+	Store      bool // check the state store, which only root uses
+}
+
+// Report lists the findings of a run
+type Report struct {
+	Findings []Finding
+}
+
+// Run runs every check
+func Run(opts Options) *Report {
+	r := &Report{Findings: []Finding{}}
+	checkAgent(r, opts.AgentPath)
+	checkIntegrity(r, opts)
+	if !opts.SkipAccess {
+		checkAccess(r, opts)
+	}
+	checkLSM(r, opts.AgentPath, opts.Processes)
+	if opts.Root {
+		checkSystemd(r)
+	}
+	if opts.Docker {
+		checkDocker(r)
+	}
+	checkState(r, opts.ConfigRoot, opts.Store)
+	checkResources(r, opts)
+	return r
+}
+
+// Count returns the number of findings of a severity
+func (r *Report) Count(severity string) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(check, severity, message, fix string) {
+	r.Findings = append(r.Findings, Finding{Check: check, Severity: severity, Message: message, Fix: fix})
+}
@@ -0,0 +1,98 @@
+package doctor_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/doctor"
+)
+
+// writeJar writes a JAR with the given manifest
+func writeJar(t *testing.T, path, manifest string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	w, err := archive.Create("META-INF/MANIFEST.MF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(manifest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// findings returns the findings of one check
+func findings(report *doctor.Report, check string) []doctor.Finding {
+	var result []doctor.Finding
+	for _, f := range report.Findings {
+		if f.Check == check {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func TestAgentCheck(t *testing.T) {
+	dir := t.TempDir()
+	agentJar := filepath.Join(dir, "agent.jar")
+	writeJar(t, agentJar, "Manifest-Version: 1.0\r\nPremain-Class: io.opentelemetry.javaagent.OpenTelemetryAgent\r\n"+
+		"Implementation-Version: 1.8.1\r\n\r\n")
+	library := filepath.Join(dir, "library.jar")
+	writeJar(t, library, "Manifest-Version: 1.0\r\n\r\n")
+	corrupt := filepath.Join(dir, "corrupt.jar")
+	os.WriteFile(corrupt, []byte("not a zip"), 0o644)
+	private := filepath.Join(dir, "private.jar")
+	writeJar(t, private, "Premain-Class: Agent\n")
+	os.Chmod(private, 0o600)
+
+	tests := []struct {
+		name     string
+		path     string
+		severity string
+		message  string
+	}{
+		{"Agent", agentJar, doctor.OK, "version 1.8.1"},
+		{"Missing", filepath.Join(dir, "missing.jar"), doctor.Error, "not found"},
+		{"Not a JAR", filepath.Join(dir), doctor.Error, "not a .jar"},
+		{"Corrupt", corrupt, doctor.Error, "corrupt"},
+		{"Not an agent", library, doctor.Error, "no Premain-Class"},
+		{"Not world-readable", private, doctor.Warning, "not world-readable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := doctor.Run(doctor.Options{AgentPath: tt.path, ConfigRoot: dir, SkipAccess: true})
+			agent := findings(report, doctor.CheckAgent)
+			last := agent[len(agent)-1]
+			if last.Severity != tt.severity || !strings.Contains(last.Message, tt.message) {
+				t.Errorf("agent finding = %s %q, want %s containing %q", last.Severity, last.Message, tt.severity, tt.message)
+			}
+			if last.Severity != doctor.OK && last.Fix == "" {
+				t.Error("finding has no fix")
+			}
+		})
+	}
+}
+
+func TestStateCheck(t *testing.T) {
+	dir := t.TempDir()
+	report := doctor.Run(doctor.Options{ConfigRoot: filepath.Join(dir, "middleware"), SkipAccess: true})
+
+	state := findings(report, doctor.CheckState)
+	if len(state) != 1 || state[0].Severity != doctor.OK {
+		t.Fatalf("state findings = %+v, want one OK", state)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("check left %d files behind", len(entries))
+	}
+}
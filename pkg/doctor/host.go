@@ -0,0 +1,250 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/lsm"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+const (
+	// MinSystemdVersion is the first systemd whose systemd-run supports
+	// --wait, which the access checks use
+	MinSystemdVersion = 232
+
+	// JVMHeadroom is roughly the memory the agent adds to each JVM
+	JVMHeadroom = 64 << 20
+
+	// MinFreeDisk is the free space below which writing configs, backups
+	// and state may fail
+	MinFreeDisk = 100 << 20
+)
+
+// checkLSM checks the SELinux mode and the agent's label, and which JVMs
+// AppArmor confines
+func checkLSM(r *Report, agentPath string, processes []discovery.JavaProcess) {
+	mode, apparmor := lsm.SELinuxMode(), lsm.AppArmorEnabled()
+	if mode == lsm.Disabled && !apparmor {
+		r.add(CheckLSM, OK, "No SELinux or AppArmor", "")
+		return
+	}
+
+	if mode != lsm.Disabled {
+		label := lsm.FileLabel(agentPath)
+		switch {
+		case mode == lsm.Permissive:
+			r.add(CheckLSM, Info, fmt.Sprintf("SELinux is permissive, denials are only logged (agent labelled %s)", label), "")
+		case !lsm.Readable(label):
+			r.add(CheckLSM, Error, fmt.Sprintf("SELinux is enforcing and the agent is labelled %s, confined services can't read it", label),
+				fmt.Sprintf("auto-instrument offers to fix it, or: semanage fcontext -a -t %s '%s' && restorecon -R %s",
+					lsm.AgentLabel, lsm.AgentDirPattern, lsm.AgentDir))
+		default:
+			r.add(CheckLSM, OK, fmt.Sprintf("SELinux is enforcing, agent labelled %s", label), "")
+		}
+	}
+
+	if apparmor {
+		confined := 0
+		for _, proc := range processes {
+			profile := lsm.EnforcedProfile(proc.ProcessPID)
+			if profile == "" {
+				continue
+			}
+			confined++
+			r.add(CheckLSM, Warning, fmt.Sprintf("PID %d runs under the AppArmor profile %s, which must allow reading the agent", proc.ProcessPID, profile),
+				fmt.Sprintf("auto-instrument offers to fix it, or add %q to %s and reload the profile",
+					lsm.AppArmorRule, lsm.LocalInclude(lsm.ProfileFile(profile))))
+		}
+		if confined == 0 {
+			r.add(CheckLSM, OK, "AppArmor is enabled, no JVM is confined", "")
+		}
+	}
+}
+
+// checkSystemd checks systemd is recent enough and D-Bus is up
+func checkSystemd(r *Report) {
+	output, err := exec.Command("systemctl", "--version").Output()
+	if err != nil {
+		r.add(CheckSystemd, Warning, "systemctl not found, only services run by other supervisors can be instrumented", "")
+		return
+	}
+
+	fields := strings.Fields(string(output))
+	version := 0
+	if len(fields) > 1 {
+		version, _ = strconv.Atoi(fields[1])
+	}
+	if version < MinSystemdVersion {
+		r.add(CheckSystemd, Warning, fmt.Sprintf("systemd %d is older than %d, the access checks can't run", version, MinSystemdVersion),
+			"Set SKIP_SE_CHECK=true in the config file and check access by hand")
+	} else {
+		r.add(CheckSystemd, OK, fmt.Sprintf("systemd %d", version), "")
+	}
+
+	if _, err := os.Stat("/run/dbus/system_bus_socket"); err != nil {
+		r.add(CheckSystemd, Warning, "The D-Bus system bus is not running, systemd --user units and restarts may fail", "systemctl start dbus")
+	} else {
+		r.add(CheckSystemd, OK, "D-Bus system bus available", "")
+	}
+}
+
+// checkDocker checks the Docker daemon and docker-compose
+func checkDocker(r *Report) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		r.add(CheckDocker, Info, "Docker is not installed, containers won't be instrumented", "")
+		return
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		r.add(CheckDocker, Warning, "Docker is installed but its daemon isn't reachable", "systemctl start docker")
+		return
+	}
+	r.add(CheckDocker, OK, "Docker daemon reachable", "")
+
+	if _, err := exec.LookPath("docker-compose"); err != nil {
+		r.add(CheckDocker, Warning, "docker-compose not found, compose services can't be instrumented", "Install docker-compose")
+	} else {
+		r.add(CheckDocker, OK, "docker-compose available", "")
+	}
+}
+
+// checkState checks configs can be written and the state store is readable
+func checkState(r *Report, configRoot string, store bool) {
+	if err := checkWritable(configRoot); err != nil {
+		r.add(CheckState, Error, fmt.Sprintf("Cannot write to %s: %v", configRoot, err), "Run with sudo, or fix the directory's permissions")
+	} else {
+		r.add(CheckState, OK, fmt.Sprintf("%s is writable", configRoot), "")
+	}
+
+	if !store {
+		return
+	}
+	s, err := state.LoadStore()
+	if err != nil {
+		r.add(CheckState, Error, err.Error(),
+			fmt.Sprintf("Move %s aside, mw-injector then starts with an empty state", filepath.Join(state.DefaultStateDir, state.StoreFile)))
+		return
+	}
+	r.add(CheckState, OK, fmt.Sprintf("State store readable, %d recorded changes", len(s.Artifacts)), "")
+}
+
+// checkWritable tests a directory, or the nearest existing parent that
+// would hold it, by creating and removing a file in it
+func checkWritable(dir string) error {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("no existing parent directory")
+		}
+		dir = parent
+	}
+	file, err := os.CreateTemp(dir, ".mw-doctor-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkResources checks free disk where configs and the agent live, and
+// free memory for the agent in each JVM and unit
+func checkResources(r *Report, opts Options) {
+	for _, dir := range []string{opts.ConfigRoot, filepath.Dir(opts.AgentPath)} {
+		free, err := freeDisk(dir)
+		if err != nil {
+			continue
+		}
+		if free < MinFreeDisk {
+			r.add(CheckResources, Warning, fmt.Sprintf("Only %s free on the filesystem of %s", formatBytes(free), dir),
+				"Free some disk space before instrumenting")
+		}
+	}
+
+	if len(opts.Processes) == 0 {
+		return
+	}
+	if available := availableMemory(); available > 0 {
+		need := uint64(len(opts.Processes)) * JVMHeadroom
+		if available < need {
+			r.add(CheckResources, Warning, fmt.Sprintf("%s of memory available, the agent needs about %s in each of %d JVMs",
+				formatBytes(available), formatBytes(JVMHeadroom), len(opts.Processes)),
+				"Free memory or instrument fewer services at a time, e.g. with --service")
+		} else {
+			r.add(CheckResources, OK, fmt.Sprintf("%s of memory available for %d JVMs", formatBytes(available), len(opts.Processes)), "")
+		}
+	}
+
+	if !opts.Root {
+		return
+	}
+	checked := make(map[string]bool)
+	for _, proc := range opts.Processes {
+		unit := discovery.SystemdUnitForPID(proc.ProcessPID)
+		if unit == "" || strings.Contains(unit, "/") || checked[unit] {
+			continue
+		}
+		checked[unit] = true
+		properties := systemd.Show(unit, "MemoryMax", "MemoryCurrent")
+		limit, err1 := strconv.ParseUint(properties["MemoryMax"], 10, 64)
+		current, err2 := strconv.ParseUint(properties["MemoryCurrent"], 10, 64)
+		if err1 != nil || err2 != nil || current > limit {
+			continue // no limit or no accounting
+		}
+		if limit-current < JVMHeadroom {
+			r.add(CheckResources, Warning, fmt.Sprintf("%s uses %s of its MemoryMax=%s, the agent may push it over",
+				unit, formatBytes(current), formatBytes(limit)),
+				fmt.Sprintf("Raise MemoryMax of %s by at least %s", unit, formatBytes(JVMHeadroom)))
+		}
+	}
+}
+
+// freeDisk returns the space available to unprivileged users on the
+// filesystem holding path, or its nearest existing parent
+func freeDisk(path string) (uint64, error) {
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return stat.Bavail * uint64(stat.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
+
+// availableMemory returns MemAvailable from /proc/meminfo, 0 when unknown
+func availableMemory() uint64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+// formatBytes formats a size in MiB or GiB
+func formatBytes(n uint64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%d MiB", n>>20)
+}
@@ -0,0 +1,188 @@
+// Package drift describes where the instrumentation set up by mw-injector no
+// longer matches what's on disk and running, typically because a config
+// management tool or a person edited a unit or compose file behind our back.
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+// ReportFile is the latest drift report, in the state directory
+const ReportFile = "drift-report.json"
+
+// Drift kinds
+const (
+	AgentMissing        = "agent-missing"         // the agent JAR is gone
+	AgentPathChanged    = "agent-path-changed"    // a config or drop-in points at another agent
+	DropInMissing       = "dropin-missing"        // the Middleware drop-in was removed
+	DropInNotLoaded     = "dropin-not-loaded"     // systemd doesn't load the drop-in
+	OptionsOverridden   = "options-overridden"    // a later setting replaced JAVA_TOOL_OPTIONS or CATALINA_OPTS
+	ManagedBlockRemoved = "managed-block-removed" // the Middleware block of a script or env file was removed
+	FileRemoved         = "file-removed"          // a file written for the service was removed
+	ComposeRemoved      = "compose-override-removed"
+	ContainerRecreated  = "container-recreated" // runs without the agent
+	ExcludedByPolicy    = "excluded-by-policy"  // instrumented, but the policy now excludes it
+)
+
+// Repair outcomes
+const (
+	Repaired     = "repaired"
+	RepairFailed = "repair-failed"
+	Manual       = "manual"   // needs an operator
+	Reported     = "reported" // repairs are turned off
+)
+
+// Finding is one difference between the desired and the actual state
+type Finding struct {
+	Target   string `json:"target"`
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Detail   string `json:"detail"`
+	Repair   string `json:"repair"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report lists the drift found by one reconcile run
+type Report struct {
+	Hostname    string     `json:"hostname"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	Repair      bool       `json:"repair"`
+	Checked     int        `json:"checked"` // services and containers checked
+	Findings    []*Finding `json:"findings"`
+}
+
+// NewReport starts a report, repair tells whether findings get repaired
+func NewReport(repair bool) *Report {
+	hostname, _ := os.Hostname()
+	return &Report{Hostname: hostname, GeneratedAt: time.Now(), Repair: repair, Findings: []*Finding{}}
+}
+
+// Add records a finding, reported only when repairs are turned off
+func (r *Report) Add(f *Finding) *Finding {
+	if !r.Repair && f.Repair != Manual {
+		f.Repair = Reported
+	}
+	r.Findings = append(r.Findings, f)
+	return f
+}
+
+// Resolve records the outcome of repairing a finding
+func (f *Finding) Resolve(err error) {
+	if err != nil {
+		f.Repair = RepairFailed
+		f.Error = err.Error()
+		return
+	}
+	f.Repair = Repaired
+}
+
+// Unresolved returns the number of findings that weren't repaired
+func (r *Report) Unresolved() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Repair != Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the report as JSON
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drift report: %w", err)
+	}
+	if err := changeset.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if err := changeset.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write drift report: %w", err)
+	}
+	return nil
+}
+
+// AgentOption returns the agent of the first -javaagent option in JVM
+// options or a drop-in, empty when there's none
+func AgentOption(options string) string {
+	_, agent, found := strings.Cut(options, "-javaagent:")
+	if !found {
+		return ""
+	}
+	if end := strings.IndexAny(agent, " \t\n\"'="); end >= 0 {
+		agent = agent[:end]
+	}
+	return agent
+}
+
+// ParseEnvironment parses the Environment property of systemctl show, where
+// assignments containing spaces are double quoted
+func ParseEnvironment(value string) map[string]string {
+	env := make(map[string]string)
+	var b strings.Builder
+	quoted, escaped, started := false, false, false
+	flush := func() {
+		if key, v, found := strings.Cut(b.String(), "="); found && started {
+			env[key] = v
+		}
+		b.Reset()
+		started = false
+	}
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case r == ' ' && !quoted:
+			flush()
+		default:
+			b.WriteRune(r)
+			started = true
+		}
+	}
+	flush()
+	return env
+}
+
+// OverriddenBy returns the drop-in loaded after ours that sets a variable
+// again, empty when none does
+func OverriddenBy(dropInPaths []string, ours, variable string) string {
+	after := false
+	overriding := ""
+	for _, path := range dropInPaths {
+		if path == ours {
+			after = true
+			continue
+		}
+		if !after {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Environment=") && strings.Contains(line, variable+"=") {
+				overriding = path
+			}
+		}
+	}
+	return overriding
+}
@@ -0,0 +1,75 @@
+package drift_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/drift"
+)
+
+func TestAgentOption(t *testing.T) {
+	tests := map[string]string{
+		"-Xmx1g -javaagent:/opt/middleware/agents/agent.jar -Dotel.service.name=billing": "/opt/middleware/agents/agent.jar",
+		`Environment="JAVA_TOOL_OPTIONS=-javaagent:/opt/a.jar"`:                          "/opt/a.jar",
+		"-javaagent:/opt/jmx.jar=config.yaml":                                            "/opt/jmx.jar",
+		"-Xmx1g":                                                                         "",
+	}
+	for options, want := range tests {
+		if got := drift.AgentOption(options); got != want {
+			t.Errorf("AgentOption(%q) = %q, expected %q", options, got, want)
+		}
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	env := drift.ParseEnvironment(`LANG=C "JAVA_TOOL_OPTIONS=-javaagent:/opt/a.jar -Dname=\"x y\"" EMPTY=`)
+	if got := env["JAVA_TOOL_OPTIONS"]; got != `-javaagent:/opt/a.jar -Dname="x y"` {
+		t.Errorf("JAVA_TOOL_OPTIONS = %q", got)
+	}
+	if env["LANG"] != "C" {
+		t.Errorf("LANG = %q", env["LANG"])
+	}
+	if value, ok := env["EMPTY"]; !ok || value != "" {
+		t.Errorf("EMPTY = %q, %v", value, ok)
+	}
+}
+
+func TestOverriddenBy(t *testing.T) {
+	dir := t.TempDir()
+	ours := filepath.Join(dir, "middleware-instrumentation.conf")
+	earlier := filepath.Join(dir, "10-memory.conf")
+	later := filepath.Join(dir, "zz-puppet.conf")
+	os.WriteFile(earlier, []byte("[Service]\nEnvironment=\"JAVA_TOOL_OPTIONS=-Xmx1g\"\n"), 0o644)
+	os.WriteFile(ours, []byte("[Service]\nEnvironment=\"JAVA_TOOL_OPTIONS=-javaagent:/opt/a.jar\"\n"), 0o644)
+	os.WriteFile(later, []byte("[Service]\nEnvironment=\"JAVA_TOOL_OPTIONS=-Xmx2g\"\n"), 0o644)
+
+	if got := drift.OverriddenBy([]string{earlier, ours, later}, ours, "JAVA_TOOL_OPTIONS"); got != later {
+		t.Errorf("OverriddenBy() = %q, expected %q", got, later)
+	}
+	if got := drift.OverriddenBy([]string{earlier, ours}, ours, "JAVA_TOOL_OPTIONS"); got != "" {
+		t.Errorf("OverriddenBy() = %q without a later drop-in", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := drift.NewReport(false)
+	f := report.Add(&drift.Finding{Target: "process billing", Kind: drift.DropInMissing})
+	report.Add(&drift.Finding{Target: "process orders", Kind: drift.OptionsOverridden, Repair: drift.Manual})
+	if f.Repair != drift.Reported {
+		t.Errorf("Repair = %q with repairs turned off", f.Repair)
+	}
+
+	f.Resolve(nil)
+	if got := report.Unresolved(); got != 1 {
+		t.Errorf("Unresolved() = %d, expected 1", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "state", drift.ReportFile)
+	if err := report.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("report not written: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package inventory is the machine-readable output of list and status: a
+// versioned schema of the Java processes and containers on a host, as JSON,
+// YAML or a table
+package inventory
+
+import (
+	"os"
+	"time"
+)
+
+// SchemaVersion is bumped when a field is renamed, removed or changes
+// meaning. New fields may be added without a bump.
+const SchemaVersion = 1
+
+// Output formats, text is the default emoji output of each command
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+	FormatTable = "table"
+	FormatWide  = "wide"
+)
+
+// Formats are the values --output accepts
+var Formats = []string{FormatText, FormatJSON, FormatYAML, FormatTable, FormatWide}
+
+// Inventory is one document written by list, list-docker, list-all or
+// status. Each command fills in its own sections, the others are left out.
+type Inventory struct {
+	SchemaVersion int       `json:"schema_version" yaml:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at" yaml:"generated_at"`
+	Host          string    `json:"host" yaml:"host"`
+
+	Processes  *[]Process   `json:"processes,omitempty" yaml:"processes,omitempty"`
+	Containers *[]Container `json:"containers,omitempty" yaml:"containers,omitempty"`
+	Services   *[]Service   `json:"services,omitempty" yaml:"services,omitempty"`
+}
+
+// Process is a Java process running on the host
+type Process struct {
+	PID            int32             `json:"pid" yaml:"pid"`
+	ServiceName    string            `json:"service_name" yaml:"service_name"` // name the agent reports
+	Owner          string            `json:"owner" yaml:"owner"`
+	DeploymentType string            `json:"deployment_type" yaml:"deployment_type"` // tomcat, wildfly, jetty, launcher or the supervisor kind
+	Supervisor     string            `json:"supervisor" yaml:"supervisor"`
+	SupervisorName string            `json:"supervisor_name,omitempty" yaml:"supervisor_name,omitempty"`
+	Unit           string            `json:"unit,omitempty" yaml:"unit,omitempty"`
+	ConfigPath     string            `json:"config_path" yaml:"config_path"`
+	Configured     bool              `json:"configured" yaml:"configured"`
+	JavaVersion    string            `json:"java_version,omitempty" yaml:"java_version,omitempty"`
+	JarFile        string            `json:"jar_file,omitempty" yaml:"jar_file,omitempty"`
+	MainClass      string            `json:"main_class,omitempty" yaml:"main_class,omitempty"`
+	Webapps        map[string]string `json:"webapps,omitempty" yaml:"webapps,omitempty"` // Tomcat context path to service name
+	Agent          Agent             `json:"agent" yaml:"agent"`
+	Policy         *Policy           `json:"policy,omitempty" yaml:"policy,omitempty"`
+}
+
+// Container is a Java Docker container
+type Container struct {
+	ID             string   `json:"id" yaml:"id"`
+	Name           string   `json:"name" yaml:"name"`
+	ServiceName    string   `json:"service_name" yaml:"service_name"`
+	Image          string   `json:"image" yaml:"image"`
+	Status         string   `json:"status" yaml:"status"`
+	DeploymentType string   `json:"deployment_type" yaml:"deployment_type"` // compose or container
+	ComposeProject string   `json:"compose_project,omitempty" yaml:"compose_project,omitempty"`
+	ComposeService string   `json:"compose_service,omitempty" yaml:"compose_service,omitempty"`
+	ConfigPath     string   `json:"config_path,omitempty" yaml:"config_path,omitempty"` // the compose file
+	Instrumented   bool     `json:"instrumented" yaml:"instrumented"`
+	JarFiles       []string `json:"jar_files,omitempty" yaml:"jar_files,omitempty"`
+	Agent          Agent    `json:"agent" yaml:"agent"`
+	Policy         *Policy  `json:"policy,omitempty" yaml:"policy,omitempty"`
+}
+
+// Agent is the Java agent a JVM runs with
+type Agent struct {
+	Type       string `json:"type" yaml:"type"` // none, middleware, opentelemetry or other
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
+	Name       string `json:"name,omitempty" yaml:"name,omitempty"`
+	Version    string `json:"version,omitempty" yaml:"version,omitempty"`
+	SDKVersion string `json:"sdk_version,omitempty" yaml:"sdk_version,omitempty"` // bundled OpenTelemetry SDK
+	Serverless bool   `json:"serverless,omitempty" yaml:"serverless,omitempty"`
+}
+
+// Policy is what the instrumentation policy decided for a process or
+// container
+type Policy struct {
+	Include bool   `json:"include" yaml:"include"`
+	Rule    string `json:"rule,omitempty" yaml:"rule,omitempty"`
+	Reason  string `json:"reason" yaml:"reason"`
+	Restart string `json:"restart,omitempty" yaml:"restart,omitempty"`
+}
+
+// Service is the instrumentation status of a process, a config whose
+// service isn't running or a container
+type Service struct {
+	Name         string   `json:"name" yaml:"name"`
+	Kind         string   `json:"kind" yaml:"kind"` // process, config or container
+	PID          int32    `json:"pid,omitempty" yaml:"pid,omitempty"`
+	Unit         string   `json:"unit,omitempty" yaml:"unit,omitempty"`
+	ConfigPath   string   `json:"config_path,omitempty" yaml:"config_path,omitempty"`
+	AgentVersion string   `json:"agent_version,omitempty" yaml:"agent_version,omitempty"` // agent the JVM runs with
+	State        string   `json:"state" yaml:"state"`
+	Reasons      []string `json:"reasons,omitempty" yaml:"reasons,omitempty"`
+}
+
+// New returns an empty inventory of this host
+func New() *Inventory {
+	host, _ := os.Hostname()
+	return &Inventory{
+		SchemaVersion: SchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Host:          host,
+	}
+}
+
+// SetProcesses fills in the processes section, present even when empty
+func (inv *Inventory) SetProcesses(processes []Process) {
+	if processes == nil {
+		processes = []Process{}
+	}
+	inv.Processes = &processes
+}
+
+// SetContainers fills in the containers section, present even when empty
+func (inv *Inventory) SetContainers(containers []Container) {
+	if containers == nil {
+		containers = []Container{}
+	}
+	inv.Containers = &containers
+}
+
+// SetServices fills in the services section, present even when empty
+func (inv *Inventory) SetServices(services []Service) {
+	if services == nil {
+		services = []Service{}
+	}
+	inv.Services = &services
+}
@@ -0,0 +1,108 @@
+package inventory_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/inventory"
+	"gopkg.in/yaml.v3"
+)
+
+func sample() *inventory.Inventory {
+	inv := inventory.New()
+	inv.SetProcesses([]inventory.Process{{
+		PID:            4242,
+		ServiceName:    "billing",
+		Owner:          "tomcat",
+		DeploymentType: "tomcat",
+		Supervisor:     "systemd",
+		Unit:           "tomcat.service",
+		ConfigPath:     "/etc/middleware/tomcat/billing.conf",
+		Configured:     true,
+		Agent:          inventory.Agent{Type: "middleware", Path: "/opt/middleware/agents/agent.jar"},
+		Policy:         &inventory.Policy{Include: true, Reason: "default"},
+	}})
+	return inv
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := inventory.Write(&buf, inventory.FormatJSON, sample()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if doc["schema_version"] != float64(inventory.SchemaVersion) {
+		t.Errorf("schema_version = %v, want %d", doc["schema_version"], inventory.SchemaVersion)
+	}
+	if _, ok := doc["containers"]; ok {
+		t.Error("containers present though list never set them")
+	}
+	processes := doc["processes"].([]any)
+	process := processes[0].(map[string]any)
+	for _, key := range []string{"pid", "service_name", "deployment_type", "unit", "config_path", "agent"} {
+		if _, ok := process[key]; !ok {
+			t.Errorf("process has no %q", key)
+		}
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	inv := inventory.New()
+	inv.SetServices(nil)
+
+	var buf bytes.Buffer
+	if err := inventory.Write(&buf, inventory.FormatYAML, inv); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid YAML: %v\n%s", err, buf.String())
+	}
+	if services, ok := doc["services"]; !ok || len(services.([]any)) != 0 {
+		t.Errorf("services = %v, want an empty list", services)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+		absent []string
+	}{
+		{inventory.FormatTable, []string{"PID", "4242", "billing", "tomcat", "middleware", "yes"}, []string{"UNIT", "tomcat.service"}},
+		{inventory.FormatWide, []string{"UNIT", "tomcat.service", "include", "/etc/middleware/tomcat/billing.conf"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := inventory.Write(&buf, tt.format, sample()); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			out := buf.String()
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("output has no %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(out, s) {
+					t.Errorf("output has %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteUnsupportedFormat(t *testing.T) {
+	if err := inventory.Write(&bytes.Buffer{}, "xml", sample()); err == nil {
+		t.Error("Write(xml) succeeded, want an error")
+	}
+}
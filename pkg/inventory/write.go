@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Write writes the inventory in a machine-readable format
+func Write(w io.Writer, format string, inv *Inventory) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inv)
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(inv); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatTable, FormatWide:
+		return writeTables(w, inv, format == FormatWide)
+	default:
+		return fmt.Errorf("unsupported output format %q, use one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// writeTables writes a table per section, wide adds the less used columns
+func writeTables(w io.Writer, inv *Inventory, wide bool) error {
+	var tables []func(*tabwriter.Writer)
+	if inv.Processes != nil {
+		tables = append(tables, func(tw *tabwriter.Writer) { processTable(tw, *inv.Processes, wide) })
+	}
+	if inv.Containers != nil {
+		tables = append(tables, func(tw *tabwriter.Writer) { containerTable(tw, *inv.Containers, wide) })
+	}
+	if inv.Services != nil {
+		tables = append(tables, func(tw *tabwriter.Writer) { serviceTable(tw, *inv.Services, wide) })
+	}
+
+	for i, table := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		table(tw)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func processTable(tw *tabwriter.Writer, processes []Process, wide bool) {
+	row(tw, wide, []string{"PID", "SERVICE", "TYPE", "AGENT", "CONFIGURED"},
+		[]string{"AGENT VERSION", "OWNER", "UNIT", "POLICY", "CONFIG"})
+	for _, p := range processes {
+		row(tw, wide,
+			[]string{strconv.Itoa(int(p.PID)), p.ServiceName, p.DeploymentType, p.Agent.Type, yesNo(p.Configured)},
+			[]string{dash(p.Agent.Version), p.Owner, dash(p.Unit), policyColumn(p.Policy), p.ConfigPath})
+	}
+}
+
+func containerTable(tw *tabwriter.Writer, containers []Container, wide bool) {
+	row(tw, wide, []string{"NAME", "IMAGE", "TYPE", "AGENT", "INSTRUMENTED"},
+		[]string{"ID", "STATUS", "SERVICE", "POLICY", "CONFIG"})
+	for _, c := range containers {
+		row(tw, wide,
+			[]string{c.Name, c.Image, c.DeploymentType, c.Agent.Type, yesNo(c.Instrumented)},
+			[]string{c.ID, c.Status, c.ServiceName, policyColumn(c.Policy), dash(c.ConfigPath)})
+	}
+}
+
+func serviceTable(tw *tabwriter.Writer, services []Service, wide bool) {
+	row(tw, wide, []string{"STATE", "NAME", "KIND"}, []string{"PID", "UNIT", "AGENT VERSION", "CONFIG", "REASONS"})
+	for _, s := range services {
+		pid := "-"
+		if s.PID != 0 {
+			pid = strconv.Itoa(int(s.PID))
+		}
+		row(tw, wide, []string{s.State, s.Name, s.Kind},
+			[]string{pid, dash(s.Unit), dash(s.AgentVersion), dash(s.ConfigPath), dash(strings.Join(s.Reasons, "; "))})
+	}
+}
+
+// row writes a table row, with the extra columns when wide
+func row(tw *tabwriter.Writer, wide bool, columns, extra []string) {
+	if wide {
+		columns = append(columns, extra...)
+	}
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+}
+
+func policyColumn(p *Policy) string {
+	switch {
+	case p == nil:
+		return "-"
+	case p.Include:
+		return "include"
+	default:
+		return "exclude"
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+// Package jetty instruments Jetty servers started through start.jar by adding
+// a start.d module file to jetty.base.
+package jetty
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+)
+
+// IniName is the start.d file holding our JVM arguments
+const IniName = "middleware.ini"
+
+// Config holds configuration for instrumenting a Jetty server
+type Config struct {
+	ServiceName string
+	Info        *discovery.JettyInfo
+	APIKey      string // empty when the unit provides it through EnvironmentFile=
+	Target      string
+	AgentPath   string
+}
+
+// IniPath returns the path of the Middleware start.d file for a jetty.base
+func IniPath(info *discovery.JettyInfo) string {
+	return filepath.Join(info.BaseDir, "start.d", IniName)
+}
+
+// Instrument writes start.d/middleware.ini and returns its path. start.jar
+// only applies JVM arguments from ini files when it forks, hence --exec.
+func Instrument(cfg *Config) (string, error) {
+	if cfg.Info == nil || !cfg.Info.IsJetty {
+		return "", fmt.Errorf("not a Jetty process")
+	}
+	if cfg.Info.BaseDir == "" {
+		return "", fmt.Errorf("could not determine jetty.base")
+	}
+	if _, err := os.Stat(cfg.Info.BaseDir); err != nil {
+		return "", fmt.Errorf("jetty.base %s is not accessible: %w", cfg.Info.BaseDir, err)
+	}
+
+	iniPath := IniPath(cfg.Info)
+	if err := managed.UpsertBlock(iniPath, buildIni(cfg), 0o644); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("   Created start.d module: %s\n", iniPath)
+	return iniPath, nil
+}
+
+// buildIni renders the start.d ini content
+func buildIni(cfg *Config) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	lines := []string{
+		"# Fork a JVM so the JVM arguments below are applied",
+		"--exec",
+		"-javaagent:" + cfg.AgentPath,
+		fmt.Sprintf("-Dotel.service.name=%s@%s", cfg.ServiceName, hostname),
+		"-Dotel.exporter.otlp.endpoint=" + cfg.Target,
+		"-Dotel.traces.exporter=otlp",
+		"-Dotel.metrics.exporter=otlp",
+		"-Dotel.logs.exporter=otlp",
+	}
+	if cfg.APIKey != "" {
+		lines = append(lines, "-Dotel.exporter.otlp.headers=authorization="+cfg.APIKey)
+	}
+
+	return strings.Join(lines, "\n")
+}
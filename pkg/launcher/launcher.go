@@ -0,0 +1,143 @@
+// Package launcher instruments applications started by bin/<app> shell
+// scripts (Gradle application plugin, Maven appassembler, hand written) by
+// exporting the agent through the *_OPTS variable the script honours.
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// Config holds configuration for instrumenting a launcher script application
+type Config struct {
+	ServiceName string
+	Info        *discovery.LauncherInfo
+	SystemdUnit string
+	Target      string
+	AgentPath   string
+}
+
+// Hook describes where the options variable was written
+type Hook struct {
+	Path   string
+	DropIn bool
+}
+
+// Instrument exports the options variable with the agent, either in the
+// environment file that already defines it or in a systemd drop-in
+func Instrument(cfg *Config) (*Hook, error) {
+	if cfg.Info == nil || !cfg.Info.IsLauncher {
+		return nil, fmt.Errorf("not started by a launcher script")
+	}
+	if cfg.SystemdUnit == "" {
+		return nil, fmt.Errorf("%s is not run by a systemd unit, add -javaagent:%s to %s where it is started",
+			cfg.Info.Script, cfg.AgentPath, cfg.Info.OptsVar)
+	}
+
+	// Variables from EnvironmentFile= override Environment=, so a variable
+	// defined in an env file has to be changed there
+	for _, envFile := range systemd.EnvironmentFiles(cfg.SystemdUnit) {
+		existing, defined := readEnvFileValue(envFile, cfg.Info.OptsVar)
+		if !defined {
+			continue
+		}
+
+		if err := managed.UpsertBlock(envFile, buildEnvFileBlock(cfg, existing), 0o644); err != nil {
+			return nil, err
+		}
+		fmt.Printf("   Updated environment file: %s\n", envFile)
+		return &Hook{Path: envFile}, nil
+	}
+
+	existing := systemd.EnvironmentValue(cfg.SystemdUnit, cfg.Info.OptsVar)
+	dropInPath, err := systemd.WriteDropIn(cfg.SystemdUnit, buildDropIn(cfg, existing))
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{Path: dropInPath, DropIn: true}, nil
+}
+
+// optsValue appends the agent to an existing options value, dropping an
+// agent added by an earlier run
+func optsValue(cfg *Config, existing string) string {
+	agentOpt := "-javaagent:" + cfg.AgentPath
+
+	var opts []string
+	for _, opt := range strings.Fields(existing) {
+		if opt != agentOpt {
+			opts = append(opts, opt)
+		}
+	}
+	return strings.Join(append(opts, agentOpt), " ")
+}
+
+// otelEnvironment returns the OpenTelemetry exporter settings. The headers
+// carry the API key and are loaded from the secrets EnvironmentFile= instead.
+func otelEnvironment(cfg *Config) [][2]string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return [][2]string{
+		{"OTEL_SERVICE_NAME", fmt.Sprintf("%s@%s", cfg.ServiceName, hostname)},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", cfg.Target},
+		{"OTEL_TRACES_EXPORTER", "otlp"},
+		{"OTEL_METRICS_EXPORTER", "otlp"},
+		{"OTEL_LOGS_EXPORTER", "otlp"},
+	}
+}
+
+// buildEnvFileBlock renders the environment file assignments. Env files don't
+// expand variables, so the existing value is repeated.
+func buildEnvFileBlock(cfg *Config, existing string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s=\"%s\"\n", cfg.Info.OptsVar, optsValue(cfg, existing))
+	for _, kv := range otelEnvironment(cfg) {
+		fmt.Fprintf(&b, "%s=\"%s\"\n", kv[0], kv[1])
+	}
+
+	return b.String()
+}
+
+// buildDropIn renders the systemd drop-in
+func buildDropIn(cfg *Config, existing string) string {
+	var b strings.Builder
+
+	b.WriteString("[Service]\n")
+	fmt.Fprintf(&b, "Environment=\"%s=%s\"\n", cfg.Info.OptsVar, optsValue(cfg, existing))
+	for _, kv := range otelEnvironment(cfg) {
+		fmt.Fprintf(&b, "Environment=\"%s=%s\"\n", kv[0], kv[1])
+	}
+
+	return b.String()
+}
+
+// readEnvFileValue returns the last value assigned to a variable in an
+// environment file, ignoring our managed block
+func readEnvFileValue(path, name string) (string, bool) {
+	content, err := managed.ReadWithoutBlock(path)
+	if err != nil {
+		return "", false
+	}
+
+	value := ""
+	defined := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "export ")
+		if !strings.HasPrefix(line, name+"=") {
+			continue
+		}
+		value = strings.Trim(strings.TrimPrefix(line, name+"="), `"'`)
+		defined = true
+	}
+
+	return value, defined
+}
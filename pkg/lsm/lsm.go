@@ -0,0 +1,136 @@
+// Package lsm finds out why SELinux or AppArmor keeps a service from reading
+// the agent, and adds the rules that allow it
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Security modules
+const (
+	SELinux  = "selinux"
+	AppArmor = "apparmor"
+)
+
+// SELinux modes
+const (
+	Enforcing  = "enforcing"
+	Permissive = "permissive"
+	Disabled   = "disabled"
+)
+
+const (
+	// AgentDir is the directory the rules allow services to read
+	AgentDir = "/opt/middleware/agents"
+
+	// AgentLabel is the SELinux file type confined services can read
+	AgentLabel = "usr_t"
+)
+
+// readableLabels are file types confined service domains can read
+var readableLabels = []string{"usr_t", "lib_t", "bin_t"}
+
+// unconfinedDomains may read files of any type
+var unconfinedDomains = []string{"unconfined_t", "unconfined_service_t", "initrc_t", "kernel_t"}
+
+// SELinuxMode returns whether SELinux is enforcing, permissive or disabled
+func SELinuxMode() string {
+	data, err := os.ReadFile("/sys/fs/selinux/enforce")
+	if err != nil {
+		return Disabled
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return Enforcing
+	}
+	return Permissive
+}
+
+// AppArmorEnabled tells whether AppArmor is enabled
+func AppArmorEnabled() bool {
+	data, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	return err == nil && strings.TrimSpace(string(data)) == "Y"
+}
+
+// FileLabel returns the SELinux label of a file, e.g.
+// system_u:object_r:usr_t:s0
+func FileLabel(path string) string {
+	output, err := exec.Command("stat", "-c", "%C", path).Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// LabelType returns the type of a label, e.g. usr_t
+func LabelType(label string) string {
+	if parts := strings.Split(label, ":"); len(parts) > 2 {
+		return parts[2]
+	}
+	return ""
+}
+
+// Readable tells whether confined services can read files of a label
+func Readable(label string) bool {
+	return slices.Contains(readableLabels, LabelType(label))
+}
+
+// ProcessContext returns the security context of a process: its SELinux
+// label, or its AppArmor profile such as "/usr/bin/java (enforce)"
+func ProcessContext(pid int32) string {
+	for _, attr := range []string{"attr/apparmor/current", "attr/current"} {
+		if data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(int(pid)), attr)); err == nil {
+			return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+		}
+	}
+	return ""
+}
+
+// EnforcedProfile returns the AppArmor profile a process is confined by in
+// enforce mode, empty when it isn't
+func EnforcedProfile(pid int32) string {
+	profile, enforced := strings.CutSuffix(ProcessContext(pid), " (enforce)")
+	if !enforced {
+		return ""
+	}
+	return profile
+}
+
+// Denial is a security module keeping a service from reading the agent
+type Denial struct {
+	Module  string // SELinux or AppArmor
+	Label   string // SELinux label of the agent
+	Domain  string // SELinux domain of the service
+	Profile string // AppArmor profile of the service
+}
+
+func (d *Denial) String() string {
+	if d.Module == SELinux {
+		return fmt.Sprintf("SELinux is enforcing and the %s domain can't read files labelled %s", d.Domain, LabelType(d.Label))
+	}
+	return fmt.Sprintf("the AppArmor profile %s doesn't allow reading the agent", d.Profile)
+}
+
+// Diagnose finds out whether SELinux or AppArmor keeps a process from
+// reading the agent, comparing the agent's label with the process' domain.
+// It returns nil when neither is the cause.
+func Diagnose(agentPath string, pid int32) *Denial {
+	if SELinuxMode() == Enforcing {
+		label := FileLabel(agentPath)
+		domain := LabelType(ProcessContext(pid))
+		if domain != "" && !slices.Contains(unconfinedDomains, domain) && !Readable(label) {
+			return &Denial{Module: SELinux, Label: label, Domain: domain}
+		}
+	}
+	if AppArmorEnabled() {
+		if profile := EnforcedProfile(pid); profile != "" {
+			return &Denial{Module: AppArmor, Profile: profile}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package lsm_test
+
+import (
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/lsm"
+)
+
+func TestReadable(t *testing.T) {
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"system_u:object_r:usr_t:s0", true},
+		{"unconfined_u:object_r:user_home_t:s0", false},
+		{"system_u:object_r:tmp_t:s0", false},
+		{"unknown", false},
+	}
+
+	for _, tt := range tests {
+		if got := lsm.Readable(tt.label); got != tt.want {
+			t.Errorf("Readable(%q) = %v, want %v", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestProfileFile(t *testing.T) {
+	tests := []struct {
+		profile string
+		file    string
+		local   string
+	}{
+		{"/usr/bin/java", "/etc/apparmor.d/usr.bin.java", "/etc/apparmor.d/local/usr.bin.java"},
+		{"tomcat", "/etc/apparmor.d/tomcat", "/etc/apparmor.d/local/tomcat"},
+	}
+
+	for _, tt := range tests {
+		file := lsm.ProfileFile(tt.profile)
+		if file != tt.file {
+			t.Errorf("ProfileFile(%q) = %q, want %q", tt.profile, file, tt.file)
+		}
+		if local := lsm.LocalInclude(file); local != tt.local {
+			t.Errorf("LocalInclude(%q) = %q, want %q", file, local, tt.local)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+)
+
+const (
+	// AgentDirPattern is the SELinux file context rule labelling the agent
+	// directory
+	AgentDirPattern = AgentDir + "(/.*)?"
+
+	// AppArmorDir holds the AppArmor profiles
+	AppArmorDir = "/etc/apparmor.d"
+)
+
+// AppArmorRule lets a profile read the agents
+const AppArmorRule = AgentDir + "/** r,"
+
+// HasFileContext tells whether a local file context rule for the agent
+// directory exists, added by us or by the administrator
+func HasFileContext() bool {
+	output, err := exec.Command("semanage", "fcontext", "-l", "-C").Output()
+	return err == nil && strings.Contains(string(output), AgentDirPattern)
+}
+
+// AllowSELinux adds a persistent file context rule labelling the agent
+// directory usr_t and relabels it. It tells whether it added the rule, an
+// existing one is only applied.
+func AllowSELinux() (bool, error) {
+	if HasFileContext() {
+		return false, relabel()
+	}
+	if _, err := exec.LookPath("semanage"); err != nil {
+		return false, fmt.Errorf("semanage not found, install policycoreutils-python-utils")
+	}
+	if err := changeset.Run(exec.Command("semanage", "fcontext", "-a", "-t", AgentLabel, AgentDirPattern)); err != nil {
+		return false, fmt.Errorf("failed to add file context rule for %s: %w", AgentDirPattern, err)
+	}
+	return true, relabel()
+}
+
+// RevertSELinux removes the file context rule and relabels the agent
+// directory with the policy's default
+func RevertSELinux() error {
+	if err := changeset.Run(exec.Command("semanage", "fcontext", "-d", AgentDirPattern)); err != nil {
+		return fmt.Errorf("failed to remove file context rule for %s: %w", AgentDirPattern, err)
+	}
+	return relabel()
+}
+
+func relabel() error {
+	if _, err := os.Stat(AgentDir); os.IsNotExist(err) {
+		return nil
+	}
+	if err := changeset.Run(exec.Command("restorecon", "-R", AgentDir)); err != nil {
+		return fmt.Errorf("failed to relabel %s: %w", AgentDir, err)
+	}
+	return nil
+}
+
+// ProfileFile returns the file of an AppArmor profile, named after the
+// profile's path by convention, e.g. usr.bin.java for /usr/bin/java
+func ProfileFile(profile string) string {
+	name := strings.TrimPrefix(strings.ReplaceAll(profile, "/", "."), ".")
+	return filepath.Join(AppArmorDir, name)
+}
+
+// LocalInclude returns the local include of a profile file, where site
+// specific rules go
+func LocalInclude(profileFile string) string {
+	return filepath.Join(AppArmorDir, "local", filepath.Base(profileFile))
+}
+
+// AllowAppArmor lets a profile read the agents through its local include,
+// reloads it and returns the include it changed
+func AllowAppArmor(profile string) (string, error) {
+	profileFile := ProfileFile(profile)
+	data, err := os.ReadFile(profileFile)
+	if err != nil {
+		return "", fmt.Errorf("profile file of %s not found: %w", profile, err)
+	}
+	local := LocalInclude(profileFile)
+	if !strings.Contains(string(data), "local/"+filepath.Base(profileFile)) {
+		return "", fmt.Errorf("%s has no local include, add %q to the profile by hand", profileFile, AppArmorRule)
+	}
+
+	if err := managed.UpsertBlock(local, "  "+AppArmorRule, 0o644); err != nil {
+		return "", err
+	}
+	return local, reload(profileFile)
+}
+
+// RevertAppArmor removes the rule from a local include and reloads its
+// profile
+func RevertAppArmor(local string) error {
+	if _, err := managed.RemoveBlock(local); err != nil {
+		return err
+	}
+	return reload(filepath.Join(AppArmorDir, filepath.Base(local)))
+}
+
+func reload(profileFile string) error {
+	if err := changeset.Run(exec.Command("apparmor_parser", "-r", profileFile)); err != nil {
+		return fmt.Errorf("failed to reload %s: %w", profileFile, err)
+	}
+	return nil
+}
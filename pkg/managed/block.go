@@ -0,0 +1,146 @@
+// Package managed edits configuration files we don't own by keeping all of
+// our changes inside a single marked block that can be removed again.
+package managed
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+const (
+	// BeginMarker starts a block managed by mw-injector
+	BeginMarker = "# BEGIN Middleware.io instrumentation (managed by mw-injector, do not edit)"
+
+	// EndMarker ends a block managed by mw-injector
+	EndMarker = "# END Middleware.io instrumentation"
+)
+
+// UpsertBlock writes content into the managed block of a file, replacing an
+// existing block or appending a new one. Missing files are created with perm.
+func UpsertBlock(path, content string, perm os.FileMode) error {
+	existing, err := changeset.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	mode := perm
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	stripped, _ := stripBlock(string(existing))
+	if stripped != "" && !strings.HasSuffix(stripped, "\n") {
+		stripped += "\n"
+	}
+
+	block := BeginMarker + "\n" + strings.TrimRight(content, "\n") + "\n" + EndMarker + "\n"
+
+	if err := changeset.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	if err := changeset.WriteFile(path, []byte(stripped+block), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// RemoveBlock removes the managed block from a file. It reports whether a
+// block was found; files that only contained the block are deleted.
+func RemoveBlock(path string) (bool, error) {
+	existing, err := changeset.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	stripped, found := stripBlock(string(existing))
+	if !found {
+		return false, nil
+	}
+
+	if strings.TrimSpace(stripped) == "" {
+		if err := changeset.Remove(path); err != nil {
+			return true, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return true, nil
+	}
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := changeset.WriteFile(path, []byte(stripped), mode); err != nil {
+		return true, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return true, nil
+}
+
+// HasBlock checks if a file contains a managed block
+func HasBlock(path string) bool {
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), BeginMarker)
+}
+
+// ReadBlock returns the content of the managed block without the markers
+func ReadBlock(path string) (string, bool) {
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	content := string(data)
+	start := strings.Index(content, BeginMarker)
+	if start == -1 {
+		return "", false
+	}
+	body := content[start+len(BeginMarker):]
+	end := strings.Index(body, EndMarker)
+	if end == -1 {
+		return "", false
+	}
+
+	return strings.Trim(body[:end], "\n"), true
+}
+
+// ReadWithoutBlock returns the content of a file with the managed block removed
+func ReadWithoutBlock(path string) (string, error) {
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	stripped, _ := stripBlock(string(data))
+	return stripped, nil
+}
+
+// stripBlock removes the managed block (including its trailing newline)
+func stripBlock(content string) (string, bool) {
+	start := strings.Index(content, BeginMarker)
+	if start == -1 {
+		return content, false
+	}
+
+	end := strings.Index(content[start:], EndMarker)
+	if end == -1 {
+		// Unterminated block, drop everything after the marker
+		return content[:start], true
+	}
+	end += start + len(EndMarker)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+
+	return content[:start] + content[end:], true
+}
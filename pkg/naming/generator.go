@@ -11,6 +11,15 @@ import (
 // GenerateServiceName generates a service name for a Java process
 // Moved from main.go: generateServiceName()
 func GenerateServiceName(proc *discovery.JavaProcess) string {
+	// WildFly is checked first, its home directory may contain "tomcat"-like paths
+	if proc.IsWildFly() {
+		return GenerateForWildFly(proc)
+	}
+
+	if proc.IsJetty() {
+		return GenerateForJetty(proc)
+	}
+
 	// For Tomcat services, use tomcat-{INSTANCE-NAME} pattern
 	if proc.IsTomcat() {
 		return GenerateForTomcat(proc)
@@ -21,6 +30,40 @@ func GenerateServiceName(proc *discovery.JavaProcess) string {
 
 // GenerateForTomcat generates service names for Tomcat processes
 func GenerateForTomcat(proc *discovery.JavaProcess) string {
+	return fmt.Sprintf("tomcat-%s", tomcatInstanceName(proc))
+}
+
+// GenerateForTomcatWebapp generates the service name for a single webapp
+// deployed in a Tomcat instance by expanding the given pattern. An empty
+// pattern falls back to DefaultTomcatWebappPattern, and the ROOT context
+// (empty webapp name) keeps the instance-level name.
+func GenerateForTomcatWebapp(proc *discovery.JavaProcess, webapp, pattern string) string {
+	if webapp == "" || webapp == "ROOT" {
+		return GenerateForTomcat(proc)
+	}
+	if pattern == "" {
+		pattern = DefaultTomcatWebappPattern
+	}
+
+	name := ExpandServiceNamePattern(pattern, tomcatInstanceName(proc), webapp)
+	if name == "" {
+		return GenerateForTomcat(proc)
+	}
+	return name
+}
+
+// GenerateForTomcatWebapps returns the effective service name for every webapp
+// deployed in a Tomcat instance, keyed by context path (e.g. "/api")
+func GenerateForTomcatWebapps(proc *discovery.JavaProcess, pattern string) ContextServiceNames {
+	names := make(ContextServiceNames)
+	for _, webapp := range proc.GetTomcatWebapps() {
+		names[ContextPath(webapp)] = GenerateForTomcatWebapp(proc, webapp, pattern)
+	}
+	return names
+}
+
+// tomcatInstanceName returns the cleaned Tomcat instance name for a process
+func tomcatInstanceName(proc *discovery.JavaProcess) string {
 	tomcatInfo := proc.ExtractTomcatInfo()
 
 	// Get instance name from CATALINA_BASE
@@ -39,11 +82,38 @@ func GenerateForTomcat(proc *discovery.JavaProcess) string {
 		instanceName = "default"
 	}
 
-	// TODO: Once MW agent supports MW_SERVICE_NAME_PATTERN with {context} expansion,
-	// we can return a pattern here instead of just the instance name.
-	// For now, just return: tomcat-{instance}
-	// Future: return pattern that agent will expand per-webapp
-	return fmt.Sprintf("tomcat-%s", instanceName)
+	return instanceName
+}
+
+// GenerateForWildFly generates service names for WildFly / JBoss EAP processes
+func GenerateForWildFly(proc *discovery.JavaProcess) string {
+	info := proc.ExtractWildFlyInfo()
+
+	// Domain-managed servers are named after the server: -D[Server:server-one]
+	if strings.HasPrefix(info.ProcessType, "Server:") {
+		if name := NormalizeServiceName(strings.TrimPrefix(info.ProcessType, "Server:")); name != "" {
+			return fmt.Sprintf("wildfly-%s", name)
+		}
+	}
+
+	instanceName := CleanTomcatInstance(info.HomeDir)
+	if instanceName == "" || instanceName == "wildfly" || instanceName == "jboss-eap" {
+		instanceName = "default"
+	}
+
+	return fmt.Sprintf("wildfly-%s", instanceName)
+}
+
+// GenerateForJetty generates service names for Jetty processes from jetty.base
+func GenerateForJetty(proc *discovery.JavaProcess) string {
+	info := proc.ExtractJettyInfo()
+
+	instanceName := CleanTomcatInstance(info.BaseDir)
+	if instanceName == "" || instanceName == "jetty" || instanceName == "jetty-base" || instanceName == "jetty-home" {
+		instanceName = "default"
+	}
+
+	return fmt.Sprintf("jetty-%s", instanceName)
 }
 
 // GenerateForStandard generates service names for standard Java processes
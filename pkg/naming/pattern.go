@@ -0,0 +1,69 @@
+package naming
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultTomcatWebappPattern is used for per-webapp service names when
+	// no MW_SERVICE_NAME_PATTERN is configured
+	DefaultTomcatWebappPattern = "tomcat-{instance}-{context}"
+
+	// PlaceholderInstance expands to the cleaned Tomcat instance name
+	PlaceholderInstance = "{instance}"
+
+	// PlaceholderContext expands to the cleaned webapp context name
+	PlaceholderContext = "{context}"
+)
+
+// ExpandServiceNamePattern expands {instance} and {context} placeholders in
+// a service name pattern and normalizes the result
+func ExpandServiceNamePattern(pattern, instance, context string) string {
+	context = strings.Trim(context, "/")
+	context = strings.ReplaceAll(context, "#", "-") // Tomcat nested context separator
+
+	name := strings.ReplaceAll(pattern, PlaceholderInstance, NormalizeServiceName(instance))
+	name = strings.ReplaceAll(name, PlaceholderContext, NormalizeServiceName(context))
+
+	return NormalizeServiceName(name)
+}
+
+// ContextPath converts a webapp directory or WAR name into its context path.
+// ROOT maps to "/" and Tomcat's '#' separator maps to '/'.
+func ContextPath(webapp string) string {
+	webapp = strings.TrimSuffix(webapp, ".war")
+	if webapp == "" || webapp == "ROOT" {
+		return "/"
+	}
+	return "/" + strings.ReplaceAll(webapp, "#", "/")
+}
+
+// FormatContextMap renders context service names as a stable, comma-separated
+// list of context=name rules understood by the agent
+func FormatContextMap(names ContextServiceNames) string {
+	contexts := make([]string, 0, len(names))
+	for context := range names {
+		contexts = append(contexts, context)
+	}
+	sort.Strings(contexts)
+
+	rules := make([]string, 0, len(contexts))
+	for _, context := range contexts {
+		rules = append(rules, context+"="+names[context])
+	}
+	return strings.Join(rules, ",")
+}
+
+// ParseContextMap parses rules produced by FormatContextMap
+func ParseContextMap(value string) ContextServiceNames {
+	names := make(ContextServiceNames)
+	for _, rule := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		names[parts[0]] = parts[1]
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package naming_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/naming"
+)
+
+func TestExpandServiceNamePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		instance string
+		context  string
+		expected string
+	}{
+		{
+			name:     "Default pattern",
+			pattern:  naming.DefaultTomcatWebappPattern,
+			instance: "ecommerce",
+			context:  "shop",
+			expected: "tomcat-ecommerce-shop",
+		},
+		{
+			name:     "Context only",
+			pattern:  "{context}",
+			instance: "ecommerce",
+			context:  "/api",
+			expected: "api",
+		},
+		{
+			name:     "Nested context",
+			pattern:  "{instance}-{context}",
+			instance: "Billing_Prod",
+			context:  "v2#admin",
+			expected: "billing-prod-v2-admin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := naming.ExpandServiceNamePattern(test.pattern, test.instance, test.context)
+			if result != test.expected {
+				t.Errorf("ExpandServiceNamePattern(%q, %q, %q) = %q, expected %q",
+					test.pattern, test.instance, test.context, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestContextPath(t *testing.T) {
+	tests := map[string]string{
+		"ROOT":     "/",
+		"api":      "/api",
+		"shop.war": "/shop",
+		"v2#admin": "/v2/admin",
+		"":         "/",
+	}
+
+	for webapp, expected := range tests {
+		if result := naming.ContextPath(webapp); result != expected {
+			t.Errorf("ContextPath(%q) = %q, expected %q", webapp, result, expected)
+		}
+	}
+}
+
+func TestContextMapRoundTrip(t *testing.T) {
+	names := naming.ContextServiceNames{
+		"/shop": "tomcat-ecommerce-shop",
+		"/api":  "tomcat-ecommerce-api",
+	}
+
+	formatted := naming.FormatContextMap(names)
+	if formatted != "/api=tomcat-ecommerce-api,/shop=tomcat-ecommerce-shop" {
+		t.Errorf("FormatContextMap() = %q, expected sorted rules", formatted)
+	}
+
+	if parsed := naming.ParseContextMap(formatted); !reflect.DeepEqual(parsed, names) {
+		t.Errorf("ParseContextMap(%q) = %v, expected %v", formatted, parsed, names)
+	}
+}
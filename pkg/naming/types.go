@@ -32,3 +32,7 @@ func (st ServiceType) String() string {
 		return "unknown"
 	}
 }
+
+// ContextServiceNames maps a Tomcat context path (e.g. "/api") to the
+// service name reported for that webapp
+type ContextServiceNames map[string]string
@@ -0,0 +1,349 @@
+// Package policy evaluates the declarative instrumentation policy: ordered
+// rules that decide which processes and containers are instrumented and with
+// which settings. The first matching rule wins.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/config"
+	"github.com/middleware-labs/java-injector/pkg/restart"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the host-wide policy file
+const DefaultPath = "/etc/middleware/injector.yaml"
+
+// Rule actions
+const (
+	ActionInclude = "include"
+	ActionExclude = "exclude"
+)
+
+// Restart strategies
+const (
+	RestartImmediate = "immediate" // restart as soon as the service is configured
+	RestartManual    = "manual"    // configure only, the operator restarts
+	RestartWindow    = "window"    // configure now, restart in the maintenance window
+)
+
+// Policy is an ordered list of rules
+type Policy struct {
+	Path          string   `yaml:"-"`
+	DefaultAction string   `yaml:"default_action"`
+	Restarts      Restarts `yaml:"restarts"`
+	Rules         []Rule   `yaml:"rules"`
+}
+
+// Restarts sets how the services configured in a run are restarted
+type Restarts struct {
+	MaxParallel       int           `yaml:"max_parallel"` // groups restarting at the same time
+	Delay             time.Duration `yaml:"delay"`        // pause between two restarts
+	Timeout           time.Duration `yaml:"timeout"`      // for each health gate
+	MaintenanceWindow string        `yaml:"maintenance_window"`
+
+	window *restart.Window
+}
+
+// Window returns the maintenance window, nil if none is set
+func (r Restarts) Window() *restart.Window {
+	return r.window
+}
+
+// Rule matches processes or containers and sets how they are instrumented
+type Rule struct {
+	Name               string            `yaml:"name"`
+	Match              Match             `yaml:"match"`
+	Action             string            `yaml:"action"`
+	ServiceName        string            `yaml:"service_name"`
+	Sampler            string            `yaml:"sampler"`
+	SamplerArg         string            `yaml:"sampler_arg"`
+	Features           Features          `yaml:"features"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+	Restart            string            `yaml:"restart"`
+	RestartGroup       string            `yaml:"restart_group"`
+	Health             Health            `yaml:"health"`
+	AgentVersion       string            `yaml:"agent_version"` // pins a version of the agent store, empty follows current
+
+	serviceName  *template.Template
+	restartGroup *template.Template
+}
+
+// Health lists the checks a restarted service must pass besides being active
+type Health struct {
+	Port int    `yaml:"port"` // TCP port on localhost that must accept connections
+	URL  string `yaml:"url"`  // must answer with a 2xx or 3xx status
+}
+
+// Match lists glob patterns that must all match. Unset fields match anything.
+type Match struct {
+	Owner          string `yaml:"owner"`
+	Jar            string `yaml:"jar"`
+	MainClass      string `yaml:"main_class"`
+	Unit           string `yaml:"unit"`
+	TomcatInstance string `yaml:"tomcat_instance"`
+	ContainerLabel string `yaml:"container_label"` // key or key=glob
+	Image          string `yaml:"image"`
+	ComposeProject string `yaml:"compose_project"`
+}
+
+// Features toggles agent signals, unset toggles keep the defaults
+type Features struct {
+	Traces    *bool `yaml:"traces"`
+	Metrics   *bool `yaml:"metrics"`
+	Logs      *bool `yaml:"logs"`
+	Profiling *bool `yaml:"profiling"`
+}
+
+// Load reads and validates a policy file
+func Load(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	p.Path = file
+	return p, nil
+}
+
+// Parse parses and validates a policy document
+func Parse(data []byte) (*Policy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var p Policy
+	if err := decoder.Decode(&p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	if p.DefaultAction == "" {
+		p.DefaultAction = ActionInclude
+	}
+	if p.DefaultAction != ActionInclude && p.DefaultAction != ActionExclude {
+		return nil, fmt.Errorf("invalid default_action %q, expected include or exclude", p.DefaultAction)
+	}
+
+	if err := p.Restarts.validate(); err != nil {
+		return nil, err
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].validate(i); err != nil {
+			return nil, err
+		}
+		if p.Rules[i].Restart == RestartWindow && p.Restarts.window == nil {
+			return nil, fmt.Errorf("rule %s: restart: window requires restarts.maintenance_window", p.Rules[i].Name)
+		}
+	}
+	return &p, nil
+}
+
+// validate checks the restart settings and parses the maintenance window
+func (r *Restarts) validate() error {
+	if r.MaxParallel < 0 || r.Delay < 0 || r.Timeout < 0 {
+		return fmt.Errorf("restarts: max_parallel, delay and timeout can't be negative")
+	}
+	if r.MaxParallel == 0 {
+		r.MaxParallel = 1
+	}
+	if r.MaintenanceWindow != "" {
+		window, err := restart.ParseWindow(r.MaintenanceWindow)
+		if err != nil {
+			return fmt.Errorf("restarts: %w", err)
+		}
+		r.window = window
+	}
+	return nil
+}
+
+// validate checks a rule and compiles its service name template
+func (r *Rule) validate(index int) error {
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("#%d", index+1)
+	}
+	if r.Action == "" {
+		r.Action = ActionInclude
+	}
+	if r.Action != ActionInclude && r.Action != ActionExclude {
+		return fmt.Errorf("rule %s: invalid action %q, expected include or exclude", r.Name, r.Action)
+	}
+	if r.Restart == "" {
+		r.Restart = RestartImmediate
+	}
+	if r.Restart != RestartImmediate && r.Restart != RestartManual && r.Restart != RestartWindow {
+		return fmt.Errorf("rule %s: invalid restart %q, expected immediate, manual or window", r.Name, r.Restart)
+	}
+	if r.Health.Port < 0 || r.Health.Port > 65535 {
+		return fmt.Errorf("rule %s: invalid health port %d", r.Name, r.Health.Port)
+	}
+	if r.Health.URL != "" && !strings.HasPrefix(r.Health.URL, "http://") && !strings.HasPrefix(r.Health.URL, "https://") {
+		return fmt.Errorf("rule %s: invalid health url %q, expected http:// or https://", r.Name, r.Health.URL)
+	}
+	if r.AgentVersion != "" && !agent.ValidVersion(r.AgentVersion) {
+		return fmt.Errorf("rule %s: invalid agent_version %q", r.Name, r.AgentVersion)
+	}
+
+	for _, pattern := range r.Match.patterns() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %s: invalid pattern %q: %w", r.Name, pattern, err)
+		}
+	}
+
+	if r.ServiceName != "" {
+		tmpl, err := template.New(r.Name).Option("missingkey=zero").Parse(r.ServiceName)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid service_name: %w", r.Name, err)
+		}
+		r.serviceName = tmpl
+	}
+	if r.RestartGroup != "" {
+		tmpl, err := template.New(r.Name).Option("missingkey=zero").Parse(r.RestartGroup)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid restart_group: %w", r.Name, err)
+		}
+		r.restartGroup = tmpl
+	}
+	return nil
+}
+
+// patterns returns the glob patterns set on a match
+func (m Match) patterns() []string {
+	var patterns []string
+	for _, p := range []string{m.Owner, m.Jar, m.MainClass, m.Unit, m.TomcatInstance, m.Image, m.ComposeProject} {
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	if _, value, found := strings.Cut(m.ContainerLabel, "="); found {
+		patterns = append(patterns, value)
+	}
+	return patterns
+}
+
+// Decision is the outcome of evaluating the policy for one process or container
+type Decision struct {
+	Include      bool
+	Rule         string
+	Reason       string
+	ServiceName  string
+	Restart      string
+	Group        string // restart group, empty for the default
+	Health       Health
+	AgentVersion string // pinned agent version, empty for the current one
+
+	rule *Rule
+}
+
+// Evaluate returns the decision of the first rule matching the subject. A
+// nil policy includes everything.
+func (p *Policy) Evaluate(s Subject) Decision {
+	if p == nil {
+		return Decision{Include: true, Reason: "no policy", ServiceName: s.Name, Restart: RestartImmediate}
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.Match.matches(s) {
+			continue
+		}
+
+		d := Decision{
+			Include:      rule.Action == ActionInclude,
+			Rule:         rule.Name,
+			Reason:       "included by rule " + rule.Name,
+			ServiceName:  s.Name,
+			Restart:      rule.Restart,
+			Health:       rule.Health,
+			AgentVersion: rule.AgentVersion,
+			rule:         rule,
+		}
+		if !d.Include {
+			d.Reason = "excluded by rule " + rule.Name
+		}
+		if name := render(rule.serviceName, s); name != "" {
+			d.ServiceName = name
+		}
+		d.Group = render(rule.restartGroup, s)
+		return d
+	}
+
+	return Decision{
+		Include:     p.DefaultAction == ActionInclude,
+		Reason:      "no rule matched, default " + p.DefaultAction,
+		ServiceName: s.Name,
+		Restart:     RestartImmediate,
+	}
+}
+
+// render executes a rule template, returning "" when it's unset or fails
+func render(tmpl *template.Template, s Subject) string {
+	if tmpl == nil {
+		return ""
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, s); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// RestartSettings returns the restart settings, the defaults for a nil policy
+func (p *Policy) RestartSettings() Restarts {
+	if p == nil {
+		return Restarts{MaxParallel: 1}
+	}
+	return p.Restarts
+}
+
+// Apply sets the rule's service name, sampler, toggles and resource
+// attributes on a configuration
+func (d Decision) Apply(cfg *config.ProcessConfiguration) {
+	cfg.MWServiceName = d.ServiceName
+	if d.rule == nil {
+		return
+	}
+
+	r := d.rule
+	if r.Sampler != "" {
+		cfg.OtelTracesSampler = r.Sampler
+	}
+	if r.SamplerArg != "" {
+		cfg.OtelTracesSamplerArg = r.SamplerArg
+	}
+	setBool(&cfg.MWAPMCollectTraces, r.Features.Traces)
+	setBool(&cfg.MWAPMCollectMetrics, r.Features.Metrics)
+	setBool(&cfg.MWAPMCollectLogs, r.Features.Logs)
+	setBool(&cfg.MWAPMCollectProfiling, r.Features.Profiling)
+
+	if len(r.ResourceAttributes) > 0 {
+		keys := make([]string, 0, len(r.ResourceAttributes))
+		for k := range r.ResourceAttributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		attributes := make([]string, 0, len(keys))
+		for _, k := range keys {
+			attributes = append(attributes, k+"="+r.ResourceAttributes[k])
+		}
+		cfg.OtelResourceAttributes = strings.Join(attributes, ",")
+	}
+}
+
+func setBool(dst *bool, value *bool) {
+	if value != nil {
+		*dst = *value
+	}
+}
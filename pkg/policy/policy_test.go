@@ -0,0 +1,220 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/config"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/policy"
+)
+
+const testPolicy = `
+default_action: exclude
+rules:
+  - name: no-batch
+    action: exclude
+    match:
+      main_class: com.example.batch.*
+  - name: billing
+    match:
+      owner: billing
+      jar: billing-*.jar
+    service_name: "{{.Name}}-prod"
+    sampler: parentbased_traceidratio
+    sampler_arg: "0.25"
+    features:
+      profiling: false
+    resource_attributes:
+      team: payments
+      env: prod
+    restart: manual
+    agent_version: 1.8.1
+  - name: payments-containers
+    match:
+      container_label: team=pay*
+      image: registry:5000/payments/*
+    service_name: "{{.ComposeService}}"
+  - name: shop
+    match:
+      compose_project: shop
+  - name: shop-tomcats
+    match:
+      tomcat_instance: shop-*
+    restart_group: "{{.TomcatInstance}}"
+`
+
+func TestEvaluate(t *testing.T) {
+	p, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		subject      policy.Subject
+		include      bool
+		rule         string
+		serviceName  string
+		agentVersion string
+		group        string
+	}{
+		{
+			name:         "Host rule with template",
+			subject:      policy.Subject{Name: "billing", Owner: "billing", Jar: "billing-1.2.jar"},
+			include:      true,
+			rule:         "billing",
+			serviceName:  "billing-prod",
+			agentVersion: "1.8.1",
+		},
+		{
+			name:    "Exclude wins when first",
+			subject: policy.Subject{Name: "job", Owner: "billing", Jar: "billing-1.2.jar", MainClass: "com.example.batch.Job"},
+			include: false,
+			rule:    "no-batch",
+		},
+		{
+			name:    "All match fields must match",
+			subject: policy.Subject{Name: "billing", Owner: "root", Jar: "billing-1.2.jar"},
+			include: false,
+		},
+		{
+			name: "Container label and image with tag",
+			subject: policy.Subject{
+				Name:           "pay",
+				Image:          "registry:5000/payments/api:1.4",
+				Labels:         map[string]string{"team": "payments"},
+				ComposeService: "payments-api",
+			},
+			include:     true,
+			rule:        "payments-containers",
+			serviceName: "payments-api",
+		},
+		{
+			name:        "Compose project",
+			subject:     policy.Subject{Name: "web", ComposeProject: "shop"},
+			include:     true,
+			rule:        "shop",
+			serviceName: "web",
+		},
+		{
+			name: "Tomcat instance",
+			subject: policy.ProcessSubject(&discovery.JavaProcess{
+				ProcessPID: 1234,
+				ProcessCommandArgs: []string{
+					"java", "-Dcatalina.base=/opt/tomcat/shop-eu", "-Dcatalina.home=/opt/tomcat/apache-tomcat-9.0.80",
+					"org.apache.catalina.startup.Bootstrap", "start",
+				},
+			}),
+			include: true,
+			rule:    "shop-tomcats",
+			group:   "shop-eu",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.subject)
+			if d.Include != tt.include || d.Rule != tt.rule {
+				t.Fatalf("Evaluate() = include %v rule %q, expected include %v rule %q (%s)", d.Include, d.Rule, tt.include, tt.rule, d.Reason)
+			}
+			if tt.serviceName != "" && d.ServiceName != tt.serviceName {
+				t.Errorf("ServiceName = %q, expected %q", d.ServiceName, tt.serviceName)
+			}
+			if d.AgentVersion != tt.agentVersion {
+				t.Errorf("AgentVersion = %q, expected %q", d.AgentVersion, tt.agentVersion)
+			}
+			if d.Group != tt.group {
+				t.Errorf("Group = %q, expected %q", d.Group, tt.group)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	d := p.Evaluate(policy.Subject{Name: "billing", Owner: "billing", Jar: "billing-1.2.jar"})
+	if d.Restart != policy.RestartManual {
+		t.Errorf("Restart = %q, expected manual", d.Restart)
+	}
+
+	cfg := config.DefaultConfiguration()
+	d.Apply(&cfg)
+
+	if cfg.MWServiceName != "billing-prod" {
+		t.Errorf("MWServiceName = %q", cfg.MWServiceName)
+	}
+	if cfg.OtelTracesSampler != "parentbased_traceidratio" || cfg.OtelTracesSamplerArg != "0.25" {
+		t.Errorf("sampler = %q %q", cfg.OtelTracesSampler, cfg.OtelTracesSamplerArg)
+	}
+	if cfg.MWAPMCollectProfiling || !cfg.MWAPMCollectTraces {
+		t.Errorf("features not applied: profiling %v traces %v", cfg.MWAPMCollectProfiling, cfg.MWAPMCollectTraces)
+	}
+	if cfg.OtelResourceAttributes != "env=prod,team=payments" {
+		t.Errorf("OtelResourceAttributes = %q", cfg.OtelResourceAttributes)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"Unknown field": "rules:\n  - name: x\n    match:\n      pid: 1\n",
+		"Bad action":    "rules:\n  - action: maybe\n",
+		"Bad restart":   "rules:\n  - restart: later\n",
+		"Bad pattern":   "rules:\n  - match:\n      jar: \"[\"\n",
+		"Bad template":  "rules:\n  - service_name: \"{{.Name\"\n",
+		"Bad default":   "default_action: skip\n",
+		"No window":     "rules:\n  - restart: window\n",
+		"Bad window":    "restarts:\n  maintenance_window: \"Sat 25:00-26:00\"\n",
+		"Bad health":    "rules:\n  - health:\n      url: localhost:8080\n",
+		"Bad agent":     "rules:\n  - agent_version: ../1.9.0\n",
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := policy.Parse([]byte(doc)); err == nil {
+				t.Errorf("Parse() expected an error")
+			}
+		})
+	}
+}
+
+func TestNilPolicyIncludesEverything(t *testing.T) {
+	var p *policy.Policy
+	if d := p.Evaluate(policy.Subject{Name: "app"}); !d.Include || d.ServiceName != "app" {
+		t.Errorf("Evaluate() = %+v", d)
+	}
+}
+
+func TestRestartSettings(t *testing.T) {
+	doc := `
+restarts:
+  max_parallel: 2
+  delay: 10s
+  maintenance_window: "Sat,Sun 02:00-04:00"
+rules:
+  - name: replicas
+    match:
+      unit: app@*.service
+    restart: window
+    restart_group: "{{.Owner}}"
+    health:
+      port: 8080
+`
+	p, err := policy.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	settings := p.RestartSettings()
+	if settings.MaxParallel != 2 || settings.Delay.String() != "10s" || settings.Window() == nil {
+		t.Errorf("RestartSettings() = %+v", settings)
+	}
+
+	d := p.Evaluate(policy.Subject{Name: "app", Unit: "app@1.service", Owner: "billing"})
+	if d.Restart != policy.RestartWindow || d.Group != "billing" || d.Health.Port != 8080 {
+		t.Errorf("Evaluate() = %+v", d)
+	}
+}
@@ -0,0 +1,137 @@
+package policy
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// Subject kinds
+const (
+	KindHost      = "host"
+	KindContainer = "container"
+)
+
+// Subject is what rules match against. Its fields are also available to
+// service_name templates, e.g. "{{.Name}}-{{index .Labels \"env\"}}".
+type Subject struct {
+	Kind           string
+	Name           string // generated service name
+	Owner          string
+	Jar            string
+	MainClass      string
+	Unit           string
+	TomcatInstance string // CATALINA_BASE directory name
+	Container      string
+	Image          string
+	Labels         map[string]string
+	ComposeProject string
+	ComposeService string
+}
+
+// ProcessSubject describes a host process
+func ProcessSubject(proc *discovery.JavaProcess) Subject {
+	s := Subject{
+		Kind:      KindHost,
+		Name:      naming.GenerateServiceName(proc),
+		Owner:     proc.ProcessOwner,
+		Jar:       filepath.Base(proc.JarFile),
+		MainClass: proc.MainClass,
+	}
+	if proc.JarFile == "" {
+		s.Jar = ""
+	}
+	if info := proc.ExtractTomcatInfo(); info.IsTomcat && info.CatalinaBase != "" {
+		s.TomcatInstance = filepath.Base(info.CatalinaBase)
+	}
+	if proc.DetectSupervisor().Kind == discovery.SupervisorSystemd {
+		// Rules name user units without the user@<uid>.service/ prefix
+		_, s.Unit, _ = systemd.SplitUserUnit(systemd.GetServiceName(proc))
+	}
+	return s
+}
+
+// ContainerSubject describes a Docker container
+func ContainerSubject(c *discovery.DockerContainer) Subject {
+	s := Subject{
+		Kind:           KindContainer,
+		Name:           c.GetServiceName(),
+		Container:      c.ContainerName,
+		Image:          c.ImageName,
+		Labels:         c.Labels,
+		ComposeProject: c.ComposeProject,
+		ComposeService: c.ComposeService,
+	}
+	if c.ImageTag != "" {
+		s.Image = c.ImageName + ":" + c.ImageTag
+	}
+	if len(c.JarFiles) > 0 {
+		s.Jar = filepath.Base(c.JarFiles[0])
+	}
+	return s
+}
+
+// EvaluateProcess evaluates the policy for a host process
+func (p *Policy) EvaluateProcess(proc *discovery.JavaProcess) Decision {
+	if p == nil {
+		// Skip the systemctl lookups of ProcessSubject
+		return p.Evaluate(Subject{Name: naming.GenerateServiceName(proc)})
+	}
+	return p.Evaluate(ProcessSubject(proc))
+}
+
+// EvaluateContainer evaluates the policy for a Docker container
+func (p *Policy) EvaluateContainer(c *discovery.DockerContainer) Decision {
+	return p.Evaluate(ContainerSubject(c))
+}
+
+// matches checks every pattern set on the match against the subject
+func (m Match) matches(s Subject) bool {
+	return glob(m.Owner, s.Owner) &&
+		glob(m.Jar, s.Jar) &&
+		glob(m.MainClass, s.MainClass) &&
+		glob(m.Unit, s.Unit) &&
+		glob(m.TomcatInstance, s.TomcatInstance) &&
+		globImage(m.Image, s.Image) &&
+		glob(m.ComposeProject, s.ComposeProject) &&
+		label(m.ContainerLabel, s.Labels)
+}
+
+// glob matches an optional pattern, an empty value never matches a set pattern
+func glob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// globImage matches an image with or without its tag
+func globImage(pattern, image string) bool {
+	if pattern == "" || glob(pattern, image) {
+		return true
+	}
+	// The tag follows the last colon after the last slash (registry:5000/app:1.0)
+	i := strings.LastIndex(image, ":")
+	return i > strings.LastIndex(image, "/") && glob(pattern, image[:i])
+}
+
+// label matches "key" (label is set) or "key=glob"
+func label(pattern string, labels map[string]string) bool {
+	if pattern == "" {
+		return true
+	}
+	key, valuePattern, hasValue := strings.Cut(pattern, "=")
+	value, ok := labels[key]
+	if !ok {
+		return false
+	}
+	return !hasValue || glob(valuePattern, value)
+}
@@ -0,0 +1,69 @@
+package restart
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Gate is a health check a restarted service must pass within the timeout
+type Gate func(timeout time.Duration) error
+
+// pollInterval is the pause between two attempts of a gate
+var pollInterval = time.Second
+
+// PortGate waits until a TCP port on localhost accepts connections
+func PortGate(port int) Gate {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	return func(timeout time.Duration) error {
+		return poll(timeout, func() error {
+			conn, err := net.DialTimeout("tcp", address, pollInterval)
+			if err != nil {
+				return fmt.Errorf("port %d isn't listening", port)
+			}
+			conn.Close()
+			return nil
+		})
+	}
+}
+
+// HTTPGate waits until a URL answers with a 2xx or 3xx status
+func HTTPGate(url string) Gate {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return func(timeout time.Duration) error {
+		return poll(timeout, func() error {
+			resp, err := client.Get(url)
+			if err != nil {
+				return fmt.Errorf("health check %s failed: %v", url, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("health check %s returned %s", url, resp.Status)
+			}
+			return nil
+		})
+	}
+}
+
+// poll retries a check until it passes or the timeout expires, returning
+// the last error
+func poll(timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%v after %s", err, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,169 @@
+// Package restart restarts the services of a run in a rolling fashion.
+// Services of one group, like the replicas of a templated unit, restart one
+// after the other, each must pass its health gates before the next one goes
+// down, and only a limited number of groups restart at the same time.
+package restart
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+// Task restarts one service
+type Task struct {
+	Name    string
+	Group   string // tasks of a group restart one after the other
+	Restart func() error
+	Gates   []Gate
+
+	// Rollback restores the service's previous configuration. With restart
+	// set the service failed its restart and is restarted again, otherwise
+	// it was never restarted and only its files are restored.
+	Rollback func(cause error, restart bool) error
+}
+
+// Result is the outcome of a task
+type Result struct {
+	Name       string
+	Err        error
+	RolledBack bool
+	Skipped    bool // an earlier restart of the group failed
+}
+
+// Orchestrator restarts tasks
+type Orchestrator struct {
+	MaxParallel int           // groups restarting at the same time
+	Delay       time.Duration // pause between two restarts
+	Timeout     time.Duration // for each health gate
+
+	mu sync.Mutex
+}
+
+// Run restarts the tasks and returns their results in the same order.
+// When a restart of a group fails, the rest of the group isn't restarted.
+func (o *Orchestrator) Run(tasks []Task) []Result {
+	results := make([]Result, len(tasks))
+	groups := groupTasks(tasks)
+
+	workers := max(o.MaxParallel, 1)
+	if changeset.Recording() {
+		// Planned restarts are listed in order
+		workers = 1
+	}
+	workers = min(workers, len(groups))
+
+	next := make(chan []int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			for group := range next {
+				failed := ""
+				for _, i := range group {
+					if failed != "" {
+						results[i] = o.skip(&tasks[i], failed)
+						continue
+					}
+					if !first && o.Delay > 0 && !changeset.Recording() {
+						time.Sleep(o.Delay)
+					}
+					first = false
+					results[i] = o.restart(&tasks[i])
+					if results[i].Err != nil {
+						failed = tasks[i].Name
+					}
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		next <- group
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// groupTasks returns the task indexes per group, in order of appearance
+func groupTasks(tasks []Task) [][]int {
+	var groups [][]int
+	index := make(map[string]int)
+	for i, t := range tasks {
+		key := t.Group
+		if key == "" {
+			key = t.Name
+		}
+		g, ok := index[key]
+		if !ok {
+			g = len(groups)
+			index[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+func (o *Orchestrator) restart(t *Task) Result {
+	o.Printf("   🔄 Restarting %s\n", t.Name)
+	err := t.Restart()
+	if err == nil {
+		err = o.check(t)
+	}
+	if err == nil {
+		o.Printf("   ✅ %s is up\n", t.Name)
+		return Result{Name: t.Name}
+	}
+
+	o.Printf("   ❌ %s: %v\n", t.Name, err)
+	result := Result{Name: t.Name, Err: err}
+	if t.Rollback == nil {
+		return result
+	}
+	if rbErr := t.Rollback(err, true); rbErr != nil {
+		o.Printf("   ❌ %s is still failing after the rollback: %v\n", t.Name, rbErr)
+		return result
+	}
+	o.Printf("   ⏪ %s rolled back, running without instrumentation\n", t.Name)
+	result.RolledBack = true
+	return result
+}
+
+// skip rolls back the configuration of a task that won't be restarted, so
+// a later restart doesn't pick up what failed for the rest of its group
+func (o *Orchestrator) skip(t *Task, failed string) Result {
+	o.Printf("   ⏸️  Not restarting %s, %s failed in the same group\n", t.Name, failed)
+	result := Result{Name: t.Name, Err: fmt.Errorf("not restarted, %s failed", failed), Skipped: true}
+	if t.Rollback != nil {
+		if err := t.Rollback(result.Err, false); err == nil {
+			result.RolledBack = true
+		}
+	}
+	return result
+}
+
+// check runs the health gates of a restarted task
+func (o *Orchestrator) check(t *Task) error {
+	if changeset.Recording() {
+		return nil
+	}
+	for _, gate := range t.Gates {
+		if err := gate(o.Timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Printf prints a message of a task, tasks running in parallel never
+// interleave their messages
+func (o *Orchestrator) Printf(format string, args ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Printf(format, args...)
+}
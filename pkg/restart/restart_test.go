@@ -0,0 +1,152 @@
+package restart_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/restart"
+)
+
+func TestWindow(t *testing.T) {
+	w, err := restart.ParseWindow("Sat,Sun 23:00-02:00")
+	if err != nil {
+		t.Fatalf("ParseWindow() error = %v", err)
+	}
+
+	// 2026-10-17 is a Saturday
+	tests := []struct {
+		time     string
+		contains bool
+	}{
+		{"2026-10-17 23:30", true},
+		{"2026-10-18 01:59", true}, // Saturday's window, after midnight
+		{"2026-10-19 01:00", true}, // Sunday's window, after midnight
+		{"2026-10-19 02:00", false},
+		{"2026-10-16 23:30", false},
+		{"2026-10-17 12:00", false},
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse("2006-01-02 15:04", tt.time)
+		if got := w.Contains(at); got != tt.contains {
+			t.Errorf("Contains(%s) = %v, expected %v", tt.time, got, tt.contains)
+		}
+	}
+
+	at, _ := time.Parse("2006-01-02 15:04", "2026-10-19 12:00")
+	if next := w.Next(at).Format("2006-01-02 15:04"); next != "2026-10-24 23:00" {
+		t.Errorf("Next() = %s", next)
+	}
+	if got := w.OnCalendar(); got != "Sat,Sun *-*-* 23:00:00" {
+		t.Errorf("OnCalendar() = %s", got)
+	}
+
+	if w, err := restart.ParseWindow("Mon-Fri 02:00-04:00"); err != nil || len(w.Days) != 5 {
+		t.Errorf("ParseWindow() of a day range = %+v, %v", w, err)
+	}
+	for _, spec := range []string{"", "02:00", "Someday 02:00-04:00", "02:00-02:00", "2am-4am"} {
+		if _, err := restart.ParseWindow(spec); err == nil {
+			t.Errorf("ParseWindow(%q) expected an error", spec)
+		}
+	}
+}
+
+func TestOrchestrator(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	running, maxRunning := 0, 0
+
+	task := func(name, group string, fail bool) restart.Task {
+		return restart.Task{
+			Name:  name,
+			Group: group,
+			Restart: func() error {
+				mu.Lock()
+				order = append(order, name)
+				running++
+				maxRunning = max(maxRunning, running)
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				if fail {
+					return errors.New("failed to start")
+				}
+				return nil
+			},
+			Rollback: func(error, bool) error { return nil },
+		}
+	}
+
+	o := &restart.Orchestrator{MaxParallel: 2}
+	results := o.Run([]restart.Task{
+		task("app@1", "app@", false),
+		task("app@2", "app@", true),
+		task("app@3", "app@", false),
+		task("billing", "", false),
+		task("orders", "", false),
+	})
+
+	if maxRunning != 2 {
+		t.Errorf("%d restarts ran at the same time, expected 2", maxRunning)
+	}
+
+	// Replicas restart in order, the one after a failure is skipped
+	var replicas []string
+	for _, name := range order {
+		if len(name) > 4 && name[:4] == "app@" {
+			replicas = append(replicas, name)
+		}
+	}
+	if len(replicas) != 2 || replicas[0] != "app@1" || replicas[1] != "app@2" {
+		t.Errorf("replica restarts = %v", replicas)
+	}
+
+	if results[0].Err != nil || results[3].Err != nil || results[4].Err != nil {
+		t.Errorf("results = %+v", results)
+	}
+	if results[1].Err == nil || !results[1].RolledBack {
+		t.Errorf("failed restart = %+v", results[1])
+	}
+	if !results[2].Skipped || !results[2].RolledBack {
+		t.Errorf("restart after a failure = %+v", results[2])
+	}
+}
+
+func TestGates(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if err := restart.HTTPGate(server.URL)(time.Second); err != nil {
+		t.Errorf("HTTPGate() on a healthy service = %v", err)
+	}
+	healthy = false
+	if err := restart.HTTPGate(server.URL)(0); err == nil {
+		t.Errorf("HTTPGate() on an unhealthy service expected an error")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := restart.PortGate(port)(time.Second); err != nil {
+		t.Errorf("PortGate() on a listening port = %v", err)
+	}
+	listener.Close()
+	if err := restart.PortGate(port)(0); err == nil {
+		t.Errorf("PortGate() on a closed port expected an error")
+	}
+}
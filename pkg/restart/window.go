@@ -0,0 +1,146 @@
+package restart
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring maintenance window, e.g. "Sat,Sun 02:00-04:00" or
+// "Mon-Fri 22:00-01:00". Without days it's open every day.
+type Window struct {
+	Days  []time.Weekday
+	Start int // minutes after midnight
+	End   int // minutes after midnight, before Start when the window spans midnight
+
+	spec string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindow parses a maintenance window
+func ParseWindow(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected e.g. \"Sat,Sun 02:00-04:00\"", spec)
+	}
+
+	w := &Window{spec: strings.Join(fields, " ")}
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		w.Days = days
+	}
+
+	from, to, found := strings.Cut(fields[len(fields)-1], "-")
+	if !found {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected a time range like 02:00-04:00", spec)
+	}
+	var err error
+	if w.Start, err = parseClock(from); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if w.End, err = parseClock(to); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if w.Start == w.End {
+		return nil, fmt.Errorf("invalid maintenance window %q: it starts and ends at the same time", spec)
+	}
+	return w, nil
+}
+
+// parseDays parses "Sat,Sun" or "Mon-Fri"
+func parseDays(spec string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[strings.ToLower(to)]; !ok {
+				return nil, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// onDay reports whether the window opens on a day
+func (w *Window) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether the window is open at t. A window spanning
+// midnight belongs to the day it opens.
+func (w *Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return w.onDay(t.Weekday()) && minute >= w.Start && minute < w.End
+	}
+	if w.onDay(t.Weekday()) && minute >= w.Start {
+		return true
+	}
+	return w.onDay((t.Weekday()+6)%7) && minute < w.End
+}
+
+// Next returns when the window is open next, t itself while it's open
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		start := time.Date(day.Year(), day.Month(), day.Day(), w.Start/60, w.Start%60, 0, 0, t.Location())
+		if start.After(t) && w.onDay(start.Weekday()) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// OnCalendar returns the systemd calendar expression of the window's start
+func (w *Window) OnCalendar() string {
+	days := ""
+	if len(w.Days) > 0 {
+		names := make([]string, len(w.Days))
+		for i, d := range w.Days {
+			names[i] = d.String()[:3]
+		}
+		days = strings.Join(names, ",") + " "
+	}
+	return fmt.Sprintf("%s*-*-* %02d:%02d:00", days, w.Start/60, w.Start%60)
+}
+
+func (w *Window) String() string {
+	return w.spec
+}
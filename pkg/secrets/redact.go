@@ -0,0 +1,55 @@
+package secrets
+
+import "strings"
+
+// Redacted replaces secret values in state files and console output
+const Redacted = "<redacted>"
+
+// secretMarkers identify variables that hold credentials
+var secretMarkers = []string{"API_KEY", "APIKEY", "TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE_KEY", "OTLP_HEADERS"}
+
+// IsSecret checks if a variable name looks like it holds a credential
+func IsSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask shows the first characters of a key, enough to tell keys apart
+func Mask(value string) string {
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", 8)
+}
+
+// RedactEnv returns a copy of an environment with secret values redacted
+func RedactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for name, value := range env {
+		if IsSecret(name) && value != "" {
+			value = Redacted
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// RedactEnvList redacts secret values in a list of KEY=VALUE pairs
+func RedactEnvList(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, kv := range env {
+		if name, value, ok := strings.Cut(kv, "="); ok && IsSecret(name) && value != "" {
+			kv = name + "=" + Redacted
+		}
+		redacted = append(redacted, kv)
+	}
+	return redacted
+}
@@ -0,0 +1,184 @@
+// Package secrets keeps the Middleware API key out of world-readable files.
+// The key lives in a 0600 environment file that systemd reads through
+// EnvironmentFile= and containers through --env-file, everything else only
+// references the file.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+const (
+	// Dir holds root-only secret files
+	Dir = "/etc/middleware/secrets"
+
+	// HostEnvFile holds the API key for units run by the system manager
+	HostEnvFile = Dir + "/middleware.env"
+
+	// ContainerDir holds the --env-file / env_file files of containers
+	ContainerDir = Dir + "/containers"
+
+	// APIKeyVar carries the API key
+	APIKeyVar = "MW_API_KEY"
+
+	// HeadersVar carries the API key for the OpenTelemetry exporter
+	HeadersVar = "OTEL_EXPORTER_OTLP_HEADERS"
+)
+
+// DefaultEnvFile returns the env file for the caller: HostEnvFile for root,
+// ~/.config/middleware/secrets/middleware.env otherwise
+func DefaultEnvFile() string {
+	if os.Geteuid() == 0 {
+		return HostEnvFile
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return HostEnvFile
+	}
+	return UserEnvFile(home)
+}
+
+// UserEnvFile returns the env file of a user, read by their systemd --user units
+func UserEnvFile(home string) string {
+	return filepath.Join(home, ".config", "middleware", "secrets", "middleware.env")
+}
+
+// APIKeyEnv returns the variables that carry the API key
+func APIKeyEnv(apiKey string) [][2]string {
+	return [][2]string{
+		{APIKeyVar, apiKey},
+		{HeadersVar, "authorization=" + apiKey},
+	}
+}
+
+// ValidateAPIKey rejects keys that can't be written unquoted to env files,
+// which docker --env-file reads literally
+func ValidateAPIKey(apiKey string) error {
+	if apiKey == "" {
+		return fmt.Errorf("API key is empty")
+	}
+	if strings.ContainsAny(apiKey, " \t\r\n\"'`$\\#") {
+		return fmt.Errorf("API key contains whitespace, quotes or shell characters")
+	}
+	return nil
+}
+
+// StoreAPIKey writes the API key to a 0600 env file owned by uid:gid (-1
+// keeps the caller as owner)
+func StoreAPIKey(path, apiKey string, uid, gid int) error {
+	if err := ValidateAPIKey(apiKey); err != nil {
+		return err
+	}
+	return WriteEnvFile(path, APIKeyEnv(apiKey), uid, gid)
+}
+
+// LoadAPIKey reads the API key from an env file
+func LoadAPIKey(path string) (string, error) {
+	vars, err := ReadEnvFile(path)
+	if err != nil {
+		return "", err
+	}
+	apiKey := vars[APIKeyVar]
+	if apiKey == "" {
+		return "", fmt.Errorf("%s not set in %s", APIKeyVar, path)
+	}
+	return apiKey, nil
+}
+
+// WriteEnvFile writes KEY=VALUE lines to a 0600 file in a 0700 directory
+func WriteEnvFile(path string, vars [][2]string, uid, gid int) error {
+	dir := filepath.Dir(path)
+	if err := changeset.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by mw-injector, do not edit. Rotate with: mw-injector rotate-key\n")
+	for _, kv := range vars {
+		fmt.Fprintf(&b, "%s=%s\n", kv[0], kv[1])
+	}
+
+	// Write to a temporary file so the key is never readable by others
+	tmp := path + ".tmp"
+	if err := changeset.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := changeset.Chmod(tmp, 0o600); err != nil {
+		changeset.Remove(tmp)
+		return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	if uid >= 0 {
+		if err := changeset.Chown(tmp, uid, gid); err != nil {
+			changeset.Remove(tmp)
+			return fmt.Errorf("failed to set ownership of %s: %w", path, err)
+		}
+		if err := changeset.Chown(dir, uid, gid); err != nil {
+			changeset.Remove(tmp)
+			return fmt.Errorf("failed to set ownership of %s: %w", dir, err)
+		}
+	}
+	if err := changeset.Rename(tmp, path); err != nil {
+		changeset.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ReadEnvFile parses KEY=VALUE lines, ignoring comments
+func ReadEnvFile(path string) (map[string]string, error) {
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+
+	return vars, scanner.Err()
+}
+
+// ReplaceInFile replaces every occurrence of the old key in a file, keeping
+// its permissions. It reports whether the file contained the key.
+func ReplaceInFile(path, oldKey, newKey string) (bool, error) {
+	if oldKey == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if !strings.Contains(string(data), oldKey) {
+		return false, nil
+	}
+
+	updated := strings.ReplaceAll(string(data), oldKey, newKey)
+	if err := changeset.WriteFile(path, []byte(updated), info.Mode().Perm()); err != nil {
+		return true, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package secrets_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+)
+
+func TestStoreAndLoadAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "middleware.env")
+
+	if err := secrets.StoreAPIKey(path, "abcd1234efgh5678", -1, -1); err != nil {
+		t.Fatalf("StoreAPIKey() error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("permissions = %o, want 600", perm)
+	}
+
+	vars, err := secrets.ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["OTEL_EXPORTER_OTLP_HEADERS"] != "authorization=abcd1234efgh5678" {
+		t.Errorf("OTEL_EXPORTER_OTLP_HEADERS = %q", vars["OTEL_EXPORTER_OTLP_HEADERS"])
+	}
+
+	apiKey, err := secrets.LoadAPIKey(path)
+	if err != nil || apiKey != "abcd1234efgh5678" {
+		t.Errorf("LoadAPIKey() = %q, %v", apiKey, err)
+	}
+
+	if err := secrets.StoreAPIKey(path, "key with spaces", -1, -1); err == nil {
+		t.Error("StoreAPIKey() should reject keys with spaces")
+	}
+}
+
+func TestReplaceInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "override.conf")
+	if err := os.WriteFile(path, []byte("environment=OTEL_EXPORTER_OTLP_HEADERS=\"authorization=old-key\"\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := secrets.ReplaceInFile(path, "old-key", "new-key")
+	if err != nil || !found {
+		t.Fatalf("ReplaceInFile() = %v, %v", found, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "environment=OTEL_EXPORTER_OTLP_HEADERS=\"authorization=new-key\"\n" {
+		t.Errorf("content = %q", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("permissions changed to %o", info.Mode().Perm())
+	}
+}
+
+func TestRedactEnv(t *testing.T) {
+	env := map[string]string{
+		"MW_API_KEY":                 "abcd1234efgh5678",
+		"OTEL_EXPORTER_OTLP_HEADERS": "authorization=abcd1234efgh5678",
+		"DB_PASSWORD":                "hunter2",
+		"JAVA_TOOL_OPTIONS":          "-Xmx1g",
+	}
+
+	want := map[string]string{
+		"MW_API_KEY":                 secrets.Redacted,
+		"OTEL_EXPORTER_OTLP_HEADERS": secrets.Redacted,
+		"DB_PASSWORD":                secrets.Redacted,
+		"JAVA_TOOL_OPTIONS":          "-Xmx1g",
+	}
+	if got := secrets.RedactEnv(env); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactEnv() = %v, want %v", got, want)
+	}
+
+	if got := secrets.Mask("abcd1234efgh5678"); got != "abcd********" {
+		t.Errorf("Mask() = %q", got)
+	}
+}
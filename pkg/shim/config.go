@@ -0,0 +1,178 @@
+// Package shim implements the opt-in Java launcher shim: a "java" wrapper for
+// JVMs started by cron, nohup or vendor binaries that no supervisor manages.
+// It adds the agent only to commands matching the policy and otherwise execs
+// the real java untouched.
+package shim
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+const (
+	// ShimDir holds the installed shim binary
+	ShimDir = "/opt/middleware/shim"
+
+	// ShimBinary is the shim, named java so it can stand in for it
+	ShimBinary = ShimDir + "/java"
+
+	// ConfigPath is the shim configuration, read on every java invocation
+	ConfigPath = "/etc/middleware/shim/shim.conf"
+
+	// KeyFile holds the API key, readable by root and the key group
+	KeyFile = "/etc/middleware/shim/secrets/middleware.env"
+
+	// PathLink is the PATH-precedence entry used in path mode
+	PathLink = "/usr/local/bin/java"
+
+	// AlternativesLink is the java link managed by update-alternatives
+	AlternativesLink = "/usr/bin/java"
+
+	// DisableEnv turns the shim into a plain pass-through for one command
+	DisableEnv = "MW_SHIM_DISABLED"
+)
+
+// Registration modes
+const (
+	ModeAlternatives = "alternatives"
+	ModePath         = "path"
+)
+
+// Config is the shim configuration
+type Config struct {
+	Enabled      bool   // kill switch, false passes every command through
+	Mode         string // alternatives or path
+	RealJava     string // java binary the shim execs
+	PreviousJava string // alternatives selection before install
+	PreviousMode string // alternatives status before install, auto or manual
+	AgentPath    string
+	APIKey       string // read from KeyFile, empty when the user can't
+	KeyGroup     string // group whose JVMs may read the API key
+	Target       string
+	Include      []Rule
+	Exclude      []Rule
+}
+
+// LoadConfig reads the shim configuration
+func LoadConfig(path string) (*Config, error) {
+	vars, err := systemd.ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	include, err := ParseRules(vars["MW_SHIM_INCLUDE"])
+	if err != nil {
+		return nil, fmt.Errorf("MW_SHIM_INCLUDE: %w", err)
+	}
+	exclude, err := ParseRules(vars["MW_SHIM_EXCLUDE"])
+	if err != nil {
+		return nil, fmt.Errorf("MW_SHIM_EXCLUDE: %w", err)
+	}
+
+	return &Config{
+		Enabled:      vars["MW_SHIM_ENABLED"] != "false",
+		Mode:         vars["MW_SHIM_MODE"],
+		RealJava:     vars["MW_SHIM_REAL_JAVA"],
+		PreviousJava: vars["MW_SHIM_PREVIOUS_JAVA"],
+		PreviousMode: vars["MW_SHIM_PREVIOUS_MODE"],
+		AgentPath:    vars["MW_JAVA_AGENT_PATH"],
+		APIKey:       loadAPIKey(vars["MW_API_KEY_FILE"]),
+		KeyGroup:     vars["MW_SHIM_KEY_GROUP"],
+		Target:       vars["MW_TARGET"],
+		Include:      include,
+		Exclude:      exclude,
+	}, nil
+}
+
+// loadAPIKey reads the API key, JVMs of users outside the key group run
+// without it
+func loadAPIKey(path string) string {
+	if path == "" {
+		return ""
+	}
+	apiKey, _ := secrets.LoadAPIKey(path)
+	return apiKey
+}
+
+// SaveConfig writes the shim configuration. The shim runs as whichever user
+// starts java, so the file has to be world-readable and only references the
+// API key, see SaveAPIKey.
+func SaveConfig(path string, cfg *Config) error {
+	if err := changeset.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf(`# Middleware.io Java launcher shim
+# Generated: %s
+
+# Kill switch: false passes every java command through untouched.
+# Set %s=1 in the environment to bypass the shim for a single command.
+MW_SHIM_ENABLED=%t
+
+# Registration
+MW_SHIM_MODE=%s
+MW_SHIM_REAL_JAVA=%s
+MW_SHIM_PREVIOUS_JAVA=%s
+MW_SHIM_PREVIOUS_MODE=%s
+
+# Commands to instrument, comma separated jar:, main: and name: globs.
+# Exclusions win over inclusions.
+MW_SHIM_INCLUDE=%s
+MW_SHIM_EXCLUDE=%s
+
+# Middleware.io settings, the API key is readable by root and MW_SHIM_KEY_GROUP
+MW_JAVA_AGENT_PATH=%s
+MW_API_KEY_FILE=%s
+MW_SHIM_KEY_GROUP=%s
+MW_TARGET=%s
+`, time.Now().Format("2006-01-02 15:04:05"), DisableEnv, cfg.Enabled,
+		cfg.Mode, cfg.RealJava, cfg.PreviousJava, cfg.PreviousMode,
+		FormatRules(cfg.Include), FormatRules(cfg.Exclude),
+		cfg.AgentPath, KeyFile, cfg.KeyGroup, cfg.Target)
+
+	return changeset.WriteFileAtomic(path, []byte(content), 0o644)
+}
+
+// SaveAPIKey writes the API key to KeyFile. With a key group the file is
+// 0640 root:<group> so JVMs of its members get the key, otherwise only JVMs
+// run by root do.
+func SaveAPIKey(cfg *Config) error {
+	gid := -1
+	if cfg.KeyGroup != "" {
+		group, err := user.LookupGroup(cfg.KeyGroup)
+		if err != nil {
+			return fmt.Errorf("key group: %w", err)
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return fmt.Errorf("invalid key group %s: %w", cfg.KeyGroup, err)
+		}
+	}
+	if err := secrets.WriteEnvFile(KeyFile, secrets.APIKeyEnv(cfg.APIKey), 0, max(gid, 0)); err != nil {
+		return err
+	}
+	if gid < 0 {
+		return nil
+	}
+	if err := changeset.Chmod(filepath.Dir(KeyFile), 0o750); err != nil {
+		return err
+	}
+	return changeset.Chmod(KeyFile, 0o640)
+}
+
+// IsInstalled checks if the shim is installed
+func IsInstalled() bool {
+	return changeset.Exists(ConfigPath)
+}
+
+// IsShimInvocation checks if the current binary was started as java
+func IsShimInvocation(argv0 string) bool {
+	return strings.TrimSuffix(filepath.Base(argv0), ".exe") == "java"
+}
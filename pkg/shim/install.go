@@ -0,0 +1,230 @@
+package shim
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+// alternativesPriority outranks every JDK package's priority
+const alternativesPriority = "100000"
+
+// HasAlternatives checks if update-alternatives is available
+func HasAlternatives() bool {
+	_, err := exec.LookPath("update-alternatives")
+	return err == nil
+}
+
+// ResolveJava returns the java binary currently found on PATH, with symlinks
+// (including the alternatives link) resolved
+func ResolveJava() (string, error) {
+	javaPath, err := exec.LookPath("java")
+	if err != nil {
+		return "", fmt.Errorf("java not found in PATH: %w", err)
+	}
+
+	resolved, err := filepath.EvalSymlinks(javaPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", javaPath, err)
+	}
+
+	if resolved == ShimBinary {
+		return "", fmt.Errorf("java already resolves to the shim")
+	}
+	return resolved, nil
+}
+
+// queryAlternatives returns the status (auto or manual) and the selected
+// java of the alternatives group
+func queryAlternatives() (status, value string, err error) {
+	output, err := exec.Command("update-alternatives", "--query", "java").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("update-alternatives --query java failed: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if v, ok := strings.CutPrefix(line, "Status: "); ok {
+			status = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(line, "Value: "); ok {
+			value = strings.TrimSpace(v)
+		}
+	}
+	return status, value, nil
+}
+
+// registerCommands make the shim the java alternative
+func registerCommands() []*exec.Cmd {
+	return []*exec.Cmd{
+		exec.Command("update-alternatives", "--install", AlternativesLink, "java", ShimBinary, alternativesPriority),
+		exec.Command("update-alternatives", "--set", "java", ShimBinary),
+	}
+}
+
+// unregisterCommands drop the shim alternative and put back the selection
+// found at install, automatic mode included
+func unregisterCommands(cfg *Config) []*exec.Cmd {
+	cmds := []*exec.Cmd{exec.Command("update-alternatives", "--remove", "java", ShimBinary)}
+	switch {
+	case cfg.PreviousMode == "auto":
+		cmds = append(cmds, exec.Command("update-alternatives", "--auto", "java"))
+	case cfg.PreviousJava != "":
+		if _, err := os.Stat(cfg.PreviousJava); err == nil {
+			cmds = append(cmds, exec.Command("update-alternatives", "--set", "java", cfg.PreviousJava))
+		}
+	}
+	return cmds
+}
+
+// Install copies the shim binary in place, writes its configuration and
+// registers it as java
+func Install(cfg *Config, shimSource string) error {
+	if cfg.RealJava == "" {
+		realJava, err := ResolveJava()
+		if err != nil {
+			return err
+		}
+		cfg.RealJava = realJava
+	}
+	cfg.PreviousJava = cfg.RealJava
+	if cfg.Mode == ModeAlternatives {
+		if status, value, err := queryAlternatives(); err == nil && value != ShimBinary {
+			cfg.PreviousMode, cfg.PreviousJava = status, value
+		}
+	}
+
+	if err := copyExecutable(shimSource, ShimBinary); err != nil {
+		return fmt.Errorf("failed to install shim binary: %w", err)
+	}
+	fmt.Printf("   Installed shim: %s\n", ShimBinary)
+
+	if err := SaveAPIKey(cfg); err != nil {
+		return fmt.Errorf("failed to store the API key: %w", err)
+	}
+	fmt.Printf("   Stored API key: %s\n", KeyFile)
+
+	if err := SaveConfig(ConfigPath, cfg); err != nil {
+		return fmt.Errorf("failed to write shim config: %w", err)
+	}
+	fmt.Printf("   Created config: %s\n", ConfigPath)
+
+	switch cfg.Mode {
+	case ModeAlternatives:
+		for _, cmd := range registerCommands() {
+			if err := run(cmd); err != nil {
+				return err
+			}
+		}
+		for _, cmd := range unregisterCommands(cfg) {
+			changeset.Current().OnUndoFirst(cmd)
+		}
+		fmt.Printf("   Registered alternative: %s → %s\n", AlternativesLink, ShimBinary)
+	case ModePath:
+		if existing, err := os.Readlink(PathLink); err == nil && existing == ShimBinary {
+			break
+		}
+		if _, err := os.Lstat(PathLink); err == nil {
+			return fmt.Errorf("%s already exists and is not the shim", PathLink)
+		}
+		if err := changeset.Symlink(ShimBinary, PathLink); err != nil {
+			return fmt.Errorf("failed to create %s: %w", PathLink, err)
+		}
+		changeset.Current().OnUndoFirst(exec.Command("rm", "-f", PathLink))
+		fmt.Printf("   Created link: %s → %s\n", PathLink, ShimBinary)
+	default:
+		return fmt.Errorf("unknown shim mode %q", cfg.Mode)
+	}
+
+	return nil
+}
+
+// Remove unregisters the shim, restores the previous java and deletes the
+// shim binary and configuration
+func Remove() error {
+	cfg, err := LoadConfig(ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shim config: %w", err)
+	}
+
+	switch cfg.Mode {
+	case ModeAlternatives:
+		cmds := unregisterCommands(cfg)
+		if err := run(cmds[0]); err != nil {
+			return err
+		}
+		for _, cmd := range cmds[1:] {
+			if err := run(cmd); err != nil {
+				fmt.Printf("   ⚠️  Could not restore java alternative: %v\n", err)
+			}
+		}
+		fmt.Printf("   Removed alternative: %s\n", ShimBinary)
+	case ModePath:
+		if existing, err := os.Readlink(PathLink); err == nil && existing == ShimBinary {
+			if err := changeset.Remove(PathLink); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", PathLink, err)
+			}
+			fmt.Printf("   Removed link: %s\n", PathLink)
+		}
+	}
+
+	// The journal doesn't keep binaries, undo installs the shim again from
+	// this binary before the registration
+	if self, err := os.Executable(); err == nil {
+		changeset.Current().OnUndo(exec.Command("install", "-D", "-m", "0755", self, ShimBinary))
+	}
+	if cfg.Mode == ModeAlternatives {
+		for _, cmd := range registerCommands() {
+			changeset.Current().OnUndo(cmd)
+		}
+	}
+
+	for _, path := range []string{ShimBinary, KeyFile, ConfigPath} {
+		if err := changeset.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	// The directories only go when nothing else lives there
+	changeset.Remove(ShimDir)
+	changeset.Remove(filepath.Dir(KeyFile))
+	changeset.Remove(filepath.Dir(ConfigPath))
+
+	fmt.Printf("   Removed shim: %s\n", ShimBinary)
+	return nil
+}
+
+// SetEnabled flips the kill switch
+func SetEnabled(enabled bool) error {
+	cfg, err := LoadConfig(ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shim config: %w", err)
+	}
+	cfg.Enabled = enabled
+	return SaveConfig(ConfigPath, cfg)
+}
+
+// copyExecutable copies a binary to dst with mode 0755
+func copyExecutable(src, dst string) error {
+	if err := changeset.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename, dst may be running
+	tmp := dst + ".tmp"
+	if err := changeset.CopyFile(src, tmp, 0o755); err != nil {
+		changeset.Remove(tmp)
+		return err
+	}
+	return changeset.Rename(tmp, dst)
+}
+
+// run runs a command that changes the host and includes its output in the
+// error
+func run(cmd *exec.Cmd) error {
+	output, err := changeset.CombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", cmd.Args[0], err, output)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package shim
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+)
+
+// Rule fields
+const (
+	FieldJar  = "jar"  // jar file name, e.g. billing-*.jar
+	FieldMain = "main" // main class, e.g. com.example.*
+	FieldName = "name" // generated service name
+)
+
+// Rule matches a java command by jar, main class or service name
+type Rule struct {
+	Field   string
+	Pattern string
+}
+
+// ParseRules parses comma separated field:glob rules
+func ParseRules(value string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid rule %q, expected jar:, main: or name:", entry)
+		}
+
+		field := strings.TrimSpace(parts[0])
+		if field != FieldJar && field != FieldMain && field != FieldName {
+			return nil, fmt.Errorf("unknown rule field %q", field)
+		}
+
+		pattern := strings.TrimSpace(parts[1])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		rules = append(rules, Rule{Field: field, Pattern: pattern})
+	}
+	return rules, nil
+}
+
+// FormatRules renders rules in the ParseRules format
+func FormatRules(rules []Rule) string {
+	entries := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		entries = append(entries, rule.Field+":"+rule.Pattern)
+	}
+	return strings.Join(entries, ",")
+}
+
+// Decision is the outcome of matching a command against the policy
+type Decision struct {
+	Inject      bool
+	ServiceName string
+	Reason      string
+}
+
+// Decide checks if a java command should be instrumented
+func (c *Config) Decide(proc *discovery.JavaProcess) Decision {
+	if !c.Enabled {
+		return Decision{Reason: "shim disabled"}
+	}
+	if proc.HasJavaAgent && proc.IsMiddlewareAgent {
+		return Decision{Reason: "already instrumented"}
+	}
+	if proc.JarFile == "" && proc.MainClass == "" {
+		return Decision{Reason: "no jar or main class"}
+	}
+
+	serviceName := naming.GenerateServiceName(proc)
+	if rule, ok := matchAny(c.Exclude, proc, serviceName); ok {
+		return Decision{ServiceName: serviceName, Reason: "excluded by " + rule.Field + ":" + rule.Pattern}
+	}
+	if rule, ok := matchAny(c.Include, proc, serviceName); ok {
+		return Decision{Inject: true, ServiceName: serviceName, Reason: "included by " + rule.Field + ":" + rule.Pattern}
+	}
+
+	return Decision{ServiceName: serviceName, Reason: "no include rule matched"}
+}
+
+// matchAny returns the first rule matching the command
+func matchAny(rules []Rule, proc *discovery.JavaProcess, serviceName string) (Rule, bool) {
+	for _, rule := range rules {
+		var value string
+		switch rule.Field {
+		case FieldJar:
+			value = filepath.Base(proc.JarFile)
+		case FieldMain:
+			value = proc.MainClass
+		case FieldName:
+			value = serviceName
+		}
+
+		if value == "" || value == "." {
+			continue
+		}
+		if matched, _ := path.Match(rule.Pattern, value); matched {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
@@ -0,0 +1,136 @@
+package shim_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/shim"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := shim.ParseRules("jar:billing-*.jar, main:com.example.*,name:orders")
+	if err != nil {
+		t.Fatalf("ParseRules() error: %v", err)
+	}
+	if got := shim.FormatRules(rules); got != "jar:billing-*.jar,main:com.example.*,name:orders" {
+		t.Errorf("FormatRules() = %q", got)
+	}
+
+	for _, invalid := range []string{"billing.jar", "path:/opt/*", "jar:[", "jar:"} {
+		if _, err := shim.ParseRules(invalid); err == nil {
+			t.Errorf("ParseRules(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	include, _ := shim.ParseRules("jar:billing-*.jar,main:com.example.batch.*")
+	exclude, _ := shim.ParseRules("main:com.example.batch.Cleanup")
+
+	cfg := &shim.Config{
+		Enabled:   true,
+		RealJava:  "/usr/lib/jvm/java-17/bin/java",
+		AgentPath: "/opt/middleware/agents/agent.jar",
+		APIKey:    "key",
+		Target:    "https://example.middleware.io:443",
+		Include:   include,
+		Exclude:   exclude,
+	}
+
+	tests := []struct {
+		name       string
+		cfg        func(c shim.Config) shim.Config
+		args       []string
+		env        []string
+		wantInject bool
+	}{
+		{
+			name:       "Included jar",
+			args:       []string{"java", "-Xmx1g", "-jar", "/opt/billing/billing-2.1.0.jar"},
+			wantInject: true,
+		},
+		{
+			name:       "Included main class",
+			args:       []string{"java", "-cp", "/opt/batch/lib/*", "com.example.batch.Nightly"},
+			wantInject: true,
+		},
+		{
+			name: "Excluded main class",
+			args: []string{"java", "-cp", "/opt/batch/lib/*", "com.example.batch.Cleanup"},
+		},
+		{
+			name: "Not in policy",
+			args: []string{"java", "-jar", "/opt/vendor/tool.jar"},
+		},
+		{
+			name: "Version check",
+			args: []string{"java", "-version"},
+		},
+		{
+			name: "Bypassed for one command",
+			args: []string{"java", "-jar", "/opt/billing/billing-2.1.0.jar"},
+			env:  []string{"MW_SHIM_DISABLED=1"},
+		},
+		{
+			name: "Kill switch",
+			cfg:  func(c shim.Config) shim.Config { c.Enabled = false; return c },
+			args: []string{"java", "-jar", "/opt/billing/billing-2.1.0.jar"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *cfg
+			if tt.cfg != nil {
+				c = tt.cfg(c)
+			}
+
+			argv, env := shim.Rewrite(&c, tt.args, tt.env)
+
+			if argv[0] != cfg.RealJava {
+				t.Errorf("argv[0] = %q, want the real java", argv[0])
+			}
+
+			injected := len(argv) > 1 && argv[1] == "-javaagent:"+cfg.AgentPath
+			if injected != tt.wantInject {
+				t.Errorf("injected = %v, want %v (argv %v)", injected, tt.wantInject, argv)
+			}
+			if !tt.wantInject && strings.Join(argv[1:], " ") != strings.Join(tt.args[1:], " ") {
+				t.Errorf("arguments changed: %v", argv)
+			}
+
+			hasServiceName := false
+			for _, kv := range env {
+				if strings.HasPrefix(kv, "OTEL_SERVICE_NAME=") {
+					hasServiceName = true
+				}
+			}
+			if hasServiceName != tt.wantInject {
+				t.Errorf("OTEL_SERVICE_NAME set = %v, want %v", hasServiceName, tt.wantInject)
+			}
+		})
+	}
+}
+
+func TestConfigPreviousAlternative(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shim.conf")
+	cfg := &shim.Config{
+		Enabled:      true,
+		Mode:         shim.ModeAlternatives,
+		RealJava:     "/usr/lib/jvm/java-17-openjdk-amd64/bin/java",
+		PreviousJava: "/usr/lib/jvm/java-17-openjdk-amd64/bin/java",
+		PreviousMode: "auto",
+	}
+	if err := shim.SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+
+	loaded, err := shim.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if loaded.PreviousMode != "auto" || loaded.PreviousJava != cfg.PreviousJava {
+		t.Errorf("previous alternative = %s %s, want auto %s", loaded.PreviousMode, loaded.PreviousJava, cfg.PreviousJava)
+	}
+}
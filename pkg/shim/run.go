@@ -0,0 +1,93 @@
+package shim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+// Run replaces the current process with the real java, adding the agent when
+// the command matches the policy. It only returns on failure.
+func Run(args []string) int {
+	cfg, err := LoadConfig(ConfigPath)
+	if err != nil || cfg.RealJava == "" {
+		fmt.Fprintf(os.Stderr, "mw-java-shim: cannot find the real java (%s): %v\n", ConfigPath, err)
+		return 127
+	}
+
+	if isSelf(cfg.RealJava) {
+		fmt.Fprintf(os.Stderr, "mw-java-shim: %s points back at the shim\n", cfg.RealJava)
+		return 127
+	}
+
+	argv, env := Rewrite(cfg, args, os.Environ())
+
+	if err := syscall.Exec(cfg.RealJava, argv, env); err != nil {
+		fmt.Fprintf(os.Stderr, "mw-java-shim: exec %s: %v\n", cfg.RealJava, err)
+		return 126
+	}
+	return 0
+}
+
+// Rewrite returns the argv and environment to exec the real java with
+func Rewrite(cfg *Config, args, environ []string) ([]string, []string) {
+	argv := append([]string{cfg.RealJava}, args[1:]...)
+
+	if lookupEnv(environ, DisableEnv) != "" {
+		return argv, environ
+	}
+
+	proc := discovery.ParseJavaCommand(int32(os.Getpid()), argv)
+	decision := cfg.Decide(proc)
+	if !decision.Inject {
+		return argv, environ
+	}
+
+	// JVM options must come before -jar / the main class
+	injected := append([]string{cfg.RealJava, "-javaagent:" + cfg.AgentPath}, args[1:]...)
+
+	otelEnv := [][2]string{
+		{"OTEL_SERVICE_NAME", decision.ServiceName},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", cfg.Target},
+		{"OTEL_TRACES_EXPORTER", "otlp"},
+		{"OTEL_METRICS_EXPORTER", "otlp"},
+		{"OTEL_LOGS_EXPORTER", "otlp"},
+	}
+	if cfg.APIKey != "" {
+		otelEnv = append(otelEnv, [2]string{"OTEL_EXPORTER_OTLP_HEADERS", "authorization=" + cfg.APIKey})
+	}
+
+	// Keep anything the caller configured explicitly
+	for _, kv := range otelEnv {
+		if lookupEnv(environ, kv[0]) == "" {
+			environ = append(environ, kv[0]+"="+kv[1])
+		}
+	}
+
+	return injected, environ
+}
+
+// lookupEnv returns a variable from a KEY=VALUE list
+func lookupEnv(environ []string, name string) string {
+	prefix := name + "="
+	for _, kv := range environ {
+		if len(kv) > len(prefix) && kv[:len(prefix)] == prefix {
+			return kv[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// isSelf checks if a path resolves to the running executable
+func isSelf(path string) bool {
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	selfResolved, err1 := filepath.EvalSymlinks(self)
+	target, err2 := filepath.EvalSymlinks(path)
+	return err1 == nil && err2 == nil && selfResolved == target
+}
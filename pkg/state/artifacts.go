@@ -0,0 +1,183 @@
+package state
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/lsm"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// RecordArtifact adds a change to the state store, replacing an earlier
+// record of the same file
+func RecordArtifact(artifact Artifact) error {
+	return UpdateStore(func(store *Store) error {
+		store.RecordArtifact(artifact)
+		return nil
+	})
+}
+
+// ArtifactsForConfig returns the changes recorded for a Middleware config file
+func ArtifactsForConfig(configPath string) ([]Artifact, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+	state, err := LoadHostState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host state: %w", err)
+	}
+
+	var artifacts []Artifact
+	for _, a := range state.Artifacts {
+		if a.ConfigPath == configPath {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts, nil
+}
+
+// RevertArtifacts undoes every change recorded for a Middleware config file
+// and removes the records. It returns the number of changes reverted.
+func RevertArtifacts(configPath string) (int, error) {
+	state, err := LoadHostState()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load host state: %w", err)
+	}
+
+	reverted := 0
+	var remaining []Artifact
+	var errs []error
+	for _, a := range state.Artifacts {
+		if a.ConfigPath != configPath {
+			remaining = append(remaining, a)
+			continue
+		}
+		if err := RevertArtifact(a); err != nil {
+			errs = append(errs, err)
+			remaining = append(remaining, a)
+			continue
+		}
+		reverted++
+	}
+
+	if reverted > 0 {
+		state.Artifacts = remaining
+		if err := SaveHostState(state); err != nil {
+			return reverted, fmt.Errorf("failed to save host state: %w", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return reverted, fmt.Errorf("failed to revert %d change(s): %v", len(errs), errs[0])
+	}
+	return reverted, nil
+}
+
+// LSMArtifacts returns the SELinux and AppArmor rules added so services
+// can read the agent
+func LSMArtifacts() ([]Artifact, error) {
+	store, err := LoadStore()
+	if err != nil {
+		return nil, err
+	}
+	var artifacts []Artifact
+	for _, a := range store.Artifacts {
+		if a.Kind == ArtifactSELinuxRule || a.Kind == ArtifactAppArmorRule {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts, nil
+}
+
+// RevertLSMArtifacts removes the SELinux and AppArmor rules and their
+// records. They're shared by all services, so only once none is left.
+func RevertLSMArtifacts() (int, error) {
+	state, err := LoadHostState()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load host state: %w", err)
+	}
+
+	reverted := 0
+	var remaining []Artifact
+	var errs []error
+	for _, a := range state.Artifacts {
+		if a.Kind != ArtifactSELinuxRule && a.Kind != ArtifactAppArmorRule {
+			remaining = append(remaining, a)
+			continue
+		}
+		if err := RevertArtifact(a); err != nil {
+			errs = append(errs, err)
+			remaining = append(remaining, a)
+			continue
+		}
+		reverted++
+	}
+
+	if reverted > 0 {
+		state.Artifacts = remaining
+		if err := SaveHostState(state); err != nil {
+			return reverted, fmt.Errorf("failed to save host state: %w", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return reverted, fmt.Errorf("failed to revert %d rule(s): %v", len(errs), errs[0])
+	}
+	return reverted, nil
+}
+
+// RevertArtifact undoes a single recorded change
+func RevertArtifact(artifact Artifact) error {
+	switch artifact.Kind {
+	case ArtifactManagedBlock:
+		found, err := managed.RemoveBlock(artifact.Path)
+		if err != nil {
+			return err
+		}
+		if found {
+			fmt.Printf("   Restored: %s\n", artifact.Path)
+		}
+		return nil
+	case ArtifactDropIn:
+		return systemd.RemoveDropIn(artifact.Unit)
+	case ArtifactFile:
+		if artifact.Existed {
+			if err := changeset.WriteFile(artifact.Path, []byte(artifact.Previous), 0o644); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", artifact.Path, err)
+			}
+			fmt.Printf("   Restored: %s\n", artifact.Path)
+			return nil
+		}
+		if err := changeset.Remove(artifact.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", artifact.Path, err)
+		}
+		fmt.Printf("   Removed: %s\n", artifact.Path)
+		return nil
+	case ArtifactComposeFile:
+		data, err := changeset.ReadFile(artifact.Backup)
+		if err != nil {
+			return fmt.Errorf("failed to read backup of %s: %w", artifact.Path, err)
+		}
+		if err := changeset.WriteFile(artifact.Path, data, 0o644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", artifact.Path, err)
+		}
+		fmt.Printf("   Restored: %s\n", artifact.Path)
+		return nil
+	case ArtifactSELinuxRule:
+		if err := lsm.RevertSELinux(); err != nil {
+			return err
+		}
+		fmt.Printf("   Removed SELinux file context rule: %s\n", artifact.Path)
+		return nil
+	case ArtifactAppArmorRule:
+		if err := lsm.RevertAppArmor(artifact.Path); err != nil {
+			return err
+		}
+		fmt.Printf("   Restored: %s\n", artifact.Path)
+		return nil
+	default:
+		return fmt.Errorf("unknown artifact kind %q for %s", artifact.Kind, artifact.Path)
+	}
+}
@@ -0,0 +1,36 @@
+package state_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", state.LockFile)
+
+	lock, err := state.AcquireLock(path)
+	if err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+
+	_, err = state.AcquireLock(path)
+	if !errors.Is(err, state.ErrLocked) {
+		t.Fatalf("second AcquireLock() error = %v, expected ErrLocked", err)
+	}
+	if pid := fmt.Sprintf("PID %d", os.Getpid()); !strings.Contains(err.Error(), pid) {
+		t.Errorf("second AcquireLock() error = %q, expected the holder's %s", err, pid)
+	}
+
+	lock.Release()
+	lock, err = state.AcquireLock(path)
+	if err != nil {
+		t.Fatalf("AcquireLock() after Release() error = %v", err)
+	}
+	lock.Release()
+}
@@ -6,35 +6,61 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
 	"github.com/middleware-labs/java-injector/pkg/naming"
 	"github.com/middleware-labs/java-injector/pkg/systemd"
+	"github.com/middleware-labs/java-injector/pkg/wildfly"
 )
 
-// FindOrphanedConfigs detects configuration files for stopped/crashed services
+// FindOrphanedConfigs detects configuration files for stopped/crashed
+// services in configRoot, /etc/middleware or ~/.config/middleware in user mode
 // Moved from main.go and commands/uninstrument.go (consolidated duplicate implementations)
-func FindOrphanedConfigs(runningProcesses []discovery.JavaProcess) ([]OrphanedConfig, error) {
+func FindOrphanedConfigs(configRoot string, runningProcesses []discovery.JavaProcess) ([]OrphanedConfig, error) {
 	var orphaned []OrphanedConfig
 
 	// Get all running process config paths
 	runningConfigs := make(map[string]bool)
 	for _, proc := range runningProcesses {
-		configPath := getConfigPath(&proc)
+		configPath := getConfigPath(configRoot, &proc)
 		runningConfigs[configPath] = true
 	}
 
 	// Check systemd configs
-	if configs, err := scanConfigDirectory("/etc/middleware/systemd", false, runningConfigs); err == nil {
+	if configs, err := scanConfigDirectory(filepath.Join(configRoot, "systemd"), false, runningConfigs); err == nil {
 		orphaned = append(orphaned, configs...)
 	}
 
 	// Check tomcat configs
-	if configs, err := scanConfigDirectory("/etc/middleware/tomcat", true, runningConfigs); err == nil {
+	if configs, err := scanConfigDirectory(filepath.Join(configRoot, "tomcat"), true, runningConfigs); err == nil {
 		orphaned = append(orphaned, configs...)
 	}
 
+	// Check wildfly configs
+	if configs, err := scanConfigDirectory(filepath.Join(configRoot, "wildfly"), false, runningConfigs); err == nil {
+		for i := range configs {
+			configs[i].IsWildFly = true
+		}
+		orphaned = append(orphaned, configs...)
+	}
+
+	// Check jetty configs
+	if configs, err := scanConfigDirectory(filepath.Join(configRoot, "jetty"), false, runningConfigs); err == nil {
+		orphaned = append(orphaned, configs...)
+	}
+
+	// Check configs of processes run by other supervisors
+	for _, kind := range []string{
+		discovery.SupervisorSupervisord, discovery.SupervisorRunit, discovery.SupervisorS6,
+		discovery.SupervisorOpenRC, discovery.SupervisorSysV,
+	} {
+		if configs, err := scanConfigDirectory(filepath.Join(configRoot, kind), false, runningConfigs); err == nil {
+			orphaned = append(orphaned, configs...)
+		}
+	}
+
 	// Check standalone configs
-	if configs, err := scanConfigDirectory("/etc/middleware/standalone", false, runningConfigs); err == nil {
+	if configs, err := scanConfigDirectory(filepath.Join(configRoot, "standalone"), false, runningConfigs); err == nil {
 		orphaned = append(orphaned, configs...)
 	}
 
@@ -59,10 +85,12 @@ func scanConfigDirectory(dir string, isTomcat bool, runningConfigs map[string]bo
 			configPath := filepath.Join(dir, file.Name())
 			if !runningConfigs[configPath] {
 				serviceName := strings.TrimSuffix(file.Name(), ".conf")
+				configVars, _ := systemd.ReadConfigFile(configPath)
 				orphaned = append(orphaned, OrphanedConfig{
 					ConfigPath:  configPath,
 					ServiceName: serviceName,
 					IsTomcat:    isTomcat,
+					SystemdUnit: configVars["MW_SYSTEMD_UNIT"],
 				})
 			}
 		}
@@ -74,17 +102,38 @@ func scanConfigDirectory(dir string, isTomcat bool, runningConfigs map[string]bo
 // RemoveOrphanedConfig removes an orphaned configuration and its associated files
 // Moved from main.go and commands/uninstrument.go (consolidated duplicate implementations)
 func RemoveOrphanedConfig(config OrphanedConfig) error {
+	// WildFly instrumentation lives in the server's launch config
+	if config.IsWildFly {
+		configVars, err := systemd.ReadConfigFile(config.ConfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		if file := configVars["MW_INSTRUMENTED_FILE"]; file != "" {
+			restored, err := wildfly.Uninstrument(file)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", file, err)
+			}
+			if restored {
+				fmt.Printf("   Restored launch config: %s\n", file)
+			}
+		}
+	}
+
+	if _, err := RevertArtifacts(config.ConfigPath); err != nil {
+		return err
+	}
+
 	// Remove config file
-	if err := os.Remove(config.ConfigPath); err != nil {
+	if err := changeset.Remove(config.ConfigPath); err != nil {
 		return fmt.Errorf("failed to remove config file: %w", err)
 	}
 	fmt.Printf("   Removed config: %s\n", config.ConfigPath)
 
-	// Determine systemd service name
-	var serviceName string
-	if config.IsTomcat {
+	// Determine systemd service name, the one the config records if any
+	serviceName := config.SystemdUnit
+	if serviceName == "" && config.IsTomcat {
 		serviceName = systemd.GetTomcatServiceName()
-	} else {
+	} else if serviceName == "" {
 		serviceName = config.ServiceName + ".service"
 	}
 
@@ -98,15 +147,17 @@ func RemoveOrphanedConfig(config OrphanedConfig) error {
 }
 
 // ScanAndSaveOrphaned scans for orphaned configs and saves the state
-func ScanAndSaveOrphaned(runningProcesses []discovery.JavaProcess) error {
-	orphaned, err := FindOrphanedConfigs(runningProcesses)
+func ScanAndSaveOrphaned(configRoot string, runningProcesses []discovery.JavaProcess) error {
+	orphaned, err := FindOrphanedConfigs(configRoot, runningProcesses)
 	if err != nil {
 		return fmt.Errorf("failed to find orphaned configs: %w", err)
 	}
 
-	state := &HostState{
-		OrphanedConfigs: orphaned,
+	state, err := LoadHostState()
+	if err != nil {
+		state = &HostState{}
 	}
+	state.OrphanedConfigs = orphaned
 
 	if err := SaveHostState(state); err != nil {
 		return fmt.Errorf("failed to save host state: %w", err)
@@ -173,27 +224,31 @@ func ValidateOrphanedConfigs(configs []OrphanedConfig) []OrphanedConfig {
 // Helper functions
 
 // getConfigPath generates the config path for a Java process
-func getConfigPath(proc *discovery.JavaProcess) string {
+func getConfigPath(configRoot string, proc *discovery.JavaProcess) string {
 	serviceName := naming.GenerateServiceName(proc)
 
+	if proc.IsWildFly() {
+		return fmt.Sprintf("%s/wildfly/%s.conf", configRoot, serviceName)
+	}
+
+	if proc.IsJetty() {
+		return fmt.Sprintf("%s/jetty/%s.conf", configRoot, serviceName)
+	}
+
 	if proc.IsTomcat() {
-		return fmt.Sprintf("/etc/middleware/tomcat/%s.conf", serviceName)
+		return fmt.Sprintf("%s/tomcat/%s.conf", configRoot, serviceName)
 	}
 
 	deploymentType := detectDeploymentType(proc)
-	return fmt.Sprintf("/etc/middleware/%s/%s.conf", deploymentType, serviceName)
+	return fmt.Sprintf("%s/%s/%s.conf", configRoot, deploymentType, serviceName)
 }
 
 // detectDeploymentType determines the deployment type for a process
 func detectDeploymentType(proc *discovery.JavaProcess) string {
-	if proc.ProcessOwner != "root" && proc.ProcessOwner != os.Getenv("USER") {
-		return "systemd"
-	}
-	return "standalone"
+	return proc.DetectSupervisor().Kind
 }
 
 // fileExists checks if a file exists
 func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	return changeset.Exists(path)
 }
@@ -6,11 +6,13 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 )
 
 // EnsureStateDir ensures the state directory exists with proper permissions
 func EnsureStateDir() error {
-	if err := os.MkdirAll(DefaultStateDir, 0o755); err != nil {
+	if err := changeset.MkdirAll(DefaultStateDir, 0o755); err != nil {
 		return fmt.Errorf("failed to create state directory: %w", err)
 	}
 	return nil
@@ -21,11 +23,11 @@ func LoadFromFile(filename string, data interface{}) error {
 	path := filepath.Join(DefaultStateDir, filename)
 
 	// Return empty data if file doesn't exist
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if !changeset.Exists(path) {
 		return nil
 	}
 
-	fileData, err := os.ReadFile(path)
+	fileData, err := changeset.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read state file %s: %w", filename, err)
 	}
@@ -50,32 +52,43 @@ func SaveToFile(filename string, data interface{}) error {
 		return fmt.Errorf("failed to marshal state data: %w", err)
 	}
 
-	if err := os.WriteFile(path, jsonData, 0o644); err != nil {
+	// Recorded file contents may include secrets, keep state root-only
+	if err := changeset.WriteFileAtomic(path, jsonData, 0o600); err != nil {
 		return fmt.Errorf("failed to write state file %s: %w", filename, err)
 	}
 
 	return nil
 }
 
-// LoadHostState loads the host instrumentation state
+// LoadHostState loads the host instrumentation state from the state store
 func LoadHostState() (*HostState, error) {
+	store, err := LoadStore()
+	if err != nil {
+		return nil, err
+	}
+
 	state := &HostState{
-		OrphanedConfigs: []OrphanedConfig{},
+		OrphanedConfigs: store.OrphanedConfigs,
+		Artifacts:       store.Artifacts,
+		LastScan:        store.LastScan,
 		Version:         StateVersion,
 	}
-
-	if err := LoadFromFile(HostStateFile, state); err != nil {
-		return nil, err
+	if state.OrphanedConfigs == nil {
+		state.OrphanedConfigs = []OrphanedConfig{}
 	}
-
 	return state, nil
 }
 
-// SaveHostState saves the host instrumentation state
+// SaveHostState saves the host instrumentation state to the state store
 func SaveHostState(state *HostState) error {
 	state.LastScan = time.Now()
 	state.Version = StateVersion
-	return SaveToFile(HostStateFile, state)
+	return UpdateStore(func(store *Store) error {
+		store.OrphanedConfigs = state.OrphanedConfigs
+		store.Artifacts = state.Artifacts
+		store.LastScan = state.LastScan
+		return nil
+	})
 }
 
 // ValidateStateFile validates a state file structure
@@ -137,7 +150,7 @@ func CleanupOldStateFiles(maxAge time.Duration) error {
 
 		if info.ModTime().Before(cutoff) {
 			path := filepath.Join(DefaultStateDir, file.Name())
-			if err := os.Remove(path); err != nil {
+			if err := changeset.Remove(path); err != nil {
 				fmt.Printf("Warning: Failed to remove old state file %s: %v\n", file.Name(), err)
 			} else {
 				fmt.Printf("Cleaned up old state file: %s\n", file.Name())
@@ -155,9 +168,7 @@ func GetStateFilePath(filename string) string {
 
 // StateFileExists checks if a state file exists
 func StateFileExists(filename string) bool {
-	path := filepath.Join(DefaultStateDir, filename)
-	_, err := os.Stat(path)
-	return err == nil
+	return changeset.Exists(filepath.Join(DefaultStateDir, filename))
 }
 
 // BackupStateFile creates a backup of a state file
@@ -169,12 +180,12 @@ func BackupStateFile(filename string) error {
 	sourcePath := filepath.Join(DefaultStateDir, filename)
 	backupPath := filepath.Join(DefaultStateDir, filename+".backup")
 
-	data, err := os.ReadFile(sourcePath)
+	data, err := changeset.ReadFile(sourcePath)
 	if err != nil {
 		return fmt.Errorf("failed to read state file: %w", err)
 	}
 
-	if err := os.WriteFile(backupPath, data, 0o644); err != nil {
+	if err := changeset.WriteFileAtomic(backupPath, data, 0o644); err != nil {
 		return fmt.Errorf("failed to create backup: %w", err)
 	}
 
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+)
+
+// PendingRestartsFile holds the restarts deferred to the maintenance window
+const PendingRestartsFile = "pending-restarts.json"
+
+// PendingRestarts are services configured in earlier runs that restart in
+// the maintenance window
+type PendingRestarts struct {
+	Window   string           `json:"window"`
+	Restarts []PendingRestart `json:"restarts"`
+}
+
+// PendingRestart is a service waiting for the maintenance window
+type PendingRestart struct {
+	Name       string                    `json:"name"`
+	Unit       string                    `json:"unit,omitempty"`
+	Supervisor *discovery.SupervisorInfo `json:"supervisor,omitempty"`
+	Group      string                    `json:"group,omitempty"`
+	HealthPort int                       `json:"health_port,omitempty"`
+	HealthURL  string                    `json:"health_url,omitempty"`
+	Journal    string                    `json:"journal,omitempty"` // of the run that configured it
+	Targets    []string                  `json:"targets,omitempty"` // its transactions in that journal
+	QueuedAt   time.Time                 `json:"queued_at"`
+}
+
+// LoadPendingRestarts loads the deferred restarts
+func LoadPendingRestarts() (*PendingRestarts, error) {
+	pending := &PendingRestarts{}
+	if err := LoadFromFile(PendingRestartsFile, pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// SavePendingRestarts saves the deferred restarts
+func SavePendingRestarts(pending *PendingRestarts) error {
+	return SaveToFile(PendingRestartsFile, pending)
+}
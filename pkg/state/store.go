@@ -0,0 +1,351 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+)
+
+const (
+	// StoreFile is the state store, in the state directory
+	StoreFile = "store.json"
+
+	// SchemaVersion is the current version of the state store. Version 1 is
+	// the layout before the store, split across host.json and
+	// LegacyDockerStateFile.
+	SchemaVersion = 2
+
+	// LegacyDockerStateFile held instrumented containers before the store
+	LegacyDockerStateFile = "/etc/middleware/docker/instrumented.json"
+)
+
+// Store is everything mw-injector remembers about the changes it made:
+// the artifacts it wrote, the containers it instrumented and the configs
+// of services that stopped
+type Store struct {
+	SchemaVersion   int                        `json:"schema_version"`
+	UpdatedAt       time.Time                  `json:"updated_at"`
+	Artifacts       []Artifact                 `json:"artifacts"`
+	Containers      map[string]json.RawMessage `json:"containers"` // owned by the docker package, by container name
+	OrphanedConfigs []OrphanedConfig           `json:"orphaned_configs"`
+	Agents          []AgentRecord              `json:"agents,omitempty"` // agents installed, by path
+	LastScan        time.Time                  `json:"last_scan"`
+
+	legacy []string // files migrated into the store, retired on save
+}
+
+// migrations upgrade a store from the version they're keyed by to the next
+var migrations = map[int]func(*Store) error{
+	1: migrateLegacyFiles,
+}
+
+// LoadStore loads the state store, migrating older layouts. Callers that
+// change it must hold the injector lock.
+func LoadStore() (*Store, error) {
+	store := &Store{SchemaVersion: 1}
+	path := filepath.Join(DefaultStateDir, StoreFile)
+	if changeset.Exists(path) {
+		data, err := changeset.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state store: %w", err)
+		}
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("failed to parse state store %s: %w", path, err)
+		}
+	}
+
+	if store.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("state store %s has schema version %d, this mw-injector supports up to %d", path, store.SchemaVersion, SchemaVersion)
+	}
+	for store.SchemaVersion < SchemaVersion {
+		migrate, ok := migrations[store.SchemaVersion]
+		if !ok {
+			return nil, fmt.Errorf("no migration from state schema version %d", store.SchemaVersion)
+		}
+		if err := migrate(store); err != nil {
+			return nil, fmt.Errorf("failed to migrate state from schema version %d: %w", store.SchemaVersion, err)
+		}
+		store.SchemaVersion++
+	}
+
+	if store.Containers == nil {
+		store.Containers = make(map[string]json.RawMessage)
+	}
+	return store, nil
+}
+
+// SaveStore atomically writes the state store, retiring the files it was
+// migrated from
+func SaveStore(store *Store) error {
+	if err := EnsureStateDir(); err != nil {
+		return err
+	}
+
+	store.SchemaVersion = SchemaVersion
+	store.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state store: %w", err)
+	}
+
+	// Recorded file contents may include secrets, keep state root-only
+	path := filepath.Join(DefaultStateDir, StoreFile)
+	if err := changeset.WriteFileAtomic(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+
+	for _, legacy := range store.legacy {
+		if err := changeset.Rename(legacy, legacy+".migrated"); err != nil {
+			fmt.Printf("⚠️  Failed to retire %s: %v\n", legacy, err)
+		}
+	}
+	store.legacy = nil
+	return nil
+}
+
+// UpdateStore loads the state store, changes it and saves it
+func UpdateStore(update func(*Store) error) error {
+	store, err := LoadStore()
+	if err != nil {
+		return err
+	}
+	if err := update(store); err != nil {
+		return err
+	}
+	return SaveStore(store)
+}
+
+// RecordArtifact adds an artifact, replacing an earlier record of the same
+// file
+func (s *Store) RecordArtifact(artifact Artifact) {
+	if artifact.CreatedAt.IsZero() {
+		artifact.CreatedAt = time.Now()
+	}
+	if artifact.OriginalHash == "" {
+		artifact.OriginalHash = originalHash(artifact)
+	}
+
+	var artifacts []Artifact
+	for _, a := range s.Artifacts {
+		if a.Kind != artifact.Kind || a.Path != artifact.Path {
+			artifacts = append(artifacts, a)
+		}
+	}
+	s.Artifacts = append(artifacts, artifact)
+}
+
+// Agent returns the record of the agent installed at path, nil when none.
+// A path through the current link finds the version it points at.
+func (s *Store) Agent(path string) *AgentRecord {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolved = path
+	}
+	for i := range s.Agents {
+		if s.Agents[i].Path == path || s.Agents[i].Path == resolved {
+			return &s.Agents[i]
+		}
+	}
+	return nil
+}
+
+// RemoveAgent forgets the record of an agent that was removed
+func (s *Store) RemoveAgent(path string) {
+	var agents []AgentRecord
+	for _, a := range s.Agents {
+		if a.Path != path {
+			agents = append(agents, a)
+		}
+	}
+	s.Agents = agents
+}
+
+// RecordAgent records an installed agent, replacing an earlier record of
+// the same path
+func (s *Store) RecordAgent(record AgentRecord) {
+	if record.InstalledAt.IsZero() {
+		record.InstalledAt = time.Now()
+	}
+	var agents []AgentRecord
+	for _, a := range s.Agents {
+		if a.Path != record.Path {
+			agents = append(agents, a)
+		}
+	}
+	s.Agents = append(agents, record)
+}
+
+// ContainerArtifacts returns the artifacts owned by a container
+func (s *Store) ContainerArtifacts(containerName string) []Artifact {
+	var artifacts []Artifact
+	for _, a := range s.Artifacts {
+		if a.Container == containerName {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts
+}
+
+// ForgetContainer removes the container and the artifacts it owns
+func (s *Store) ForgetContainer(containerName string) {
+	delete(s.Containers, containerName)
+	var artifacts []Artifact
+	for _, a := range s.Artifacts {
+		if a.Container != containerName {
+			artifacts = append(artifacts, a)
+		}
+	}
+	s.Artifacts = artifacts
+}
+
+// originalHash hashes the content a file had before the artifact changed
+// it, empty when the file didn't exist
+func originalHash(a Artifact) string {
+	switch a.Kind {
+	case ArtifactManagedBlock, ArtifactAppArmorRule:
+		content, err := managed.ReadWithoutBlock(a.Path)
+		if err != nil {
+			return ""
+		}
+		return changeset.Hash([]byte(content))
+	case ArtifactFile:
+		if !a.Existed {
+			return ""
+		}
+		return changeset.Hash([]byte(a.Previous))
+	case ArtifactComposeFile:
+		if data, err := changeset.ReadFile(a.Backup); err == nil {
+			return changeset.Hash(data)
+		}
+	}
+	return ""
+}
+
+// migrateLegacyFiles imports host.json and the docker state file, and
+// records the compose backups next to instrumented compose files
+func migrateLegacyFiles(s *Store) error {
+	hostPath := filepath.Join(DefaultStateDir, HostStateFile)
+	if changeset.Exists(hostPath) {
+		var host HostState
+		if err := LoadFromFile(HostStateFile, &host); err != nil {
+			return err
+		}
+		s.OrphanedConfigs = host.OrphanedConfigs
+		s.LastScan = host.LastScan
+		for _, a := range host.Artifacts {
+			s.RecordArtifact(a)
+		}
+		s.legacy = append(s.legacy, hostPath)
+	}
+
+	if changeset.Exists(LegacyDockerStateFile) {
+		data, err := changeset.ReadFile(LegacyDockerStateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", LegacyDockerStateFile, err)
+		}
+		var docker struct {
+			Containers map[string]json.RawMessage `json:"containers"`
+		}
+		if err := json.Unmarshal(data, &docker); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", LegacyDockerStateFile, err)
+		}
+		s.Containers = docker.Containers
+		s.legacy = append(s.legacy, LegacyDockerStateFile)
+	}
+
+	for name, raw := range s.Containers {
+		var container struct {
+			ComposeFile string `json:"compose_file"`
+		}
+		if json.Unmarshal(raw, &container) != nil || container.ComposeFile == "" {
+			continue
+		}
+		backup := container.ComposeFile + ".backup"
+		if changeset.Exists(backup) {
+			s.RecordArtifact(Artifact{Kind: ArtifactComposeFile, Path: container.ComposeFile, Backup: backup, Container: name})
+		}
+	}
+	return nil
+}
+
+// IsStateFile tells whether a path is mw-injector bookkeeping rather than a
+// file it manages for a service
+func IsStateFile(path string) bool {
+	return strings.HasPrefix(path, DefaultStateDir+"/") || strings.HasPrefix(path, LegacyDockerStateFile)
+}
+
+// EarlierStore rebuilds the state store as an earlier run found it, from
+// the original contents of the state files that run changed. A nil content
+// means the file didn't exist.
+func EarlierStore(originals map[string][]byte) (*Store, error) {
+	earlier := &Store{Containers: make(map[string]json.RawMessage)}
+	if data := originals[filepath.Join(DefaultStateDir, StoreFile)]; data != nil {
+		if err := json.Unmarshal(data, earlier); err != nil {
+			return nil, fmt.Errorf("failed to parse earlier state store: %w", err)
+		}
+	}
+	if data := originals[filepath.Join(DefaultStateDir, HostStateFile)]; data != nil {
+		var host HostState
+		if err := json.Unmarshal(data, &host); err != nil {
+			return nil, fmt.Errorf("failed to parse earlier host state: %w", err)
+		}
+		earlier.Artifacts = append(earlier.Artifacts, host.Artifacts...)
+	}
+	if data := originals[LegacyDockerStateFile]; data != nil {
+		var docker struct {
+			Containers map[string]json.RawMessage `json:"containers"`
+		}
+		if err := json.Unmarshal(data, &docker); err != nil {
+			return nil, fmt.Errorf("failed to parse earlier docker state: %w", err)
+		}
+		for name, raw := range docker.Containers {
+			earlier.Containers[name] = raw
+		}
+	}
+	return earlier, nil
+}
+
+// RevertStore puts the records of some files and containers back the way
+// they were in an earlier store, keeping everything recorded for others
+func RevertStore(earlier *Store, paths, containers []string) error {
+	restored := make(map[string]bool)
+	for _, path := range paths {
+		restored[path] = true
+	}
+	owned := make(map[string]bool)
+	for _, name := range containers {
+		owned[name] = true
+	}
+	affected := func(a Artifact) bool {
+		return restored[a.Path] || (a.Container != "" && owned[a.Container])
+	}
+
+	return UpdateStore(func(store *Store) error {
+		var artifacts []Artifact
+		for _, a := range store.Artifacts {
+			if !affected(a) {
+				artifacts = append(artifacts, a)
+			}
+		}
+		for _, a := range earlier.Artifacts {
+			if affected(a) {
+				artifacts = append(artifacts, a)
+			}
+		}
+		store.Artifacts = artifacts
+
+		for _, name := range containers {
+			if raw, ok := earlier.Containers[name]; ok {
+				store.Containers[name] = raw
+			} else {
+				delete(store.Containers, name)
+			}
+		}
+		return nil
+	})
+}
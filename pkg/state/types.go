@@ -7,15 +7,45 @@ type OrphanedConfig struct {
 	ConfigPath  string `json:"config_path"`
 	ServiceName string `json:"service_name"`
 	IsTomcat    bool   `json:"is_tomcat"`
+	IsWildFly   bool   `json:"is_wildfly,omitempty"`
+	SystemdUnit string `json:"systemd_unit,omitempty"` // MW_SYSTEMD_UNIT of the config
 }
 
 // HostState represents the state of host-based instrumentation
 type HostState struct {
 	OrphanedConfigs []OrphanedConfig `json:"orphaned_configs"`
+	Artifacts       []Artifact       `json:"artifacts,omitempty"`
 	LastScan        time.Time        `json:"last_scan"`
 	Version         string           `json:"version"`
 }
 
+// Artifact records a change made outside /etc/middleware so it can be reverted,
+// owned by the service of ConfigPath or by Container
+type Artifact struct {
+	Kind         string    `json:"kind"`
+	Path         string    `json:"path"`
+	Unit         string    `json:"unit,omitempty"`
+	ConfigPath   string    `json:"config_path"`
+	ServiceName  string    `json:"service_name"`
+	Container    string    `json:"container,omitempty"` // owner, for container artifacts
+	Existed      bool      `json:"existed,omitempty"`
+	Previous     string    `json:"previous,omitempty"`
+	Backup       string    `json:"backup,omitempty"`        // copy of the original, for compose files
+	OriginalHash string    `json:"original_hash,omitempty"` // content before the change, empty if there was no file
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AgentRecord is an agent mw-injector installed, as its manifest describes
+// it
+type AgentRecord struct {
+	Path         string    `json:"path"`
+	Version      string    `json:"version"`
+	SDKVersion   string    `json:"sdk_version,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Verification string    `json:"verification,omitempty"` // how it was verified, see agent.Verify
+	InstalledAt  time.Time `json:"installed_at"`
+}
+
 // StateFile represents a generic state file structure
 type StateFile struct {
 	Path         string
@@ -29,16 +59,36 @@ type ValidationResult struct {
 	Warnings []string `json:"warnings"`
 }
 
+// Artifact kinds
+const (
+	// ArtifactManagedBlock is a marked block inside a file we don't own
+	ArtifactManagedBlock = "managed-block"
+
+	// ArtifactDropIn is the Middleware systemd drop-in of a unit
+	ArtifactDropIn = "systemd-dropin"
+
+	// ArtifactFile is a whole file, restored to Previous or removed
+	ArtifactFile = "file"
+
+	// ArtifactComposeFile is a compose file changed in place, restored from Backup
+	ArtifactComposeFile = "compose-file"
+
+	// ArtifactSELinuxRule is the file context rule letting services read the
+	// agent, Path is its pattern. Host-wide, like ArtifactAppArmorRule.
+	ArtifactSELinuxRule = "selinux-fcontext"
+
+	// ArtifactAppArmorRule is the agent rule in the local include of an
+	// AppArmor profile
+	ArtifactAppArmorRule = "apparmor-local"
+)
+
 const (
 	// DefaultStateDir is the centralized state directory
 	DefaultStateDir = "/etc/middleware/state"
 
-	// HostStateFile is the file for host instrumentation state
+	// HostStateFile held host instrumentation state before the state store
 	HostStateFile = "host.json"
 
-	// DockerStateFile is the file for Docker instrumentation state
-	DockerStateFile = "docker.json"
-
 	// StateVersion is the current state format version
 	StateVersion = "1.0"
 )
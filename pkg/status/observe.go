@@ -0,0 +1,197 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/docker"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// ObserveProcess observes a running JVM and the config written for it
+func ObserveProcess(proc *discovery.JavaProcess, configPath string) Observation {
+	o := Observation{
+		Running:   true,
+		StartedAt: proc.ProcessCreateTime,
+		Unit:      discovery.SystemdUnitForPID(proc.ProcessPID),
+	}
+	if proc.HasJavaAgent {
+		o.LoadedAgent = proc.JavaAgentPath
+		o.AgentChanged = agentChanged(o.LoadedAgent)
+	}
+	observeConfig(&o, configPath)
+	if o.Unit != "" {
+		observeUnit(&o)
+	}
+	return o
+}
+
+// checksums caches the checksums of agents, each is hashed once per run
+var checksums = make(map[string]string)
+
+// agentChanged tells whether an agent mw-injector installed no longer has
+// the checksum recorded then
+func agentChanged(path string) bool {
+	store, err := state.LoadStore()
+	if err != nil {
+		return false
+	}
+	recorded := store.Agent(path)
+	if recorded == nil || recorded.SHA256 == "" {
+		return false
+	}
+	sum, ok := checksums[path]
+	if !ok {
+		sum, _ = agent.Checksum(path)
+		checksums[path] = sum
+	}
+	return sum != recorded.SHA256
+}
+
+// ObserveConfig observes a config whose service isn't running
+func ObserveConfig(configPath string) Observation {
+	var o Observation
+	observeConfig(&o, configPath)
+	if o.Unit == "" {
+		return o
+	}
+
+	properties := systemd.Show(o.Unit, "ActiveState", "Result")
+	if properties["ActiveState"] == "failed" {
+		o.UnitFailed = true
+		o.FailureReason = fmt.Sprintf("%s failed (%s)", o.Unit, properties["Result"])
+	}
+	return o
+}
+
+// observeConfig reads the config of a service and checks that the changes
+// recorded for it are still in place
+func observeConfig(o *Observation, configPath string) {
+	o.ConfigPath = configPath
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return
+	}
+	o.ConfigTime = info.ModTime()
+
+	configVars, err := systemd.ReadConfigFile(configPath)
+	if err == nil {
+		o.AgentPath = configVars["MW_JAVA_AGENT_PATH"]
+	}
+
+	artifacts, _ := state.ArtifactsForConfig(configPath)
+	for _, a := range artifacts {
+		switch a.Kind {
+		case state.ArtifactManagedBlock:
+			if !managed.HasBlock(a.Path) {
+				o.Missing = append(o.Missing, a.Path)
+			}
+		case state.ArtifactDropIn:
+			o.DropIns = append(o.DropIns, a.Path)
+			if o.Unit == "" {
+				o.Unit = a.Unit
+			}
+		default:
+			if !fileExists(a.Path) {
+				o.Missing = append(o.Missing, a.Path)
+			}
+		}
+	}
+
+	// Standard and Tomcat configs record no changes, the unit loads the
+	// agent from the Middleware drop-in
+	if len(artifacts) == 0 {
+		unit := configVars["MW_SYSTEMD_UNIT"]
+		if filepath.Base(filepath.Dir(configPath)) == "tomcat" {
+			unit = systemd.GetTomcatServiceName()
+		}
+		if unit != "" {
+			if o.Unit == "" {
+				o.Unit = unit
+			}
+			if dropIn, err := systemd.DropInPath(unit); err == nil {
+				o.DropIns = append(o.DropIns, dropIn)
+			}
+		}
+	}
+
+	for _, dropIn := range o.DropIns {
+		info, err := os.Stat(dropIn)
+		if err != nil {
+			o.Missing = append(o.Missing, dropIn)
+			continue
+		}
+		if info.ModTime().After(o.DropInTime) {
+			o.DropInTime = info.ModTime()
+		}
+	}
+}
+
+// observeUnit checks that systemd loaded the drop-ins of a running unit
+func observeUnit(o *Observation) {
+	properties := systemd.Show(o.Unit, "DropInPaths", "NeedDaemonReload")
+	if len(properties) == 0 {
+		return
+	}
+	o.NeedsReload = properties["NeedDaemonReload"] == "yes"
+
+	loaded := make(map[string]bool)
+	for _, path := range strings.Fields(properties["DropInPaths"]) {
+		loaded[path] = true
+	}
+	for _, dropIn := range o.DropIns {
+		if !loaded[dropIn] {
+			o.NotLoaded = append(o.NotLoaded, dropIn)
+		}
+	}
+}
+
+// ObserveContainer observes an instrumented container, running is nil when
+// it isn't among the running Java containers
+func ObserveContainer(saved *docker.ContainerState, running *discovery.DockerContainer) Observation {
+	o := Observation{ConfigTime: saved.InstrumentedAt}
+	if saved.EnvFile != "" && !fileExists(saved.EnvFile) {
+		o.Missing = append(o.Missing, saved.EnvFile)
+	}
+	if saved.ComposeFile != "" && !fileExists(saved.ComposeFile) {
+		o.Missing = append(o.Missing, saved.ComposeFile)
+	}
+
+	if running == nil {
+		output, err := exec.Command("docker", "inspect", "-f", "{{.State.Status}} {{.State.ExitCode}}", saved.ContainerName).Output()
+		if err != nil {
+			return o
+		}
+		var status string
+		var exitCode int
+		fmt.Sscan(strings.TrimSpace(string(output)), &status, &exitCode)
+		if status == "dead" || (status == "exited" && exitCode != 0) {
+			o.UnitFailed = true
+			o.FailureReason = fmt.Sprintf("container %s with code %d", status, exitCode)
+		}
+		return o
+	}
+
+	if running.Status == "restarting" {
+		o.UnitFailed = true
+		o.FailureReason = "container is restarting"
+		return o
+	}
+	o.Running = true
+	if running.HasJavaAgent {
+		o.LoadedAgent = running.JavaAgentPath
+	}
+	return o
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
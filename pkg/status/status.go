@@ -0,0 +1,123 @@
+// Package status compares the instrumentation a service is configured for
+// with what it actually runs. A config file alone doesn't mean the agent is
+// loaded: the JVM may not have been restarted since, or a drop-in may have
+// been removed or overridden.
+package status
+
+import (
+	"fmt"
+	"time"
+)
+
+// Service states
+const (
+	Instrumented    = "instrumented"     // configured and running with the agent
+	PendingRestart  = "pending-restart"  // configured, loads the agent on its next restart
+	Drifted         = "drifted"          // configured, but the changes are gone or don't take effect
+	Orphaned        = "orphaned"         // configured, but not running
+	Failed          = "failed"           // failed with the agent, or was rolled back
+	NotInstrumented = "not-instrumented" // never configured
+)
+
+// States lists the service states in the order they are reported
+var States = []string{Instrumented, PendingRestart, Drifted, Orphaned, Failed, NotInstrumented}
+
+// Observation is what's configured for a service and what it runs
+type Observation struct {
+	// Desired state
+	ConfigPath string
+	ConfigTime time.Time // modification time, zero when there's no config
+	AgentPath  string    // agent the config points at
+	DropIns    []string  // drop-ins the unit must load
+	DropInTime time.Time // latest drop-in modification
+	Missing    []string  // recorded changes that are gone
+	Queued     bool      // restart deferred to the maintenance window
+	RolledBack string    // why the latest run rolled the service back
+
+	// Observed state
+	Unit          string
+	Running       bool
+	StartedAt     time.Time
+	LoadedAgent   string   // -javaagent the JVM runs with, empty without one
+	AgentChanged  bool     // the loaded agent differs from the one installed
+	NotLoaded     []string // expected drop-ins systemd didn't load
+	NeedsReload   bool     // systemd hasn't seen the latest unit files
+	UnitFailed    bool
+	FailureReason string
+}
+
+// Classify returns the state of a service and the reasons for it
+func Classify(o Observation) (string, []string) {
+	configured := !o.ConfigTime.IsZero()
+
+	if !o.Running {
+		switch {
+		case o.UnitFailed:
+			reason := "the service failed"
+			if o.FailureReason != "" {
+				reason = o.FailureReason
+			}
+			return Failed, []string{reason}
+		case configured:
+			return Orphaned, []string{"configured but not running"}
+		}
+		return NotInstrumented, nil
+	}
+
+	if o.AgentChanged {
+		return Drifted, []string{fmt.Sprintf("%s changed since it was installed, it may have been tampered with", o.LoadedAgent)}
+	}
+
+	if !configured {
+		switch {
+		case o.RolledBack != "":
+			return Failed, []string{"rolled back: " + o.RolledBack}
+		case o.LoadedAgent != "":
+			return Instrumented, []string{"agent added outside mw-injector: " + o.LoadedAgent}
+		}
+		return NotInstrumented, nil
+	}
+
+	var reasons []string
+	for _, path := range o.Missing {
+		reasons = append(reasons, path+" was removed")
+	}
+	if len(reasons) > 0 {
+		return Drifted, reasons
+	}
+
+	changed := o.ConfigTime
+	if o.DropInTime.After(changed) {
+		changed = o.DropInTime
+	}
+	restartedBefore := !o.StartedAt.IsZero() && o.StartedAt.Before(changed)
+
+	if o.LoadedAgent != "" {
+		switch {
+		case restartedBefore:
+			return PendingRestart, []string{fmt.Sprintf("configuration changed at %s, after the JVM started", changed.Format(time.DateTime))}
+		case o.AgentPath != "" && o.LoadedAgent != o.AgentPath:
+			return Drifted, []string{fmt.Sprintf("runs %s, configured for %s", o.LoadedAgent, o.AgentPath)}
+		}
+		return Instrumented, nil
+	}
+
+	switch {
+	case o.RolledBack != "":
+		return Failed, []string{"rolled back: " + o.RolledBack}
+	case o.Queued:
+		return PendingRestart, []string{"restart deferred to the maintenance window"}
+	case o.NeedsReload:
+		return PendingRestart, []string{"systemd hasn't reloaded the unit files, run systemctl daemon-reload and restart " + o.Unit}
+	case restartedBefore:
+		return PendingRestart, []string{fmt.Sprintf("the JVM started at %s, before it was configured", o.StartedAt.Format(time.DateTime))}
+	}
+
+	for _, path := range o.NotLoaded {
+		reasons = append(reasons, "systemd doesn't load "+path)
+	}
+	if len(reasons) > 0 {
+		return Drifted, reasons
+	}
+	return Drifted, []string{"the JVM runs without the agent, a later setting may override the Middleware one"}
+}
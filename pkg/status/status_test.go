@@ -0,0 +1,49 @@
+package status_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/status"
+)
+
+func TestClassify(t *testing.T) {
+	configured := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	before := configured.Add(-time.Hour)
+	after := configured.Add(time.Hour)
+	agent := "/opt/middleware/agents/middleware-javaagent.jar"
+	dropIn := "/etc/systemd/system/billing.service.d/middleware-instrumentation.conf"
+
+	tests := []struct {
+		name string
+		o    status.Observation
+		want string
+	}{
+		{"loaded after configuring", status.Observation{ConfigTime: configured, AgentPath: agent, Running: true, StartedAt: after, LoadedAgent: agent}, status.Instrumented},
+		{"not restarted yet", status.Observation{ConfigTime: configured, Running: true, StartedAt: before}, status.PendingRestart},
+		{"deferred restart", status.Observation{ConfigTime: configured, Running: true, StartedAt: after, Queued: true}, status.PendingRestart},
+		{"unit files not reloaded", status.Observation{ConfigTime: configured, Running: true, StartedAt: after, NeedsReload: true}, status.PendingRestart},
+		{"agent changed since start", status.Observation{ConfigTime: configured, AgentPath: agent, Running: true, StartedAt: before, LoadedAgent: agent}, status.PendingRestart},
+		{"drop-in removed", status.Observation{ConfigTime: configured, Running: true, StartedAt: after, Missing: []string{dropIn}}, status.Drifted},
+		{"drop-in not loaded", status.Observation{ConfigTime: configured, Running: true, StartedAt: after, NotLoaded: []string{dropIn}}, status.Drifted},
+		{"drop-in overridden", status.Observation{ConfigTime: configured, Running: true, StartedAt: after}, status.Drifted},
+		{"other agent", status.Observation{ConfigTime: configured, AgentPath: agent, Running: true, StartedAt: after, LoadedAgent: "/tmp/agent.jar"}, status.Drifted},
+		{"agent tampered with", status.Observation{ConfigTime: configured, AgentPath: agent, Running: true, StartedAt: after, LoadedAgent: agent, AgentChanged: true}, status.Drifted},
+		{"stopped", status.Observation{ConfigTime: configured}, status.Orphaned},
+		{"unit failed", status.Observation{ConfigTime: configured, UnitFailed: true}, status.Failed},
+		{"rolled back", status.Observation{Running: true, RolledBack: "billing.service failed to start"}, status.Failed},
+		{"agent added by hand", status.Observation{Running: true, LoadedAgent: agent}, status.Instrumented},
+		{"never configured", status.Observation{Running: true}, status.NotInstrumented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reasons := status.Classify(tt.o)
+			if got != tt.want {
+				t.Errorf("Classify() = %s %v, expected %s", got, reasons, tt.want)
+			}
+			if got != status.Instrumented && got != status.NotInstrumented && len(reasons) == 0 {
+				t.Errorf("Classify() = %s without a reason", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// envDirInjector writes one file per variable to the env/ directory of a
+// runit or s6 service, read by `chpst -e ./env` or `s6-envdir ./env`
+type envDirInjector struct {
+	kind       string
+	name       string
+	serviceDir string
+}
+
+func (e *envDirInjector) String() string {
+	return e.kind + ":" + e.name
+}
+
+func (e *envDirInjector) Inject(env []EnvVar) ([]state.Artifact, error) {
+	// Nothing would read the variables
+	if !e.runScriptReadsEnvDir() {
+		return nil, fmt.Errorf("%s/run does not load ./env, add `%s` to it", e.serviceDir, e.envDirCommand())
+	}
+
+	envDir := filepath.Join(e.serviceDir, "env")
+	if err := changeset.MkdirAll(envDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", envDir, err)
+	}
+
+	var artifacts []state.Artifact
+	for _, kv := range env {
+		path := filepath.Join(envDir, kv.Name)
+		artifact := state.Artifact{Kind: state.ArtifactFile, Path: path}
+
+		value := kv.Value
+		if previous, err := changeset.ReadFile(path); err == nil {
+			artifact.Existed = true
+			artifact.Previous = string(previous)
+			if kv.Name == "JAVA_TOOL_OPTIONS" {
+				value = appendOption(firstLine(string(previous)), kv.Value)
+			}
+		}
+
+		// The envdir is read before privileges are dropped, so secrets can be root-only
+		perm := os.FileMode(0o644)
+		if secrets.IsSecret(kv.Name) {
+			perm = 0o600
+		}
+		if err := changeset.WriteFile(path, []byte(value+"\n"), perm); err != nil {
+			return artifacts, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := changeset.Chmod(path, perm); err != nil {
+			return artifacts, fmt.Errorf("failed to set permissions of %s: %w", path, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	fmt.Printf("   Updated env directory: %s\n", envDir)
+	return artifacts, nil
+}
+
+func (e *envDirInjector) Restart() error {
+	if e.kind == discovery.SupervisorS6 {
+		return run("s6-svc", "-r", e.serviceDir)
+	}
+	return run("sv", "restart", e.serviceDir)
+}
+
+// runScriptReadsEnvDir checks if the service's run script loads ./env
+func (e *envDirInjector) runScriptReadsEnvDir() bool {
+	data, err := changeset.ReadFile(filepath.Join(e.serviceDir, "run"))
+	if err != nil {
+		return false
+	}
+	content := string(data)
+	return strings.Contains(content, "envdir") || strings.Contains(content, "chpst -e") ||
+		strings.Contains(content, "s6-envdir")
+}
+
+// envDirCommand returns the command that loads ./env for the supervisor
+func (e *envDirInjector) envDirCommand() string {
+	if e.kind == discovery.SupervisorS6 {
+		return "s6-envdir ./env"
+	}
+	return "chpst -e ./env"
+}
+
+// firstLine returns the first line of an envdir file, which holds the value
+func firstLine(content string) string {
+	return strings.TrimRight(strings.SplitN(content, "\n", 2)[0], " \t")
+}
@@ -0,0 +1,102 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// openRCInjector exports the variables from /etc/conf.d/<service>, which
+// openrc-run sources before starting the service
+type openRCInjector struct {
+	name string
+}
+
+func (o *openRCInjector) String() string {
+	return "openrc:" + o.name
+}
+
+func (o *openRCInjector) Inject(env []EnvVar) ([]state.Artifact, error) {
+	return injectShellEnv(filepath.Join("/etc/conf.d", o.name), env)
+}
+
+func (o *openRCInjector) Restart() error {
+	return run("rc-service", o.name, "restart")
+}
+
+// sysVInjector exports the variables from the defaults file sourced by the
+// init script: /etc/default/<name> on Debian, /etc/sysconfig/<name> on RHEL
+type sysVInjector struct {
+	name   string
+	script string
+}
+
+func (s *sysVInjector) String() string {
+	return "sysv:" + s.name
+}
+
+func (s *sysVInjector) Inject(env []EnvVar) ([]state.Artifact, error) {
+	envFile := s.defaultsFile()
+
+	// Nothing would read the variables
+	data, err := changeset.ReadFile(s.script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read init script: %w", err)
+	}
+	if !strings.Contains(string(data), envFile) && !strings.Contains(string(data), filepath.Dir(envFile)) {
+		return nil, fmt.Errorf("%s does not source %s, load it from the script", s.script, envFile)
+	}
+
+	return injectShellEnv(envFile, env)
+}
+
+func (s *sysVInjector) Restart() error {
+	if err := run("service", s.name, "restart"); err == nil {
+		return nil
+	}
+	return run(s.script, "restart")
+}
+
+// defaultsFile returns the defaults file of the init script
+func (s *sysVInjector) defaultsFile() string {
+	for _, dir := range []string{"/etc/default", "/etc/sysconfig"} {
+		if _, err := os.Stat(dir); err == nil {
+			return filepath.Join(dir, s.name)
+		}
+	}
+	return filepath.Join("/etc/default", s.name)
+}
+
+// injectShellEnv adds export statements to a shell fragment in a managed block.
+// Init scripts run as root, so secrets are sourced from the root-only env file.
+func injectShellEnv(path string, env []EnvVar) ([]state.Artifact, error) {
+	var b strings.Builder
+	sourceSecrets := false
+	for _, kv := range env {
+		if secrets.IsSecret(kv.Name) {
+			sourceSecrets = true
+			continue
+		}
+		if kv.Name == "JAVA_TOOL_OPTIONS" {
+			fmt.Fprintf(&b, "export JAVA_TOOL_OPTIONS=\"${JAVA_TOOL_OPTIONS:+$JAVA_TOOL_OPTIONS }%s\"\n", kv.Value)
+			continue
+		}
+		fmt.Fprintf(&b, "export %s=\"%s\"\n", kv.Name, kv.Value)
+	}
+	if sourceSecrets {
+		fmt.Fprintf(&b, "[ -r %[1]s ] && set -a && . %[1]s && set +a\n", secrets.HostEnvFile)
+	}
+
+	if err := managed.UpsertBlock(path, b.String(), 0o644); err != nil {
+		return nil, err
+	}
+	fmt.Printf("   Updated environment file: %s\n", path)
+
+	return []state.Artifact{{Kind: state.ArtifactManagedBlock, Path: path}}, nil
+}
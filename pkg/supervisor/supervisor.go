@@ -0,0 +1,93 @@
+// Package supervisor injects the agent environment into processes kept
+// running by supervisors other than systemd: supervisord, runit, s6, OpenRC
+// and SysV init scripts.
+package supervisor
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// EnvVar is an environment variable passed to the supervised process
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Injector applies environment variables through a supervisor's own
+// configuration and restarts the supervised process
+type Injector interface {
+	// Inject writes the environment and returns the changes made
+	Inject(env []EnvVar) ([]state.Artifact, error)
+
+	// Restart restarts the process so the environment is applied
+	Restart() error
+
+	// String describes the supervised service, e.g. "runit:billing"
+	String() string
+}
+
+// NewInjector returns the injector for a supervisor
+func NewInjector(info *discovery.SupervisorInfo) (Injector, error) {
+	switch info.Kind {
+	case discovery.SupervisorSupervisord:
+		if info.Name == "" || info.ConfigPath == "" {
+			return nil, fmt.Errorf("could not determine the supervisord program and config")
+		}
+		return &supervisordInjector{program: info.Name, configPath: info.ConfigPath}, nil
+	case discovery.SupervisorRunit, discovery.SupervisorS6:
+		if info.ServiceDir == "" {
+			return nil, fmt.Errorf("could not determine the %s service directory", info.Kind)
+		}
+		return &envDirInjector{kind: info.Kind, name: info.Name, serviceDir: info.ServiceDir}, nil
+	case discovery.SupervisorOpenRC:
+		if info.Name == "" {
+			return nil, fmt.Errorf("could not determine the OpenRC service")
+		}
+		return &openRCInjector{name: info.Name}, nil
+	case discovery.SupervisorSysV:
+		return &sysVInjector{name: info.Name, script: info.ConfigPath}, nil
+	default:
+		return nil, fmt.Errorf("no injector for %s processes", info.Kind)
+	}
+}
+
+// AgentEnvironment returns the variables that load and configure the agent
+func AgentEnvironment(serviceName, agentPath, apiKey, target string) []EnvVar {
+	return []EnvVar{
+		{"JAVA_TOOL_OPTIONS", "-javaagent:" + agentPath},
+		{"OTEL_SERVICE_NAME", serviceName},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", target},
+		{"OTEL_EXPORTER_OTLP_HEADERS", "authorization=" + apiKey},
+		{"OTEL_TRACES_EXPORTER", "otlp"},
+		{"OTEL_METRICS_EXPORTER", "otlp"},
+		{"OTEL_LOGS_EXPORTER", "otlp"},
+	}
+}
+
+// appendOption adds an option to a space separated value unless present
+func appendOption(existing, option string) string {
+	for _, opt := range strings.Fields(existing) {
+		if opt == option {
+			return existing
+		}
+	}
+	if strings.TrimSpace(existing) == "" {
+		return option
+	}
+	return strings.TrimSpace(existing) + " " + option
+}
+
+// run executes a supervisor control command
+func run(name string, args ...string) error {
+	output, err := changeset.CombinedOutput(exec.Command(name, args...))
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
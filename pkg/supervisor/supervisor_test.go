@@ -0,0 +1,141 @@
+package supervisor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/state"
+	"github.com/middleware-labs/java-injector/pkg/supervisor"
+)
+
+func TestSupervisordInject(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "supervisord.conf"), "[supervisord]\nlogfile=/tmp/supervisord.log\n\n[include]\nfiles = conf.d/*.conf\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "billing.conf"),
+		"[program:billing]\ncommand=/usr/bin/java -jar /opt/billing/billing.jar\nenvironment=JAVA_TOOL_OPTIONS=\"-Xmx512m\",HOME=\"/srv/billing\"\n")
+
+	injector, err := supervisor.NewInjector(&discovery.SupervisorInfo{
+		Kind:       discovery.SupervisorSupervisord,
+		Name:       "billing",
+		ConfigPath: filepath.Join(dir, "supervisord.conf"),
+	})
+	if err != nil {
+		t.Fatalf("NewInjector() error: %v", err)
+	}
+
+	artifacts, err := injector.Inject(supervisor.AgentEnvironment("billing", "/opt/agent.jar", "key", "https://example.middleware.io:443"))
+	if err != nil {
+		t.Fatalf("Inject() error: %v", err)
+	}
+
+	overridePath := filepath.Join(dir, "conf.d", "zz-middleware-billing.conf")
+	if len(artifacts) != 1 || artifacts[0].Path != overridePath || artifacts[0].Kind != state.ArtifactManagedBlock {
+		t.Fatalf("Inject() artifacts = %+v", artifacts)
+	}
+
+	data, err := os.ReadFile(overridePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+
+	for _, want := range []string{
+		"[program:billing]",
+		`JAVA_TOOL_OPTIONS="-Xmx512m -javaagent:/opt/agent.jar"`,
+		`HOME="/srv/billing"`,
+		`OTEL_SERVICE_NAME="billing"`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("override missing %q\n%s", want, content)
+		}
+	}
+}
+
+func TestEnvDirInject(t *testing.T) {
+	serviceDir := t.TempDir()
+	writeFile(t, filepath.Join(serviceDir, "run"), "#!/bin/sh\nexec chpst -e ./env java -jar /opt/app.jar\n")
+	writeFile(t, filepath.Join(serviceDir, "env", "JAVA_TOOL_OPTIONS"), "-Xmx1g\n")
+
+	injector, err := supervisor.NewInjector(&discovery.SupervisorInfo{
+		Kind:       discovery.SupervisorRunit,
+		Name:       "app",
+		ServiceDir: serviceDir,
+	})
+	if err != nil {
+		t.Fatalf("NewInjector() error: %v", err)
+	}
+
+	artifacts, err := injector.Inject([]supervisor.EnvVar{
+		{Name: "JAVA_TOOL_OPTIONS", Value: "-javaagent:/opt/agent.jar"},
+		{Name: "OTEL_SERVICE_NAME", Value: "app"},
+	})
+	if err != nil {
+		t.Fatalf("Inject() error: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(serviceDir, "env", "JAVA_TOOL_OPTIONS"))
+	if string(data) != "-Xmx1g -javaagent:/opt/agent.jar\n" {
+		t.Errorf("JAVA_TOOL_OPTIONS = %q", data)
+	}
+
+	if len(artifacts) != 2 {
+		t.Fatalf("expected 2 artifacts, got %d", len(artifacts))
+	}
+	if !artifacts[0].Existed || artifacts[0].Previous != "-Xmx1g\n" {
+		t.Errorf("JAVA_TOOL_OPTIONS artifact = %+v, want the previous value recorded", artifacts[0])
+	}
+	if artifacts[1].Existed {
+		t.Errorf("OTEL_SERVICE_NAME artifact = %+v, want a new file", artifacts[1])
+	}
+}
+
+func TestInjectWithoutLoader(t *testing.T) {
+	serviceDir := t.TempDir()
+	writeFile(t, filepath.Join(serviceDir, "run"), "#!/bin/sh\nexec java -jar /opt/app.jar\n")
+	script := filepath.Join(t.TempDir(), "mw-test-app")
+	writeFile(t, script, "#!/bin/sh\nstart() {\n  java -jar /opt/app.jar &\n}\n")
+
+	tests := []struct {
+		name string
+		info discovery.SupervisorInfo
+		file string // must not be written
+	}{
+		{"Run script without envdir", discovery.SupervisorInfo{Kind: discovery.SupervisorRunit, Name: "app", ServiceDir: serviceDir}, filepath.Join(serviceDir, "env")},
+		{"Init script not sourcing defaults", discovery.SupervisorInfo{Kind: discovery.SupervisorSysV, Name: "mw-test-app", ConfigPath: script}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := supervisor.NewInjector(&tt.info)
+			if err != nil {
+				t.Fatalf("NewInjector() error: %v", err)
+			}
+			artifacts, err := injector.Inject(supervisor.AgentEnvironment("app", "/opt/agent.jar", "key", "https://example.middleware.io:443"))
+			if err == nil || len(artifacts) != 0 {
+				t.Fatalf("Inject() = %v, %v, want an error and no changes", artifacts, err)
+			}
+			for _, dir := range []string{"/etc/default", "/etc/sysconfig"} {
+				if _, err := os.Stat(filepath.Join(dir, "mw-test-app")); err == nil {
+					t.Errorf("Inject() wrote %s", filepath.Join(dir, "mw-test-app"))
+				}
+			}
+			if tt.file != "" {
+				if _, err := os.Stat(tt.file); !os.IsNotExist(err) {
+					t.Errorf("Inject() created %s", tt.file)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package supervisor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/state"
+)
+
+// supervisordInjector overrides a program's environment= from an include file.
+// supervisord reads includes after its main config and later files win, so
+// our file only has to sort after the one defining the program.
+type supervisordInjector struct {
+	program    string
+	configPath string
+}
+
+func (s *supervisordInjector) String() string {
+	return "supervisord:" + s.program
+}
+
+func (s *supervisordInjector) Inject(env []EnvVar) ([]state.Artifact, error) {
+	cfg, err := parseSupervisordConfig(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.includes) == 0 {
+		return nil, fmt.Errorf("%s has no [include] section to add the Middleware override to", s.configPath)
+	}
+
+	// supervisord expands %(...)s in values, escape ours
+	escaped := make([]EnvVar, 0, len(env))
+	for _, kv := range env {
+		escaped = append(escaped, EnvVar{Name: kv.Name, Value: strings.ReplaceAll(kv.Value, "%", "%%")})
+	}
+
+	existing := parseSupervisordEnvironment(cfg.environment[s.program])
+	merged := mergeEnvironment(existing, escaped)
+
+	// Use the first include pattern's directory and extension
+	pattern := cfg.includes[0]
+	overridePath := filepath.Join(filepath.Dir(pattern), "zz-middleware-"+s.program+filepath.Ext(pattern))
+
+	// supervisord reads its config as root, keep the API key away from others
+	content := fmt.Sprintf("[program:%s]\nenvironment=%s\n", s.program, formatSupervisordEnvironment(merged))
+	if err := managed.UpsertBlock(overridePath, content, 0o600); err != nil {
+		return nil, err
+	}
+	if err := changeset.Chmod(overridePath, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to set permissions of %s: %w", overridePath, err)
+	}
+	fmt.Printf("   Created supervisord override: %s\n", overridePath)
+
+	return []state.Artifact{{Kind: state.ArtifactManagedBlock, Path: overridePath}}, nil
+}
+
+// Restart rereads the configuration; update restarts programs that changed
+func (s *supervisordInjector) Restart() error {
+	if err := run("supervisorctl", "-c", s.configPath, "reread"); err != nil {
+		return err
+	}
+	return run("supervisorctl", "-c", s.configPath, "update", s.program)
+}
+
+// supervisordConfig is the part of a supervisord configuration we need
+type supervisordConfig struct {
+	includes    []string          // absolute include patterns
+	environment map[string]string // program name -> environment= value
+}
+
+// parseSupervisordConfig reads the main config and its includes in the order
+// supervisord does, skipping our own overrides
+func parseSupervisordConfig(configPath string) (*supervisordConfig, error) {
+	cfg := &supervisordConfig{environment: make(map[string]string)}
+
+	includes, err := readSupervisordFile(configPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configPath), pattern)
+		}
+		cfg.includes = append(cfg.includes, pattern)
+
+		matches, _ := filepath.Glob(pattern)
+		sort.Strings(matches)
+		for _, match := range matches {
+			if strings.HasPrefix(filepath.Base(match), "zz-middleware-") {
+				continue
+			}
+			if _, err := readSupervisordFile(match, cfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// readSupervisordFile records environment= of every program section and
+// returns the include patterns of the file
+func readSupervisordFile(path string, cfg *supervisordConfig) ([]string, error) {
+	data, err := changeset.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var includes []string
+	section := ""
+	key := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Continuation lines are indented
+		if raw[0] == ' ' || raw[0] == '\t' {
+			if key == "environment" {
+				cfg.environment[section] += line
+			} else if key == "files" && section == "include" {
+				includes = append(includes, strings.Fields(line)...)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			switch {
+			case name == "include":
+				section = "include"
+			case strings.HasPrefix(name, "program:"):
+				section = strings.TrimPrefix(name, "program:")
+			default:
+				section = ""
+			}
+			key = ""
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key = strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch {
+		case section == "include" && key == "files":
+			includes = append(includes, strings.Fields(value)...)
+		case section != "" && section != "include" && key == "environment":
+			cfg.environment[section] = value
+		}
+	}
+
+	return includes, scanner.Err()
+}
+
+// parseSupervisordEnvironment parses KEY="value",KEY2=value2
+func parseSupervisordEnvironment(value string) []EnvVar {
+	var env []EnvVar
+
+	var current strings.Builder
+	inQuotes := false
+	flush := func() {
+		if parts := strings.SplitN(strings.TrimSpace(current.String()), "=", 2); len(parts) == 2 && parts[0] != "" {
+			env = append(env, EnvVar{Name: parts[0], Value: parts[1]})
+		}
+		current.Reset()
+	}
+
+	for _, r := range value {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ',' && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return env
+}
+
+// formatSupervisordEnvironment renders KEY="value" pairs
+func formatSupervisordEnvironment(env []EnvVar) string {
+	pairs := make([]string, 0, len(env))
+	for _, kv := range env {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", kv.Name, kv.Value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// mergeEnvironment sets our variables on top of the existing ones, appending
+// to JAVA_TOOL_OPTIONS instead of replacing it
+func mergeEnvironment(existing, env []EnvVar) []EnvVar {
+	merged := append([]EnvVar{}, existing...)
+
+	for _, kv := range env {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name != kv.Name {
+				continue
+			}
+			if kv.Name == "JAVA_TOOL_OPTIONS" {
+				merged[i].Value = appendOption(merged[i].Value, kv.Value)
+			} else {
+				merged[i].Value = kv.Value
+			}
+			replaced = true
+		}
+		if !replaced {
+			merged = append(merged, kv)
+		}
+	}
+
+	return merged
+}
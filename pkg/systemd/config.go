@@ -2,18 +2,22 @@ package systemd
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
 )
 
 // CreateTomcatConfig creates configuration for Tomcat services
 // Moved from main.go: createTomcatConfig()
 func CreateTomcatConfig(configPath string, config *TomcatConfig) error {
 	dir := filepath.Dir(configPath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := changeset.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
 
@@ -24,30 +28,28 @@ func CreateTomcatConfig(configPath string, config *TomcatConfig) error {
 # Dynamic service naming for webapps
 MW_SERVICE_NAME_PATTERN=%s
 MW_TOMCAT_INSTANCE=%s
+MW_SERVICE_NAME_CONTEXT_MAP=%s
 
-# Middleware.io settings
-MW_API_KEY=%s
+# Middleware.io settings, the API key is kept in a root-only file
+MW_API_KEY_FILE=%s
 MW_TARGET=%s
-MW_LOG_LEVEL=INFO
 
 # Java agent
 MW_JAVA_AGENT_PATH=%s
 
-# Telemetry collection
-MW_APM_COLLECT_TRACES=true
-MW_APM_COLLECT_METRICS=true
-MW_APM_COLLECT_LOGS=true
-`, config.InstanceName, getCurrentTime(), config.Pattern, config.InstanceName,
-		config.APIKey, config.Target, config.AgentPath)
+# Agent settings
+%s`, config.InstanceName, getCurrentTime(), config.Pattern, config.InstanceName,
+		naming.FormatContextMap(config.WebappServiceNames), secrets.DefaultEnvFile(), config.Target, config.AgentPath,
+		settingsConfigLines(settingsOrDefault(config.Settings), "MW_SERVICE_NAME", "MW_TARGET"))
 
-	return os.WriteFile(configPath, []byte(content), 0o644)
+	return changeset.WriteFile(configPath, []byte(content), 0o644)
 }
 
 // CreateStandardConfig creates configuration for standard Java services
 // Moved from main.go: createStandardConfig()
 func CreateStandardConfig(configPath string, config *StandardConfig) error {
 	dir := filepath.Dir(configPath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := changeset.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
 
@@ -57,36 +59,33 @@ func CreateStandardConfig(configPath string, config *StandardConfig) error {
 
 # Service identification
 MW_SERVICE_NAME=%s
+MW_SYSTEMD_UNIT=%s
 
-# Middleware.io settings
-MW_API_KEY=%s
+# Middleware.io settings, the API key is kept in a root-only file
+MW_API_KEY_FILE=%s
 MW_TARGET=%s
-MW_LOG_LEVEL=INFO
 
 # Java agent
 MW_JAVA_AGENT_PATH=%s
 
-# Telemetry collection
-MW_APM_COLLECT_TRACES=true
-MW_APM_COLLECT_METRICS=true
-MW_APM_COLLECT_LOGS=true
-`, config.ServiceName, getCurrentTime(), config.ServiceName,
-		config.APIKey, config.Target, config.AgentPath)
+# Agent settings
+%s`, config.ServiceName, getCurrentTime(), config.ServiceName, config.SystemdUnit,
+		secrets.DefaultEnvFile(), config.Target, config.AgentPath,
+		settingsConfigLines(settingsOrDefault(config.Settings), "MW_SERVICE_NAME", "MW_TARGET"))
 
-	return os.WriteFile(configPath, []byte(content), 0o644)
+	return changeset.WriteFile(configPath, []byte(content), 0o644)
 }
 
 // ReadConfigFile reads and parses a configuration file
 // Moved from main.go: readConfigFile()
 func ReadConfigFile(path string) (ConfigVars, error) {
-	file, err := os.Open(path)
+	data, err := changeset.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
 	vars := make(ConfigVars)
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -4,6 +4,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/middleware-labs/java-injector/pkg/agent"
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+)
+
+const (
+	// dropInName is the drop-in holding the agent configuration
+	dropInName = "middleware-instrumentation.conf"
+
+	// secretsDropInName is the drop-in loading the API key file
+	secretsDropInName = "middleware-secrets.conf"
 )
 
 // CreateDropIn creates a systemd drop-in file
@@ -20,6 +32,19 @@ func CreateDropIn(config *DropInConfig) error {
 		hostname = "unknown"
 	}
 
+	// The API key is read by systemd from a root-only file
+	envFile, err := SecretsEnvFile(config.ServiceName)
+	if err != nil {
+		return err
+	}
+
+	settings := settingsOrDefault(config.Settings)
+	if config.Settings == nil {
+		settings.MWServiceName = configVars["MW_SERVICE_NAME"]
+		settings.MWTarget = configVars["MW_TARGET"]
+	}
+	options := javaOptions(configVars["MW_JAVA_AGENT_PATH"], settings)
+
 	var dropInContent string
 
 	if config.IsTomcat {
@@ -27,9 +52,13 @@ func CreateDropIn(config *DropInConfig) error {
 		existingOpts := readExistingCatalinaOpts(config.ServiceName)
 
 		// Build full CATALINA_OPTS with agent appended
-		fullOpts := fmt.Sprintf("%s -javaagent:%s", existingOpts, configVars["MW_JAVA_AGENT_PATH"])
+		fullOpts := fmt.Sprintf("%s %s", existingOpts, options)
 
-		serviceNameWithHost := fmt.Sprintf("%s@%s", configVars["MW_SERVICE_NAME_PATTERN"], hostname)
+		instanceServiceName := configVars["MW_TOMCAT_INSTANCE"]
+		if instanceServiceName == "" {
+			instanceServiceName = configVars["MW_SERVICE_NAME_PATTERN"]
+		}
+		serviceNameWithHost := fmt.Sprintf("%s@%s", instanceServiceName, hostname)
 
 		dropInContent = fmt.Sprintf(`[Service]
 # Grant read access to Middleware agent
@@ -38,73 +67,149 @@ ReadOnlyPaths=%s
 # Tomcat options with Middleware agent (hardcoded - systemd doesn't support variable expansion)
 Environment="CATALINA_OPTS=%s"
 
-# OpenTelemetry configuration
+# OpenTelemetry configuration, the exporter headers come from the EnvironmentFile
+EnvironmentFile=%s
 Environment="OTEL_SERVICE_NAME=%s"
 Environment="OTEL_EXPORTER_OTLP_ENDPOINT=%s"
-Environment="OTEL_EXPORTER_OTLP_HEADERS=authorization=%s"
-Environment="OTEL_TRACES_EXPORTER=otlp"
-Environment="OTEL_METRICS_EXPORTER=otlp"
-Environment="OTEL_LOGS_EXPORTER=otlp"
-`,
+%s`,
 			configVars["MW_JAVA_AGENT_PATH"],
 			fullOpts,
+			envFile,
 			serviceNameWithHost,
 			configVars["MW_TARGET"],
-			configVars["MW_API_KEY"])
+			exporterLines(settings))
+
+		// Per-webapp service names, resolved by the agent from the request context path
+		names := naming.ParseContextMap(configVars["MW_SERVICE_NAME_CONTEXT_MAP"])
+		if len(names) > 0 && agent.SupportsContextMap(configVars["MW_JAVA_AGENT_PATH"]) {
+			dropInContent += fmt.Sprintf("Environment=\"MW_SERVICE_NAME_CONTEXT_MAP=%s\"\n", contextMapWithHost(names, hostname))
+		}
+
+		// Webapps are named by the context map, not MW_SERVICE_NAME
+		dropInContent += "\n# Middleware agent settings\n" + environmentLines(agentEnvironment(settings, "MW_SERVICE_NAME"))
 	} else {
 		dropInContent = fmt.Sprintf(`[Service]
-Environment="JAVA_TOOL_OPTIONS=-javaagent:%s"
+EnvironmentFile=%s
+Environment="JAVA_TOOL_OPTIONS=%s"
 Environment="OTEL_SERVICE_NAME=%s"
 Environment="OTEL_EXPORTER_OTLP_ENDPOINT=%s"
-Environment="OTEL_EXPORTER_OTLP_HEADERS=authorization=%s"
-Environment="OTEL_TRACES_EXPORTER=otlp"
-Environment="OTEL_METRICS_EXPORTER=otlp"
-Environment="OTEL_LOGS_EXPORTER=otlp"
-`, configVars["MW_JAVA_AGENT_PATH"], configVars["MW_SERVICE_NAME"],
-			configVars["MW_TARGET"], configVars["MW_API_KEY"])
+%s
+# Middleware agent settings
+%s`, envFile, options, configVars["MW_SERVICE_NAME"], configVars["MW_TARGET"],
+			exporterLines(settings), environmentLines(agentEnvironment(settings)))
 	}
 
+	_, err = WriteDropIn(config.ServiceName, dropInContent)
+	return err
+}
+
+// WriteDropIn writes raw content to the Middleware drop-in of a service and
+// returns the drop-in path. Drop-ins of user units go to
+// ~/.config/systemd/user/<unit>.d and are owned by that user.
+func WriteDropIn(serviceName, content string) (string, error) {
 	// Create drop-in directory
-	dropInDir := fmt.Sprintf("/etc/systemd/system/%s.d", config.ServiceName)
-	if err := os.MkdirAll(dropInDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create drop-in directory: %v", err)
+	dropInDir, uid, gid, err := unitDropInDir(serviceName)
+	if err != nil {
+		return "", err
+	}
+	if err := mkdirAllOwned(dropInDir, uid, gid); err != nil {
+		return "", fmt.Errorf("failed to create drop-in directory: %v", err)
 	}
 
 	// Write drop-in file
-	dropInPath := filepath.Join(dropInDir, "middleware-instrumentation.conf")
-	if err := os.WriteFile(dropInPath, []byte(dropInContent), 0o644); err != nil {
-		return fmt.Errorf("failed to write drop-in file: %v", err)
+	dropInPath := filepath.Join(dropInDir, dropInName)
+	if err := changeset.WriteFile(dropInPath, []byte(content), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write drop-in file: %v", err)
+	}
+	if uid >= 0 && os.Geteuid() == 0 {
+		if err := changeset.Chown(dropInPath, uid, gid); err != nil {
+			return "", fmt.Errorf("failed to set drop-in ownership: %v", err)
+		}
 	}
 
 	fmt.Printf("   Created drop-in: %s\n", dropInPath)
-	return nil
+	return dropInPath, nil
 }
 
-// RemoveDropIn removes a systemd drop-in file
+// WriteSecretsDropIn points a unit at the API key file, for integrations
+// that configure the agent outside of the Middleware drop-in
+func WriteSecretsDropIn(serviceName string) (string, error) {
+	envFile, err := SecretsEnvFile(serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	dropInDir, uid, gid, err := unitDropInDir(serviceName)
+	if err != nil {
+		return "", err
+	}
+	if err := mkdirAllOwned(dropInDir, uid, gid); err != nil {
+		return "", fmt.Errorf("failed to create drop-in directory: %v", err)
+	}
+
+	dropInPath := filepath.Join(dropInDir, secretsDropInName)
+	content := fmt.Sprintf("[Service]\n# Middleware.io API key, kept out of world-readable files\nEnvironmentFile=%s\n", envFile)
+	if err := changeset.WriteFile(dropInPath, []byte(content), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write drop-in file: %v", err)
+	}
+	if uid >= 0 && os.Geteuid() == 0 {
+		if err := changeset.Chown(dropInPath, uid, gid); err != nil {
+			return "", fmt.Errorf("failed to set drop-in ownership: %v", err)
+		}
+	}
+
+	fmt.Printf("   Created drop-in: %s\n", dropInPath)
+	return dropInPath, nil
+}
+
+// DropInPath returns the path of the Middleware drop-in of a service
+func DropInPath(serviceName string) (string, error) {
+	dropInDir, _, _, err := unitDropInDir(serviceName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dropInDir, dropInName), nil
+}
+
+// RemoveDropIn removes the Middleware drop-in files of a service
 func RemoveDropIn(serviceName string) error {
-	dropInDir := fmt.Sprintf("/etc/systemd/system/%s.d", serviceName)
-	dropInPath := filepath.Join(dropInDir, "middleware-instrumentation.conf")
+	dropInDir, _, _, err := unitDropInDir(serviceName)
+	if err != nil {
+		return err
+	}
 
-	if fileExists(dropInPath) {
-		if err := os.Remove(dropInPath); err != nil {
+	for _, name := range []string{dropInName, secretsDropInName} {
+		dropInPath := filepath.Join(dropInDir, name)
+		if !fileExists(dropInPath) {
+			continue
+		}
+		if err := changeset.Remove(dropInPath); err != nil {
 			return fmt.Errorf("failed to remove drop-in file: %v", err)
 		}
 		fmt.Printf("   Removed drop-in: %s\n", dropInPath)
+	}
 
-		// Remove directory if empty
-		files, _ := os.ReadDir(dropInDir)
-		if len(files) == 0 {
-			if err := os.Remove(dropInDir); err == nil {
-				fmt.Printf("   Removed empty directory: %s\n", dropInDir)
-			}
+	// Remove directory if empty
+	if files, err := os.ReadDir(dropInDir); err == nil && len(files) == 0 {
+		if err := changeset.Remove(dropInDir); err == nil {
+			fmt.Printf("   Removed empty directory: %s\n", dropInDir)
 		}
 	}
 
 	return nil
 }
 
+// contextMapWithHost appends the hostname to every service name in a
+// context map, matching the instance-level OTEL_SERVICE_NAME format
+func contextMapWithHost(names naming.ContextServiceNames, hostname string) string {
+	withHost := make(naming.ContextServiceNames, len(names))
+	for context, name := range names {
+		withHost[context] = fmt.Sprintf("%s@%s", name, hostname)
+	}
+	return naming.FormatContextMap(withHost)
+}
+
 // fileExists checks if a file exists
 func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	return changeset.Exists(path)
 }
@@ -0,0 +1,79 @@
+package systemd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShowProperty returns a property of a unit as reported by systemctl show
+func ShowProperty(serviceName, property string) (string, error) {
+	cmd := systemctl(serviceName, "show", "-p", property, "--value")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of %s: %w", property, serviceName, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// EnvironmentFiles returns the EnvironmentFile= paths of a unit
+func EnvironmentFiles(serviceName string) []string {
+	value, err := ShowProperty(serviceName, "EnvironmentFiles")
+	if err != nil {
+		return nil
+	}
+	return ParseEnvironmentFiles(value)
+}
+
+// ParseEnvironmentFiles parses the EnvironmentFiles property, one
+// "path (ignore_errors=yes|no)" entry per line
+func ParseEnvironmentFiles(value string) []string {
+	var files []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if idx := strings.Index(line, " (ignore_errors="); idx != -1 {
+			line = line[:idx]
+		}
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files
+}
+
+// EnvironmentValue returns the value a unit's Environment= sets for a variable
+func EnvironmentValue(serviceName, name string) string {
+	value, err := ShowProperty(serviceName, "Environment")
+	if err != nil {
+		return ""
+	}
+	return ParseEnvironment(value)[name]
+}
+
+// ParseEnvironment parses the Environment property: space separated
+// assignments, double-quoted when the value contains spaces
+func ParseEnvironment(value string) map[string]string {
+	env := make(map[string]string)
+
+	var current strings.Builder
+	inQuotes := false
+	flush := func() {
+		if parts := strings.SplitN(current.String(), "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+		current.Reset()
+	}
+
+	for _, r := range value {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\n') && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return env
+}
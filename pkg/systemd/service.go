@@ -3,8 +3,11 @@ package systemd
 import (
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/middleware-labs/java-injector/pkg/changeset"
 	"github.com/middleware-labs/java-injector/pkg/discovery"
 	"github.com/middleware-labs/java-injector/pkg/naming"
 )
@@ -12,6 +15,12 @@ import (
 // GetServiceName tries to find the actual systemd service name for a Java process
 // Moved from main.go: getSystemdServiceName()
 func GetServiceName(proc *discovery.JavaProcess) string {
+	// systemctl status <pid> reports user@<uid>.service for processes run by a
+	// user manager, the cgroup names the actual unit
+	if unit := discovery.SystemdUnitForPID(proc.ProcessPID); IsUserUnit(unit) {
+		return unit
+	}
+
 	// Try to find the actual systemd service by PID
 	cmd := exec.Command("systemctl", "status", fmt.Sprintf("%d", proc.ProcessPID))
 	output, err := cmd.CombinedOutput()
@@ -50,7 +59,7 @@ func GetServiceName(proc *discovery.JavaProcess) string {
 
 // GetServiceStatus returns the status of a systemd service
 func GetServiceStatus(serviceName string) (string, error) {
-	cmd := exec.Command("systemctl", "is-active", serviceName)
+	cmd := systemctl(serviceName, "is-active")
 	output, err := cmd.Output()
 	if err != nil {
 		return "unknown", err
@@ -59,21 +68,103 @@ func GetServiceStatus(serviceName string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-// RestartService restarts a systemd service
+// RestartService restarts a systemd service. User managers aren't reloaded
+// by ReloadSystemd, so they are reloaded here first.
 func RestartService(serviceName string) error {
-	cmd := exec.Command("systemctl", "restart", serviceName)
-	return cmd.Run()
+	if IsUserUnit(serviceName) {
+		if err := reloadUserManager(serviceName); err != nil {
+			return fmt.Errorf("failed to reload user manager: %w", err)
+		}
+	}
+
+	cmd := systemctl(serviceName, "restart")
+	return changeset.Run(cmd)
+}
+
+// RestartTimeout is how long a restarted service gets to become active
+var RestartTimeout = 60 * time.Second
+
+// restartSettle is how long a service must stay active to count as started
+const restartSettle = 5 * time.Second
+
+// WaitActive waits for a restarted service to become active and stay up. A
+// service that fails, or that systemd restarts while we wait, is crash-looping.
+func WaitActive(serviceName string, timeout time.Duration) error {
+	if changeset.Recording() {
+		return nil
+	}
+
+	_, restarts := activeState(serviceName)
+	deadline := time.Now().Add(timeout)
+	var activeSince time.Time
+	for {
+		state, n := activeState(serviceName)
+		switch state {
+		case "failed":
+			return fmt.Errorf("%s failed to start", serviceName)
+		case "active":
+			if activeSince.IsZero() {
+				activeSince = time.Now()
+			}
+			if time.Since(activeSince) >= restartSettle {
+				return nil
+			}
+		default:
+			activeSince = time.Time{}
+		}
+
+		if n > restarts {
+			return fmt.Errorf("%s is crash-looping (restarted %d time(s))", serviceName, n-restarts)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not become active within %s (%s)", serviceName, timeout, state)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// activeState returns the state of a service and how often systemd
+// restarted it automatically
+func activeState(serviceName string) (string, int) {
+	properties := Show(serviceName, "ActiveState", "NRestarts")
+	state := properties["ActiveState"]
+	if state == "" {
+		state = "unknown"
+	}
+	restarts, _ := strconv.Atoi(properties["NRestarts"])
+	return state, restarts
+}
+
+// Show returns unit properties as reported by systemctl show, empty when
+// systemctl fails
+func Show(serviceName string, properties ...string) map[string]string {
+	args := []string{"show"}
+	for _, p := range properties {
+		args = append(args, "--property="+p)
+	}
+	values := make(map[string]string)
+	output, err := systemctl(serviceName, args...).Output()
+	if err != nil {
+		return values
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if key, value, found := strings.Cut(strings.TrimSpace(line), "="); found {
+			values[key] = value
+		}
+	}
+	return values
 }
 
 // ReloadSystemd reloads the systemd daemon
 func ReloadSystemd() error {
 	cmd := exec.Command("systemctl", "daemon-reload")
-	return cmd.Run()
+	return changeset.Run(cmd)
 }
 
 // ServiceExists checks if a systemd service exists
 func ServiceExists(serviceName string) bool {
-	cmd := exec.Command("systemctl", "status", serviceName)
+	cmd := systemctl(serviceName, "status")
 	err := cmd.Run()
 	return err == nil
 }
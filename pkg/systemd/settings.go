@@ -0,0 +1,87 @@
+package systemd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/config"
+)
+
+// settingsOrDefault returns the agent settings of a service, the defaults
+// when none were given
+func settingsOrDefault(settings *config.ProcessConfiguration) *config.ProcessConfiguration {
+	if settings != nil {
+		return settings
+	}
+	defaults := config.DefaultConfiguration()
+	return &defaults
+}
+
+// agentEnvironment returns the settings passed to the agent as environment
+// variables, sorted. The API key comes from the EnvironmentFile and the OTEL
+// settings are passed as -D options, keys in skip are set elsewhere.
+func agentEnvironment(settings *config.ProcessConfiguration, skip ...string) [][2]string {
+	env := settings.ToEnvironmentVariables()
+	delete(env, "MW_API_KEY")
+	for _, key := range skip {
+		delete(env, key)
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if !strings.HasPrefix(k, "OTEL_") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	vars := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, [2]string{k, env[k]})
+	}
+	return vars
+}
+
+// javaOptions returns the agent and OTEL -D options of a service
+func javaOptions(agentPath string, settings *config.ProcessConfiguration) string {
+	return strings.Join(append([]string{"-javaagent:" + agentPath}, settings.SystemProperties()...), " ")
+}
+
+// exporterLines renders the OTLP exporters, turning off signals the settings
+// don't collect
+func exporterLines(settings *config.ProcessConfiguration) string {
+	exporter := func(enabled bool) string {
+		if enabled {
+			return "otlp"
+		}
+		return "none"
+	}
+	return fmt.Sprintf(`Environment="OTEL_TRACES_EXPORTER=%s"
+Environment="OTEL_METRICS_EXPORTER=%s"
+Environment="OTEL_LOGS_EXPORTER=%s"
+`, exporter(settings.MWAPMCollectTraces), exporter(settings.MWAPMCollectMetrics), exporter(settings.MWAPMCollectLogs))
+}
+
+// environmentLines renders variables as drop-in Environment= lines
+func environmentLines(vars [][2]string) string {
+	var b strings.Builder
+	for _, kv := range vars {
+		fmt.Fprintf(&b, "Environment=\"%s=%s\"\n", kv[0], kv[1])
+	}
+	return b.String()
+}
+
+// settingsConfigLines renders the settings recorded in a Middleware config file
+func settingsConfigLines(settings *config.ProcessConfiguration, skip ...string) string {
+	var b strings.Builder
+	for _, kv := range agentEnvironment(settings, skip...) {
+		fmt.Fprintf(&b, "%s=%s\n", kv[0], kv[1])
+	}
+	for _, prop := range settings.SystemProperties() {
+		// -Dotel.traces.sampler=x is recorded as OTEL_TRACES_SAMPLER=x
+		name, value, _ := strings.Cut(strings.TrimPrefix(prop, "-D"), "=")
+		fmt.Fprintf(&b, "%s=%s\n", strings.ToUpper(strings.ReplaceAll(name, ".", "_")), value)
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package systemd
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+)
+
+// unitDir holds the units written by mw-injector itself
+const unitDir = "/etc/systemd/system"
+
+// InstallTimer writes a oneshot service running command and a timer that
+// starts it on a calendar schedule, then enables the timer
+func InstallTimer(name, description, onCalendar string, command []string) error {
+	service := fmt.Sprintf(`[Unit]
+Description=%s
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, description, strings.Join(command, " "))
+
+	timer := fmt.Sprintf(`[Unit]
+Description=%s
+
+[Timer]
+OnCalendar=%s
+
+[Install]
+WantedBy=timers.target
+`, description, onCalendar)
+
+	if err := changeset.WriteFile(filepath.Join(unitDir, name+".service"), []byte(service), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.service: %w", name, err)
+	}
+	if err := changeset.WriteFile(filepath.Join(unitDir, name+".timer"), []byte(timer), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.timer: %w", name, err)
+	}
+	if err := ReloadSystemd(); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	if err := changeset.Run(exec.Command("systemctl", "enable", "--now", name+".timer")); err != nil {
+		return fmt.Errorf("failed to enable %s.timer: %w", name, err)
+	}
+	return nil
+}
@@ -1,28 +1,36 @@
 package systemd
 
+import (
+	"github.com/middleware-labs/java-injector/pkg/config"
+	"github.com/middleware-labs/java-injector/pkg/naming"
+)
+
 // DropInConfig holds configuration for creating systemd drop-in files
 type DropInConfig struct {
 	ServiceName string
 	ConfigPath  string
 	IsTomcat    bool
 	AgentPath   string
+	Settings    *config.ProcessConfiguration // agent settings, defaults when nil
 }
 
 // TomcatConfig holds configuration for Tomcat services
 type TomcatConfig struct {
-	InstanceName string
-	Pattern      string
-	APIKey       string
-	Target       string
-	AgentPath    string
+	InstanceName       string
+	Pattern            string
+	WebappServiceNames naming.ContextServiceNames
+	Target             string
+	AgentPath          string
+	Settings           *config.ProcessConfiguration
 }
 
 // StandardConfig holds configuration for standard Java services
 type StandardConfig struct {
 	ServiceName string
-	APIKey      string
+	SystemdUnit string
 	Target      string
 	AgentPath   string
+	Settings    *config.ProcessConfiguration
 }
 
 // ServiceInfo represents information about a systemd service
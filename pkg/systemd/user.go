@@ -0,0 +1,170 @@
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+)
+
+// UserUnitName qualifies a unit run by the user manager of uid, using the
+// same form as the cgroup path: "user@1000.service/app.service"
+func UserUnitName(uid int, unit string) string {
+	return fmt.Sprintf("user@%d.service/%s", uid, unit)
+}
+
+// SplitUserUnit splits a qualified user unit into the uid of its manager and
+// the unit name. ok is false for system units.
+func SplitUserUnit(serviceName string) (uid int, unit string, ok bool) {
+	manager, unit, found := strings.Cut(serviceName, "/")
+	if !found || !strings.HasPrefix(manager, "user@") || !strings.HasSuffix(manager, ".service") {
+		return 0, serviceName, false
+	}
+
+	uid, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(manager, "user@"), ".service"))
+	if err != nil {
+		return 0, serviceName, false
+	}
+	return uid, unit, true
+}
+
+// IsUserUnit checks if a service is run by a systemd --user manager
+func IsUserUnit(serviceName string) bool {
+	_, _, ok := SplitUserUnit(serviceName)
+	return ok
+}
+
+// IsOwnUserUnit checks if a service is run by the user manager of the caller
+func IsOwnUserUnit(serviceName string) bool {
+	uid, _, ok := SplitUserUnit(serviceName)
+	return ok && uid == os.Getuid()
+}
+
+// systemctl builds a systemctl command against the manager that runs the
+// service. Root reaches other users' managers with --machine=<user>@, which
+// needs systemd 248 or newer.
+func systemctl(serviceName string, args ...string) *exec.Cmd {
+	uid, unit, ok := SplitUserUnit(serviceName)
+	if !ok {
+		return exec.Command("systemctl", append(args, serviceName)...)
+	}
+
+	flags := []string{"--user"}
+	if uid != os.Getuid() {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			flags = append(flags, "--machine="+u.Username+"@")
+		}
+	}
+	return exec.Command("systemctl", append(append(flags, args...), unit)...)
+}
+
+// ManualRestartCommand returns the command a user can run to restart a service
+func ManualRestartCommand(serviceName string) string {
+	uid, unit, ok := SplitUserUnit(serviceName)
+	if !ok {
+		return "sudo systemctl restart " + serviceName
+	}
+	if uid == os.Getuid() {
+		return "systemctl --user restart " + unit
+	}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return fmt.Sprintf("sudo systemctl --user --machine=%s@ restart %s", u.Username, unit)
+	}
+	return "systemctl --user restart " + unit
+}
+
+// unitDropInDir returns the drop-in directory of a service and the owner of user
+// units (-1 for system units)
+func unitDropInDir(serviceName string) (dir string, uid, gid int, err error) {
+	uid, unit, ok := SplitUserUnit(serviceName)
+	if !ok {
+		return fmt.Sprintf("/etc/systemd/system/%s.d", serviceName), -1, -1, nil
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", -1, -1, fmt.Errorf("failed to look up user %d: %w", uid, err)
+	}
+	gid, err = strconv.Atoi(u.Gid)
+	if err != nil {
+		return "", -1, -1, fmt.Errorf("invalid group of user %s: %w", u.Username, err)
+	}
+
+	return filepath.Join(u.HomeDir, ".config", "systemd", "user", unit+".d"), uid, gid, nil
+}
+
+// mkdirAllOwned creates a directory like os.MkdirAll, handing the directories
+// it creates to uid:gid when running as root
+func mkdirAllOwned(dir string, uid, gid int) error {
+	var created []string
+	for d := dir; !fileExists(d); d = filepath.Dir(d) {
+		created = append(created, d)
+	}
+
+	if err := changeset.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	if uid < 0 || os.Geteuid() != 0 {
+		return nil
+	}
+	for _, d := range created {
+		if err := changeset.Chown(d, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadUserManager reloads the user manager that runs a user unit
+func reloadUserManager(serviceName string) error {
+	uid, _, _ := SplitUserUnit(serviceName)
+
+	args := []string{"--user"}
+	if uid != os.Getuid() {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return fmt.Errorf("failed to look up user %d: %w", uid, err)
+		}
+		args = append(args, "--machine="+u.Username+"@")
+	}
+	return changeset.Run(exec.Command("systemctl", append(args, "daemon-reload")...))
+}
+
+// SecretsEnvFile returns the API key file for a unit. User managers can't
+// read the root-only host file, so root gives each user a copy they own.
+func SecretsEnvFile(serviceName string) (string, error) {
+	uid, _, ok := SplitUserUnit(serviceName)
+	if !ok || os.Geteuid() != 0 {
+		return secrets.DefaultEnvFile(), nil
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user %d: %w", uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return "", fmt.Errorf("invalid group of user %s: %w", u.Username, err)
+	}
+
+	apiKey, err := secrets.LoadAPIKey(secrets.HostEnvFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	envFile := secrets.UserEnvFile(u.HomeDir)
+	if err := mkdirAllOwned(filepath.Dir(filepath.Dir(envFile)), uid, gid); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(envFile), err)
+	}
+	if err := secrets.StoreAPIKey(envFile, apiKey, uid, gid); err != nil {
+		return "", err
+	}
+	return envFile, nil
+}
@@ -0,0 +1,36 @@
+package systemd_test
+
+import (
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+func TestSplitUserUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		wantUID  int
+		wantUnit string
+		wantOK   bool
+	}{
+		{"User unit", "user@1000.service/catalog.service", 1000, "catalog.service", true},
+		{"System unit", "billing.service", 0, "billing.service", false},
+		{"User manager", "user@1000.service", 0, "user@1000.service", false},
+		{"Invalid uid", "user@alice.service/catalog.service", 0, "user@alice.service/catalog.service", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, unit, ok := systemd.SplitUserUnit(tt.service)
+			if uid != tt.wantUID || unit != tt.wantUnit || ok != tt.wantOK {
+				t.Errorf("SplitUserUnit(%q) = %d, %q, %v, want %d, %q, %v",
+					tt.service, uid, unit, ok, tt.wantUID, tt.wantUnit, tt.wantOK)
+			}
+		})
+	}
+
+	if got := systemd.UserUnitName(1000, "catalog.service"); got != "user@1000.service/catalog.service" {
+		t.Errorf("UserUnitName() = %q", got)
+	}
+}
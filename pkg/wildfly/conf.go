@@ -0,0 +1,194 @@
+package wildfly
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/middleware-labs/java-injector/pkg/changeset"
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/managed"
+	"github.com/middleware-labs/java-injector/pkg/secrets"
+	"github.com/middleware-labs/java-injector/pkg/systemd"
+)
+
+// bootClassPathJars are the modules that must be on the boot class path when
+// java.util.logging.manager points at the JBoss log manager
+var bootClassPathJars = []string{
+	"modules/system/layers/base/org/jboss/logmanager/main/jboss-logmanager-*.jar",
+	"modules/system/layers/base/org/wildfly/common/main/wildfly-common-*.jar",
+}
+
+// LaunchConfPath returns bin/standalone.conf or bin/domain.conf for a server
+func LaunchConfPath(info *discovery.WildFlyInfo) string {
+	if info.HomeDir == "" {
+		return ""
+	}
+	return filepath.Join(info.HomeDir, "bin", info.Mode+".conf")
+}
+
+// Instrument adds the agent and JBoss Modules settings to the server's launch
+// configuration, or a drop-in of its unit when there is none
+func Instrument(cfg *Config) (*Result, error) {
+	if cfg.Info == nil || !cfg.Info.IsWildFly {
+		return nil, fmt.Errorf("not a WildFly process")
+	}
+
+	if cfg.Info.Mode == discovery.WildFlyModeDomain {
+		return nil, fmt.Errorf("domain mode servers take their JVM options from host.xml, add the agent to the server group's <jvm-options> instead")
+	}
+
+	result := &Result{}
+	bootJars := findBootClassPathJars(cfg.Info.HomeDir)
+	if len(bootJars) == 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("JBoss log manager not found under %s, skipping log manager setup", cfg.Info.HomeDir))
+	}
+
+	confPath := LaunchConfPath(cfg.Info)
+	if confPath != "" && fileExists(confPath) {
+		if err := managed.UpsertBlock(confPath, buildLaunchConfBlock(cfg, bootJars), 0o644); err != nil {
+			return nil, err
+		}
+		result.File = confPath
+		return result, nil
+	}
+
+	if cfg.SystemdUnit == "" {
+		return nil, fmt.Errorf("%s not found and no systemd unit to fall back to", confPath)
+	}
+
+	dropIn, err := systemd.WriteDropIn(cfg.SystemdUnit, buildDropIn(cfg, bootJars))
+	if err != nil {
+		return nil, err
+	}
+	result.File, result.DropIn = dropIn, true
+	return result, nil
+}
+
+// Uninstrument removes our settings from a launch configuration file and
+// tells whether there were any
+func Uninstrument(confPath string) (bool, error) {
+	return managed.RemoveBlock(confPath)
+}
+
+// CreateConfig creates the Middleware configuration for a WildFly server
+func CreateConfig(cfg *Config, instrumentedFile string) error {
+	if err := changeset.MkdirAll(filepath.Dir(cfg.ConfigPath), 0o755); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf(`# Middleware.io Configuration for WildFly
+# Service: %s
+# Generated: %s
+
+# Service identification
+MW_SERVICE_NAME=%s
+MW_WILDFLY_HOME=%s
+MW_WILDFLY_MODE=%s
+MW_SYSTEMD_UNIT=%s
+
+# Middleware.io settings, the API key is kept in a 0600 file
+MW_API_KEY_FILE=%s
+MW_TARGET=%s
+MW_LOG_LEVEL=INFO
+
+# Java agent
+MW_JAVA_AGENT_PATH=%s
+
+# File containing the instrumentation, restored on uninstrument
+MW_INSTRUMENTED_FILE=%s
+`, cfg.ServiceName, time.Now().Format("2006-01-02 15:04:05"), cfg.ServiceName,
+		cfg.Info.HomeDir, cfg.Info.Mode, cfg.SystemdUnit, apiKeyFile(cfg), cfg.Target, cfg.AgentPath, instrumentedFile)
+
+	return changeset.WriteFile(cfg.ConfigPath, []byte(content), 0o644)
+}
+
+// apiKeyFile returns the file the server loads the API key from
+func apiKeyFile(cfg *Config) string {
+	if cfg.APIKeyFile != "" {
+		return cfg.APIKeyFile
+	}
+	return secrets.DefaultEnvFile()
+}
+
+// javaOptions returns the JVM options needed to load the agent in WildFly
+func javaOptions(cfg *Config, bootJars []string) []string {
+	var opts []string
+	if len(bootJars) > 0 {
+		opts = append(opts,
+			"-Djava.util.logging.manager="+LogManagerClass,
+			"-Xbootclasspath/a:"+strings.Join(bootJars, ":"))
+	}
+	return append(opts, "-javaagent:"+cfg.AgentPath)
+}
+
+// otelEnvironment returns the OpenTelemetry exporter settings
+func otelEnvironment(cfg *Config) [][2]string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return [][2]string{
+		{"OTEL_SERVICE_NAME", fmt.Sprintf("%s@%s", cfg.ServiceName, hostname)},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", cfg.Target},
+		{"OTEL_TRACES_EXPORTER", "otlp"},
+		{"OTEL_METRICS_EXPORTER", "otlp"},
+		{"OTEL_LOGS_EXPORTER", "otlp"},
+	}
+}
+
+// buildLaunchConfBlock renders the shell snippet appended to standalone.conf
+func buildLaunchConfBlock(cfg *Config, bootJars []string) string {
+	var b strings.Builder
+
+	b.WriteString("# Expose the JBoss log manager to the agent before JBoss Modules boots\n")
+	fmt.Fprintf(&b, "JBOSS_MODULES_SYSTEM_PKGS=\"${JBOSS_MODULES_SYSTEM_PKGS:-%s},%s\"\n", DefaultSystemPackages, LogManagerPackage)
+	fmt.Fprintf(&b, "JAVA_OPTS=\"$JAVA_OPTS -Djboss.modules.system.pkgs=$JBOSS_MODULES_SYSTEM_PKGS %s\"\n",
+		strings.Join(javaOptions(cfg, bootJars), " "))
+
+	for _, kv := range otelEnvironment(cfg) {
+		fmt.Fprintf(&b, "export %s=\"%s\"\n", kv[0], kv[1])
+	}
+	if cfg.APIKeyFile != "" {
+		b.WriteString("# The API key, readable by the server's user only\n")
+		fmt.Fprintf(&b, "if [ -r \"%s\" ]; then set -a; . \"%s\"; set +a; fi\n", cfg.APIKeyFile, cfg.APIKeyFile)
+	}
+
+	return b.String()
+}
+
+// buildDropIn renders the systemd drop-in used when the launch config is missing.
+// The launch scripts add -Djboss.modules.system.pkgs from JBOSS_MODULES_SYSTEM_PKGS.
+func buildDropIn(cfg *Config, bootJars []string) string {
+	var b strings.Builder
+
+	b.WriteString("[Service]\n")
+	fmt.Fprintf(&b, "Environment=\"JBOSS_MODULES_SYSTEM_PKGS=%s,%s\"\n", DefaultSystemPackages, LogManagerPackage)
+	fmt.Fprintf(&b, "Environment=\"JAVA_TOOL_OPTIONS=%s\"\n", strings.Join(javaOptions(cfg, bootJars), " "))
+
+	for _, kv := range otelEnvironment(cfg) {
+		fmt.Fprintf(&b, "Environment=\"%s=%s\"\n", kv[0], kv[1])
+	}
+
+	return b.String()
+}
+
+// findBootClassPathJars locates the log manager jars in the server's module tree
+func findBootClassPathJars(homeDir string) []string {
+	var jars []string
+	for _, pattern := range bootClassPathJars {
+		matches, _ := filepath.Glob(filepath.Join(homeDir, pattern))
+		if len(matches) > 0 {
+			jars = append(jars, matches[len(matches)-1])
+		}
+	}
+	return jars
+}
+
+// fileExists checks if a file exists
+func fileExists(path string) bool {
+	return changeset.Exists(path)
+}
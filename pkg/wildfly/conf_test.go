@@ -0,0 +1,123 @@
+package wildfly_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middleware-labs/java-injector/pkg/discovery"
+	"github.com/middleware-labs/java-injector/pkg/wildfly"
+)
+
+func TestInstrumentStandaloneConf(t *testing.T) {
+	home := t.TempDir()
+
+	original := "if [ \"x$JAVA_OPTS\" = \"x\" ]; then\n   JAVA_OPTS=\"-Xms64m -Xmx512m\"\nfi\n"
+	confPath := filepath.Join(home, "bin", "standalone.conf")
+	writeFile(t, confPath, original)
+
+	logManagerJar := filepath.Join(home, "modules/system/layers/base/org/jboss/logmanager/main/jboss-logmanager-2.1.19.Final.jar")
+	writeFile(t, logManagerJar, "")
+
+	cfg := &wildfly.Config{
+		ServiceName: "wildfly-prod",
+		Info: &discovery.WildFlyInfo{
+			IsWildFly: true,
+			HomeDir:   home,
+			Mode:      discovery.WildFlyModeStandalone,
+		},
+		APIKeyFile: "/etc/middleware/wildfly/wildfly-prod.secrets/middleware.env",
+		Target:     "https://example.middleware.io:443",
+		AgentPath:  "/opt/middleware/agents/agent.jar",
+	}
+
+	// Instrumenting twice must not duplicate the settings
+	for i := 0; i < 2; i++ {
+		result, err := wildfly.Instrument(cfg)
+		if err != nil {
+			t.Fatalf("Instrument() error: %v", err)
+		}
+		if result.File != confPath || result.DropIn || len(result.Warnings) > 0 {
+			t.Fatalf("Instrument() = %+v, want %s without warnings", result, confPath)
+		}
+	}
+
+	data, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+
+	for _, want := range []string{
+		"-Djboss.modules.system.pkgs=$JBOSS_MODULES_SYSTEM_PKGS",
+		"org.jboss.logmanager\"",
+		"-Djava.util.logging.manager=org.jboss.logmanager.LogManager",
+		"-Xbootclasspath/a:" + logManagerJar,
+		"-javaagent:/opt/middleware/agents/agent.jar",
+		"export OTEL_EXPORTER_OTLP_ENDPOINT=\"https://example.middleware.io:443\"",
+		". \"/etc/middleware/wildfly/wildfly-prod.secrets/middleware.env\"",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("standalone.conf missing %q\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "-javaagent:") != 1 {
+		t.Errorf("expected a single -javaagent entry\n%s", content)
+	}
+
+	if restored, err := wildfly.Uninstrument(confPath); err != nil || !restored {
+		t.Fatalf("Uninstrument() = %v, %v, want true", restored, err)
+	}
+
+	data, _ = os.ReadFile(confPath)
+	if string(data) != original {
+		t.Errorf("Uninstrument() left\n%s\nwant\n%s", data, original)
+	}
+}
+
+func TestInstrumentWithoutLogManager(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, "bin", "standalone.conf"), "JAVA_OPTS=\"-Xmx512m\"\n")
+	cfg := &wildfly.Config{
+		ServiceName: "wildfly-prod",
+		Info: &discovery.WildFlyInfo{
+			IsWildFly: true,
+			HomeDir:   home,
+			Mode:      discovery.WildFlyModeStandalone,
+		},
+		AgentPath: "/opt/middleware/agents/agent.jar",
+	}
+
+	result, err := wildfly.Instrument(cfg)
+	if err != nil {
+		t.Fatalf("Instrument() error: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "log manager not found") {
+		t.Errorf("Instrument() warnings = %q, want the missing log manager", result.Warnings)
+	}
+}
+
+func TestInstrumentDomainMode(t *testing.T) {
+	cfg := &wildfly.Config{
+		Info: &discovery.WildFlyInfo{
+			IsWildFly: true,
+			HomeDir:   t.TempDir(),
+			Mode:      discovery.WildFlyModeDomain,
+		},
+	}
+
+	if _, err := wildfly.Instrument(cfg); err == nil {
+		t.Error("Instrument() should refuse domain mode servers")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+// Package wildfly instruments WildFly and JBoss EAP servers. Java agents need
+// the JBoss log manager on the boot class path and exposed as a system
+// package, so the agent can't simply be added like for a plain JVM.
+package wildfly
+
+import "github.com/middleware-labs/java-injector/pkg/discovery"
+
+// Config holds configuration for instrumenting a WildFly server
+type Config struct {
+	ServiceName string
+	Info        *discovery.WildFlyInfo
+	ConfigPath  string // Middleware config, e.g. /etc/middleware/wildfly/<name>.conf
+	SystemdUnit string // Used when the launch script config can't be edited
+	APIKeyFile  string // sourced by the launch config, empty when the unit loads the key
+	Target      string
+	AgentPath   string
+}
+
+// Result describes what Instrument changed
+type Result struct {
+	File     string   // launch config or systemd drop-in holding the settings
+	DropIn   bool     // the launch config was missing, File is a drop-in
+	Warnings []string // settings that couldn't be applied
+}
+
+const (
+	// LogManagerClass is the JUL log manager WildFly expects to be installed
+	LogManagerClass = "org.jboss.logmanager.LogManager"
+
+	// DefaultSystemPackages is JBoss Modules' default system package list
+	DefaultSystemPackages = "org.jboss.byteman"
+
+	// LogManagerPackage must be a system package so the agent and the server
+	// share the same log manager classes
+	LogManagerPackage = "org.jboss.logmanager"
+)